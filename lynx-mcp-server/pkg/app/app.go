@@ -0,0 +1,224 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/audit"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/cache"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/confirm"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/guard"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/health"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/idempotency"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/logging"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/oauth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/outbox"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/rest"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/staging"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/tools"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/tracing"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// New creates the server of the configuration with every route: the MCP SSE endpoint, the REST endpoints, metrics,
+// health probes and the OAuth authorization server when enabled
+func New(cfg config.Config) (*Server, error) {
+	serverConfig := cfg.Server
+	lynxConfig := cfg.Lynx
+
+	// Every Lynx request, sign ins and uploads included, waits for its turn within the limits of the account
+	utils.SetTransport(guard.NewGuard(lynxConfig.Limit, auth.IdentityFromContext).Transport(metrics.Transport))
+
+	tokens, err := auth.NewRegistry(serverConfig, toolScopes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tokens: %w", err)
+	}
+	for tool := range serverConfig.ToolTimeouts {
+		if _, ok := toolScopes[tool]; !ok {
+			return nil, fmt.Errorf("server.toolTimeouts: unknown tool %q", tool)
+		}
+	}
+	for tool := range lynxConfig.ToolRetry {
+		if _, ok := toolScopes[tool]; !ok {
+			return nil, fmt.Errorf("lynx.toolRetry: unknown tool %q", tool)
+		}
+	}
+	// A cached write would never reach Lynx again
+	for tool := range cfg.Cache.TTLs {
+		if scopes, ok := toolScopes[tool]; !ok || !slices.Equal(scopes, []auth.Scope{auth.SCOPE_READ}) {
+			return nil, fmt.Errorf("cache.ttls: %q is not a read tool", tool)
+		}
+	}
+
+	// Staff sign in with the built-in authorization server, its access tokens are accepted next to the static ones
+	var oauthServer *oauth.Server
+	if serverConfig.OAuth.Enabled() {
+		oauthServer, err = oauth.NewServer(serverConfig.OAuth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OAuth authorization server: %w", err)
+		}
+		tokens.AddVerifier(oauthServer, serverConfig.OAuth.RateLimit)
+		tokens.SetResourceMetadata(oauthServer.ResourceMetadataURL())
+		slog.Info("OAuth authorization server enabled", "publicUrl", serverConfig.OAuth.PublicURL)
+	}
+
+	attachmentStore, err := staging.NewStore(cfg.Staging)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachment staging store: %w", err)
+	}
+	attachmentStore.StartCleanup(context.Background())
+
+	// Shared by the REST endpoint and the tools, attachments are streamed to Lynx
+	uploads := upload.NewService(lynxConfig, cfg.Upload)
+
+	chunkedUploads, err := rest.NewChunkedUploads(lynxConfig, cfg.Upload, uploads)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunked uploads: %w", err)
+	}
+	chunkedUploads.StartCleanup(context.Background())
+
+	// Routes authenticate bearer tokens then check their scopes, requests are traced and tagged with an ID
+	srv := NewServer(tokens.Middleware)
+	srv.Use(tracing.Middleware, logging.Middleware)
+
+	// Every change made to Lynx is recorded when an audit log is configured, it is closed once requests stopped
+	var auditLog *audit.Log
+	if cfg.Audit.Enabled() {
+		auditLog, err = audit.Open(cfg.Audit.File, lynxConfig.Username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		srv.OnShutdown(func(context.Context) error { return auditLog.Close() })
+		slog.Info("Recording changes made to Lynx", "file", cfg.Audit.File)
+	} else {
+		slog.Warn("Audit log disabled, set AUDIT_LOG_FILE to record changes made to Lynx")
+	}
+
+	attachments := tools.Attachments{
+		Store:   attachmentStore,
+		Uploads: uploads,
+	}
+
+	// Read tool results are reused until they expire or a save changes their file
+	cached := func(next server.ToolHandlerFunc) server.ToolHandlerFunc { return next }
+	if cfg.Cache.Enabled() {
+		results, err := cache.New(cfg.Cache)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cache: %w", err)
+		}
+		results.StartCleanup(context.Background())
+		lynx.OnWrite(results.InvalidateFile)
+		srv.OnShutdown(func(context.Context) error { return results.Close() })
+		cached = tools.NewCacheMiddleware(results, cfg.Cache, lynxConfig)
+	}
+
+	// Uploads and saves repeating an idempotency key get the result of the first call instead of changing Lynx again
+	keys, err := idempotency.NewStore(cfg.Idempotency)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create idempotency store: %w", err)
+	}
+	keys.StartCleanup(context.Background())
+	srv.OnShutdown(func(context.Context) error { return keys.Close() })
+
+	lynxAccounts := lynx.NewAccounts(lynxConfig)
+
+	// Writes failing while Lynx is unreachable are kept in the outbox and applied in the background once it is back
+	var queue *outbox.Outbox
+	var enqueueEmail outbox.Enqueue
+	if cfg.Outbox.Enabled() {
+		queue, err = outbox.New(cfg.Outbox, serverConfig, lynxConfig, lynxAccounts, attachmentStore, keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create outbox: %w", err)
+		}
+		queue.Start()
+		srv.OnShutdown(func(context.Context) error { return queue.Close() })
+		enqueueEmail = queue.Enqueuer(tools.TOOL_EMAIL_INGEST)
+		slog.Info("Queueing writes while Lynx is unreachable", "file", cfg.Outbox.File)
+	}
+
+	// Write tools wait for a person to approve them through /actions, confirmed actions keep the deadline and retry
+	// policy of their own tool
+	var pendingActions *confirm.Store
+	if serverConfig.ConfirmWriteTools {
+		deadline := tools.NewDeadlineMiddleware(serverConfig)
+		retry := tools.NewRetryMiddleware(lynxConfig)
+		pendingActions = confirm.NewStore(confirm.DEFAULT_PENDING_ACTION_TTL, func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+			return deadline(retry(next))
+		})
+	}
+
+	mcpServer := NewMCPServer(serverConfig, lynxConfig, attachments, tokens, auditLog, srv, cached, tools.NewIdempotencyMiddleware(keys, attachmentStore, lynxConfig), queue, pendingActions)
+	sse := server.NewSSEServer(mcpServer, server.WithSSEContextFunc(srv.MessageContext))
+
+	// Routes run as the Lynx account of the caller, except for /metrics and /debug/lynx
+	accounts := lynxAccounts.Middleware
+
+	// Add the SSE server route at root for MCP client compatibility, streams end on shutdown once tool calls
+	// completed
+	srv.HandleStream("/", accounts(auth.Require(sse, auth.SCOPE_READ)))
+
+	// Add the attachment upload endpoint
+	srv.Handle("/attachmentUpload", accounts(auth.Require(audited(auditLog, rest.NewAttachmentUploadHandler(lynxConfig, uploads, keys)), auth.SCOPE_UPLOAD)))
+
+	// Add the attachment staging endpoint, staged attachments are referred to by handle in tools
+	srv.Handle("/attachments", accounts(auth.RequireFunc(rest.NewAttachmentStagingHandler(attachmentStore), auth.SCOPE_UPLOAD)))
+
+	// Add the resumable chunked upload endpoints
+	srv.Handle("POST /uploads", accounts(auth.RequireFunc(chunkedUploads.HandleCreate, auth.SCOPE_UPLOAD)))
+	srv.Handle("GET /uploads/{uploadId}", accounts(auth.RequireFunc(chunkedUploads.HandleStatus, auth.SCOPE_UPLOAD)))
+	srv.Handle("PUT /uploads/{uploadId}", accounts(auth.RequireFunc(chunkedUploads.HandleChunk, auth.SCOPE_UPLOAD)))
+	srv.Handle("POST /uploads/{uploadId}/finalize", accounts(auth.Require(audited(auditLog, http.HandlerFunc(chunkedUploads.HandleFinalize)), auth.SCOPE_UPLOAD)))
+	srv.Handle("DELETE /uploads/{uploadId}", accounts(auth.RequireFunc(chunkedUploads.HandleCancel, auth.SCOPE_UPLOAD)))
+
+	// Add the email ingestion endpoint, raw supplier emails are filed against their booking
+	srv.Handle("/emailIngest", accounts(auth.Require(audited(auditLog, rest.NewEmailIngestHandler(lynxConfig, uploads, enqueueEmail)), auth.SCOPE_WRITE, auth.SCOPE_UPLOAD)))
+
+	// Add the pending action endpoints, people approve the changes agents request with tokens of their own
+	if pendingActions != nil {
+		actions := rest.NewPendingActions(pendingActions)
+		srv.Handle("GET /actions", accounts(auth.RequireFunc(actions.HandleList, auth.SCOPE_APPROVE)))
+		srv.Handle("POST /actions/{actionId}/approve", accounts(auth.RequireFunc(actions.HandleApprove, auth.SCOPE_APPROVE)))
+		srv.Handle("POST /actions/{actionId}/deny", accounts(auth.RequireFunc(actions.HandleDeny, auth.SCOPE_APPROVE)))
+	}
+
+	// Add the audit log search endpoint
+	if auditLog != nil {
+		srv.Handle("GET /audit", accounts(auth.RequireFunc(rest.NewAuditQueryHandler(auditLog), auth.SCOPE_ADMIN)))
+	}
+
+	// Scrapers of /metrics don't need a Lynx account
+	srv.Handle("GET /metrics", auth.Require(metrics.Handler(), auth.SCOPE_METRICS))
+
+	// The diagnostics report on the service account and every session, not on the Lynx account of the caller
+	checker := health.NewChecker(cfg)
+	srv.Handle("GET /debug/lynx", auth.RequireFunc(rest.NewDebugLynxHandler(checker), auth.SCOPE_ADMIN))
+
+	// The probes are public and polled every few seconds, they are neither traced nor logged
+	srv.HandleProbe("GET /healthz", http.HandlerFunc(rest.HandleHealthz))
+	srv.HandleProbe("GET /readyz", rest.NewReadyzHandler(checker))
+
+	// The OAuth metadata and sign in routes are public
+	if oauthServer != nil {
+		oauthServer.Register(srv.Public())
+	}
+
+	return srv, nil
+}
+
+// audited records the calls of a REST write endpoint in the audit log, when there is one
+func audited(auditLog *audit.Log, handler http.Handler) http.Handler {
+	if auditLog == nil {
+		return handler
+	}
+	return auditLog.Middleware(handler)
+}
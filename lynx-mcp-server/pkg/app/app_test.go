@@ -0,0 +1,258 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const TEST_METRICS_TOKEN = "metrics-token-123456"
+
+func newTestConfig(t *testing.T) config.Config {
+	t.Helper()
+	directory := t.TempDir()
+
+	cfg := config.Default()
+	cfg.Server.Tokens = []config.TokenConfig{
+		{Name: "prometheus", Hash: auth.HashToken(TEST_METRICS_TOKEN), Scopes: []string{string(auth.SCOPE_METRICS)}},
+	}
+	// Nothing listens there, the readiness checks fail fast
+	cfg.Lynx.RemoteHost = "127.0.0.1:1"
+	cfg.Lynx.Username = "jdoe"
+	cfg.Lynx.Password = "secret"
+	cfg.Lynx.CompanyCode = "ACME"
+	cfg.Staging.Directory = filepath.Join(directory, "staging")
+	cfg.Upload.ChunkDirectory = filepath.Join(directory, "uploads")
+	cfg.Audit.File = filepath.Join(directory, "audit.jsonl")
+	return cfg
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	srv, err := New(newTestConfig(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { srv.Shutdown(context.Background()) })
+	return srv
+}
+
+func TestCacheRejectsWriteTools(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Cache.TTLs = map[string]time.Duration{"file_document_save": time.Minute}
+
+	if _, err := New(cfg); err == nil || !strings.Contains(err.Error(), "not a read tool") {
+		t.Errorf("expected a TTL on a write tool to be rejected, got %v", err)
+	}
+}
+
+// request builds a request matching a route pattern such as "PUT /uploads/{uploadId}"
+func request(t *testing.T, baseURL string, pattern string, token string) *http.Request {
+	t.Helper()
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = http.MethodGet, pattern
+	}
+	path = strings.ReplaceAll(path, "{uploadId}", "0123456789abcdef")
+
+	req, err := http.NewRequest(method, baseURL+path, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestEveryRouteAuthenticates(t *testing.T) {
+	srv := newTestServer(t)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// The SSE stream of a request passing the checks would never end
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	for _, route := range srv.Routes() {
+		t.Run(route.Pattern, func(t *testing.T) {
+			resp, err := client.Do(request(t, ts.URL, route.Pattern, ""))
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			resp.Body.Close()
+
+			if route.Public {
+				if resp.StatusCode == http.StatusUnauthorized {
+					t.Errorf("public route answered 401")
+				}
+				return
+			}
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("status without token = %d, want 401", resp.StatusCode)
+			}
+
+			// Every route checks scopes too, a scraper token only reaches /metrics
+			resp, err = client.Do(request(t, ts.URL, route.Pattern, TEST_METRICS_TOKEN))
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			resp.Body.Close()
+
+			want := http.StatusForbidden
+			if route.Pattern == "GET /metrics" {
+				want = http.StatusOK
+			}
+			if resp.StatusCode != want {
+				t.Errorf("status with metrics token = %d, want %d", resp.StatusCode, want)
+			}
+		})
+	}
+}
+
+func TestShutdownDrains(t *testing.T) {
+	srv := NewServer(func(next http.Handler) http.Handler { return next })
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	tool := srv.ToolMiddleware(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if request.Params.Name == "slow" {
+			close(started)
+			<-release
+		}
+		return mcp.NewToolResultText("saved"), nil
+	})
+
+	streamStarted, streamEnded := make(chan struct{}), make(chan struct{})
+	srv.HandleStream("GET /sse", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(streamStarted)
+		<-r.Context().Done()
+		close(streamEnded)
+	}))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	go http.Get(ts.URL + "/sse")
+	<-streamStarted
+
+	slow := mcp.CallToolRequest{}
+	slow.Params.Name = "slow"
+	go tool(context.Background(), slow)
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+
+	// Wait for the drain to start, new calls are then rejected
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := tool(context.Background(), mcp.CallToolRequest{})
+		if errors.Is(err, ErrShuttingDown) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("tool call during shutdown error = %v, want ErrShuttingDown", err)
+		}
+	}
+
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown() returned before the tool call completed")
+	case <-streamEnded:
+		t.Fatal("stream ended before the tool call completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-streamEnded:
+	case <-time.After(time.Second):
+		t.Fatal("stream still open after shutdown")
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+}
+
+func TestToolCallCancel(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel func(t *testing.T, mcpClient *client.Client)
+	}{
+		{"notifications/cancelled", func(t *testing.T, mcpClient *client.Client) {
+			// The initialize request has ID 1, the tool call 2
+			notification := mcp.JSONRPCNotification{JSONRPC: mcp.JSONRPC_VERSION}
+			notification.Method = METHOD_NOTIFICATION_CANCELLED
+			notification.Params.AdditionalFields = map[string]any{"requestId": 2, "reason": "user stopped"}
+			if err := mcpClient.GetTransport().SendNotification(context.Background(), notification); err != nil {
+				t.Fatalf("SendNotification() error = %v", err)
+			}
+		}},
+		{"session ends", func(t *testing.T, mcpClient *client.Client) {
+			mcpClient.Close()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer(func(next http.Handler) http.Handler { return next })
+			hooks := &server.Hooks{}
+			srv.AddHooks(hooks)
+			mcpServer := server.NewMCPServer("test", "1.0.0", server.WithHooks(hooks), server.WithToolHandlerMiddleware(srv.ToolMiddleware))
+			mcpServer.AddNotificationHandler(METHOD_NOTIFICATION_CANCELLED, srv.CancelCall)
+
+			started, canceled := make(chan struct{}), make(chan struct{})
+			mcpServer.AddTool(mcp.NewTool("slow"), func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				close(started)
+				select {
+				case <-ctx.Done():
+					close(canceled)
+					return nil, ctx.Err()
+				case <-time.After(5 * time.Second):
+					return mcp.NewToolResultText("saved"), nil
+				}
+			})
+
+			srv.HandleStream("/", server.NewSSEServer(mcpServer, server.WithSSEContextFunc(srv.MessageContext)))
+			ts := httptest.NewServer(srv.Handler())
+			defer ts.Close()
+
+			mcpClient, err := client.NewSSEMCPClient(ts.URL + "/sse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mcpClient.Close()
+			if err := mcpClient.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if _, err := mcpClient.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+				t.Fatalf("Initialize() error = %v", err)
+			}
+
+			go func() {
+				request := mcp.CallToolRequest{}
+				request.Params.Name = "slow"
+				mcpClient.CallTool(context.Background(), request)
+			}()
+			<-started
+
+			tt.cancel(t, mcpClient)
+			select {
+			case <-canceled:
+			case <-time.After(time.Second):
+				t.Fatal("tool call still running")
+			}
+		})
+	}
+}
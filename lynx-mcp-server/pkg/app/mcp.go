@@ -0,0 +1,265 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/audit"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/confirm"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/outbox"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/tools"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/tracing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// toolScopes lists the scopes a token needs to list and call each tool
+var toolScopes = map[string][]auth.Scope{
+	tools.TOOL_FILE_SEARCH_BY_PARTY_NAME:     {auth.SCOPE_READ},
+	tools.TOOL_FILE_SEARCH_BY_FILE_REFERENCE: {auth.SCOPE_READ},
+	tools.TOOL_RETRIEVE_ITINERARY:            {auth.SCOPE_READ},
+	tools.TOOL_RETRIEVE_FILE_DOCUMENTS:       {auth.SCOPE_READ},
+	tools.ATTACHMENT_UPLOAD:                  {auth.SCOPE_UPLOAD},
+	tools.TOOL_FILE_DOCUMENT_SAVE:            {auth.SCOPE_WRITE},
+	tools.TOOL_TRANSACTION_DOCUMENT_SAVE:     {auth.SCOPE_WRITE},
+	tools.TOOL_EMAIL_INGEST:                  {auth.SCOPE_WRITE, auth.SCOPE_UPLOAD},
+	tools.TOOL_ATTACH_DOCUMENT:               {auth.SCOPE_WRITE, auth.SCOPE_UPLOAD},
+	tools.TOOL_CONFIRM_ACTION:                {auth.SCOPE_WRITE},
+	tools.TOOL_AUDIT_QUERY:                   {auth.SCOPE_ADMIN},
+	tools.TOOL_OUTBOX_LIST:                   {auth.SCOPE_WRITE},
+	tools.TOOL_OUTBOX_RETRY:                  {auth.SCOPE_WRITE},
+	tools.TOOL_OUTBOX_CANCEL:                 {auth.SCOPE_WRITE},
+}
+
+// NewMCPServer creates the MCP server with the tools the configuration enables, srv drains the tool calls on shutdown
+// and cancels those their client canceled or whose session ended, cached answers the calls of read tools from the
+// cache and idempotent replays the calls of write tools repeating an idempotency key. Writes failing while Lynx is
+// unreachable are queued in the outbox when queue is set, and write tools wait for a person to approve them when
+// pendingActions is set.
+func NewMCPServer(serverConfig config.MCPServerConfig, lynxConfig config.LynxServerConfig, attachments tools.Attachments, tokens *auth.Registry, auditLog *audit.Log, srv *Server, cached server.ToolHandlerMiddleware, idempotent server.ToolHandlerMiddleware, queue *outbox.Outbox, pendingActions *confirm.Store) *server.MCPServer {
+	hooks := &server.Hooks{}
+	metrics.AddHooks(hooks)
+	srv.AddHooks(hooks)
+
+	hooks.AddBeforeAny(func(ctx context.Context, id any, method mcp.MCPMethod, message any) {
+		if method == "tools/call" {
+			if callToolRequest, ok := message.(*mcp.CallToolRequest); ok {
+				logToolCall(ctx, "beforeAny", callToolRequest, nil)
+			}
+		} else {
+			slog.DebugContext(ctx, "beforeAny", "method", method, "id", id)
+		}
+	})
+	hooks.AddOnSuccess(func(ctx context.Context, id any, method mcp.MCPMethod, message any, result any) {
+		if method == "tools/call" {
+			if callToolRequest, ok := message.(*mcp.CallToolRequest); ok {
+				logToolCall(ctx, "onSuccess", callToolRequest, nil)
+			}
+		} else {
+			slog.DebugContext(ctx, "onSuccess", "method", method, "id", id)
+		}
+	})
+	hooks.AddOnError(func(ctx context.Context, id any, method mcp.MCPMethod, message any, err error) {
+		if method == "tools/call" {
+			if callToolRequest, ok := message.(*mcp.CallToolRequest); ok {
+				logToolCall(ctx, "onError", callToolRequest, err)
+			}
+		} else {
+			slog.WarnContext(ctx, "onError", "method", method, "id", id, "error", err)
+		}
+	})
+	hooks.AddBeforeInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest) {
+		slog.DebugContext(ctx, "beforeInitialize", "id", id, "client", message.Params.ClientInfo.Name)
+	})
+	hooks.AddOnRequestInitialization(func(ctx context.Context, id any, message any) error {
+		slog.DebugContext(ctx, "AddOnRequestInitialization", "id", id)
+		return nil
+	})
+	hooks.AddAfterInitialize(func(ctx context.Context, id any, message *mcp.InitializeRequest, result *mcp.InitializeResult) {
+		slog.InfoContext(ctx, "afterInitialize", "id", id, "client", message.Params.ClientInfo.Name, "clientVersion", message.Params.ClientInfo.Version, "protocolVersion", result.ProtocolVersion)
+	})
+	hooks.AddBeforeCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest) {
+		logToolCall(ctx, "beforeCallTool", message, nil)
+	})
+	hooks.AddAfterCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest, result *mcp.CallToolResult) {
+		logToolCall(ctx, "afterCallTool", message, nil)
+	})
+
+	mcpServer := server.NewMCPServer(
+		serverConfig.Name,
+		serverConfig.Version,
+		server.WithResourceCapabilities(false, false),
+		server.WithPromptCapabilities(false),
+		server.WithToolCapabilities(true),
+		server.WithLogging(),
+		server.WithHooks(hooks),
+		server.WithRecovery(),
+		// Tokens only see and call the tools their scopes and allow-list grant
+		server.WithToolFilter(tokens.FilterTools),
+		server.WithToolHandlerMiddleware(srv.ToolMiddleware),
+		server.WithToolHandlerMiddleware(tracing.ToolMiddleware),
+		server.WithToolHandlerMiddleware(tokens.ToolMiddleware),
+		server.WithToolHandlerMiddleware(tools.NewDeadlineMiddleware(serverConfig)),
+		server.WithToolHandlerMiddleware(tools.NewRetryMiddleware(lynxConfig)),
+	)
+	mcpServer.AddNotificationHandler(METHOD_NOTIFICATION_CANCELLED, srv.CancelCall)
+
+	// Write tools are recorded in the audit log once they ran
+	auditedHandler := func(handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return handler
+	}
+	if auditLog != nil {
+		auditedHandler = auditLog.Tool
+
+		mcpServer.AddTool(newTool(
+			tools.TOOL_AUDIT_QUERY,
+			tools.TOOL_AUDIT_QUERY_DESCRIPTION,
+			tools.GetAuditQuerySchema(),
+			tools.ReadOnlyToolAnnotation("Search audit log"),
+		), tools.NewAuditQueryHandler(auditLog))
+	}
+
+	// Writes failing while Lynx is unreachable are queued and applied once it is back, they are recorded when applied.
+	// Tools making several changes record those made before Lynx went down with outbox.Done.
+	queuedHandler := func(name string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return auditedHandler(handler)
+	}
+	if queue != nil {
+		queuedHandler = func(name string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+			return queue.Handle(name, auditedHandler(handler))
+		}
+
+		mcpServer.AddTool(newTool(
+			tools.TOOL_OUTBOX_LIST,
+			tools.TOOL_OUTBOX_LIST_DESCRIPTION,
+			tools.GetOutboxListSchema(),
+			tools.ReadOnlyToolAnnotation("List queued changes"),
+		), tools.NewOutboxListHandler(queue))
+
+		mcpServer.AddTool(newTool(
+			tools.TOOL_OUTBOX_RETRY,
+			tools.TOOL_OUTBOX_RETRY_DESCRIPTION,
+			tools.GetOutboxRetrySchema(),
+			tools.WriteToolAnnotation("Retry queued change", true, false),
+		), tools.NewOutboxRetryHandler(queue))
+
+		mcpServer.AddTool(newTool(
+			tools.TOOL_OUTBOX_CANCEL,
+			tools.TOOL_OUTBOX_CANCEL_DESCRIPTION,
+			tools.GetOutboxCancelSchema(),
+			tools.WriteToolAnnotation("Cancel queued change", true, false),
+		), tools.NewOutboxCancelHandler(queue))
+	}
+
+	// Write tools run once per idempotency key, replayed results are not recorded again
+	idempotentHandler := func(name string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return idempotent(queuedHandler(name, handler))
+	}
+
+	// Write tools are held back as pending actions when confirmation is required, confirming runs and records them
+	writeHandler := idempotentHandler
+	if pendingActions != nil {
+		writeHandler = func(name string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+			return pendingActions.RequireConfirmation(idempotentHandler(name, handler))
+		}
+
+		mcpServer.AddTool(newTool(
+			tools.TOOL_CONFIRM_ACTION,
+			tools.TOOL_CONFIRM_ACTION_DESCRIPTION,
+			tools.GetConfirmActionSchema(),
+			tools.WriteToolAnnotation("Confirm pending action", true, false),
+		), tools.NewConfirmActionHandler(pendingActions))
+	}
+
+	mcpServer.AddTool(newTool(
+		tools.TOOL_FILE_SEARCH_BY_PARTY_NAME,
+		tools.TOOL_FILE_SEARCH_BY_PARTY_NAME_DESCRIPTION,
+		tools.GetFileSearchByPartyNameSchema(),
+		tools.ReadOnlyToolAnnotation("Search files by party name"),
+	), cached(tools.NewFileSearchByPartyNameHandler(lynxConfig)))
+
+	mcpServer.AddTool(newTool(
+		tools.TOOL_FILE_SEARCH_BY_FILE_REFERENCE,
+		tools.TOOL_FILE_SEARCH_BY_FILE_REFERENCE_DESCRIPTION,
+		tools.GetFileSearchByFileReferenceSchema(),
+		tools.ReadOnlyToolAnnotation("Search files by file reference"),
+	), cached(tools.NewFileSearchByFileReferenceHandler(lynxConfig)))
+
+	mcpServer.AddTool(newTool(
+		tools.TOOL_RETRIEVE_ITINERARY,
+		tools.TOOL_RETRIEVE_ITINERARY_DESCRIPTION,
+		tools.GetRetrieveItinerarySchema(),
+		tools.ReadOnlyToolAnnotation("Retrieve file itinerary"),
+	), cached(tools.NewRetrieveItineraryHandler(lynxConfig)))
+
+	mcpServer.AddTool(newTool(
+		tools.TOOL_RETRIEVE_FILE_DOCUMENTS,
+		tools.TOOL_RETRIEVE_FILE_DOCUMENTS_DESCRIPTION,
+		tools.GetRetrieveFileDocumentsSchema(),
+		tools.ReadOnlyToolAnnotation("Retrieve file documents"),
+	), cached(tools.NewRetrieveFileDocumentsHandler(lynxConfig)))
+
+	mcpServer.AddTool(newTool(
+		tools.ATTACHMENT_UPLOAD,
+		tools.ATTACHMENT_UPLOAD_DESCRIPTION,
+		tools.GetAttachmentUploadSchema(),
+		tools.WriteToolAnnotation("Upload attachment", false, false),
+	), writeHandler(tools.ATTACHMENT_UPLOAD, tools.NewAttachmentUploadHandler(lynxConfig, attachments)))
+
+	mcpServer.AddTool(newTool(
+		tools.TOOL_FILE_DOCUMENT_SAVE,
+		tools.TOOL_FILE_DOCUMENT_SAVE_DESCRIPTION,
+		tools.GetFileDocumentSaveDetailsSchema(),
+		tools.WriteToolAnnotation("Save file document", true, false),
+	), writeHandler(tools.TOOL_FILE_DOCUMENT_SAVE, tools.NewFileDocumentSaveHandler(lynxConfig, attachments)))
+
+	mcpServer.AddTool(newTool(
+		tools.TOOL_TRANSACTION_DOCUMENT_SAVE,
+		tools.TOOL_TRANSACTION_DOCUMENT_SAVE_DESCRIPTION,
+		tools.GetTransactionDocumentSaveDetailsSchema(),
+		tools.WriteToolAnnotation("Save transaction document", true, false),
+	), writeHandler(tools.TOOL_TRANSACTION_DOCUMENT_SAVE, tools.NewTransactionDocumentSaveHandler(lynxConfig, attachments)))
+
+	mcpServer.AddTool(newTool(
+		tools.TOOL_EMAIL_INGEST,
+		tools.TOOL_EMAIL_INGEST_DESCRIPTION,
+		tools.GetEmailIngestSchema(),
+		tools.WriteToolAnnotation("Ingest supplier email", true, false),
+	), writeHandler(tools.TOOL_EMAIL_INGEST, tools.NewEmailIngestHandler(lynxConfig, attachments)))
+
+	mcpServer.AddTool(newTool(
+		tools.TOOL_ATTACH_DOCUMENT,
+		tools.TOOL_ATTACH_DOCUMENT_DESCRIPTION,
+		tools.GetAttachDocumentSchema(),
+		tools.WriteToolAnnotation("Attach document to booking", true, false),
+	), writeHandler(tools.TOOL_ATTACH_DOCUMENT, tools.NewAttachDocumentHandler(lynxConfig, attachments)))
+
+	return mcpServer
+}
+
+// newTool creates a tool from its raw schema and attaches its behaviour annotations
+func newTool(name string, description string, schema json.RawMessage, annotation mcp.ToolAnnotation) mcp.Tool {
+	tool := mcp.NewToolWithRawSchema(name, description, schema)
+	tool.Annotations = annotation
+	return tool
+}
+
+// logToolCall logs tool calls and who made them, the arguments go through the redaction policy of the logger
+func logToolCall(ctx context.Context, prefix string, message *mcp.CallToolRequest, loggedErr error) {
+	args := []any{
+		"tool", message.Params.Name,
+		"identity", auth.IdentityFromContext(ctx),
+		"arguments", message.GetArguments(),
+	}
+
+	if loggedErr != nil {
+		slog.WarnContext(ctx, prefix, append(args, "error", loggedErr)...)
+	} else {
+		slog.InfoContext(ctx, prefix, args...)
+	}
+}
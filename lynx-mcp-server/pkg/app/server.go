@@ -0,0 +1,360 @@
+// Package app assembles the HTTP server: its routes, the middleware chain every route but the probes goes through,
+// and the graceful drain of tool calls and uploads on shutdown
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/oauth"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ErrShuttingDown is returned for the tool calls and requests arriving once shutdown started
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// METHOD_NOTIFICATION_CANCELLED is the notification a client sends to cancel one of its requests
+const METHOD_NOTIFICATION_CANCELLED = "notifications/cancelled"
+
+// Middleware wraps a handler
+type Middleware func(http.Handler) http.Handler
+
+// Route is a registered route, Public when it doesn't authenticate its callers
+type Route struct {
+	Pattern string
+	Public  bool
+}
+
+// Server is the HTTP server of every route. Routes are authenticated unless registered as public or probes, and
+// go through the middleware chain unless registered as probes.
+type Server struct {
+	authenticate Middleware
+	middleware   []Middleware
+	probes       *http.ServeMux
+	mux          *http.ServeMux
+	routes       []Route
+	handlerOnce  sync.Once
+	httpServer   *http.Server
+
+	// Canceled once the drain completed or timed out, SSE streams and the tool calls still running end with it
+	closing  context.Context
+	close    context.CancelFunc
+	inflight inflight
+	closers  []func(context.Context) error
+
+	// Tool calls in flight by MCP session, canceled by their client or when their session ends
+	calls calls
+}
+
+// NewServer creates a server authenticating its routes with authenticate
+func NewServer(authenticate Middleware) *Server {
+	s := &Server{
+		authenticate: authenticate,
+		probes:       http.NewServeMux(),
+		mux:          http.NewServeMux(),
+	}
+	s.closing, s.close = context.WithCancel(context.Background())
+	s.httpServer = &http.Server{Handler: s.probes}
+	return s
+}
+
+// Use appends middleware to the chain of every route but the probes, the first one added is the outermost
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// Handle registers an authenticated route, shutdown waits for its requests to complete
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.handle(pattern, s.authenticate(s.drained(handler)), false)
+}
+
+// HandleStream registers an authenticated route serving long-lived streams, they end when shutdown starts
+func (s *Server) HandleStream(pattern string, handler http.Handler) {
+	s.handle(pattern, s.authenticate(s.stream(handler)), false)
+}
+
+// HandlePublic registers a route anyone can call
+func (s *Server) HandlePublic(pattern string, handler http.Handler) {
+	s.handle(pattern, handler, true)
+}
+
+// HandleProbe registers a public route outside the middleware chain, probes polled every few seconds would flood
+// the logs and traces
+func (s *Server) HandleProbe(pattern string, handler http.Handler) {
+	s.probes.Handle(pattern, handler)
+	s.routes = append(s.routes, Route{Pattern: pattern, Public: true})
+}
+
+func (s *Server) handle(pattern string, handler http.Handler, public bool) {
+	s.mux.Handle(pattern, handler)
+	s.routes = append(s.routes, Route{Pattern: pattern, Public: public})
+}
+
+// Routes lists the registered routes
+func (s *Server) Routes() []Route {
+	return s.routes
+}
+
+// OnShutdown registers a function run once the HTTP server stopped, such as flushing spans
+func (s *Server) OnShutdown(close func(context.Context) error) {
+	s.closers = append(s.closers, close)
+}
+
+// Public registers routes with HandlePublic, for the packages registering their own routes
+func (s *Server) Public() oauth.Router {
+	return publicRouter{s}
+}
+
+type publicRouter struct {
+	server *Server
+}
+
+func (r publicRouter) Handle(pattern string, handler http.Handler) {
+	r.server.HandlePublic(pattern, handler)
+}
+
+// Handler returns the handler of every route, the middleware chain is fixed on the first call
+func (s *Server) Handler() http.Handler {
+	s.handlerOnce.Do(func() {
+		var handler http.Handler = s.mux
+		for i := len(s.middleware) - 1; i >= 0; i-- {
+			handler = s.middleware[i](handler)
+		}
+		s.probes.Handle("/", handler)
+	})
+	return s.probes
+}
+
+// Serve serves the routes on listener until Shutdown is called, then returns http.ErrServerClosed
+func (s *Server) Serve(listener net.Listener) error {
+	s.Handler()
+	return s.httpServer.Serve(listener)
+}
+
+// ListenAndServe serves the routes on addr until Shutdown is called, then returns http.ErrServerClosed
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Shutdown waits for in-flight tool calls and requests, new ones are rejected meanwhile, then ends the streams,
+// stops the HTTP server and runs the OnShutdown functions. Whatever still runs when ctx is done is cut off.
+func (s *Server) Shutdown(ctx context.Context) error {
+	drainErr := s.inflight.wait(ctx)
+	s.close()
+
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		s.httpServer.Close()
+	}
+
+	errs := []error{drainErr, err}
+	for _, close := range s.closers {
+		errs = append(errs, close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// ToolMiddleware makes shutdown wait for the tool calls in flight, their results are sent on the SSE stream.
+// The calls still running when the drain times out are canceled, as are the calls their client canceled and those
+// of a session that ended: mcp-go runs them detached from the HTTP request that carried them.
+func (s *Server) ToolMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if !s.inflight.start() {
+			return nil, ErrShuttingDown
+		}
+		defer s.inflight.done()
+
+		ctx, cancel := s.untilClosing(ctx)
+		defer cancel()
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			var id string
+			if call, ok := ctx.Value(callIDContextKey).(*callID); ok {
+				id = call.id
+			}
+			defer s.calls.add(session.SessionID(), id, cancel)()
+		}
+		return next(ctx, request)
+	}
+}
+
+// MessageContext prepares the context of an MCP message for ToolMiddleware to learn the request ID of its tool call,
+// it is the server.SSEContextFunc of the SSE server
+func (s *Server) MessageContext(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, callIDContextKey, &callID{})
+}
+
+// AddHooks registers the hooks tracking the tool calls of each MCP session: the request ID of a call is recorded
+// before it runs, and the calls of a session are canceled once it is unregistered
+func (s *Server) AddHooks(hooks *server.Hooks) {
+	hooks.AddBeforeCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest) {
+		if call, ok := ctx.Value(callIDContextKey).(*callID); ok {
+			call.id = requestID(id)
+		}
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		s.calls.cancelSession(session.SessionID())
+	})
+}
+
+// CancelCall handles the notifications/cancelled notification, it cancels the tool call of the session with the
+// request ID
+func (s *Server) CancelCall(ctx context.Context, notification mcp.JSONRPCNotification) {
+	session := server.ClientSessionFromContext(ctx)
+	id, ok := notification.Params.AdditionalFields["requestId"]
+	if session == nil || !ok {
+		return
+	}
+	s.calls.cancel(session.SessionID(), requestID(id))
+}
+
+// drained makes shutdown wait for the requests of a route
+func (s *Server) drained(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.inflight.start() {
+			http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer s.inflight.done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stream cancels the requests of a route once drained, http.Server.Shutdown would wait for them forever
+func (s *Server) stream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := s.untilClosing(r.Context())
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// untilClosing returns a context canceled along with ctx or once shutdown stops waiting
+func (s *Server) untilClosing(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.closing, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// inflight counts the tool calls and requests shutdown waits for, none start once it waits
+type inflight struct {
+	mu       sync.Mutex
+	draining bool
+	wg       sync.WaitGroup
+}
+
+func (f *inflight) start() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return false
+	}
+	f.wg.Add(1)
+	return true
+}
+
+func (f *inflight) done() {
+	f.wg.Done()
+}
+
+func (f *inflight) wait(ctx context.Context) error {
+	f.mu.Lock()
+	f.draining = true
+	f.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type contextKey string
+
+const callIDContextKey = contextKey("callID")
+
+// callID receives the request ID of a tool call from the beforeCallTool hook, tool middlewares aren't given it
+type callID struct {
+	id string
+}
+
+// requestID returns the key of a JSON-RPC request ID, numbers decoded as float64 or int64 get the same key
+func requestID(id any) string {
+	if id, ok := id.(mcp.RequestId); ok {
+		return id.String()
+	}
+	return mcp.NewRequestId(id).String()
+}
+
+// call is a tool call in flight, id is empty when it isn't known
+type call struct {
+	id     string
+	cancel context.CancelFunc
+}
+
+// calls holds the tool calls in flight by MCP session
+type calls struct {
+	mu       sync.Mutex
+	sessions map[string]map[*call]bool
+}
+
+// add records a tool call of session, the returned function removes it once it completed
+func (c *calls) add(session string, id string, cancel context.CancelFunc) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessions == nil {
+		c.sessions = map[string]map[*call]bool{}
+	}
+	if c.sessions[session] == nil {
+		c.sessions[session] = map[*call]bool{}
+	}
+	added := &call{id: id, cancel: cancel}
+	c.sessions[session][added] = true
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.sessions[session], added)
+		if len(c.sessions[session]) == 0 {
+			delete(c.sessions, session)
+		}
+	}
+}
+
+// cancel cancels the tool call of session with the request ID id
+func (c *calls) cancel(session string, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for call := range c.sessions[session] {
+		if call.id == id {
+			call.cancel()
+		}
+	}
+}
+
+// cancelSession cancels every tool call of session
+func (c *calls) cancelSession(session string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for call := range c.sessions[session] {
+		call.cancel()
+	}
+}
@@ -0,0 +1,220 @@
+// Package audit keeps an append-only JSONL record of every change made to Lynx, by tools and REST endpoints
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SOURCE_TOOL = "tool"
+	SOURCE_REST = "rest"
+
+	RESULT_SUCCESS = "success"
+	RESULT_ERROR   = "error"
+
+	// MAX_RESPONSE_LENGTH bounds the Lynx response kept in an entry
+	MAX_RESPONSE_LENGTH = 4096
+
+	DEFAULT_QUERY_LIMIT = 100
+	MAX_QUERY_LIMIT     = 1000
+)
+
+// binaryArguments are replaced by their size and SHA-256 in entries
+var binaryArguments = []string{"binary", "eml", "fileBinary"}
+
+// Entry is one write operation
+type Entry struct {
+	Time                  time.Time      `json:"time"`
+	Identity              string         `json:"identity"`
+	LynxUser              string         `json:"lynxUser,omitempty"`
+	SessionID             string         `json:"sessionId,omitempty"`
+	Source                string         `json:"source"`
+	Action                string         `json:"action"`
+	Arguments             map[string]any `json:"arguments,omitempty"`
+	FileReference         string         `json:"fileReference,omitempty"`
+	FileIdentifier        string         `json:"fileIdentifier,omitempty"`
+	TransactionIdentifier string         `json:"transactionIdentifier,omitempty"`
+	Result                string         `json:"result"`
+	Status                int            `json:"status,omitempty"`
+	Response              string         `json:"response,omitempty"`
+	Error                 string         `json:"error,omitempty"`
+	DurationMS            int64          `json:"durationMs"`
+}
+
+// Filter selects entries, empty fields match everything
+type Filter struct {
+	File     string
+	Identity string
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+// Log appends entries to a JSONL file, the file is never rewritten
+type Log struct {
+	mu             sync.Mutex
+	file           *os.File
+	path           string
+	serviceAccount string
+}
+
+// Open opens the audit log for appending, serviceAccount is recorded as the Lynx user of callers without their own account
+func Open(path string, serviceAccount string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return &Log{
+		file:           file,
+		path:           path,
+		serviceAccount: serviceAccount,
+	}, nil
+}
+
+// Record appends an entry, each entry is a single write so a crash can't interleave entries
+func (l *Log) Record(entry Entry) error {
+	if len(entry.Response) > MAX_RESPONSE_LENGTH {
+		entry.Response = entry.Response[:MAX_RESPONSE_LENGTH] + "..."
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return l.file.Sync()
+}
+
+// Query returns the matching entries, newest first
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DEFAULT_QUERY_LIMIT
+	}
+	limit = min(limit, MAX_QUERY_LIMIT)
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	// Entries hold document contents, well beyond the default line limit
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn last line after a crash doesn't hide the rest of the log
+			continue
+		}
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	slices.Reverse(entries)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Close closes the audit log file
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+func (f Filter) matches(entry Entry) bool {
+	switch {
+	case f.File != "" && entry.FileReference != f.File && entry.FileIdentifier != f.File:
+		return false
+	case f.Identity != "" && entry.Identity != f.Identity:
+		return false
+	case !f.From.IsZero() && entry.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && entry.Time.After(f.To):
+		return false
+	}
+	return true
+}
+
+// sanitizeArguments copies the arguments, binaries are replaced by their size and SHA-256
+func sanitizeArguments(arguments map[string]any) map[string]any {
+	sanitized := make(map[string]any, len(arguments))
+	for key, value := range arguments {
+		stringValue, ok := value.(string)
+		if ok && slices.Contains(binaryArguments, key) {
+			sanitized[key] = describeBinary(stringValue)
+		} else {
+			sanitized[key] = value
+		}
+	}
+	return sanitized
+}
+
+// describeBinary hashes the decoded content when the value is base64, the raw value otherwise
+func describeBinary(value string) map[string]any {
+	content := []byte(value)
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+		content = decoded
+	}
+	return digest(int64(len(content)), sha256.Sum256(content))
+}
+
+func digest(size int64, sum [sha256.Size]byte) map[string]any {
+	return map[string]any{
+		"size":   size,
+		"sha256": hex.EncodeToString(sum[:]),
+	}
+}
+
+// ParseFilter reads a filter from named string values: file, user, from and to as RFC 3339 times, and limit
+func ParseFilter(get func(name string) string) (Filter, error) {
+	filter := Filter{
+		File:     get("file"),
+		Identity: get("user"),
+	}
+
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return Filter{}, fmt.Errorf("%s must be an RFC 3339 time, got %q", name, value)
+			}
+			*target = parsed
+		}
+	}
+
+	if value := get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return Filter{}, fmt.Errorf("limit must be a positive integer, got %q", value)
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestToolRecordsWrites(t *testing.T) {
+	auditLog, err := Open(filepath.Join(t.TempDir(), "audit.jsonl"), "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	ctx := auth.WithToken(context.Background(), &auth.Token{Name: "jdoe", Identity: "jdoe"})
+
+	succeed := auditLog.Tool(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(`{"documentIdentifier":"42"}`), nil
+	})
+	fail := auditLog.Tool(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return nil, errors.New("lynx is down")
+	})
+
+	call := func(handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), ctx context.Context, arguments map[string]any) {
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "attachment_upload"
+		request.Params.Arguments = arguments
+		handler(ctx, request)
+	}
+
+	call(succeed, ctx, map[string]any{"fileIdentifier": "F1", "binary": "aGVsbG8="})
+	call(fail, ctx, map[string]any{"fileReference": "REF2"})
+	call(succeed, context.Background(), map[string]any{"fileIdentifier": "F1"})
+
+	entries, err := auditLog.Query(Filter{File: "F1", Identity: "jdoe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+
+	binary, _ := entries[0].Arguments["binary"].(map[string]any)
+	tests := []struct {
+		name     string
+		got      any
+		expected any
+	}{
+		{"identity", entries[0].Identity, "jdoe"},
+		{"lynx user", entries[0].LynxUser, "service"},
+		{"result", entries[0].Result, RESULT_SUCCESS},
+		{"response", entries[0].Response, `{"documentIdentifier":"42"}`},
+		{"binary size", binary["size"], float64(5)},
+		{"binary hash", binary["sha256"], "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, tt.got)
+			}
+		})
+	}
+
+	entries, err = auditLog.Query(Filter{File: "REF2"})
+	if err != nil || len(entries) != 1 || entries[0].Error != "lynx is down" {
+		t.Errorf("expected the failed call, got %+v, %v", entries, err)
+	}
+
+	if entries, _ := auditLog.Query(Filter{From: time.Now().Add(time.Hour)}); len(entries) != 0 {
+		t.Errorf("expected no entries after now, got %d", len(entries))
+	}
+}
+
+func TestMiddlewareRecordsBodyDigest(t *testing.T) {
+	auditLog, err := Open(filepath.Join(t.TempDir(), "audit.jsonl"), "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	handler := auditLog.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buffer := make([]byte, 64)
+		for {
+			if _, err := r.Body.Read(buffer); err != nil {
+				break
+			}
+		}
+		http.Error(w, "Failed to upload", http.StatusBadGateway)
+	}))
+
+	request := httptest.NewRequest(http.MethodPost, "/attachmentUpload?fileId=F9", strings.NewReader("hello"))
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	entries, err := auditLog.Query(Filter{File: "F9"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %+v, %v", entries, err)
+	}
+
+	body, _ := entries[0].Arguments["body"].(map[string]any)
+	if body["size"] != float64(5) || entries[0].Status != http.StatusBadGateway || entries[0].Result != RESULT_ERROR || entries[0].Error != "Failed to upload" {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+}
@@ -0,0 +1,168 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Tool wraps a write tool handler so each call is recorded once it ran
+func (l *Log) Tool(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		start := time.Now()
+		result, err := next(ctx, request)
+
+		arguments := request.GetArguments()
+		entry := l.newEntry(ctx, SOURCE_TOOL, request.Params.Name, start)
+		entry.Arguments = sanitizeArguments(arguments)
+		entry.FileReference = stringArgument(arguments["fileReference"])
+		entry.FileIdentifier = stringArgument(arguments["fileIdentifier"])
+		entry.TransactionIdentifier = stringArgument(arguments["transactionIdentifier"])
+
+		switch {
+		case err != nil:
+			entry.Result = RESULT_ERROR
+			entry.Error = err.Error()
+		case result != nil && result.IsError:
+			entry.Result = RESULT_ERROR
+			entry.Error = resultText(result)
+		default:
+			entry.Result = RESULT_SUCCESS
+			entry.Response = resultText(result)
+		}
+
+		if recordErr := l.Record(entry); recordErr != nil {
+			slog.ErrorContext(ctx, "Failed to audit tool call", "tool", request.Params.Name, "error", recordErr)
+		}
+
+		return result, err
+	}
+}
+
+// Middleware records the calls of a REST write endpoint, the body is recorded by size and SHA-256
+func (l *Log) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		body := &digestReader{ReadCloser: r.Body, hash: sha256.New()}
+		r.Body = body
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		query := r.URL.Query()
+		arguments := make(map[string]any, len(query)+1)
+		for key := range query {
+			arguments[key] = query.Get(key)
+		}
+		var sum [sha256.Size]byte
+		copy(sum[:], body.hash.Sum(nil))
+		arguments["body"] = digest(body.size, sum)
+
+		entry := l.newEntry(r.Context(), SOURCE_REST, r.Method+" "+r.URL.Path, start)
+		entry.Arguments = arguments
+		entry.FileReference = query.Get("fileReference")
+		entry.FileIdentifier = query.Get("fileId")
+		entry.TransactionIdentifier = query.Get("transactionIdentifier")
+		entry.Status = recorder.status
+
+		if recorder.status >= http.StatusBadRequest {
+			entry.Result = RESULT_ERROR
+			entry.Error = strings.TrimSpace(recorder.body.String())
+		} else {
+			entry.Result = RESULT_SUCCESS
+			entry.Response = recorder.body.String()
+		}
+
+		if err := l.Record(entry); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to audit request", "method", r.Method, "path", r.URL.Path, "error", err)
+		}
+	})
+}
+
+// newEntry fills in who made the call and how long it took
+func (l *Log) newEntry(ctx context.Context, source string, action string, start time.Time) Entry {
+	entry := Entry{
+		Time:       start.UTC(),
+		Identity:   auth.IdentityFromContext(ctx),
+		LynxUser:   l.serviceAccount,
+		Source:     source,
+		Action:     action,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+
+	if credentials, ok := utils.CredentialsFromContext(ctx); ok {
+		entry.LynxUser = credentials.Username
+	}
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		entry.SessionID = session.SessionID()
+	}
+
+	return entry
+}
+
+func stringArgument(value any) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func resultText(result *mcp.CallToolResult) string {
+	if result == nil {
+		return ""
+	}
+
+	var text strings.Builder
+	for _, content := range result.Content {
+		if textContent, ok := content.(mcp.TextContent); ok {
+			text.WriteString(textContent.Text)
+		}
+	}
+	return text.String()
+}
+
+// digestReader hashes the request body as the handler reads it
+type digestReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	size int64
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	d.hash.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+// responseRecorder keeps the status and the start of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   strings.Builder
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if room := MAX_RESPONSE_LENGTH + 1 - r.body.Len(); room > 0 {
+		r.body.Write(p[:min(len(p), room)])
+	}
+	return r.ResponseWriter.Write(p)
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const TOKEN_SIZE = 32
+
+// GenerateToken returns a new random bearer token
+func GenerateToken() (string, error) {
+	token := make([]byte, TOKEN_SIZE)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// Command runs the tokens subcommand, printing new tokens and the hashes to register in server.tokens
+func Command(name string, args []string) error {
+	flags := flag.NewFlagSet(name+" tokens", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `Usage: %s tokens COMMAND
+
+Commands:
+  generate  Print a new token and its hash, only the hash goes into server.tokens
+  hash      Print the hash of a token read from stdin
+`, name)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch flags.Arg(0) {
+	case "generate":
+		token, err := GenerateToken()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("token: %s\nhash: %s\n", token, HashToken(token))
+		return nil
+
+	case "hash":
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read token from stdin: %w", err)
+		}
+		token := strings.TrimSpace(line)
+		if token == "" {
+			return fmt.Errorf("token is empty")
+		}
+		fmt.Println(HashToken(token))
+		return nil
+	}
+
+	flags.Usage()
+	return flag.ErrHelp
+}
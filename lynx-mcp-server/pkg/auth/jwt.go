@@ -0,0 +1,388 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+const (
+	ALG_RS256 = "RS256"
+	ALG_ES256 = "ES256"
+	ALG_HS256 = "HS256"
+
+	// JWT_TOKEN_NAME names tokens authenticated as JWTs, their identity comes from the claims
+	JWT_TOKEN_NAME = "jwt"
+)
+
+var (
+	ErrInvalidJWT = errors.New("invalid JWT")
+
+	// ErrUnknownSignature is an invalid JWT none of the keys verifies, another verifier may know its key
+	ErrUnknownSignature = fmt.Errorf("%w: no key verifies the signature", ErrInvalidJWT)
+)
+
+// jwtKey verifies the signatures of one algorithm
+type jwtKey struct {
+	id        string
+	algorithm string
+	rsa       *rsa.PublicKey
+	ecdsa     *ecdsa.PublicKey
+	secret    []byte
+}
+
+// JWTVerifier checks the signature and the registered claims of JWT bearer tokens and maps the other claims
+// to scopes, allowed tools and the staff identity
+type JWTVerifier struct {
+	keys          []jwtKey
+	issuer        string
+	audience      string
+	leeway        time.Duration
+	scopesClaim   string
+	toolsClaim    string
+	identityClaim string
+	now           func() time.Time
+}
+
+// NewJWTVerifier loads the keys of the JWKS file, the PEM public keys and the HS256 secret
+func NewJWTVerifier(jwtConfig config.JWTConfig) (*JWTVerifier, error) {
+	verifier := &JWTVerifier{
+		issuer:        jwtConfig.Issuer,
+		audience:      jwtConfig.Audience,
+		leeway:        jwtConfig.Leeway,
+		scopesClaim:   jwtConfig.ScopesClaim,
+		toolsClaim:    jwtConfig.ToolsClaim,
+		identityClaim: jwtConfig.IdentityClaim,
+		now:           time.Now,
+	}
+
+	if jwtConfig.JWKSFile != "" {
+		keys, err := loadJWKS(jwtConfig.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		verifier.keys = append(verifier.keys, keys...)
+	}
+
+	for _, keyConfig := range jwtConfig.Keys {
+		key, err := loadPublicKey(keyConfig.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key.id = keyConfig.ID
+		verifier.keys = append(verifier.keys, key)
+	}
+
+	if jwtConfig.Secret != "" {
+		verifier.keys = append(verifier.keys, jwtKey{algorithm: ALG_HS256, secret: []byte(jwtConfig.Secret)})
+	}
+
+	if len(verifier.keys) == 0 {
+		return nil, fmt.Errorf("no JWT verification key configured")
+	}
+
+	return verifier, nil
+}
+
+// jwtHeader is the JOSE header of a JWT
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Verify checks the token and returns it as a Token with the scopes, tools and identity of its claims
+func (v *JWTVerifier) Verify(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 parts", ErrInvalidJWT)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidJWT, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidJWT, err)
+	}
+
+	// The algorithm of the key decides, a token can't pick HS256 to be checked against a public key
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range v.keys {
+		if key.algorithm != header.Algorithm || (header.KeyID != "" && key.id != "" && key.id != header.KeyID) {
+			continue
+		}
+		if key.verify(signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w of algorithm %q", ErrUnknownSignature, header.Algorithm)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidJWT, err)
+	}
+
+	now := v.now()
+
+	expiresAt, ok := numericDate(claims["exp"])
+	if !ok {
+		return nil, fmt.Errorf("%w: exp claim is required", ErrInvalidJWT)
+	}
+	if now.After(expiresAt.Add(v.leeway)) {
+		return nil, fmt.Errorf("%w: expired at %s", ErrTokenExpired, expiresAt.Format(time.RFC3339))
+	}
+
+	if notBefore, ok := numericDate(claims["nbf"]); ok && now.Add(v.leeway).Before(notBefore) {
+		return nil, fmt.Errorf("%w: not valid before %s", ErrInvalidJWT, notBefore.Format(time.RFC3339))
+	}
+
+	if issuer, _ := claims["iss"].(string); v.issuer != "" && issuer != v.issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidJWT, issuer)
+	}
+
+	audiences := stringList(claims["aud"])
+	if audience, ok := claims["aud"].(string); ok {
+		audiences = []string{audience}
+	}
+	if v.audience != "" && !slices.Contains(audiences, v.audience) {
+		return nil, fmt.Errorf("%w: audience %q not accepted", ErrInvalidJWT, v.audience)
+	}
+
+	identity, _ := claims[v.identityClaim].(string)
+	if identity == "" {
+		return nil, fmt.Errorf("%w: %s claim is required", ErrInvalidJWT, v.identityClaim)
+	}
+
+	token := &Token{
+		Name:      JWT_TOKEN_NAME,
+		Identity:  identity,
+		Tools:     stringList(claims[v.toolsClaim]),
+		ExpiresAt: expiresAt,
+	}
+
+	// Unknown scopes are ignored, the issuer may grant scopes meant for other services
+	for _, scope := range stringList(claims[v.scopesClaim]) {
+		switch Scope(scope) {
+		case SCOPE_READ, SCOPE_WRITE, SCOPE_UPLOAD, SCOPE_ADMIN, SCOPE_METRICS, SCOPE_APPROVE:
+			token.Scopes = append(token.Scopes, Scope(scope))
+		}
+	}
+
+	return token, nil
+}
+
+// SignHS256 returns a JWT of the claims signed with the secret
+func SignHS256(claims map[string]any, secret []byte) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": ALG_HS256, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWT claims: %w", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (k jwtKey) verify(signed []byte, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch k.algorithm {
+	case ALG_RS256:
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest[:], signature) == nil
+	case ALG_ES256:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k.ecdsa, digest[:], r, s)
+	case ALG_HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+
+	return false
+}
+
+// jwk is one key of a JWKS file, only the members used for RS256, ES256 and HS256
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	K         string `json:"k"`
+}
+
+func loadJWKS(path string) ([]jwtKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file %s: %w", path, err)
+	}
+
+	var keys []jwtKey
+	for i, entry := range set.Keys {
+		if entry.Use != "" && entry.Use != "sig" {
+			continue
+		}
+
+		key, err := entry.parse()
+		if err != nil {
+			return nil, fmt.Errorf("JWKS file %s key %d: %w", path, i, err)
+		}
+		if entry.Algorithm != "" && entry.Algorithm != key.algorithm {
+			return nil, fmt.Errorf("JWKS file %s key %d: unsupported algorithm %s", path, i, entry.Algorithm)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (j jwk) parse() (jwtKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch j.KeyType {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return jwtKey{}, fmt.Errorf("invalid n: %w", err)
+		}
+		e, err := decode(j.E)
+		if err != nil || len(e) > 4 {
+			return jwtKey{}, fmt.Errorf("invalid e")
+		}
+		return jwtKey{
+			id:        j.KeyID,
+			algorithm: ALG_RS256,
+			rsa:       &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())},
+		}, nil
+
+	case "EC":
+		if j.Curve != "P-256" {
+			return jwtKey{}, fmt.Errorf("unsupported curve %s", j.Curve)
+		}
+		x, errX := decode(j.X)
+		y, errY := decode(j.Y)
+		if errX != nil || errY != nil {
+			return jwtKey{}, fmt.Errorf("invalid coordinates")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return jwtKey{}, fmt.Errorf("point is not on curve P-256")
+		}
+		return jwtKey{id: j.KeyID, algorithm: ALG_ES256, ecdsa: key}, nil
+
+	case "oct":
+		secret, err := decode(j.K)
+		if err != nil || len(secret) == 0 {
+			return jwtKey{}, fmt.Errorf("invalid k")
+		}
+		return jwtKey{id: j.KeyID, algorithm: ALG_HS256, secret: secret}, nil
+	}
+
+	return jwtKey{}, fmt.Errorf("unsupported key type %q", j.KeyType)
+}
+
+func loadPublicKey(path string) (jwtKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return jwtKey{}, fmt.Errorf("failed to read JWT public key: %w", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return jwtKey{}, fmt.Errorf("no PEM block in JWT public key %s", path)
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return jwtKey{}, fmt.Errorf("failed to parse JWT public key %s: %w", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PublicKey:
+		return jwtKey{algorithm: ALG_RS256, rsa: key}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return jwtKey{}, fmt.Errorf("JWT public key %s: only P-256 EC keys are supported", path)
+		}
+		return jwtKey{algorithm: ALG_ES256, ecdsa: key}, nil
+	}
+
+	return jwtKey{}, fmt.Errorf("JWT public key %s: unsupported key type %T", path, parsed)
+}
+
+func decodeSegment(segment string, target any) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, target)
+}
+
+// numericDate reads a NumericDate claim, seconds since the epoch
+func numericDate(value any) (time.Time, bool) {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// stringList reads a claim holding a string array, a single string or space separated scopes
+func stringList(value any) []string {
+	switch value := value.(type) {
+	case string:
+		return strings.Fields(value)
+	case []any:
+		var list []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+func TestJWTVerify(t *testing.T) {
+	directory := t.TempDir()
+	now := time.Now()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The RSA key comes from a JWKS file, the EC key from a PEM file
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "rsa-1",
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+	}}})
+	jwksFile := filepath.Join(directory, "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemFile := filepath.Join(directory, "ec.pem")
+	if err := os.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	jwtConfig := config.DefaultMCPServerConfig().JWT
+	jwtConfig.JWKSFile = jwksFile
+	jwtConfig.Keys = []config.JWTKeyConfig{{PublicKeyFile: pemFile}}
+	jwtConfig.Secret = "hmac-secret"
+	jwtConfig.Issuer = "https://sso.example.com"
+	jwtConfig.Audience = "lynx-mcp"
+	jwtConfig.IdentityClaim = "email"
+
+	verifier, err := NewJWTVerifier(jwtConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.now = func() time.Time { return now }
+
+	claims := func(changes map[string]any) map[string]any {
+		claims := map[string]any{
+			"iss":   "https://sso.example.com",
+			"aud":   []string{"other", "lynx-mcp"},
+			"exp":   now.Add(5 * time.Minute).Unix(),
+			"nbf":   now.Add(-time.Minute).Unix(),
+			"email": "jdoe@example.com",
+			"scope": "read write openid",
+			"tools": []string{"retrieve_itinerary"},
+		}
+		for key, value := range changes {
+			if value == nil {
+				delete(claims, key)
+			} else {
+				claims[key] = value
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"RS256 from JWKS", signJWT(t, "RS256", "rsa-1", rsaKey, claims(nil)), nil},
+		{"ES256 from PEM", signJWT(t, "ES256", "", ecKey, claims(nil)), nil},
+		{"HS256 secret", signJWT(t, "HS256", "", []byte("hmac-secret"), claims(nil)), nil},
+		{"single audience", signJWT(t, "HS256", "", []byte("hmac-secret"), claims(map[string]any{"aud": "lynx-mcp"})), nil},
+		{"within leeway", signJWT(t, "HS256", "", []byte("hmac-secret"), claims(map[string]any{"exp": now.Add(-10 * time.Second).Unix()})), nil},
+		{"expired", signJWT(t, "HS256", "", []byte("hmac-secret"), claims(map[string]any{"exp": now.Add(-time.Minute).Unix()})), ErrTokenExpired},
+		{"missing exp", signJWT(t, "HS256", "", []byte("hmac-secret"), claims(map[string]any{"exp": nil})), ErrInvalidJWT},
+		{"not yet valid", signJWT(t, "HS256", "", []byte("hmac-secret"), claims(map[string]any{"nbf": now.Add(time.Minute).Unix()})), ErrInvalidJWT},
+		{"wrong issuer", signJWT(t, "HS256", "", []byte("hmac-secret"), claims(map[string]any{"iss": "https://evil.example.com"})), ErrInvalidJWT},
+		{"wrong audience", signJWT(t, "HS256", "", []byte("hmac-secret"), claims(map[string]any{"aud": "other"})), ErrInvalidJWT},
+		{"missing identity", signJWT(t, "HS256", "", []byte("hmac-secret"), claims(map[string]any{"email": nil})), ErrInvalidJWT},
+		{"wrong secret", signJWT(t, "HS256", "", []byte("guess"), claims(nil)), ErrInvalidJWT},
+		{"unknown RSA key", signJWT(t, "RS256", "rsa-1", otherKey, claims(nil)), ErrInvalidJWT},
+		{"alg none", signJWT(t, "none", "", nil, claims(nil)), ErrInvalidJWT},
+		{"not a JWT", "a.b", ErrInvalidJWT},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := verifier.Verify(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if token.Identity != "jdoe@example.com" {
+				t.Errorf("unexpected identity %q", token.Identity)
+			}
+			if !slices.Equal(token.Scopes, []Scope{SCOPE_READ, SCOPE_WRITE}) || !slices.Equal(token.Tools, []string{"retrieve_itinerary"}) {
+				t.Errorf("unexpected scopes %v or tools %v", token.Scopes, token.Tools)
+			}
+		})
+	}
+
+	// Registered tokens are checked first, JWTs only when no hash matches
+	registry, err := NewRegistry(config.MCPServerConfig{BearerToken: "legacy", JWT: jwtConfig}, testToolScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.verifiers[0].Verifier.(*JWTVerifier).now = verifier.now
+	token, err := registry.Authenticate(signJWT(t, "ES256", "", ecKey, claims(map[string]any{"tools": []string{"search", "ingest"}})))
+	if err != nil || token.Name != JWT_TOKEN_NAME || !registry.CanCallTool(token, "search") || registry.CanCallTool(token, "save") || registry.CanCallTool(token, "ingest") {
+		t.Errorf("unexpected JWT token %+v, %v", token, err)
+	}
+}
+
+func signJWT(t *testing.T, algorithm string, keyID string, key any, claims map[string]any) string {
+	t.Helper()
+
+	header := map[string]string{"alg": algorithm, "typ": "JWT"}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	encode := func(value any) string {
+		content, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(content)
+	}
+
+	signed := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
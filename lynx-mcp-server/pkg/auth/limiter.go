@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket allowing a burst of limit requests, refilled over period
+type Limiter struct {
+	mu       sync.Mutex
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+// NewLimiter creates a full bucket of limit requests per period
+func NewLimiter(limit int, period time.Duration) *Limiter {
+	return &Limiter{
+		capacity: float64(limit),
+		rate:     float64(limit) / period.Seconds(),
+		tokens:   float64(limit),
+	}
+}
+
+// Allow takes one request from the bucket, or returns how long until one is available
+func (l *Limiter) Allow(now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+
+	return false, l.wait()
+}
+
+// Wait returns how long until a request is available without taking it, zero when one is
+func (l *Limiter) Wait(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+
+	if l.tokens >= 1 {
+		return 0
+	}
+	return l.wait()
+}
+
+// Full reports whether the bucket refilled completely, it then behaves like a new one
+func (l *Limiter) Full(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	return l.tokens >= l.capacity
+}
+
+// refill adds the requests earned since the last call, the caller holds the lock
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens = min(l.capacity, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+// wait returns how long until one request is available, the caller holds the lock
+func (l *Limiter) wait() time.Duration {
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
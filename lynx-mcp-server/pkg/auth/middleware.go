@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Middleware authenticates the bearer token and applies its rate limit, the token is stored in the request context
+func (r *Registry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authHeader := req.Header.Get(utils.AUTHORIZATION_HEADER)
+		if authHeader == "" {
+			r.unauthorized(w, "Authorization header required")
+			return
+		}
+
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			r.unauthorized(w, "Invalid authorization format")
+			return
+		}
+
+		token, err := r.Authenticate(strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer ")))
+		if err != nil {
+			slog.WarnContext(req.Context(), "Rejected bearer token", "method", req.Method, "path", req.URL.Path, "error", err)
+			if errors.Is(err, ErrTokenExpired) {
+				r.unauthorized(w, "Token expired")
+			} else {
+				r.unauthorized(w, "Invalid token")
+			}
+			return
+		}
+
+		if ok, retryAfter := r.Allow(token); !ok {
+			slog.WarnContext(req.Context(), "Rate limited", "method", req.Method, "path", req.URL.Path, "token", token.Name)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(WithToken(req.Context(), token)))
+	})
+}
+
+// unauthorized answers 401, pointing OAuth clients to the protected resource metadata when available
+func (r *Registry) unauthorized(w http.ResponseWriter, message string) {
+	if r.resourceMetadataURL != "" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer resource_metadata="%s"`, r.resourceMetadataURL))
+	}
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// Require wraps a route so only tokens with every scope reach it, it must run behind Middleware
+func Require(handler http.Handler, scopes ...Scope) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, ok := TokenFromContext(req.Context())
+		if !ok {
+			http.Error(w, "Authorization header required", http.StatusUnauthorized)
+			return
+		}
+
+		if !token.HasScopes(scopes) {
+			slog.WarnContext(req.Context(), "Forbidden", "method", req.Method, "path", req.URL.Path, "token", token.Name, "scopes", scopes)
+			http.Error(w, fmt.Sprintf("Token %s lacks scope %v", token.Name, scopes), http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// RequireFunc is Require for handler functions
+func RequireFunc(handler http.HandlerFunc, scopes ...Scope) http.HandlerFunc {
+	return Require(handler, scopes...).ServeHTTP
+}
+
+// FilterTools hides the tools the token of the request can't call from tools/list
+func (r *Registry) FilterTools(ctx context.Context, tools []mcp.Tool) []mcp.Tool {
+	token, ok := TokenFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	allowed := make([]mcp.Tool, 0, len(tools))
+	for _, tool := range tools {
+		if r.CanCallTool(token, tool.Name) {
+			allowed = append(allowed, tool)
+		}
+	}
+	return allowed
+}
+
+// ToolMiddleware rejects tools/call for tools the token of the request can't call
+func (r *Registry) ToolMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		token, ok := TokenFromContext(ctx)
+		if !ok {
+			return nil, fmt.Errorf("%w: unauthenticated tool call %s", ErrForbidden, request.Params.Name)
+		}
+
+		if !r.CanCallTool(token, request.Params.Name) {
+			slog.WarnContext(ctx, "Forbidden tool call", "tool", request.Params.Name, "token", token.Name)
+			return nil, fmt.Errorf("%w: token %s can't call %s", ErrForbidden, token.Name, request.Params.Name)
+		}
+
+		return next(ctx, request)
+	}
+}
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+// Scope grants access to a group of tools and routes
+type Scope string
+
+const (
+	SCOPE_READ    Scope = "read"
+	SCOPE_WRITE   Scope = "write"
+	SCOPE_UPLOAD  Scope = "upload"
+	SCOPE_ADMIN   Scope = "admin"
+	SCOPE_METRICS Scope = "metrics" // Only /metrics, for Prometheus scrapers
+	SCOPE_APPROVE Scope = "approve" // Approving pending write actions, for people rather than agents
+
+	HASH_PREFIX = "sha256:"
+
+	// DEFAULT_TOKEN_NAME is the name of the BEARER_TOKEN, it keeps every scope
+	DEFAULT_TOKEN_NAME = "default"
+
+	// IDENTITY_LIMITERS_SWEEP_SIZE is the number of identity rate limits past which the refilled ones are dropped
+	IDENTITY_LIMITERS_SWEEP_SIZE = 1000
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrRateLimited  = errors.New("rate limit exceeded")
+	ErrForbidden    = errors.New("token is not allowed")
+)
+
+// Token is an authenticated bearer token, the token itself is never kept.
+// Identity is the staff member or client acting with it, carried into logs and saved documents.
+type Token struct {
+	Name      string
+	Identity  string
+	Scopes    []Scope
+	Tools     []string
+	ExpiresAt time.Time
+
+	hash    []byte
+	limiter *Limiter
+}
+
+// HasScope reports whether the token was granted the scope, admin implies every scope
+func (t *Token) HasScope(scope Scope) bool {
+	return slices.Contains(t.Scopes, SCOPE_ADMIN) || slices.Contains(t.Scopes, scope)
+}
+
+// HasScopes reports whether the token was granted every scope
+func (t *Token) HasScopes(scopes []Scope) bool {
+	for _, scope := range scopes {
+		if !t.HasScope(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsTool reports whether the tool is on the token's allow-list, an empty list allows every tool
+func (t *Token) AllowsTool(name string) bool {
+	return len(t.Tools) == 0 || slices.Contains(t.Tools, name)
+}
+
+// HashToken returns the hash of a token as written in the configuration
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return HASH_PREFIX + hex.EncodeToString(sum[:])
+}
+
+// Verifier authenticates bearer tokens that aren't registered, such as JWTs
+type Verifier interface {
+	Verify(token string) (*Token, error)
+}
+
+// limitedVerifier is a verifier whose tokens get a rate limit of rateLimit requests per minute and identity,
+// none when zero
+type limitedVerifier struct {
+	Verifier
+	rateLimit int
+}
+
+// Registry authenticates bearer tokens and checks what they are allowed to do
+type Registry struct {
+	tokens              []*Token
+	verifiers           []limitedVerifier
+	toolScopes          map[string][]Scope
+	resourceMetadataURL string
+	now                 func() time.Time
+
+	mu               sync.Mutex
+	identityLimiters map[string]*Limiter // by verifier and identity, verified tokens are new at each request
+}
+
+// NewRegistry registers the configured tokens, plus BEARER_TOKEN with every scope when set, and accepts JWTs
+// when server.jwt is configured.
+// toolScopes lists the scopes needed by each tool, tools missing from it are only available to admin tokens.
+func NewRegistry(serverConfig config.MCPServerConfig, toolScopes map[string][]Scope) (*Registry, error) {
+	registry := &Registry{
+		toolScopes:       toolScopes,
+		now:              time.Now,
+		identityLimiters: make(map[string]*Limiter),
+	}
+
+	if serverConfig.BearerToken != "" {
+		registry.tokens = append(registry.tokens, &Token{
+			Name:     DEFAULT_TOKEN_NAME,
+			Identity: DEFAULT_TOKEN_NAME,
+			Scopes:   []Scope{SCOPE_ADMIN},
+			hash:     hashBytes(strings.TrimSpace(serverConfig.BearerToken)),
+		})
+	}
+
+	for _, tokenConfig := range serverConfig.Tokens {
+		hash, err := hex.DecodeString(strings.TrimPrefix(tokenConfig.Hash, HASH_PREFIX))
+		if err != nil || !strings.HasPrefix(tokenConfig.Hash, HASH_PREFIX) || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("token %s: invalid hash", tokenConfig.Name)
+		}
+
+		token := &Token{
+			Name:      tokenConfig.Name,
+			Identity:  tokenConfig.Name,
+			Tools:     tokenConfig.Tools,
+			ExpiresAt: tokenConfig.ExpiresAt,
+			hash:      hash,
+		}
+
+		for _, scope := range tokenConfig.Scopes {
+			switch Scope(scope) {
+			case SCOPE_READ, SCOPE_WRITE, SCOPE_UPLOAD, SCOPE_ADMIN, SCOPE_METRICS, SCOPE_APPROVE:
+				token.Scopes = append(token.Scopes, Scope(scope))
+			default:
+				return nil, fmt.Errorf("token %s: unknown scope %q, expected read, write, upload, admin, metrics or approve", tokenConfig.Name, scope)
+			}
+		}
+
+		for _, tool := range tokenConfig.Tools {
+			if _, ok := toolScopes[tool]; !ok {
+				return nil, fmt.Errorf("token %s: unknown tool %q", tokenConfig.Name, tool)
+			}
+		}
+
+		if tokenConfig.RateLimit > 0 {
+			token.limiter = NewLimiter(tokenConfig.RateLimit, time.Minute)
+		}
+
+		registry.tokens = append(registry.tokens, token)
+	}
+
+	if serverConfig.JWT.Enabled() {
+		verifier, err := NewJWTVerifier(serverConfig.JWT)
+		if err != nil {
+			return nil, err
+		}
+		registry.AddVerifier(verifier, serverConfig.JWT.RateLimit)
+	}
+
+	return registry, nil
+}
+
+// Authenticate returns the token matching the presented one. Every registered hash is compared in constant time
+// so the response time doesn't reveal which token came close. Unregistered JWTs go through the verifiers.
+func (r *Registry) Authenticate(presented string) (*Token, error) {
+	hash := hashBytes(presented)
+
+	var found *Token
+	for _, token := range r.tokens {
+		if subtle.ConstantTimeCompare(hash, token.hash) == 1 {
+			found = token
+		}
+	}
+
+	if found == nil {
+		if strings.Count(presented, ".") != 2 {
+			return nil, ErrInvalidToken
+		}
+
+		// The verifier holding the signing key decides, the others don't recognise the signature
+		err := ErrInvalidToken
+		for i, verifier := range r.verifiers {
+			token, verifyErr := verifier.Verify(presented)
+			if verifyErr == nil {
+				token.limiter = r.identityLimiter(i, verifier.rateLimit, token.Identity)
+				return token, nil
+			}
+			err = verifyErr
+			if !errors.Is(verifyErr, ErrUnknownSignature) {
+				break
+			}
+		}
+		return nil, err
+	}
+
+	now := r.now()
+	if !found.ExpiresAt.IsZero() && now.After(found.ExpiresAt) {
+		return nil, fmt.Errorf("%w: %s expired at %s", ErrTokenExpired, found.Name, found.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return found, nil
+}
+
+// AddVerifier accepts the tokens of another issuer, such as the built-in OAuth authorization server, each identity
+// may make rateLimit requests per minute, without limit when zero
+func (r *Registry) AddVerifier(verifier Verifier, rateLimit int) {
+	r.verifiers = append(r.verifiers, limitedVerifier{Verifier: verifier, rateLimit: rateLimit})
+}
+
+// identityLimiter returns the rate limit shared by the tokens of an identity verified by the verifier at index
+func (r *Registry) identityLimiter(index int, rateLimit int, identity string) *Limiter {
+	if rateLimit <= 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := fmt.Sprintf("%d\x00%s", index, identity)
+	limiter, ok := r.identityLimiters[key]
+	if ok {
+		return limiter
+	}
+
+	// Identities that stopped calling don't pile up
+	if len(r.identityLimiters) >= IDENTITY_LIMITERS_SWEEP_SIZE {
+		now := r.now()
+		for key, limiter := range r.identityLimiters {
+			if limiter.Full(now) {
+				delete(r.identityLimiters, key)
+			}
+		}
+	}
+
+	limiter = NewLimiter(rateLimit, time.Minute)
+	r.identityLimiters[key] = limiter
+	return limiter
+}
+
+// SetResourceMetadata advertises the OAuth protected resource metadata in the WWW-Authenticate header of 401 responses
+func (r *Registry) SetResourceMetadata(url string) {
+	r.resourceMetadataURL = url
+}
+
+// Allow takes one request from the token's rate limit, it returns how long to wait when exhausted
+func (r *Registry) Allow(token *Token) (bool, time.Duration) {
+	if token.limiter == nil {
+		return true, 0
+	}
+	return token.limiter.Allow(r.now())
+}
+
+// CanCallTool reports whether the token has the scopes of the tool and the tool is on its allow-list
+func (r *Registry) CanCallTool(token *Token, name string) bool {
+	if !token.AllowsTool(name) {
+		return false
+	}
+
+	scopes, ok := r.toolScopes[name]
+	if !ok {
+		return token.HasScope(SCOPE_ADMIN)
+	}
+
+	return token.HasScopes(scopes)
+}
+
+type tokenContextKey struct{}
+
+// WithToken returns a context carrying the authenticated token
+func WithToken(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, token)
+}
+
+// TokenFromContext returns the authenticated token of the request, if any
+func TokenFromContext(ctx context.Context) (*Token, bool) {
+	token, ok := ctx.Value(tokenContextKey{}).(*Token)
+	return token, ok && token != nil
+}
+
+// IdentityFromContext returns the identity of the authenticated token of the request, empty when unauthenticated
+func IdentityFromContext(ctx context.Context) string {
+	if token, ok := TokenFromContext(ctx); ok {
+		return token.Identity
+	}
+	return ""
+}
+
+func hashBytes(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var testToolScopes = map[string][]Scope{
+	"search": {SCOPE_READ},
+	"save":   {SCOPE_WRITE},
+	"ingest": {SCOPE_WRITE, SCOPE_UPLOAD},
+}
+
+func newTestRegistry(t *testing.T, now time.Time) *Registry {
+	registry, err := NewRegistry(config.MCPServerConfig{
+		BearerToken: "legacy",
+		Tokens: []config.TokenConfig{
+			{Name: "extension", Hash: HashToken("extension-token"), Scopes: []string{"read", "upload"}},
+			{Name: "n8n", Hash: HashToken("n8n-token"), Scopes: []string{"write", "upload"}, Tools: []string{"ingest"}, RateLimit: 2},
+			{Name: "expired", Hash: HashToken("expired-token"), Scopes: []string{"admin"}, ExpiresAt: now.Add(-time.Hour)},
+		},
+	}, testToolScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.now = func() time.Time { return now }
+	return registry
+}
+
+func TestAuthenticate(t *testing.T) {
+	registry := newTestRegistry(t, time.Now())
+
+	tests := []struct {
+		name     string
+		token    string
+		expected string
+		err      error
+	}{
+		{"legacy bearer token", "legacy", DEFAULT_TOKEN_NAME, nil},
+		{"registered token", "n8n-token", "n8n", nil},
+		{"unknown token", "n8n-token2", "", ErrInvalidToken},
+		{"empty token", "", "", ErrInvalidToken},
+		{"expired token", "expired-token", "", ErrTokenExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := registry.Authenticate(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err == nil && token.Name != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, token.Name)
+			}
+		})
+	}
+}
+
+func TestCanCallTool(t *testing.T) {
+	registry := newTestRegistry(t, time.Now())
+
+	tests := []struct {
+		token    string
+		tool     string
+		expected bool
+	}{
+		{"legacy", "save", true},
+		{"legacy", "unregistered", true},
+		{"extension-token", "search", true},
+		{"extension-token", "save", false},
+		{"extension-token", "unregistered", false},
+		{"n8n-token", "ingest", true},
+		{"n8n-token", "save", false},
+		{"n8n-token", "search", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.token+" "+tt.tool, func(t *testing.T) {
+			token, err := registry.Authenticate(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := registry.CanCallTool(token, tt.tool); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	token, _ := registry.Authenticate("extension-token")
+	tools := registry.FilterTools(WithToken(context.Background(), token), []mcp.Tool{{Name: "search"}, {Name: "save"}, {Name: "ingest"}})
+	if len(tools) != 1 || tools[0].Name != "search" {
+		t.Errorf("expected only search, got %v", tools)
+	}
+	if tools := registry.FilterTools(context.Background(), []mcp.Tool{{Name: "search"}}); len(tools) != 0 {
+		t.Errorf("expected no tools without a token, got %v", tools)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Now()
+	registry := newTestRegistry(t, now)
+
+	handler := registry.Middleware(Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), SCOPE_UPLOAD))
+
+	tests := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{"missing header", "", http.StatusUnauthorized},
+		{"basic auth", "Basic abc", http.StatusUnauthorized},
+		{"invalid token", "Bearer nope", http.StatusUnauthorized},
+		{"expired token", "Bearer expired-token", http.StatusUnauthorized},
+		{"admin implies every scope", "Bearer legacy", http.StatusNoContent},
+		{"scope granted", "Bearer extension-token", http.StatusNoContent},
+		{"rate limit 1", "Bearer n8n-token", http.StatusNoContent},
+		{"rate limit 2", "Bearer n8n-token", http.StatusNoContent},
+		{"rate limit exceeded", "Bearer n8n-token", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/attachments", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != tt.expected {
+				t.Errorf("expected %d, got %d: %s", tt.expected, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	// Two requests a minute, one is available again after 30 seconds
+	registry.now = func() time.Time { return now.Add(31 * time.Second) }
+	request := httptest.NewRequest(http.MethodPost, "/attachments", nil)
+	request.Header.Set("Authorization", "Bearer n8n-token")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("expected the rate limit to refill, got %d", recorder.Code)
+	}
+
+	readOnly := registry.Middleware(Require(http.NotFoundHandler(), SCOPE_WRITE))
+	request = httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", "Bearer extension-token")
+	recorder = httptest.NewRecorder()
+	readOnly.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without the write scope, got %d", recorder.Code)
+	}
+}
+
+// identityVerifier accepts the JWTs of its identities, by token
+type identityVerifier map[string]string
+
+func (v identityVerifier) Verify(token string) (*Token, error) {
+	identity, ok := v[token]
+	if !ok {
+		return nil, ErrUnknownSignature
+	}
+	return &Token{Name: JWT_TOKEN_NAME, Identity: identity, Scopes: []Scope{SCOPE_READ}}, nil
+}
+
+func TestVerifiedRateLimit(t *testing.T) {
+	now := time.Now()
+	registry := newTestRegistry(t, now)
+	registry.AddVerifier(identityVerifier{"a.jdoe.1": "jdoe", "a.jdoe.2": "jdoe", "a.asmith.1": "asmith"}, 2)
+
+	tests := []struct {
+		name     string
+		token    string
+		expected bool
+	}{
+		{"first request", "a.jdoe.1", true},
+		{"another token of the identity", "a.jdoe.2", true},
+		{"limit of the identity exceeded", "a.jdoe.1", false},
+		{"other identity", "a.asmith.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := registry.Authenticate(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed, _ := registry.Allow(token); allowed != tt.expected {
+				t.Errorf("Allow() = %v, want %v", allowed, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNewRegistryRejectsUnknownScopesAndTools(t *testing.T) {
+	for _, token := range []config.TokenConfig{
+		{Name: "scope", Hash: HashToken("a"), Scopes: []string{"delete"}},
+		{Name: "tool", Hash: HashToken("a"), Scopes: []string{"read"}, Tools: []string{"drop"}},
+		{Name: "hash", Hash: "sha256:zz", Scopes: []string{"read"}},
+	} {
+		if _, err := NewRegistry(config.MCPServerConfig{Tokens: []config.TokenConfig{token}}, testToolScopes); err == nil {
+			t.Errorf("%s: expected an error", token.Name)
+		}
+	}
+}
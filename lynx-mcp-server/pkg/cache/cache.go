@@ -0,0 +1,321 @@
+// Package cache keeps the results of read tools for a while, agents retrieve the same file many times in one
+// conversation. Results live in a memory LRU, and in a bbolt file across restarts when configured.
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// CLEANUP_INTERVAL is how often expired results are removed from the cache file
+const CLEANUP_INTERVAL = time.Minute
+
+var (
+	// entriesBucket holds the entries by key
+	entriesBucket = []byte("entries")
+	// filesBucket indexes the entries by file, its keys are the file identifier, a NUL byte and the entry key
+	filesBucket = []byte("files")
+)
+
+// Entry is a cached tool result, Files lists the Lynx files it describes
+type Entry struct {
+	Result    json.RawMessage `json:"result"`
+	Files     []string        `json:"files,omitempty"`
+	StoredAt  time.Time       `json:"storedAt"`
+	ExpiresAt time.Time       `json:"expiresAt"`
+}
+
+// Cache holds entries by key. The least recently used ones are evicted from memory beyond its maximum, the cache
+// file keeps every entry until it expires.
+type Cache struct {
+	maxEntries int
+
+	mu    sync.Mutex
+	lru   *list.List // Of *item, the most recently used first
+	items map[string]*list.Element
+	db    *bolt.DB
+
+	// generation counts the invalidations, a result fetched across one may predate the change
+	generation uint64
+}
+
+type item struct {
+	key   string
+	entry Entry
+}
+
+// New creates the cache of the configuration, opening its file when there is one
+func New(cacheConfig config.CacheConfig) (*Cache, error) {
+	c := &Cache{
+		maxEntries: cacheConfig.MaxEntries,
+		lru:        list.New(),
+		items:      make(map[string]*list.Element),
+	}
+	if cacheConfig.File == "" {
+		return c, nil
+	}
+
+	db, err := bolt.Open(cacheConfig.File, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache file: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{entriesBucket, filesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize cache file: %w", err)
+	}
+	c.db = db
+	return c, nil
+}
+
+// Get returns the entry of key unless it expired at now
+func (c *Cache) Get(key string, now time.Time) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		entry := element.Value.(*item).entry
+		if !now.Before(entry.ExpiresAt) {
+			c.removeLocked(element)
+			return Entry{}, false
+		}
+		c.lru.MoveToFront(element)
+		return entry, true
+	}
+
+	if c.db == nil {
+		return Entry{}, false
+	}
+	entry, err := c.load(key)
+	if err != nil {
+		slog.Warn("Failed to read cache file", "error", err)
+		return Entry{}, false
+	}
+	if entry == nil || !now.Before(entry.ExpiresAt) {
+		return Entry{}, false
+	}
+	c.addLocked(key, *entry)
+	return *entry, true
+}
+
+// Generation returns the current generation, to be given to PutSince once the result is fetched
+func (c *Cache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Put stores the entry of key, replacing the previous one
+func (c *Cache) Put(key string, entry Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.putLocked(key, entry)
+}
+
+// PutSince stores the entry of key unless a file was invalidated since generation: the result may have been fetched
+// before a save landed and would be stale
+func (c *Cache) PutSince(key string, entry Entry, generation uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return false
+	}
+	c.putLocked(key, entry)
+	return true
+}
+
+func (c *Cache) putLocked(key string, entry Entry) {
+	if element, ok := c.items[key]; ok {
+		c.removeLocked(element)
+	}
+	c.addLocked(key, entry)
+
+	if c.db == nil {
+		return
+	}
+	if err := c.store(key, entry); err != nil {
+		slog.Warn("Failed to write cache file", "error", err)
+	}
+}
+
+// InvalidateFile drops the entries describing a Lynx file, once it changed
+func (c *Cache) InvalidateFile(fileIdentifier string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if slices.Contains(element.Value.(*item).entry.Files, fileIdentifier) {
+			c.removeLocked(element)
+		}
+		element = next
+	}
+
+	if c.db == nil {
+		return
+	}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		prefix := []byte(fileIdentifier + "\x00")
+		entries, files := tx.Bucket(entriesBucket), tx.Bucket(filesBucket)
+
+		var indexed [][]byte
+		cursor := files.Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			indexed = append(indexed, bytes.Clone(k))
+		}
+		for _, k := range indexed {
+			if err := entries.Delete(k[len(prefix):]); err != nil {
+				return err
+			}
+			if err := files.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Failed to invalidate cache file", "fileIdentifier", fileIdentifier, "error", err)
+	}
+}
+
+// StartCleanup removes the expired entries every CLEANUP_INTERVAL until ctx is done
+func (c *Cache) StartCleanup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(CLEANUP_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				c.removeExpired(now)
+			}
+		}
+	}()
+}
+
+// Close closes the cache file
+func (c *Cache) Close() error {
+	if c.db == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+func (c *Cache) addLocked(key string, entry Entry) {
+	c.items[key] = c.lru.PushFront(&item{key: key, entry: entry})
+	for c.lru.Len() > c.maxEntries {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// removeLocked drops an entry from memory, the cache file keeps it
+func (c *Cache) removeLocked(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.items, element.Value.(*item).key)
+}
+
+func (c *Cache) load(key string) (*Entry, error) {
+	var entry *Entry
+	err := c.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(entriesBucket).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		entry = &Entry{}
+		return json.Unmarshal(value, entry)
+	})
+	return entry, err
+}
+
+func (c *Cache) store(key string, entry Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(entriesBucket).Put([]byte(key), value); err != nil {
+			return err
+		}
+		for _, file := range entry.Files {
+			if err := tx.Bucket(filesBucket).Put(fileIndexKey(file, key), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Cache) removeExpired(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if !now.Before(element.Value.(*item).entry.ExpiresAt) {
+			c.removeLocked(element)
+		}
+		element = next
+	}
+
+	if c.db == nil {
+		return
+	}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		entries, files := tx.Bucket(entriesBucket), tx.Bucket(filesBucket)
+
+		expired := make(map[string]Entry)
+		err := entries.ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil || !now.Before(entry.ExpiresAt) {
+				expired[string(k)] = entry
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for key, entry := range expired {
+			if err := entries.Delete([]byte(key)); err != nil {
+				return err
+			}
+			for _, file := range entry.Files {
+				if err := files.Delete(fileIndexKey(file, key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Failed to remove expired entries from cache file", "error", err)
+	}
+}
+
+func fileIndexKey(fileIdentifier string, key string) []byte {
+	return []byte(fileIdentifier + "\x00" + key)
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+func entry(file string, expiresAt time.Time) Entry {
+	return Entry{Result: json.RawMessage(`{"content":[]}`), Files: []string{file}, ExpiresAt: expiresAt}
+}
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	c, err := New(config.CacheConfig{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put("itinerary-1", entry("1", now.Add(time.Minute)))
+	c.Put("documents-1", entry("1", now.Add(time.Minute)))
+	c.Get("itinerary-1", now)
+	// Evicts documents-1, the least recently used
+	c.Put("itinerary-2", entry("2", now.Add(time.Second)))
+
+	tests := []struct {
+		name string
+		key  string
+		at   time.Time
+		want bool
+	}{
+		{name: "recently used", key: "itinerary-1", at: now, want: true},
+		{name: "evicted", key: "documents-1", at: now, want: false},
+		{name: "expired", key: "itinerary-2", at: now.Add(time.Second), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := c.Get(tt.key, tt.at); ok != tt.want {
+				t.Errorf("Get(%q) found %v, want %v", tt.key, ok, tt.want)
+			}
+		})
+	}
+
+	c.InvalidateFile("1")
+	if _, ok := c.Get("itinerary-1", now); ok {
+		t.Error("Get() found an entry of an invalidated file")
+	}
+}
+
+func TestCachePutSince(t *testing.T) {
+	now := time.Now()
+	c, err := New(config.CacheConfig{MaxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A save lands while the itinerary is fetched, the fetched result may predate it
+	generation := c.Generation()
+	c.InvalidateFile("1")
+	if c.PutSince("itinerary-1", entry("1", now.Add(time.Minute)), generation) {
+		t.Error("PutSince() stored a result fetched across an invalidation")
+	}
+	if _, ok := c.Get("itinerary-1", now); ok {
+		t.Error("Get() found a result fetched across an invalidation")
+	}
+
+	if !c.PutSince("itinerary-1", entry("1", now.Add(time.Minute)), c.Generation()) {
+		t.Error("PutSince() didn't store a result of the current generation")
+	}
+}
+
+func TestCacheFile(t *testing.T) {
+	now := time.Now()
+	cacheConfig := config.CacheConfig{MaxEntries: 10, File: filepath.Join(t.TempDir(), "cache.db")}
+
+	c, err := New(cacheConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("itinerary-1", entry("1", now.Add(time.Minute)))
+	c.Put("itinerary-2", entry("2", now.Add(time.Minute)))
+	c.Close()
+
+	// Entries outlive a restart, until their file changes
+	c, err = New(cacheConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, ok := c.Get("itinerary-1", now); !ok {
+		t.Fatal("Get() didn't find the entry stored before reopening")
+	}
+
+	c.InvalidateFile("1")
+	if _, ok := c.Get("itinerary-1", now); ok {
+		t.Error("Get() found an entry of an invalidated file")
+	}
+	if _, ok := c.Get("itinerary-2", now); !ok {
+		t.Error("Get() didn't find the entry of another file in the cache file")
+	}
+}
@@ -0,0 +1,15 @@
+package config
+
+// AuditConfig holds the audit log of changes made to Lynx
+type AuditConfig struct {
+	File string `yaml:"file"`
+}
+
+// Enabled reports whether write operations are recorded
+func (a AuditConfig) Enabled() bool {
+	return a.File != ""
+}
+
+func DefaultAuditConfig() AuditConfig {
+	return AuditConfig{}
+}
@@ -0,0 +1,29 @@
+package config
+
+import "time"
+
+// CacheConfig holds the cache of read tool results. MaxEntries results are kept in memory, File keeps them on disk
+// across restarts too. Only the tools with a
+// positive TTL are cached, for their TTL.
+type CacheConfig struct {
+	MaxEntries int                      `yaml:"maxEntries"`
+	File       string                   `yaml:"file,omitempty"`
+	TTLs       map[string]time.Duration `yaml:"ttls"`
+}
+
+// Enabled reports whether tool results are cached
+func (c CacheConfig) Enabled() bool {
+	return c.MaxEntries > 0
+}
+
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		MaxEntries: 1000,
+		TTLs: map[string]time.Duration{
+			"retrieve_itinerary":            5 * time.Minute,
+			"retrieve_file_documents":       5 * time.Minute,
+			"file_search_by_party_name":     time.Minute,
+			"file_search_by_file_reference": time.Minute,
+		},
+	}
+}
@@ -0,0 +1,530 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/credentials"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// CONFIG_FILE_ENV points to the YAML configuration file when --config isn't given
+	CONFIG_FILE_ENV = "LYNX_MCP_CONFIG"
+
+	REDACTED = "REDACTED"
+)
+
+// Config is the complete server configuration.
+// Values are layered: defaults, then the YAML file, then the credential store, then environment variables,
+// then command-line flags. Every environment variable can also be read from a file named by its _FILE variant.
+type Config struct {
+	Server      MCPServerConfig   `yaml:"server"`
+	Lynx        LynxServerConfig  `yaml:"lynx"`
+	Staging     StagingConfig     `yaml:"staging"`
+	Upload      UploadConfig      `yaml:"upload"`
+	Audit       AuditConfig       `yaml:"audit"`
+	Cache       CacheConfig       `yaml:"cache"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Outbox      OutboxConfig      `yaml:"outbox"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+}
+
+// Command holds the command-line flags that act on the configuration rather than set it
+type Command struct {
+	ConfigFile      string
+	CredentialsFile string
+	PrintConfig     bool
+}
+
+// setting binds one configuration value to its environment variable and command-line flag.
+// Secrets have no flag so they never show up in process listings, use a _FILE variant or the credential store.
+type setting struct {
+	env     string
+	flag    string
+	usage   string
+	boolean bool
+	set     func(config *Config, value string) error
+}
+
+var (
+	tokenHashPattern       = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+	accountPasswordPattern = regexp.MustCompile(`^` + ACCOUNT_PASSWORD_PREFIX + `[A-Z0-9_]+$`)
+)
+
+var settings = []setting{
+	{"PORT", "port", "Port to listen on", false, func(c *Config, v string) error { c.Server.Port = v; return nil }},
+	{"BEARER_TOKEN", "", "", false, func(c *Config, v string) error { c.Server.BearerToken = v; return nil }},
+	{"JWT_JWKS_FILE", "jwt-jwks-file", "JWKS file with the keys verifying JWT bearer tokens", false, func(c *Config, v string) error { c.Server.JWT.JWKSFile = v; return nil }},
+	{"JWT_SECRET", "", "", false, func(c *Config, v string) error { c.Server.JWT.Secret = v; return nil }},
+	{"JWT_ISSUER", "jwt-issuer", "Expected iss claim of JWT bearer tokens", false, func(c *Config, v string) error { c.Server.JWT.Issuer = v; return nil }},
+	{"JWT_AUDIENCE", "jwt-audience", "Expected aud claim of JWT bearer tokens", false, func(c *Config, v string) error { c.Server.JWT.Audience = v; return nil }},
+	{"OAUTH_PUBLIC_URL", "oauth-public-url", "URL MCP clients reach the server at, issuer of OAuth tokens", false, func(c *Config, v string) error { c.Server.OAuth.PublicURL = v; return nil }},
+	{"OAUTH_USERS_FILE", "oauth-users-file", "YAML file of the staff signing in through OAuth", false, func(c *Config, v string) error { c.Server.OAuth.UsersFile = v; return nil }},
+	{"OAUTH_CLIENTS_FILE", "oauth-clients-file", "File keeping dynamically registered OAuth clients across restarts", false, func(c *Config, v string) error { c.Server.OAuth.ClientsFile = v; return nil }},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "How long in-flight tool calls and uploads may run once shutdown starts", false, func(c *Config, v string) error { return parseDuration(&c.Server.ShutdownTimeout, v) }},
+	{"TOOL_TIMEOUT", "tool-timeout", "Deadline of a tool call unless server.toolTimeouts names the tool", false, func(c *Config, v string) error { return parseDuration(&c.Server.ToolTimeout, v) }},
+	{"CONFIRM_WRITE_TOOLS", "confirm-write-tools", "Require confirm_action before write tools run", true, func(c *Config, v string) error { return parseBool(&c.Server.ConfirmWriteTools, v) }},
+
+	{"LYNX_REMOTE_HOST", "lynx-host", "Lynx Reservations host", false, func(c *Config, v string) error { c.Lynx.RemoteHost = v; return nil }},
+	{"LYNX_AUTH_COOKIE_DURATION", "lynx-auth-cookie-duration", "How long a Lynx session is reused", false, func(c *Config, v string) error { return parseDuration(&c.Lynx.AuthCookieDuration, v) }},
+	{"LYNX_USERNAME", "lynx-username", "Lynx Reservations username", false, func(c *Config, v string) error { c.Lynx.Username = v; return nil }},
+	{"LYNX_PASSWORD", "", "", false, func(c *Config, v string) error { c.Lynx.Password = v; return nil }},
+	{"LYNX_COMPANY_CODE", "lynx-company-code", "Lynx company code", false, func(c *Config, v string) error { c.Lynx.CompanyCode = v; return nil }},
+	{"LYNX_REQUIRE_ACCOUNT", "lynx-require-account", "Reject callers without their own Lynx account instead of using the service account", true, func(c *Config, v string) error { return parseBool(&c.Lynx.RequireAccount, v) }},
+	{"LYNX_RETRY_MAX_ATTEMPTS", "retry-max-attempts", "Attempts per Lynx request", false, func(c *Config, v string) error { return parseInt(&c.Lynx.Retry.MaxAttempts, v) }},
+	{"LYNX_RETRY_INITIAL_DELAY", "retry-initial-delay", "Delay before the first retry", false, func(c *Config, v string) error { return parseDuration(&c.Lynx.Retry.InitialDelay, v) }},
+	{"LYNX_RETRY_BACKOFF_MULTIPLIER", "retry-backoff-multiplier", "Delay multiplier between retries", false, func(c *Config, v string) error { return parseFloat(&c.Lynx.Retry.BackoffMultiplier, v) }},
+	{"LYNX_RETRY_MAX_DELAY", "retry-max-delay", "Longest delay between retries", false, func(c *Config, v string) error { return parseDuration(&c.Lynx.Retry.MaxDelay, v) }},
+	{"LYNX_MAX_CONCURRENT", "lynx-max-concurrent", "Lynx requests in flight at once, 0 for no limit", false, func(c *Config, v string) error { return parseInt(&c.Lynx.Limit.MaxConcurrent, v) }},
+	{"LYNX_REQUESTS_PER_SECOND", "lynx-requests-per-second", "Lynx requests started per second, 0 for no limit", false, func(c *Config, v string) error { return parseFloat(&c.Lynx.Limit.RequestsPerSecond, v) }},
+	{"LYNX_BREAKER_THRESHOLD", "lynx-breaker-threshold", "Failed Lynx requests in a row opening the circuit breaker, 0 to disable it", false, func(c *Config, v string) error { return parseInt(&c.Lynx.Limit.BreakerThreshold, v) }},
+	{"LYNX_BREAKER_COOLDOWN", "lynx-breaker-cooldown", "How long the open circuit breaker rejects Lynx requests", false, func(c *Config, v string) error { return parseDuration(&c.Lynx.Limit.BreakerCooldown, v) }},
+
+	{"ATTACHMENT_STAGING_DIR", "staging-dir", "Directory holding staged attachments", false, func(c *Config, v string) error { c.Staging.Directory = v; return nil }},
+	{"ATTACHMENT_STAGING_TTL", "staging-ttl", "How long a staged attachment handle stays valid", false, func(c *Config, v string) error { return parseDuration(&c.Staging.TTL, v) }},
+	{"ATTACHMENT_STAGING_MAX_FILE_SIZE", "staging-max-file-size", "Maximum size of a staged attachment in bytes", false, func(c *Config, v string) error { return parseInt64(&c.Staging.MaxFileSize, v) }},
+	{"ATTACHMENT_STAGING_MAX_TOTAL_SIZE", "staging-max-total-size", "Maximum size of all staged attachments in bytes", false, func(c *Config, v string) error { return parseInt64(&c.Staging.MaxTotalSize, v) }},
+
+	{"ATTACHMENT_UPLOAD_MAX_SIZE", "upload-max-size", "Maximum size of an attachment forwarded to Lynx in bytes", false, func(c *Config, v string) error { return parseInt64(&c.Upload.MaxSize, v) }},
+	{"ATTACHMENT_UPLOAD_CHUNK_DIR", "upload-chunk-dir", "Directory holding resumable uploads", false, func(c *Config, v string) error { c.Upload.ChunkDirectory = v; return nil }},
+	{"ATTACHMENT_UPLOAD_SESSION_TTL", "upload-session-ttl", "How long an idle resumable upload is kept", false, func(c *Config, v string) error { return parseDuration(&c.Upload.SessionTTL, v) }},
+	{"ATTACHMENT_UPLOAD_MAX_SESSIONS", "upload-max-sessions", "Maximum number of resumable uploads open at once", false, func(c *Config, v string) error { return parseInt(&c.Upload.MaxSessions, v) }},
+	{"ATTACHMENT_UPLOAD_MAX_CHUNK_DIR_SIZE", "upload-max-chunk-dir-size", "Maximum size of all resumable uploads in bytes", false, func(c *Config, v string) error { return parseInt64(&c.Upload.MaxChunkDirectorySize, v) }},
+
+	{"AUDIT_LOG_FILE", "audit-log-file", "JSONL file recording every change made to Lynx", false, func(c *Config, v string) error { c.Audit.File = v; return nil }},
+
+	{"CACHE_MAX_ENTRIES", "cache-max-entries", "Read tool results kept in memory, 0 disables the cache", false, func(c *Config, v string) error { return parseInt(&c.Cache.MaxEntries, v) }},
+	{"CACHE_FILE", "cache-file", "bbolt file keeping cached read tool results across restarts", false, func(c *Config, v string) error { c.Cache.File = v; return nil }},
+
+	{"IDEMPOTENCY_TTL", "idempotency-ttl", "How long the result of a write call is replayed to calls repeating its idempotency key", false, func(c *Config, v string) error { return parseDuration(&c.Idempotency.TTL, v) }},
+	{"IDEMPOTENCY_FILE", "idempotency-file", "bbolt file keeping idempotency keys and their results across restarts", false, func(c *Config, v string) error { c.Idempotency.File = v; return nil }},
+
+	{"OUTBOX_FILE", "outbox-file", "bbolt file queueing the writes that failed while Lynx was unreachable, disabled when empty", false, func(c *Config, v string) error { c.Outbox.File = v; return nil }},
+	{"OUTBOX_MAX_ATTEMPTS", "outbox-max-attempts", "Attempts to apply a queued write before it waits for outbox_retry", false, func(c *Config, v string) error { return parseInt(&c.Outbox.MaxAttempts, v) }},
+	{"OUTBOX_INITIAL_DELAY", "outbox-initial-delay", "Delay before applying a queued write again", false, func(c *Config, v string) error { return parseDuration(&c.Outbox.InitialDelay, v) }},
+	{"OUTBOX_MAX_DELAY", "outbox-max-delay", "Longest delay between two attempts of a queued write", false, func(c *Config, v string) error { return parseDuration(&c.Outbox.MaxDelay, v) }},
+
+	{"LOG_LEVEL", "log-level", "Log level: debug, info, warn or error", false, func(c *Config, v string) error { c.Logging.Level = v; return nil }},
+	{"LOG_FORMAT", "log-format", "Log format: text or json", false, func(c *Config, v string) error { c.Logging.Format = v; return nil }},
+	{"LOG_REDACT", "log-redact", "Comma separated categories masked in logs, or none", false, func(c *Config, v string) error { c.Logging.Redact = parseList(v); return nil }},
+
+	{"TRACING_EXPORTER", "tracing-exporter", "OpenTelemetry span exporter: none, otlp or stdout", false, func(c *Config, v string) error { c.Tracing.Exporter = v; return nil }},
+	{"TRACING_ENDPOINT", "tracing-endpoint", "host:port of the OTLP/HTTP collector", false, func(c *Config, v string) error { c.Tracing.Endpoint = v; return nil }},
+	{"TRACING_INSECURE", "tracing-insecure", "Send spans to the collector over plain HTTP", true, func(c *Config, v string) error { return parseBool(&c.Tracing.Insecure, v) }},
+	{"TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "Share of traces started here that are sampled, from 0 to 1", false, func(c *Config, v string) error { return parseFloat(&c.Tracing.SampleRatio, v) }},
+}
+
+// Default returns the configuration before any file, environment variable or flag is applied
+func Default() Config {
+	return Config{
+		Server:      DefaultMCPServerConfig(),
+		Lynx:        DefaultLynxServerConfig(),
+		Staging:     DefaultStagingConfig(),
+		Upload:      DefaultUploadConfig(),
+		Audit:       DefaultAuditConfig(),
+		Cache:       DefaultCacheConfig(),
+		Idempotency: DefaultIdempotencyConfig(),
+		Outbox:      DefaultOutboxConfig(),
+		Logging:     DefaultLoggingConfig(),
+		Tracing:     DefaultTracingConfig(),
+	}
+}
+
+// Load builds the configuration from the YAML file, the environment and the command-line arguments.
+// The configuration is returned along with every problem found, so it can still be printed when invalid.
+func Load(name string, args []string) (Config, Command, error) {
+	config := Default()
+	command := Command{
+		ConfigFile:      os.Getenv(CONFIG_FILE_ENV),
+		CredentialsFile: os.Getenv(credentials.STORE_FILE_ENV),
+	}
+
+	// Flags are parsed first to find the file, but applied last so they win
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&command.ConfigFile, "config", command.ConfigFile, "YAML configuration file (env "+CONFIG_FILE_ENV+")")
+	flags.StringVar(&command.CredentialsFile, "credentials", command.CredentialsFile, "Encrypted credential store (env "+credentials.STORE_FILE_ENV+")")
+	flags.BoolVar(&command.PrintConfig, "print-config", false, "Print the configuration with secrets redacted and exit")
+
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+	var flagValues []flagValue
+
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+		record := func(value string) error {
+			flagValues = append(flagValues, flagValue{s, value})
+			return nil
+		}
+		if s.boolean {
+			flags.BoolFunc(s.flag, usage, record)
+		} else {
+			flags.Func(s.flag, usage, record)
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return config, command, err
+	}
+
+	var problems []error
+
+	if command.ConfigFile != "" {
+		if err := loadFile(&config, command.ConfigFile); err != nil {
+			return config, command, err
+		}
+	}
+
+	var store *credentials.Store
+	if command.CredentialsFile != "" {
+		var err error
+		if store, err = loadCredentials(&config, command.CredentialsFile); err != nil {
+			return config, command, err
+		}
+	}
+
+	for _, s := range settings {
+		value, ok, err := credentials.LookupEnv(s.env)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if ok {
+			if err := s.set(&config, value); err != nil {
+				problems = append(problems, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+
+	for _, f := range flagValues {
+		if err := f.setting.set(&config, f.value); err != nil {
+			problems = append(problems, fmt.Errorf("--%s: %w", f.setting.flag, err))
+		}
+	}
+
+	problems = append(problems, loadAccountPasswords(&config, store)...)
+
+	problems = append(problems, config.validate()...)
+
+	return config, command, errors.Join(problems...)
+}
+
+// loadFile merges a YAML file into the configuration, unknown keys are rejected to catch typos
+func loadFile(config *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadCredentials applies the secrets of the encrypted credential store, the store is returned for account passwords
+func loadCredentials(config *Config, path string) (*credentials.Store, error) {
+	key, err := credentials.MasterKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open credential store %s: %w", path, err)
+	}
+
+	store, err := credentials.Open(path, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range settings {
+		if value, ok := store.Get(s.env); ok {
+			if err := s.set(config, value); err != nil {
+				return nil, fmt.Errorf("credential store %s: %w", s.env, err)
+			}
+		}
+	}
+
+	return store, nil
+}
+
+// loadAccountPasswords reads the passwords of consultant accounts named by passwordEnv, from the credential store
+// then the environment
+func loadAccountPasswords(config *Config, store *credentials.Store) []error {
+	var problems []error
+
+	for i := range config.Lynx.Accounts {
+		account := &config.Lynx.Accounts[i]
+		if !isAccountPasswordName(account.PasswordEnv) {
+			continue
+		}
+
+		if store != nil {
+			if value, ok := store.Get(account.PasswordEnv); ok {
+				account.Password = value
+			}
+		}
+
+		value, ok, err := credentials.LookupEnv(account.PasswordEnv)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		if ok {
+			account.Password = value
+		}
+	}
+
+	return problems
+}
+
+// IsSetting reports whether name is the environment variable of a setting or of an account password, these are the
+// names the credential store accepts
+func IsSetting(name string) bool {
+	for _, s := range settings {
+		if s.env == name {
+			return true
+		}
+	}
+	return isAccountPasswordName(name)
+}
+
+// isAccountPasswordName accepts LYNX_PASSWORD_JDOE but not LYNX_PASSWORD_FILE, the file variant of LYNX_PASSWORD
+func isAccountPasswordName(name string) bool {
+	return accountPasswordPattern.MatchString(name) && !strings.HasSuffix(name, credentials.FILE_SUFFIX)
+}
+
+// validate lists every invalid or missing value instead of stopping at the first one
+func (c Config) validate() []error {
+	var problems []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "server.port must be a port number, got %q", c.Server.Port)
+	check(c.Server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive")
+	check(c.Server.ToolTimeout > 0, "server.toolTimeout must be positive")
+	for tool, timeout := range c.Server.ToolTimeouts {
+		check(timeout > 0, "server.toolTimeouts.%s must be positive", tool)
+	}
+	check(c.Server.BearerToken != "" || len(c.Server.Tokens) > 0 || c.Server.JWT.Enabled() || c.Server.OAuth.Enabled(), "server.bearerToken is required (env BEARER_TOKEN, BEARER_TOKEN_FILE or the credential store) unless server.tokens, server.jwt or server.oauth are configured")
+
+	names := make(map[string]bool)
+	for i, token := range c.Server.Tokens {
+		check(token.Name != "", "server.tokens[%d].name is required", i)
+		check(!names[token.Name], "server.tokens[%d].name %q is used twice", i, token.Name)
+		check(tokenHashPattern.MatchString(token.Hash), "server.tokens[%d].hash must be sha256: followed by 64 hex digits", i)
+		check(len(token.Scopes) > 0, "server.tokens[%d].scopes must list at least one scope", i)
+		check(token.RateLimit >= 0, "server.tokens[%d].rateLimit must not be negative", i)
+		names[token.Name] = true
+	}
+
+	if c.Server.JWT.Enabled() {
+		check(c.Server.JWT.Issuer != "", "server.jwt.issuer is required to accept JWT bearer tokens (env JWT_ISSUER)")
+		check(c.Server.JWT.Audience != "", "server.jwt.audience is required to accept JWT bearer tokens (env JWT_AUDIENCE)")
+		check(c.Server.JWT.Leeway >= 0, "server.jwt.leeway must not be negative")
+		check(c.Server.JWT.IdentityClaim != "", "server.jwt.identityClaim is required")
+		check(c.Server.JWT.RateLimit >= 0, "server.jwt.rateLimit must not be negative")
+		for i, key := range c.Server.JWT.Keys {
+			check(key.PublicKeyFile != "", "server.jwt.keys[%d].publicKeyFile is required", i)
+		}
+	}
+
+	if c.Server.OAuth.Enabled() {
+		publicURL, err := url.Parse(c.Server.OAuth.PublicURL)
+		check(err == nil && (publicURL.Scheme == "https" || publicURL.Scheme == "http") && publicURL.Host != "", "server.oauth.publicUrl must be the absolute URL of the server to enable OAuth (env OAUTH_PUBLIC_URL)")
+		check(c.Server.OAuth.AccessTokenTTL > 0, "server.oauth.accessTokenTTL must be positive")
+		check(c.Server.OAuth.RefreshTokenTTL >= 0, "server.oauth.refreshTokenTTL must not be negative")
+		check(c.Server.OAuth.RateLimit >= 0, "server.oauth.rateLimit must not be negative")
+	}
+
+	check(c.Lynx.RemoteHost != "", "lynx.remoteHost is required")
+	check(c.Lynx.AuthCookieDuration > 0, "lynx.authCookieDuration must be positive")
+	// The service account is only optional when every caller has their own account
+	serviceAccount := !c.Lynx.RequireAccount || len(c.Lynx.Accounts) == 0
+	check(!serviceAccount || c.Lynx.Username != "", "lynx.username is required (env LYNX_USERNAME)")
+	check(!serviceAccount || c.Lynx.Password != "", "lynx.password is required (env LYNX_PASSWORD, LYNX_PASSWORD_FILE or the credential store)")
+	check(c.Lynx.CompanyCode != "", "lynx.companyCode is required (env LYNX_COMPANY_CODE)")
+
+	identities := make(map[string]bool)
+	for i, account := range c.Lynx.Accounts {
+		check(account.Identity != "", "lynx.accounts[%d].identity is required", i)
+		check(!identities[account.Identity], "lynx.accounts[%d].identity %q is used twice", i, account.Identity)
+		check(account.Username != "", "lynx.accounts[%d].username is required", i)
+		check(account.PasswordEnv == "" || isAccountPasswordName(account.PasswordEnv), "lynx.accounts[%d].passwordEnv must look like %sJDOE, got %q", i, ACCOUNT_PASSWORD_PREFIX, account.PasswordEnv)
+		check(account.Password != "", "lynx.accounts[%d].password is required (passwordEnv, its _FILE variant or the credential store)", i)
+		identities[account.Identity] = true
+	}
+
+	retries := map[string]RetryConfig{"lynx.retry": c.Lynx.Retry}
+	for tool := range c.Lynx.ToolRetry {
+		retries[fmt.Sprintf("lynx.toolRetry.%s", tool)] = c.Lynx.RetryFor(tool)
+	}
+	for _, name := range slices.Sorted(maps.Keys(retries)) {
+		retry := retries[name]
+		check(retry.MaxAttempts > 0, "%s.maxAttempts must be at least 1", name)
+		check(retry.InitialDelay >= 0, "%s.initialDelay must not be negative", name)
+		check(retry.BackoffMultiplier >= 1, "%s.backoffMultiplier must be at least 1", name)
+		check(retry.MaxDelay >= retry.InitialDelay, "%s.maxDelay must not be lower than %s.initialDelay", name, name)
+	}
+
+	check(c.Lynx.Limit.MaxConcurrent >= 0, "lynx.limit.maxConcurrent must not be negative")
+	check(c.Lynx.Limit.RequestsPerSecond >= 0, "lynx.limit.requestsPerSecond must not be negative")
+	check(c.Lynx.Limit.BreakerThreshold >= 0, "lynx.limit.breakerThreshold must not be negative")
+	check(c.Lynx.Limit.BreakerThreshold == 0 || c.Lynx.Limit.BreakerCooldown > 0, "lynx.limit.breakerCooldown must be positive")
+
+	check(c.Staging.Directory != "", "staging.directory is required")
+	check(c.Staging.TTL > 0, "staging.ttl must be positive")
+	check(c.Staging.MaxFileSize > 0, "staging.maxFileSize must be positive")
+	check(c.Staging.MaxTotalSize >= c.Staging.MaxFileSize, "staging.maxTotalSize must not be lower than staging.maxFileSize")
+
+	check(c.Upload.MaxSize > 0, "upload.maxSize must be positive")
+	check(c.Upload.ChunkDirectory != "", "upload.chunkDirectory is required")
+	check(c.Upload.SessionTTL > 0, "upload.sessionTTL must be positive")
+	check(c.Upload.MaxSessions > 0, "upload.maxSessions must be positive")
+	check(c.Upload.MaxChunkDirectorySize >= c.Upload.MaxSize, "upload.maxChunkDirectorySize must not be lower than upload.maxSize")
+
+	check(c.Cache.MaxEntries >= 0, "cache.maxEntries must not be negative")
+	for tool, ttl := range c.Cache.TTLs {
+		check(ttl >= 0, "cache.ttls.%s must not be negative", tool)
+	}
+
+	check(c.Idempotency.TTL > 0, "idempotency.ttl must be positive")
+
+	check(c.Outbox.MaxAttempts > 0, "outbox.maxAttempts must be at least 1")
+	check(c.Outbox.InitialDelay > 0, "outbox.initialDelay must be positive")
+	check(c.Outbox.MaxDelay >= c.Outbox.InitialDelay, "outbox.maxDelay must not be lower than outbox.initialDelay")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Logging.Level)) == nil, "logging.level must be debug, info, warn or error, got %q", c.Logging.Level)
+	check(c.Logging.Format == LOG_FORMAT_TEXT || c.Logging.Format == LOG_FORMAT_JSON, "logging.format must be %s or %s, got %q", LOG_FORMAT_TEXT, LOG_FORMAT_JSON, c.Logging.Format)
+	for _, category := range c.Logging.Redact {
+		check(slices.Contains(RedactCategories, category), "logging.redact: unknown category %q, expected any of %v", category, RedactCategories)
+	}
+
+	check(slices.Contains([]string{TRACING_EXPORTER_NONE, TRACING_EXPORTER_OTLP, TRACING_EXPORTER_STDOUT}, c.Tracing.Exporter), "tracing.exporter must be %s, %s or %s, got %q", TRACING_EXPORTER_NONE, TRACING_EXPORTER_OTLP, TRACING_EXPORTER_STDOUT, c.Tracing.Exporter)
+	check(c.Tracing.Exporter != TRACING_EXPORTER_OTLP || c.Tracing.Endpoint != "", "tracing.endpoint is required to export spans over OTLP (env TRACING_ENDPOINT)")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1")
+	check(c.Tracing.ServiceName != "", "tracing.serviceName is required")
+
+	return problems
+}
+
+// Validate reports every problem of the configuration
+func (c Config) Validate() error {
+	return errors.Join(c.validate()...)
+}
+
+// Redacted returns a copy safe to print or log, secrets are masked
+func (c Config) Redacted() Config {
+	if c.Server.BearerToken != "" {
+		c.Server.BearerToken = REDACTED
+	}
+	if c.Server.JWT.Secret != "" {
+		c.Server.JWT.Secret = REDACTED
+	}
+	// Token hashes can't be reversed, but they identify the tokens
+	c.Server.Tokens = append([]TokenConfig(nil), c.Server.Tokens...)
+	for i := range c.Server.Tokens {
+		c.Server.Tokens[i].Hash = REDACTED
+	}
+	if c.Lynx.Password != "" {
+		c.Lynx.Password = REDACTED
+	}
+	c.Lynx.Accounts = append([]LynxAccountConfig(nil), c.Lynx.Accounts...)
+	for i := range c.Lynx.Accounts {
+		if c.Lynx.Accounts[i].Password != "" {
+			c.Lynx.Accounts[i].Password = REDACTED
+		}
+	}
+	return c
+}
+
+// Print writes the redacted configuration as YAML
+func (c Config) Print(writer io.Writer) error {
+	encoder := yaml.NewEncoder(writer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// parseList splits a comma separated value, "none" is the empty list
+func parseList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" && item != "none" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parseBool(target *bool, value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", value)
+	}
+	*target = parsed
+	return nil
+}
+
+func parseInt(target *int, value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+	*target = parsed
+	return nil
+}
+
+func parseInt64(target *int64, value string) error {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+	*target = parsed
+	return nil
+}
+
+func parseFloat(target *float64, value string) error {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", value)
+	}
+	*target = parsed
+	return nil
+}
+
+func parseDuration(target *time.Duration, value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a value like 30s or 15m", value)
+	}
+	*target = parsed
+	return nil
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/credentials"
+)
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`
+server:
+  port: 9700
+lynx:
+  username: file-user
+  companyCode: FILE
+  authCookieDuration: 10m
+  retry:
+    maxAttempts: 3
+  toolRetry:
+    retrieve_itinerary:
+      maxDelay: 5s
+staging:
+  ttl: 1h
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("BEARER_TOKEN", "token")
+	t.Setenv("LYNX_PASSWORD", "secret")
+	t.Setenv("LYNX_USERNAME", "env-user")
+	t.Setenv("LYNX_COMPANY_CODE", "")
+
+	config, command, err := Load("test", []string{"--config", file, "--lynx-username", "flag-user", "--confirm-write-tools", "--print-config"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		got      any
+		expected any
+	}{
+		{"default", config.Lynx.RemoteHost, "www.lynx-reservations.com"},
+		{"file", config.Server.Port, "9700"},
+		{"file duration", config.Lynx.AuthCookieDuration, 10 * time.Minute},
+		{"file nested", config.Lynx.Retry.MaxAttempts, 3},
+		{"file keeps nested defaults", config.Lynx.Retry.MaxDelay, 30 * time.Second},
+		{"tool retry override", config.Lynx.RetryFor("retrieve_itinerary").MaxDelay, 5 * time.Second},
+		{"tool retry keeps the policy", config.Lynx.RetryFor("retrieve_itinerary").MaxAttempts, 3},
+		{"empty env ignored", config.Lynx.CompanyCode, "FILE"},
+		{"env", config.Lynx.Password, "secret"},
+		{"flag wins over env and file", config.Lynx.Username, "flag-user"},
+		{"boolean flag", config.Server.ConfirmWriteTools, true},
+		{"command", command.PrintConfig, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, tt.got)
+			}
+		})
+	}
+}
+
+func TestLoadListsEveryProblem(t *testing.T) {
+	for _, name := range []string{"BEARER_TOKEN", "LYNX_USERNAME", "LYNX_PASSWORD", "LYNX_COMPANY_CODE", CONFIG_FILE_ENV} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("ATTACHMENT_STAGING_TTL", "soon")
+
+	_, _, err := Load("test", []string{"--port", "http"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	for _, expected := range []string{
+		"ATTACHMENT_STAGING_TTL: invalid duration",
+		"server.port must be a port number",
+		"server.bearerToken is required",
+		"lynx.username is required",
+		"lynx.password is required",
+		"lynx.companyCode is required",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in:\n%v", expected, err)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("lynx:\n  pasword: typo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Load("test", []string{"--config", file}); err == nil || !strings.Contains(err.Error(), "pasword") {
+		t.Errorf("expected an unknown key error, got %v", err)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	config := Default()
+	config.Server.BearerToken = "token"
+	config.Lynx.Password = "secret"
+
+	var output bytes.Buffer
+	if err := config.Print(&output); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(output.String(), "secret") || strings.Contains(output.String(), "token\n") {
+		t.Errorf("secrets leaked:\n%s", output.String())
+	}
+	if !strings.Contains(output.String(), "password: "+REDACTED) || !strings.Contains(output.String(), "authCookieDuration: 15m0s") {
+		t.Errorf("unexpected output:\n%s", output.String())
+	}
+}
+
+func TestLoadSecretsFromFileAndCredentialStore(t *testing.T) {
+	directory := t.TempDir()
+
+	key, err := credentials.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := credentials.ParseKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storePath := filepath.Join(directory, "credentials.json")
+	store, err := credentials.Open(storePath, parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("LYNX_PASSWORD", "stored-secret")
+	store.Set("LYNX_USERNAME", "stored-user")
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenFile := filepath.Join(directory, "bearer_token")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(credentials.MASTER_KEY_ENV, key)
+	t.Setenv("BEARER_TOKEN", "")
+	t.Setenv("BEARER_TOKEN_FILE", tokenFile)
+	t.Setenv("LYNX_PASSWORD", "")
+	t.Setenv("LYNX_USERNAME", "env-user")
+	t.Setenv("LYNX_COMPANY_CODE", "CODE")
+
+	config, command, err := Load("test", []string{"--credentials", storePath})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		got      any
+		expected any
+	}{
+		{"file variant", config.Server.BearerToken, "file-token"},
+		{"credential store", config.Lynx.Password, "stored-secret"},
+		{"env wins over credential store", config.Lynx.Username, "env-user"},
+		{"command", command.CredentialsFile, storePath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, tt.got)
+			}
+		})
+	}
+
+	t.Setenv("BEARER_TOKEN", "token")
+	if _, _, err := Load("test", []string{"--credentials", storePath}); err == nil || !strings.Contains(err.Error(), "set either BEARER_TOKEN or BEARER_TOKEN_FILE") {
+		t.Errorf("expected a conflict error, got %v", err)
+	}
+}
+
+func TestLoadLynxAccounts(t *testing.T) {
+	directory := t.TempDir()
+
+	file := filepath.Join(directory, "config.yaml")
+	err := os.WriteFile(file, []byte(`
+lynx:
+  requireAccount: true
+  accounts:
+    - identity: jdoe@example.com
+      username: jdoe
+      passwordEnv: LYNX_PASSWORD_JDOE
+    - identity: n8n
+      username: automation
+      password: inline-secret
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwordFile := filepath.Join(directory, "jdoe")
+	if err := os.WriteFile(passwordFile, []byte("jdoe-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("BEARER_TOKEN", "token")
+	t.Setenv("LYNX_USERNAME", "")
+	t.Setenv("LYNX_PASSWORD", "")
+	t.Setenv("LYNX_COMPANY_CODE", "CODE")
+	t.Setenv("LYNX_PASSWORD_JDOE_FILE", passwordFile)
+
+	// The service account isn't needed when every caller has an account
+	config, _, err := Load("test", []string{"--config", file})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		got      any
+		expected any
+	}{
+		{"password from file variant", config.Lynx.Accounts[0].Password, "jdoe-secret"},
+		{"inline password", config.Lynx.Accounts[1].Password, "inline-secret"},
+		{"account password is a setting", IsSetting("LYNX_PASSWORD_JDOE"), true},
+		{"file variant isn't an account password", IsSetting("LYNX_PASSWORD_FILE"), false},
+		{"redacted", config.Redacted().Lynx.Accounts[0].Password, REDACTED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, tt.got)
+			}
+		})
+	}
+
+	t.Setenv("LYNX_PASSWORD_JDOE_FILE", "")
+	if _, _, err := Load("test", []string{"--config", file}); err == nil || !strings.Contains(err.Error(), "lynx.accounts[0].password is required") {
+		t.Errorf("expected a missing account password error, got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+package config
+
+import "time"
+
+// IdempotencyConfig holds the results of write calls made with an idempotency key, they are replayed to calls
+// repeating the key for TTL. File keeps them on disk across restarts.
+type IdempotencyConfig struct {
+	TTL  time.Duration `yaml:"ttl"`
+	File string        `yaml:"file,omitempty"`
+}
+
+func DefaultIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{
+		TTL: 24 * time.Hour,
+	}
+}
@@ -0,0 +1,31 @@
+package config
+
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+
+	REDACT_COOKIES   = "cookies"
+	REDACT_PASSWORDS = "passwords"
+	REDACT_EMAILS    = "emails"
+	REDACT_PHONES    = "phones"
+	REDACT_NAMES     = "names"
+	REDACT_CONTENT   = "content"
+)
+
+// RedactCategories lists what the redaction policy can mask in logs
+var RedactCategories = []string{REDACT_COOKIES, REDACT_PASSWORDS, REDACT_EMAILS, REDACT_PHONES, REDACT_NAMES, REDACT_CONTENT}
+
+// LoggingConfig holds the log level, format and the redaction policy applied to every log record
+type LoggingConfig struct {
+	Level  string   `yaml:"level"`
+	Format string   `yaml:"format"`
+	Redact []string `yaml:"redact"`
+}
+
+func DefaultLoggingConfig() LoggingConfig {
+	return LoggingConfig{
+		Level:  "info",
+		Format: LOG_FORMAT_TEXT,
+		Redact: append([]string(nil), RedactCategories...),
+	}
+}
@@ -1,36 +1,97 @@
 package config
 
 import (
-	"os"
 	"time"
 )
 
+// ACCOUNT_PASSWORD_PREFIX starts the environment variable names holding the passwords of consultant accounts
+const ACCOUNT_PASSWORD_PREFIX = "LYNX_PASSWORD_"
+
 type LynxServerConfig struct {
-	RemoteHost         string
-	AuthCookieDuration time.Duration
-	Username           string
-	Password           string
-	CompanyCode        string
+	RemoteHost         string              `yaml:"remoteHost"`
+	AuthCookieDuration time.Duration       `yaml:"authCookieDuration"`
+	Username           string              `yaml:"username"`
+	Password           string              `yaml:"password"`
+	CompanyCode        string              `yaml:"companyCode"`
+	Accounts           []LynxAccountConfig `yaml:"accounts,omitempty"`
+	RequireAccount     bool                `yaml:"requireAccount"`
+	Retry              RetryConfig         `yaml:"retry"`
+	Limit              LimitConfig         `yaml:"limit"`
+	// ToolRetry overrides Retry for the Lynx requests of a tool, unset fields keep the values of Retry
+	ToolRetry map[string]RetryConfig `yaml:"toolRetry,omitempty"`
 }
 
-func NewLynxServerConfig() LynxServerConfig {
-	if os.Getenv("LYNX_USERNAME") == "" {
-		panic("LYNX_USERNAME is not set")
-	}
+// LynxAccountConfig is the Lynx account of a consultant, actions of the matching caller run as them instead of the
+// service account. Identity is a token name or the identity claim of a JWT or OAuth token.
+type LynxAccountConfig struct {
+	Identity    string `yaml:"identity"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password,omitempty"`
+	PasswordEnv string `yaml:"passwordEnv,omitempty"`
+}
 
-	if os.Getenv("LYNX_PASSWORD") == "" {
-		panic("LYNX_PASSWORD is not set")
-	}
+// RetryConfig holds configuration for retry behavior of Lynx requests. The delay before a retry is drawn at random
+// up to InitialDelay * BackoffMultiplier^retries, capped at MaxDelay.
+type RetryConfig struct {
+	MaxAttempts       int           `yaml:"maxAttempts"`
+	InitialDelay      time.Duration `yaml:"initialDelay"`
+	BackoffMultiplier float64       `yaml:"backoffMultiplier"`
+	MaxDelay          time.Duration `yaml:"maxDelay"`
+}
 
-	if os.Getenv("LYNX_COMPANY_CODE") == "" {
-		panic("LYNX_COMPANY_CODE is not set")
-	}
+// LimitConfig bounds the traffic sent to Lynx, busy callers queue without holding up the others. The circuit breaker
+// rejects requests for BreakerCooldown once BreakerThreshold requests in a row failed. Zero disables a limit.
+type LimitConfig struct {
+	MaxConcurrent     int           `yaml:"maxConcurrent"`
+	RequestsPerSecond float64       `yaml:"requestsPerSecond"`
+	BreakerThreshold  int           `yaml:"breakerThreshold"`
+	BreakerCooldown   time.Duration `yaml:"breakerCooldown"`
+}
 
+// DefaultLynxServerConfig returns the Lynx settings that don't need to be configured, credentials are left empty
+func DefaultLynxServerConfig() LynxServerConfig {
 	return LynxServerConfig{
 		RemoteHost:         "www.lynx-reservations.com",
 		AuthCookieDuration: 15 * time.Minute,
-		Username:           os.Getenv("LYNX_USERNAME"),
-		Password:           os.Getenv("LYNX_PASSWORD"),
-		CompanyCode:        os.Getenv("LYNX_COMPANY_CODE"),
+		Retry:              DefaultRetryConfig(),
+		Limit: LimitConfig{
+			MaxConcurrent:     8,
+			RequestsPerSecond: 10,
+			BreakerThreshold:  5,
+			BreakerCooldown:   30 * time.Second,
+		},
+	}
+}
+
+// DefaultRetryConfig returns a default retry configuration
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:       5,
+		InitialDelay:      time.Second,
+		BackoffMultiplier: 2.0,
+		MaxDelay:          30 * time.Second,
+	}
+}
+
+// RetryFor returns the retry policy of the Lynx requests of a tool
+func (c LynxServerConfig) RetryFor(tool string) RetryConfig {
+	retry := c.Retry
+	override, ok := c.ToolRetry[tool]
+	if !ok {
+		return retry
+	}
+
+	if override.MaxAttempts != 0 {
+		retry.MaxAttempts = override.MaxAttempts
+	}
+	if override.InitialDelay != 0 {
+		retry.InitialDelay = override.InitialDelay
+	}
+	if override.BackoffMultiplier != 0 {
+		retry.BackoffMultiplier = override.BackoffMultiplier
+	}
+	if override.MaxDelay != 0 {
+		retry.MaxDelay = override.MaxDelay
 	}
+	return retry
 }
@@ -1,23 +1,105 @@
 package config
 
-import "os"
+import "time"
 
 type MCPServerConfig struct {
-	Name        string
-	Version     string
-	Port        string
-	BearerToken string
+	Name              string                   `yaml:"-"`
+	Version           string                   `yaml:"-"`
+	Port              string                   `yaml:"port"`
+	BearerToken       string                   `yaml:"bearerToken"`
+	Tokens            []TokenConfig            `yaml:"tokens,omitempty"`
+	JWT               JWTConfig                `yaml:"jwt"`
+	OAuth             OAuthConfig              `yaml:"oauth"`
+	ConfirmWriteTools bool                     `yaml:"confirmWriteTools"`
+	ShutdownTimeout   time.Duration            `yaml:"shutdownTimeout"`
+	ToolTimeout       time.Duration            `yaml:"toolTimeout"`
+	ToolTimeouts      map[string]time.Duration `yaml:"toolTimeouts,omitempty"`
 }
 
-func NewMCPServerConfig() MCPServerConfig {
-	if os.Getenv("BEARER_TOKEN") == "" {
-		panic("BEARER_TOKEN is not set")
+// Timeout returns how long a call of the tool may run, retries of Lynx requests included: its entry in ToolTimeouts,
+// ToolTimeout otherwise
+func (c MCPServerConfig) Timeout(tool string) time.Duration {
+	if timeout, ok := c.ToolTimeouts[tool]; ok {
+		return timeout
 	}
+	return c.ToolTimeout
+}
+
+// TokenConfig registers a bearer token, only its SHA-256 hash is kept in the configuration
+type TokenConfig struct {
+	Name      string    `yaml:"name"`
+	Hash      string    `yaml:"hash"`
+	Scopes    []string  `yaml:"scopes"`
+	Tools     []string  `yaml:"tools,omitempty"`
+	ExpiresAt time.Time `yaml:"expiresAt,omitempty"`
+	RateLimit int       `yaml:"rateLimit,omitempty"`
+}
+
+// JWTConfig accepts signed JWT bearer tokens, verified with a JWKS file, PEM public keys or an HS256 secret
+type JWTConfig struct {
+	JWKSFile      string         `yaml:"jwksFile,omitempty"`
+	Keys          []JWTKeyConfig `yaml:"keys,omitempty"`
+	Secret        string         `yaml:"secret,omitempty"`
+	Issuer        string         `yaml:"issuer,omitempty"`
+	Audience      string         `yaml:"audience,omitempty"`
+	Leeway        time.Duration  `yaml:"leeway"`
+	ScopesClaim   string         `yaml:"scopesClaim"`
+	ToolsClaim    string         `yaml:"toolsClaim"`
+	IdentityClaim string         `yaml:"identityClaim"`
+	RateLimit     int            `yaml:"rateLimit,omitempty"`
+}
+
+// JWTKeyConfig is a static RSA or EC public key, ID matches the kid header of tokens when set
+type JWTKeyConfig struct {
+	ID            string `yaml:"id,omitempty"`
+	PublicKeyFile string `yaml:"publicKeyFile"`
+}
+
+// Enabled reports whether any verification key is configured
+func (c JWTConfig) Enabled() bool {
+	return c.JWKSFile != "" || len(c.Keys) > 0 || c.Secret != ""
+}
+
+// OAuthConfig enables the built-in OAuth 2.1 authorization server, staff sign in with the users of UsersFile
+type OAuthConfig struct {
+	PublicURL       string        `yaml:"publicUrl,omitempty"`
+	UsersFile       string        `yaml:"usersFile,omitempty"`
+	ClientsFile     string        `yaml:"clientsFile,omitempty"`
+	AccessTokenTTL  time.Duration `yaml:"accessTokenTTL"`
+	RefreshTokenTTL time.Duration `yaml:"refreshTokenTTL"`
+	RateLimit       int           `yaml:"rateLimit"`
+}
+
+// Enabled reports whether staff can sign in through OAuth
+func (c OAuthConfig) Enabled() bool {
+	return c.UsersFile != ""
+}
 
+func DefaultMCPServerConfig() MCPServerConfig {
 	return MCPServerConfig{
-		Name:        "lynx-mcp-server",
-		Version:     "1.0.0",
-		Port:        "9600",
-		BearerToken: os.Getenv("BEARER_TOKEN"),
+		Name:    "lynx-mcp-server",
+		Version: "1.0.0",
+		Port:    "9600",
+		// Ends before orchestrators usually kill the process, 30s after asking it to stop
+		ShutdownTimeout: 25 * time.Second,
+		// Room for every retry of a Lynx request, uploads stream attachments of up to 32MB
+		ToolTimeout: 90 * time.Second,
+		ToolTimeouts: map[string]time.Duration{
+			"attachment_upload":          5 * time.Minute,
+			"email_ingest":               5 * time.Minute,
+			"attach_document_to_booking": 5 * time.Minute,
+		},
+		JWT: JWTConfig{
+			Leeway:        30 * time.Second,
+			ScopesClaim:   "scope",
+			ToolsClaim:    "tools",
+			IdentityClaim: "sub",
+		},
+		OAuth: OAuthConfig{
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 12 * time.Hour,
+			// Staff clients, an agent searching in a loop doesn't hold Lynx up for everyone
+			RateLimit: 120,
+		},
 	}
 }
@@ -0,0 +1,26 @@
+package config
+
+import "time"
+
+// OutboxConfig holds the queue of write tool calls that failed because Lynx was unreachable, they are applied once it
+// is back. The queue is kept in File, there is no outbox without one. A call failing MaxAttempts times waits for
+// outbox_retry.
+type OutboxConfig struct {
+	File         string        `yaml:"file,omitempty"`
+	MaxAttempts  int           `yaml:"maxAttempts"`
+	InitialDelay time.Duration `yaml:"initialDelay"`
+	MaxDelay     time.Duration `yaml:"maxDelay"`
+}
+
+// Enabled reports whether failed writes are queued
+func (c OutboxConfig) Enabled() bool {
+	return c.File != ""
+}
+
+func DefaultOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		MaxAttempts:  20,
+		InitialDelay: 30 * time.Second,
+		MaxDelay:     15 * time.Minute,
+	}
+}
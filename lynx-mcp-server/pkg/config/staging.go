@@ -0,0 +1,23 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type StagingConfig struct {
+	Directory    string        `yaml:"directory"`
+	TTL          time.Duration `yaml:"ttl"`
+	MaxFileSize  int64         `yaml:"maxFileSize"`
+	MaxTotalSize int64         `yaml:"maxTotalSize"`
+}
+
+func DefaultStagingConfig() StagingConfig {
+	return StagingConfig{
+		Directory:    filepath.Join(os.TempDir(), "lynx-staging"),
+		TTL:          30 * time.Minute,
+		MaxFileSize:  32 << 20,  // 32MB
+		MaxTotalSize: 512 << 20, // 512MB
+	}
+}
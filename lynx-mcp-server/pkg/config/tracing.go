@@ -0,0 +1,32 @@
+package config
+
+const (
+	TRACING_EXPORTER_NONE   = "none"
+	TRACING_EXPORTER_OTLP   = "otlp"
+	TRACING_EXPORTER_STDOUT = "stdout"
+)
+
+// TracingConfig holds the OpenTelemetry exporter of the spans covering MCP calls and Lynx requests
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+	ServiceName string  `yaml:"serviceName"`
+}
+
+// Enabled reports whether spans are exported
+func (t TracingConfig) Enabled() bool {
+	return t.Exporter != TRACING_EXPORTER_NONE
+}
+
+func DefaultTracingConfig() TracingConfig {
+	return TracingConfig{
+		Exporter: TRACING_EXPORTER_NONE,
+		// OTLP over HTTP to a collector on the same host
+		Endpoint:    "localhost:4318",
+		Insecure:    true,
+		SampleRatio: 1,
+		ServiceName: "lynx-mcp-server",
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// UploadConfig bounds the attachments forwarded to Lynx. Resumable uploads are kept in ChunkDirectory, up to
+// MaxSessions at once taking up to MaxChunkDirectorySize bytes together.
+type UploadConfig struct {
+	MaxSize               int64         `yaml:"maxSize"`
+	ChunkDirectory        string        `yaml:"chunkDirectory"`
+	SessionTTL            time.Duration `yaml:"sessionTTL"`
+	MaxSessions           int           `yaml:"maxSessions"`
+	MaxChunkDirectorySize int64         `yaml:"maxChunkDirectorySize"`
+}
+
+func DefaultUploadConfig() UploadConfig {
+	return UploadConfig{
+		MaxSize:               32 << 20, // 32MB
+		ChunkDirectory:        filepath.Join(os.TempDir(), "lynx-uploads"),
+		SessionTTL:            24 * time.Hour,
+		MaxSessions:           100,
+		MaxChunkDirectorySize: 1 << 30, // 1GB
+	}
+}
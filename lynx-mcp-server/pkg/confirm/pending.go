@@ -0,0 +1,287 @@
+package confirm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	DEFAULT_PENDING_ACTION_TTL = 10 * time.Minute
+	PREVIEW_CONTENT_MAX_LENGTH = 500
+	STATUS_PENDING             = "pending_confirmation"
+)
+
+var (
+	ErrUnknownAction    = errors.New("unknown or expired action")
+	ErrAwaitingApproval = errors.New("action awaits approval")
+	ErrDenied           = errors.New("action was denied")
+	ErrSelfApproval     = errors.New("action can't be approved by the identity that requested it")
+	ErrAlreadyApproved  = errors.New("action was already approved")
+	ErrOtherSession     = errors.New("action belongs to another session")
+)
+
+// PendingAction is a write tool call held back until a person approves it
+type PendingAction struct {
+	ID         string                 `json:"actionId"`
+	ToolName   string                 `json:"toolName"`
+	Preview    map[string]interface{} `json:"preview"`
+	Identity   string                 `json:"identity,omitempty"`
+	ApprovedBy string                 `json:"approvedBy,omitempty"`
+	SessionID  string                 `json:"-"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	ExpiresAt  time.Time              `json:"expiresAt"`
+
+	deniedBy string
+	request  mcp.CallToolRequest
+	handler  server.ToolHandlerFunc
+}
+
+// Store keeps pending actions in memory until they are confirmed, rejected or expired
+type Store struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	policy  server.ToolHandlerMiddleware
+	actions map[string]*PendingAction
+}
+
+// NewStore creates a pending action store, actions expire after ttl. Confirmed actions run through policy, which
+// applies the deadline and retry policy of their own tool rather than the ones of confirm_action.
+func NewStore(ttl time.Duration, policy server.ToolHandlerMiddleware) *Store {
+	if ttl <= 0 {
+		ttl = DEFAULT_PENDING_ACTION_TTL
+	}
+	if policy == nil {
+		policy = func(next server.ToolHandlerFunc) server.ToolHandlerFunc { return next }
+	}
+
+	return &Store{
+		ttl:     ttl,
+		policy:  policy,
+		actions: make(map[string]*PendingAction),
+	}
+}
+
+// RequireConfirmation wraps a write tool handler so that calling it only records a pending action
+// with a preview, the wrapped handler runs once the action is confirmed
+func (s *Store) RequireConfirmation(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		action, err := s.add(ctx, request, next)
+		if err != nil {
+			return nil, err
+		}
+
+		slog.InfoContext(ctx, "Pending action created", "action", action.ID, "tool", action.ToolName)
+
+		return utils.NewToolResultJSON(map[string]interface{}{
+			"status":    STATUS_PENDING,
+			"actionId":  action.ID,
+			"toolName":  action.ToolName,
+			"preview":   action.Preview,
+			"expiresAt": action.ExpiresAt,
+			"message":   "This action modifies Lynx and needs a person to approve it outside this conversation, with POST /actions/" + action.ID + "/approve. Show the preview to the user and call confirm_action with this actionId once they approved it.",
+		}), nil
+	}
+}
+
+// Approve records that a person approved the pending action with the given ID, it runs once confirm_action is
+// called from the session that requested it. The token that requested the action can't approve it.
+func (s *Store) Approve(ctx context.Context, actionID string) (*PendingAction, error) {
+	return s.settle(ctx, actionID, func(action *PendingAction, approver string) {
+		action.ApprovedBy = approver
+	})
+}
+
+// Deny discards the pending action with the given ID on behalf of a person, confirm_action then reports it was
+// denied
+func (s *Store) Deny(ctx context.Context, actionID string) (*PendingAction, error) {
+	return s.settle(ctx, actionID, func(action *PendingAction, approver string) {
+		action.deniedBy = approver
+	})
+}
+
+// List returns the pending actions, oldest first
+func (s *Store) List() []PendingAction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpiredLocked(time.Now())
+
+	actions := make([]PendingAction, 0, len(s.actions))
+	for _, action := range s.actions {
+		if action.deniedBy == "" {
+			actions = append(actions, *action)
+		}
+	}
+	slices.SortFunc(actions, func(a, b PendingAction) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return actions
+}
+
+// Confirm runs the pending action with the given ID once a person approved it, and removes it from the store
+func (s *Store) Confirm(ctx context.Context, actionID string) (*mcp.CallToolResult, error) {
+	action, err := s.take(ctx, actionID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Pending action confirmed", "action", action.ID, "tool", action.ToolName, "approvedBy", action.ApprovedBy)
+
+	return s.policy(action.handler)(ctx, action.request)
+}
+
+// Reject discards the pending action with the given ID without running it
+func (s *Store) Reject(ctx context.Context, actionID string) (*PendingAction, error) {
+	action, err := s.take(ctx, actionID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Pending action rejected", "action", action.ID, "tool", action.ToolName)
+
+	return action, nil
+}
+
+func (s *Store) add(ctx context.Context, request mcp.CallToolRequest, handler server.ToolHandlerFunc) (*PendingAction, error) {
+	id, err := newActionID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	action := &PendingAction{
+		ID:        id,
+		ToolName:  request.Params.Name,
+		Preview:   buildPreview(request),
+		Identity:  auth.IdentityFromContext(ctx),
+		SessionID: sessionIDFromContext(ctx),
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+		request:   request,
+		handler:   handler,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpiredLocked(now)
+	s.actions[id] = action
+
+	return action, nil
+}
+
+// take removes the pending action from the store for the session that created it, approved tells whether it must
+// have been approved
+func (s *Store) take(ctx context.Context, actionID string, approved bool) (*PendingAction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpiredLocked(time.Now())
+
+	action, ok := s.actions[actionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAction, actionID)
+	}
+
+	// Pending actions can only be settled from the MCP session that created them
+	if action.SessionID != sessionIDFromContext(ctx) {
+		return nil, fmt.Errorf("%w: %s", ErrOtherSession, actionID)
+	}
+
+	if action.deniedBy != "" {
+		delete(s.actions, actionID)
+		return nil, fmt.Errorf("%w by %s: %s", ErrDenied, action.deniedBy, actionID)
+	}
+	if approved && action.ApprovedBy == "" {
+		return nil, fmt.Errorf("%w: %s, a person must approve it with POST /actions/%s/approve", ErrAwaitingApproval, actionID, actionID)
+	}
+
+	delete(s.actions, actionID)
+
+	return action, nil
+}
+
+// settle records the decision of a person on a pending action, outside the MCP session that created it
+func (s *Store) settle(ctx context.Context, actionID string, decide func(action *PendingAction, approver string)) (*PendingAction, error) {
+	approver := auth.IdentityFromContext(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpiredLocked(time.Now())
+
+	action, found := s.actions[actionID]
+	if !found || action.deniedBy != "" {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAction, actionID)
+	}
+	if action.ApprovedBy != "" {
+		return nil, fmt.Errorf("%w by %s: %s", ErrAlreadyApproved, action.ApprovedBy, actionID)
+	}
+	// The agent that requested a change can't approve it itself, JWT and OAuth tokens all share one name: the
+	// identities are compared
+	if approver == "" || strings.EqualFold(approver, action.Identity) {
+		return nil, fmt.Errorf("%w: %s", ErrSelfApproval, actionID)
+	}
+
+	decide(action, approver)
+	slog.InfoContext(ctx, "Pending action settled", "action", action.ID, "tool", action.ToolName, "approvedBy", action.ApprovedBy, "deniedBy", action.deniedBy)
+
+	settled := *action
+	return &settled, nil
+}
+
+func (s *Store) removeExpiredLocked(now time.Time) {
+	for id, action := range s.actions {
+		if now.After(action.ExpiresAt) {
+			delete(s.actions, id)
+		}
+	}
+}
+
+// buildPreview describes the tool call without leaking binaries or overly long content
+func buildPreview(request mcp.CallToolRequest) map[string]interface{} {
+	arguments := make(map[string]interface{})
+	for key, value := range request.GetArguments() {
+		stringValue, ok := value.(string)
+
+		switch {
+		case key == "binary" && ok:
+			arguments[key] = fmt.Sprintf("<%d base64 characters>", len(stringValue))
+		case ok && len([]rune(stringValue)) > PREVIEW_CONTENT_MAX_LENGTH:
+			arguments[key] = string([]rune(stringValue)[:PREVIEW_CONTENT_MAX_LENGTH]) + "..."
+		default:
+			arguments[key] = value
+		}
+	}
+
+	return map[string]interface{}{
+		"tool":      request.Params.Name,
+		"arguments": arguments,
+	}
+}
+
+func sessionIDFromContext(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
+
+func newActionID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate action ID: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
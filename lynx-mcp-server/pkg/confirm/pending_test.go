@@ -0,0 +1,209 @@
+package confirm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+type fakeSession struct {
+	id string
+}
+
+func (f *fakeSession) Initialize()                                         {}
+func (f *fakeSession) Initialized() bool                                   { return true }
+func (f *fakeSession) NotificationChannel() chan<- mcp.JSONRPCNotification { return nil }
+func (f *fakeSession) SessionID() string                                   { return f.id }
+
+func newRequest(arguments map[string]interface{}) mcp.CallToolRequest {
+	return mcp.CallToolRequest{
+		Params: mcp.CallToolParams{
+			Name:      "file_document_save",
+			Arguments: arguments,
+		},
+	}
+}
+
+func pendingActionID(t *testing.T, result *mcp.CallToolResult) string {
+	t.Helper()
+	text := result.Content[0].(mcp.TextContent).Text
+	idx := strings.Index(text, `"actionId":"`)
+	if idx < 0 {
+		t.Fatalf("expected actionId in result, got %s", text)
+	}
+	rest := text[idx+len(`"actionId":"`):]
+	return rest[:strings.Index(rest, `"`)]
+}
+
+func TestRequireConfirmation(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	agent := &auth.Token{Name: "n8n", Identity: "n8n"}
+	ctx := auth.WithToken(mcpServer.WithContext(context.Background(), &fakeSession{id: "session-1"}), agent)
+	otherCtx := auth.WithToken(mcpServer.WithContext(context.Background(), &fakeSession{id: "session-2"}), agent)
+	personCtx := auth.WithToken(context.Background(), &auth.Token{Name: "jdoe", Identity: "jdoe@example.com"})
+
+	calls := 0
+	handler := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		calls++
+		return mcp.NewToolResultText("saved"), nil
+	}
+
+	// The policy of the confirmed tool applies, not the one of confirm_action
+	var policyTools []string
+	store := NewStore(time.Minute, func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			policyTools = append(policyTools, request.Params.Name)
+			return next(ctx, request)
+		}
+	})
+	wrapped := store.RequireConfirmation(handler)
+
+	tests := []struct {
+		name          string
+		approveCtx    context.Context
+		deny          bool
+		confirmCtx    context.Context
+		wantSettleErr error
+		wantErr       error
+		expectedCalls int
+	}{
+		{
+			name:          "Approved by a person runs the handler",
+			approveCtx:    personCtx,
+			confirmCtx:    ctx,
+			expectedCalls: 1,
+		},
+		{
+			name:       "Not approved is refused",
+			confirmCtx: ctx,
+			wantErr:    ErrAwaitingApproval,
+		},
+		{
+			name:          "Approved by the agent itself is refused",
+			approveCtx:    ctx,
+			confirmCtx:    ctx,
+			wantSettleErr: ErrSelfApproval,
+			wantErr:       ErrAwaitingApproval,
+		},
+		{
+			name:       "Denied by a person is refused",
+			approveCtx: personCtx,
+			deny:       true,
+			confirmCtx: ctx,
+			wantErr:    ErrDenied,
+		},
+		{
+			name:       "Confirmed from another session is refused",
+			approveCtx: personCtx,
+			confirmCtx: otherCtx,
+			wantErr:    ErrOtherSession,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			policyTools = nil
+
+			result, err := wrapped(ctx, newRequest(map[string]interface{}{"name": "voucher"}))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if calls != 0 {
+				t.Fatalf("handler ran before confirmation")
+			}
+			actionID := pendingActionID(t, result)
+
+			if tt.approveCtx != nil {
+				settle := store.Approve
+				if tt.deny {
+					settle = store.Deny
+				}
+				if _, err := settle(tt.approveCtx, actionID); !errors.Is(err, tt.wantSettleErr) {
+					t.Errorf("settling = %v, want %v", err, tt.wantSettleErr)
+				}
+			}
+
+			_, err = store.Confirm(tt.confirmCtx, actionID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Confirm() = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.expectedCalls {
+				t.Errorf("expected %d handler calls, got %d", tt.expectedCalls, calls)
+			}
+			if calls > 0 && (len(policyTools) != 1 || policyTools[0] != "file_document_save") {
+				t.Errorf("policy applied to %v, want file_document_save", policyTools)
+			}
+		})
+	}
+}
+
+func TestApproveJWTIdentities(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0")
+	store := NewStore(time.Minute, nil)
+	wrapped := store.RequireConfirmation(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("saved"), nil
+	})
+
+	// JWT and OAuth tokens share their name, the identity tells the agent from the person
+	agent := &auth.Token{Name: auth.JWT_TOKEN_NAME, Identity: "agent@example.com"}
+	person := &auth.Token{Name: auth.JWT_TOKEN_NAME, Identity: "jdoe@example.com"}
+	ctx := auth.WithToken(mcpServer.WithContext(context.Background(), &fakeSession{id: "session-1"}), agent)
+
+	result, err := wrapped(ctx, newRequest(map[string]interface{}{"name": "voucher"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actionID := pendingActionID(t, result)
+
+	if _, err := store.Approve(auth.WithToken(context.Background(), agent), actionID); !errors.Is(err, ErrSelfApproval) {
+		t.Errorf("Approve() by the agent = %v, want %v", err, ErrSelfApproval)
+	}
+	if _, err := store.Approve(auth.WithToken(context.Background(), person), actionID); err != nil {
+		t.Errorf("Approve() by another identity = %v, want approved", err)
+	}
+	if _, err := store.Confirm(ctx, actionID); err != nil {
+		t.Errorf("Confirm() = %v, want the action run", err)
+	}
+}
+
+func TestConfirmExpiredAction(t *testing.T) {
+	store := NewStore(time.Millisecond, nil)
+	wrapped := store.RequireConfirmation(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		t.Fatalf("handler should not run for an expired action")
+		return nil, nil
+	})
+
+	result, err := wrapped(context.Background(), newRequest(map[string]interface{}{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := store.Confirm(context.Background(), pendingActionID(t, result)); err == nil {
+		t.Errorf("expected error for expired action")
+	}
+}
+
+func TestBuildPreviewHidesBinary(t *testing.T) {
+	preview := buildPreview(newRequest(map[string]interface{}{
+		"binary":  "QUJDRA==",
+		"content": strings.Repeat("a", PREVIEW_CONTENT_MAX_LENGTH+10),
+	}))
+
+	arguments := preview["arguments"].(map[string]interface{})
+	if arguments["binary"] != "<8 base64 characters>" {
+		t.Errorf("expected binary to be summarised, got %v", arguments["binary"])
+	}
+	if content := arguments["content"].(string); len(content) != PREVIEW_CONTENT_MAX_LENGTH+3 {
+		t.Errorf("expected content to be truncated, got %d characters", len(content))
+	}
+}
@@ -0,0 +1,151 @@
+package credentials
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Command runs the credentials subcommand. Secret values are read from the terminal without echo, or from stdin
+// when piped, never from the arguments. isName restricts the names that can be stored.
+func Command(name string, args []string, isName func(string) bool) error {
+	path := os.Getenv(STORE_FILE_ENV)
+
+	flags := flag.NewFlagSet(name+" credentials", flag.ContinueOnError)
+	flags.StringVar(&path, "credentials", path, "Encrypted credential store (env "+STORE_FILE_ENV+")")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `Usage: %s credentials [--credentials FILE] COMMAND
+
+Commands:
+  generate-key  Print a new master key, keep it in %s or %s%s
+  set NAME      Store a secret, e.g. LYNX_PASSWORD, read from the terminal or stdin
+  delete NAME   Remove a secret
+  list          List the stored secret names
+
+Flags:
+`, name, MASTER_KEY_ENV, MASTER_KEY_ENV, FILE_SUFFIX)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	command, operands := flags.Arg(0), flags.Args()[1:]
+
+	if command == "generate-key" {
+		key, err := GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	}
+
+	if path == "" {
+		return fmt.Errorf("credential store is required (--credentials or env %s)", STORE_FILE_ENV)
+	}
+
+	key, err := MasterKey()
+	if err != nil {
+		return err
+	}
+
+	store, err := Open(path, key)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "list":
+		for _, name := range store.Names() {
+			fmt.Println(name)
+		}
+		return nil
+
+	case "set":
+		if len(operands) != 1 {
+			return fmt.Errorf("usage: %s credentials set NAME", name)
+		}
+		if !isName(operands[0]) {
+			return fmt.Errorf("unknown setting %s", operands[0])
+		}
+
+		value, err := ReadSecret(operands[0])
+		if err != nil {
+			return err
+		}
+
+		store.Set(operands[0], value)
+		if err := store.Save(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Stored %s in %s\n", operands[0], path)
+		return nil
+
+	case "delete":
+		if len(operands) != 1 {
+			return fmt.Errorf("usage: %s credentials delete NAME", name)
+		}
+		if !store.Delete(operands[0]) {
+			return fmt.Errorf("%s is not in %s", operands[0], path)
+		}
+		if err := store.Save(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Deleted %s from %s\n", operands[0], path)
+		return nil
+	}
+
+	return fmt.Errorf("unknown credentials command %q", command)
+}
+
+// ReadSecret prompts twice on a terminal without echo, otherwise it reads the first line of stdin
+func ReadSecret(name string) (string, error) {
+	fd := int(os.Stdin.Fd())
+
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("failed to read %s from stdin: %w", name, err)
+		}
+		value := strings.TrimRight(line, "\r\n")
+		if value == "" {
+			return "", fmt.Errorf("%s is empty", name)
+		}
+		return value, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", name)
+	value, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if len(value) == 0 {
+		return "", fmt.Errorf("%s is empty", name)
+	}
+
+	fmt.Fprintf(os.Stderr, "Repeat %s: ", name)
+	repeated, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if string(value) != string(repeated) {
+		return "", fmt.Errorf("values don't match")
+	}
+
+	return string(value), nil
+}
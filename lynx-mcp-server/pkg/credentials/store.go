@@ -0,0 +1,236 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// STORE_FILE_ENV points to the credential store when --credentials isn't given
+	STORE_FILE_ENV = "LYNX_MCP_CREDENTIALS"
+
+	// MASTER_KEY_ENV holds the base64 encoded key of the credential store, or use MASTER_KEY_ENV + FILE_SUFFIX
+	MASTER_KEY_ENV = "LYNX_MCP_MASTER_KEY"
+
+	// FILE_SUFFIX marks an environment variable naming a file that holds the value, e.g. Docker or Kubernetes secrets
+	FILE_SUFFIX = "_FILE"
+
+	KEY_SIZE      = 32
+	STORE_VERSION = 1
+
+	// additionalData binds the ciphertext to this file format
+	additionalData = "lynx-mcp-credentials/v1"
+)
+
+var (
+	ErrNoMasterKey = errors.New("master key is required (env " + MASTER_KEY_ENV + " or " + MASTER_KEY_ENV + FILE_SUFFIX + ")")
+	ErrWrongKey    = errors.New("credential store cannot be decrypted, wrong master key or corrupted file")
+)
+
+// Store holds secrets in a file encrypted with AES-256-GCM, the plain values only live in memory
+type Store struct {
+	path    string
+	key     []byte
+	secrets map[string]string
+}
+
+// storeFile is the on-disk format, the nonce and ciphertext are base64 encoded by encoding/json
+type storeFile struct {
+	Version    int    `json:"version"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// GenerateKey returns a new random master key, base64 encoded
+func GenerateKey() (string, error) {
+	key := make([]byte, KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate master key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseKey decodes a base64 master key
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != KEY_SIZE {
+		return nil, fmt.Errorf("master key must be %d bytes, base64 encoded", KEY_SIZE)
+	}
+	return key, nil
+}
+
+// MasterKey reads the master key from the environment or from the file it points to
+func MasterKey() ([]byte, error) {
+	encoded, ok, err := LookupEnv(MASTER_KEY_ENV)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNoMasterKey
+	}
+	return ParseKey(encoded)
+}
+
+// LookupEnv returns the value of an environment variable, or the content of the file named by its _FILE variant.
+// Setting both is an error since it's unclear which one should win.
+func LookupEnv(name string) (string, bool, error) {
+	value := os.Getenv(name)
+	path := os.Getenv(name + FILE_SUFFIX)
+
+	if path == "" {
+		return value, value != "", nil
+	}
+	if value != "" {
+		return "", false, fmt.Errorf("set either %s or %s%s, not both", name, name, FILE_SUFFIX)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s%s: %w", name, FILE_SUFFIX, err)
+	}
+
+	// Secret files usually end with a newline that isn't part of the value
+	value = strings.TrimRight(string(content), "\r\n")
+	return value, value != "", nil
+}
+
+// Open decrypts the credential store, a missing file is an empty store
+func Open(path string, key []byte) (*Store, error) {
+	store := &Store{
+		path:    path,
+		key:     key,
+		secrets: make(map[string]string),
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential store: %w", err)
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse credential store %s: %w", path, err)
+	}
+	if file.Version != STORE_VERSION {
+		return nil, fmt.Errorf("unsupported credential store version %d", file.Version)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, ErrWrongKey
+	}
+
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, []byte(additionalData))
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+
+	if err := json.Unmarshal(plaintext, &store.secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse credential store secrets: %w", err)
+	}
+
+	return store, nil
+}
+
+// Get returns a secret
+func (s *Store) Get(name string) (string, bool) {
+	value, ok := s.secrets[name]
+	return value, ok
+}
+
+// Set adds or replaces a secret, call Save to persist it
+func (s *Store) Set(name string, value string) {
+	s.secrets[name] = value
+}
+
+// Delete removes a secret and reports whether it existed, call Save to persist it
+func (s *Store) Delete(name string) bool {
+	_, ok := s.secrets[name]
+	delete(s.secrets, name)
+	return ok
+}
+
+// Names returns the secret names, sorted
+func (s *Store) Names() []string {
+	names := make([]string, 0, len(s.secrets))
+	for name := range s.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the secrets with a fresh nonce and replaces the file atomically
+func (s *Store) Save() error {
+	plaintext, err := json.Marshal(s.secrets)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets: %w", err)
+	}
+
+	aead, err := newAEAD(s.key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	content, err := json.MarshalIndent(storeFile{
+		Version:    STORE_VERSION,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, []byte(additionalData)),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode credential store: %w", err)
+	}
+
+	directory := filepath.Dir(s.path)
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return fmt.Errorf("failed to create credential store directory: %w", err)
+	}
+
+	temp, err := os.CreateTemp(directory, ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("failed to create credential store: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write credential store: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write credential store: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace credential store: %w", err)
+	}
+
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
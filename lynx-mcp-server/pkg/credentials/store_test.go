@@ -0,0 +1,110 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	key := newKey(t)
+
+	store, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("LYNX_PASSWORD", "secret")
+	store.Set("BEARER_TOKEN", "token")
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secret") || strings.Contains(string(content), "LYNX_PASSWORD") {
+		t.Errorf("store is not encrypted:\n%s", content)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	reopened, err := Open(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := reopened.Get("LYNX_PASSWORD"); !ok || value != "secret" {
+		t.Errorf("expected secret, got %q", value)
+	}
+	if names := reopened.Names(); strings.Join(names, ",") != "BEARER_TOKEN,LYNX_PASSWORD" {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	if _, err := Open(path, newKey(t)); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("expected ErrWrongKey, got %v", err)
+	}
+}
+
+func TestLookupEnv(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("from-file\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		file     string
+		expected string
+		ok       bool
+		err      string
+	}{
+		{"unset", "", "", "", false, ""},
+		{"value", "from-env", "", "from-env", true, ""},
+		{"file", "", secret, "from-file", true, ""},
+		{"both", "from-env", secret, "", false, "set either"},
+		{"missing file", "", secret + ".missing", "", false, "failed to read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LYNX_TEST_SECRET", tt.value)
+			t.Setenv("LYNX_TEST_SECRET_FILE", tt.file)
+
+			value, ok, err := LookupEnv("LYNX_TEST_SECRET")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != tt.expected || ok != tt.ok {
+				t.Errorf("expected %q/%v, got %q/%v", tt.expected, tt.ok, value, ok)
+			}
+		})
+	}
+}
+
+func newKey(t *testing.T) []byte {
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
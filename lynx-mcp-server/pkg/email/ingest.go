@@ -0,0 +1,407 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/outbox"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/output"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+)
+
+const (
+	DEFAULT_DOCUMENT_TYPE    = "EMAIL"
+	DOCUMENT_NAME_MAX_LENGTH = 100
+
+	MATCHED_BY_VOUCHER      = "voucher"
+	MATCHED_BY_CONFIRMATION = "confirmationNumber"
+	MATCHED_BY_ARGUMENT     = "argument"
+	MATCHED_BY_NONE         = "none"
+	MATCHED_BY_AMBIGUOUS    = "ambiguous"
+)
+
+// Arguments of email_ingest recorded with outbox.Done, a queued email doesn't file the documents of earlier attempts
+// again
+const (
+	FILED_DOCUMENTS_ARGUMENT         = "filedDocuments"
+	UPLOADED_ATTACHMENT_URL_ARGUMENT = "uploadedAttachmentUrl"
+)
+
+// ErrFileNotFound is returned when an email does not refer to a known Lynx file
+var ErrFileNotFound = errors.New("no file found for email")
+
+// fileReferencePattern matches Lynx file references such as FTSWA230184
+var fileReferencePattern = regexp.MustCompile(`(?i)\bFT[A-Z]{2,4}[0-9]{5,7}\b`)
+
+// Ingestion holds the optional overrides for Ingest
+type Ingestion struct {
+	FileReference         string
+	TransactionIdentifier string
+	DocumentType          string
+
+	// Filed is how many documents earlier attempts filed, the note and then the attachments in order, they are skipped
+	Filed int
+	// UploadedAttachmentURL is the attachment earlier attempts uploaded for the next document, it isn't uploaded again
+	UploadedAttachmentURL string
+}
+
+// IngestionResult reports where an email was filed and which documents were created.
+// Error is set when filing stopped part way, Documents then lists what was filed before the failure.
+type IngestionResult struct {
+	Subject               string              `json:"subject"`
+	From                  string              `json:"from"`
+	FileReference         string              `json:"fileReference"`
+	FileIdentifier        string              `json:"fileIdentifier"`
+	TransactionIdentifier string              `json:"transactionIdentifier,omitempty"`
+	MatchedBy             string              `json:"matchedBy"`
+	Candidates            []string            `json:"candidates,omitempty"`
+	Warning               string              `json:"warning,omitempty"`
+	Documents             []IngestionDocument `json:"documents"`
+	Error                 string              `json:"error,omitempty"`
+}
+
+// IngestionDocument is a document saved while ingesting an email
+type IngestionDocument struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	AttachmentURL string `json:"attachmentUrl,omitempty"`
+	Size          int64  `json:"size,omitempty"`
+}
+
+// Ingester files supplier emails against the Lynx bookings they refer to
+type Ingester struct {
+	lynxConfig config.LynxServerConfig
+	uploads    *upload.Service
+}
+
+// NewIngester creates an Ingester uploading attachments through the shared upload service
+func NewIngester(lynxConfig config.LynxServerConfig, uploads *upload.Service) *Ingester {
+	return &Ingester{
+		lynxConfig: lynxConfig,
+		uploads:    uploads,
+	}
+}
+
+// Ingest files a parsed email against the booking it refers to.
+// The file is found by file reference and the transaction by voucher or confirmation number,
+// the email is filed at file level when no single transaction matches.
+// Filing isn't atomic: when it fails after a document was saved, the result lists the documents filed so far
+// next to the error. The documents filed are recorded with outbox.Done, a queued email files the others.
+func (i *Ingester) Ingest(
+	ctx context.Context,
+	session *utils.SessionContext,
+	message *Message,
+	ingestion Ingestion,
+) (*IngestionResult, error) {
+	text := message.Text()
+
+	searchable := message.Subject + "\n" + text
+	for _, attachment := range message.Attachments {
+		searchable += "\n" + attachment.FileName
+	}
+
+	// Oversized attachments are refused before anything is filed
+	for _, attachment := range message.Attachments {
+		if attachment.Size > i.uploads.MaxSize() {
+			return nil, fmt.Errorf("email attachment %s: %w", attachment.FileName, upload.ErrTooLarge)
+		}
+	}
+
+	file, err := i.findFile(ctx, session, searchable, ingestion.FileReference)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &IngestionResult{
+		Subject:        message.Subject,
+		From:           message.From,
+		FileReference:  file.FileReference,
+		FileIdentifier: file.FileIdentifier,
+		MatchedBy:      MATCHED_BY_NONE,
+		Documents:      []IngestionDocument{},
+	}
+
+	if ingestion.TransactionIdentifier != "" {
+		result.TransactionIdentifier = ingestion.TransactionIdentifier
+		result.MatchedBy = MATCHED_BY_ARGUMENT
+	} else {
+		itinerary, err := lynx.RetrieveItinerary(ctx, i.lynxConfig, session, file.FileIdentifier)
+		if err != nil {
+			return nil, err
+		}
+
+		matches, matchedBy := matchTransactions(searchable, itinerary.Itineraries)
+		if len(matches) == 1 {
+			result.TransactionIdentifier = matches[0].TransactionIdentifier
+			result.MatchedBy = matchedBy
+		}
+		if len(matches) > 1 {
+			for _, match := range matches {
+				result.Candidates = append(result.Candidates, match.TransactionIdentifier)
+			}
+			result.MatchedBy = MATCHED_BY_AMBIGUOUS
+			result.Warning = fmt.Sprintf("%d transactions match the email by %s, it was filed at file level: call again with the transactionIdentifier of a candidate to file it against one", len(matches), matchedBy)
+		}
+	}
+
+	documentType := ingestion.DocumentType
+	if documentType == "" {
+		documentType = DEFAULT_DOCUMENT_TYPE
+	}
+
+	if ingestion.Filed == 0 {
+		name := truncateRunes("Email: "+message.Subject, DOCUMENT_NAME_MAX_LENGTH)
+		if err := i.saveDocument(ctx, session, result, name, noteContent(message, text), documentType, ""); err != nil {
+			return nil, err
+		}
+		result.Documents = append(result.Documents, IngestionDocument{Name: name, Type: documentType})
+		filed(ctx, result, 1)
+	}
+
+	for index, attachment := range message.Attachments {
+		// The note is the first document
+		document := index + 1
+		if document < ingestion.Filed {
+			continue
+		}
+
+		uploaded := &upload.Result{AttachmentURL: ingestion.UploadedAttachmentURL}
+		if document > ingestion.Filed || uploaded.AttachmentURL == "" {
+			var err error
+			uploaded, err = i.uploadAttachment(ctx, session, file.FileIdentifier, attachment)
+			if err != nil {
+				return partial(result, fmt.Errorf("failed to upload email attachment %s: %w", attachment.FileName, err))
+			}
+			outbox.Done(ctx, map[string]any{UPLOADED_ATTACHMENT_URL_ARGUMENT: uploaded.AttachmentURL})
+		}
+
+		name := truncateRunes(attachment.FileName, DOCUMENT_NAME_MAX_LENGTH)
+		content := "<p>Attachment of email " + html.EscapeString(message.Subject) + "</p>"
+		if err := i.saveDocument(ctx, session, result, name, content, documentType, uploaded.AttachmentURL); err != nil {
+			return partial(result, fmt.Errorf("failed to save email attachment %s uploaded to %s: %w", attachment.FileName, uploaded.AttachmentURL, err))
+		}
+
+		result.Documents = append(result.Documents, IngestionDocument{
+			Name:          name,
+			Type:          documentType,
+			AttachmentURL: uploaded.AttachmentURL,
+			Size:          uploaded.Size,
+		})
+		filed(ctx, result, document+1)
+	}
+
+	return result, nil
+}
+
+// filed records the documents filed so far with outbox.Done, a queued email files the others against the same booking
+func filed(ctx context.Context, result *IngestionResult, documents int) {
+	arguments := map[string]any{
+		"fileReference":                  result.FileReference,
+		FILED_DOCUMENTS_ARGUMENT:         documents,
+		UPLOADED_ATTACHMENT_URL_ARGUMENT: nil,
+	}
+	if result.TransactionIdentifier != "" {
+		arguments["transactionIdentifier"] = result.TransactionIdentifier
+	}
+	outbox.Done(ctx, arguments)
+}
+
+// partial returns the documents filed before err next to it
+func partial(result *IngestionResult, err error) (*IngestionResult, error) {
+	result.Error = err.Error()
+	return result, err
+}
+
+// uploadAttachment streams a spooled attachment to Lynx
+func (i *Ingester) uploadAttachment(
+	ctx context.Context,
+	session *utils.SessionContext,
+	fileIdentifier string,
+	attachment Attachment,
+) (*upload.Result, error) {
+	content, err := attachment.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return i.uploads.Upload(ctx, session, upload.Request{
+		FileIdentifier: fileIdentifier,
+		FileName:       attachment.FileName,
+		Content:        content,
+	})
+}
+
+// saveDocument saves against the matched transaction, or at file level when there is none
+func (i *Ingester) saveDocument(
+	ctx context.Context,
+	session *utils.SessionContext,
+	result *IngestionResult,
+	name string,
+	content string,
+	documentType string,
+	attachmentUrl string,
+) error {
+	if result.TransactionIdentifier == "" {
+		return lynx.SaveFileDocument(ctx, i.lynxConfig, session, &gwt.FileDocumentSaveDetailsArgs{
+			FileIdentifier: result.FileIdentifier,
+
+			Name:          name,
+			Content:       content,
+			Type:          documentType,
+			AttachmentURL: attachmentUrl,
+		})
+	}
+
+	return lynx.SaveTransactionDocument(ctx, i.lynxConfig, session, &gwt.TransactionDocumentSaveDetailsArgs{
+		FileIdentifier:        result.FileIdentifier,
+		TransactionIdentifier: result.TransactionIdentifier,
+
+		Name:          name,
+		Content:       content,
+		Type:          documentType,
+		AttachmentURL: attachmentUrl,
+	})
+}
+
+// findFile returns the first file reference of the email that resolves to a Lynx file
+func (i *Ingester) findFile(ctx context.Context, session *utils.SessionContext, text string, fileReference string) (*gwt.FileSearchResult, error) {
+	candidates := []string{fileReference}
+	if fileReference == "" {
+		candidates = findFileReferences(text)
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: no file reference found, pass fileReference explicitly", ErrFileNotFound)
+	}
+
+	for _, candidate := range candidates {
+		file, err := lynx.FindFileByReference(ctx, i.lynxConfig, session, candidate)
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, lynx.ErrFileNotFound) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("%w: file references %s", ErrFileNotFound, strings.Join(candidates, ", "))
+}
+
+// findFileReferences returns the distinct file references of a text, in order of appearance
+func findFileReferences(text string) []string {
+	var references []string
+	seen := map[string]bool{}
+
+	for _, match := range fileReferencePattern.FindAllString(text, -1) {
+		reference := strings.ToUpper(match)
+		if !seen[reference] {
+			seen[reference] = true
+			references = append(references, reference)
+		}
+	}
+
+	return references
+}
+
+// matchTransactions finds the transactions whose voucher, or else confirmation number, appears in the text
+func matchTransactions(text string, transactions []gwt.ItineraryTransactionSummary) ([]gwt.ItineraryTransactionSummary, string) {
+	text = strings.ToUpper(text)
+
+	var byVoucher, byConfirmation []gwt.ItineraryTransactionSummary
+	for _, transaction := range transactions {
+		if transaction.VoucherIdentifier != "" && containsToken(text, strings.ToUpper(transaction.VoucherIdentifier)) {
+			byVoucher = append(byVoucher, transaction)
+			continue
+		}
+
+		// Confirmation numbers may combine several supplier references, e.g. PPA3CC057402/XX124156
+		for _, confirmation := range strings.FieldsFunc(transaction.ConfirmationNumber, func(r rune) bool {
+			return r == '/' || r == ',' || unicode.IsSpace(r)
+		}) {
+			if len(confirmation) >= 4 && containsToken(text, strings.ToUpper(confirmation)) {
+				byConfirmation = append(byConfirmation, transaction)
+				break
+			}
+		}
+	}
+
+	if len(byVoucher) > 0 {
+		return byVoucher, MATCHED_BY_VOUCHER
+	}
+	if len(byConfirmation) > 0 {
+		return byConfirmation, MATCHED_BY_CONFIRMATION
+	}
+	return nil, MATCHED_BY_NONE
+}
+
+// containsToken reports whether token appears in text without being part of a longer word
+func containsToken(text string, token string) bool {
+	for offset := 0; ; {
+		index := strings.Index(text[offset:], token)
+		if index < 0 {
+			return false
+		}
+
+		start := offset + index
+		end := start + len(token)
+		if !isWordByte(text, start-1) && !isWordByte(text, end) {
+			return true
+		}
+		offset = start + 1
+	}
+}
+
+func isWordByte(text string, index int) bool {
+	if index < 0 || index >= len(text) {
+		return false
+	}
+	char := text[index]
+	return char == '-' || char >= '0' && char <= '9' || char >= 'A' && char <= 'Z' || char >= 'a' && char <= 'z'
+}
+
+// Text returns the plain text body, converted from the HTML body when there is none
+func (m *Message) Text() string {
+	if strings.TrimSpace(m.TextBody) != "" {
+		return m.TextBody
+	}
+	return output.HTMLToText(m.HTMLBody)
+}
+
+// noteContent renders the email headers and text as the HTML content of a document
+func noteContent(message *Message, text string) string {
+	var builder strings.Builder
+
+	builder.WriteString("<p>")
+	builder.WriteString("<b>From:</b> " + html.EscapeString(message.From) + "<br>")
+	if message.To != "" {
+		builder.WriteString("<b>To:</b> " + html.EscapeString(message.To) + "<br>")
+	}
+	if !message.Date.IsZero() {
+		builder.WriteString("<b>Date:</b> " + html.EscapeString(message.Date.Format("02 Jan 2006 03:04 PM")) + "<br>")
+	}
+	builder.WriteString("<b>Subject:</b> " + html.EscapeString(message.Subject))
+	builder.WriteString("</p>")
+
+	text = strings.ReplaceAll(strings.TrimSpace(text), "\r\n", "\n")
+	builder.WriteString("<p>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>") + "</p>")
+
+	return builder.String()
+}
+
+func truncateRunes(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+	return string(runes[:max])
+}
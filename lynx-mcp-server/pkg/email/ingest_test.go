@@ -0,0 +1,54 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+)
+
+func TestFindFileReferences(t *testing.T) {
+	references := findFileReferences("Re: ftswa230184 / FTAUB252039 and again FTSWA230184, not XFTSWA230184")
+
+	expected := []string{"FTSWA230184", "FTAUB252039"}
+	if !reflect.DeepEqual(references, expected) {
+		t.Errorf("expected %v, got %v", expected, references)
+	}
+}
+
+func TestMatchTransactions(t *testing.T) {
+	transactions := []gwt.ItineraryTransactionSummary{
+		{TransactionIdentifier: "BgBFw", VoucherIdentifier: "16454569-4", ConfirmationNumber: "PPA3CC057402/XX124156"},
+		{TransactionIdentifier: "BgBFx", VoucherIdentifier: "16454569-12", ConfirmationNumber: "RUE234MPTA"},
+		{TransactionIdentifier: "BgExO", VoucherIdentifier: "16454569-1", ConfirmationNumber: "223447575"},
+	}
+
+	tests := []struct {
+		name              string
+		text              string
+		expectedMatches   []string
+		expectedMatchedBy string
+	}{
+		{"voucher", "Voucher 16454569-12 attached", []string{"BgBFx"}, MATCHED_BY_VOUCHER},
+		{"voucher prefix is not a match", "Voucher 16454569-123", nil, MATCHED_BY_NONE},
+		{"voucher wins over confirmation", "16454569-1 and RUE234MPTA", []string{"BgExO"}, MATCHED_BY_VOUCHER},
+		{"confirmation part", "Supplier ref xx124156", []string{"BgBFw"}, MATCHED_BY_CONFIRMATION},
+		{"several confirmations", "RUE234MPTA, 223447575", []string{"BgBFx", "BgExO"}, MATCHED_BY_CONFIRMATION},
+		{"nothing", "Thank you for your booking", nil, MATCHED_BY_NONE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, matchedBy := matchTransactions(tt.text, transactions)
+
+			var identifiers []string
+			for _, match := range matches {
+				identifiers = append(identifiers, match.TransactionIdentifier)
+			}
+
+			if !reflect.DeepEqual(identifiers, tt.expectedMatches) || matchedBy != tt.expectedMatchedBy {
+				t.Errorf("expected %v by %s, got %v by %s", tt.expectedMatches, tt.expectedMatchedBy, identifiers, matchedBy)
+			}
+		})
+	}
+}
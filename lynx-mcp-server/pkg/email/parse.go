@@ -0,0 +1,269 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// MAX_PART_DEPTH bounds nested multiparts in malformed or hostile messages
+	MAX_PART_DEPTH = 10
+)
+
+// Message is a parsed RFC 822 email with its text bodies and MIME attachments
+type Message struct {
+	From        string
+	To          string
+	Subject     string
+	MessageID   string
+	Date        time.Time
+	TextBody    string
+	HTMLBody    string
+	Attachments []Attachment
+}
+
+// Attachment is a MIME part sent as a file, its decoded content is spooled to a temporary file
+type Attachment struct {
+	FileName    string
+	ContentType string
+	Size        int64
+	path        string
+}
+
+// Open returns the decoded content of the attachment
+func (a Attachment) Open() (io.ReadCloser, error) {
+	return os.Open(a.path)
+}
+
+// Close removes the spooled attachments of the message
+func (m *Message) Close() error {
+	var errs []error
+	for _, attachment := range m.Attachments {
+		if err := os.Remove(attachment.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Parse reads a raw RFC 822 / .eml message, the message must be closed to remove its spooled attachments
+func Parse(reader io.Reader) (*Message, error) {
+	parsed, err := mail.ReadMessage(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email: %w", err)
+	}
+
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+
+	message := &Message{
+		From:      decodeHeader(decoder, parsed.Header.Get("From")),
+		To:        decodeHeader(decoder, parsed.Header.Get("To")),
+		Subject:   decodeHeader(decoder, parsed.Header.Get("Subject")),
+		MessageID: strings.Trim(parsed.Header.Get("Message-Id"), "<> "),
+	}
+
+	if date, err := parsed.Header.Date(); err == nil {
+		message.Date = date
+	}
+
+	if err := message.readPart(decoder, textprotoHeader(parsed.Header), parsed.Body, 0); err != nil {
+		message.Close()
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// headerGetter is implemented by both mail.Header and textproto.MIMEHeader
+type headerGetter interface {
+	Get(key string) string
+}
+
+type textprotoHeader mail.Header
+
+func (h textprotoHeader) Get(key string) string {
+	return mail.Header(h).Get(key)
+}
+
+// readPart walks the MIME tree, keeping the first text bodies and collecting attachments
+func (m *Message) readPart(decoder *mime.WordDecoder, header headerGetter, body io.Reader, depth int) error {
+	if depth > MAX_PART_DEPTH {
+		return fmt.Errorf("email has too many nested parts")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		boundary := params["boundary"]
+		if boundary == "" {
+			return fmt.Errorf("multipart email part without boundary")
+		}
+
+		reader := multipart.NewReader(body, boundary)
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read email part: %w", err)
+			}
+
+			err = m.readPart(decoder, part.Header, part, depth+1)
+			part.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	decoded := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	fileName := decodeHeader(decoder, dispositionParams["filename"])
+	if fileName == "" {
+		fileName = decodeHeader(decoder, params["name"])
+	}
+
+	isText := mediaType == "text/plain" || mediaType == "text/html"
+
+	// Attachments are copied to disk as they are decoded, only the text bodies are held in memory
+	if disposition == "attachment" || fileName != "" || (!isText && depth > 0) {
+		attachment, err := spoolAttachment(decoded)
+		if err != nil {
+			return fmt.Errorf("failed to decode email part: %w", err)
+		}
+		attachment.FileName = attachmentFileName(fileName, mediaType, len(m.Attachments)+1)
+		attachment.ContentType = mediaType
+		m.Attachments = append(m.Attachments, attachment)
+		return nil
+	}
+
+	content, err := io.ReadAll(decoded)
+	if err != nil {
+		return fmt.Errorf("failed to decode email part: %w", err)
+	}
+
+	switch {
+	case mediaType == "text/plain" && m.TextBody == "":
+		m.TextBody = toUTF8(content, params["charset"])
+	case mediaType == "text/html" && m.HTMLBody == "":
+		m.HTMLBody = toUTF8(content, params["charset"])
+	}
+
+	return nil
+}
+
+// spoolAttachment copies the decoded content of an attachment to a temporary file
+func spoolAttachment(content io.Reader) (Attachment, error) {
+	spooled, err := os.CreateTemp("", "lynx-email-*")
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer spooled.Close()
+
+	size, err := io.Copy(spooled, content)
+	if err != nil {
+		os.Remove(spooled.Name())
+		return Attachment{}, err
+	}
+
+	return Attachment{Size: size, path: spooled.Name()}, nil
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &base64Cleaner{reader: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// base64Cleaner drops the line breaks and spaces found in base64 email bodies
+type base64Cleaner struct {
+	reader io.Reader
+}
+
+func (b *base64Cleaner) Read(p []byte) (int, error) {
+	for {
+		n, err := b.reader.Read(p)
+		kept := 0
+		for _, char := range p[:n] {
+			if char != '\r' && char != '\n' && char != ' ' && char != '\t' {
+				p[kept] = char
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+func decodeHeader(decoder *mime.WordDecoder, value string) string {
+	decoded, err := decoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// charsetReader supports the single byte charsets commonly found in supplier emails on top of UTF-8
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	content, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	return strings.NewReader(toUTF8(content, charset)), nil
+}
+
+func toUTF8(content []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "iso-8859-15", "latin1", "windows-1252", "cp1252":
+		// Single byte charsets, mapped rune by rune
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	default:
+		if utf8.Valid(content) {
+			return string(content)
+		}
+		return string(bytes.ToValidUTF8(content, []byte("�")))
+	}
+}
+
+func attachmentFileName(fileName string, mediaType string, index int) string {
+	if fileName != "" {
+		return filepath.Base(fileName)
+	}
+
+	extension := ""
+	if extensions, err := mime.ExtensionsByType(mediaType); err == nil && len(extensions) > 0 {
+		extension = extensions[0]
+	}
+	if mediaType == "message/rfc822" {
+		extension = ".eml"
+	}
+
+	return fmt.Sprintf("attachment-%d%s", index, extension)
+}
@@ -0,0 +1,106 @@
+package email
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testEmail = "From: Reservations <res@crowneplaza.example>\r\n" +
+	"To: bookings@agency.example\r\n" +
+	"Subject: =?UTF-8?Q?Confirmation_FTSWA230184_=E2=80=93_voucher_16454569-4?=\r\n" +
+	"Date: Tue, 14 Jan 2025 10:49:00 +1000\r\n" +
+	"Message-ID: <abc123@crowneplaza.example>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Dear agent, your booking PPA3CC057402 is confirmed =E2=80=93 caf=C3=A9 incl=\r\n" +
+	"uded.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Dear agent, your booking is confirmed</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"voucher.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"voucher.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0x\r\n" +
+	"LjQK\r\n" +
+	"--outer--\r\n"
+
+func TestParse(t *testing.T) {
+	message, err := Parse(strings.NewReader(testEmail))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer message.Close()
+
+	tests := []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{"from", message.From, "Reservations <res@crowneplaza.example>"},
+		{"subject", message.Subject, "Confirmation FTSWA230184 – voucher 16454569-4"},
+		{"message id", message.MessageID, "abc123@crowneplaza.example"},
+		{"text body", message.TextBody, "Dear agent, your booking PPA3CC057402 is confirmed – café included."},
+		{"html body", message.HTMLBody, "<p>Dear agent, your booking is confirmed</p>"},
+		{"date", message.Date.UTC().Format("2006-01-02 15:04"), "2025-01-14 00:49"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, tt.got)
+			}
+		})
+	}
+
+	if len(message.Attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(message.Attachments))
+	}
+
+	attachment := message.Attachments[0]
+	content, err := attachment.Open()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer content.Close()
+
+	decoded, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attachment.FileName != "voucher.pdf" || attachment.ContentType != "application/pdf" || attachment.Size != 9 || string(decoded) != "%PDF-1.4\n" {
+		t.Errorf("unexpected attachment: %s %s %d %q", attachment.FileName, attachment.ContentType, attachment.Size, decoded)
+	}
+
+	// Closing the message removes the spooled attachments
+	if err := message.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(attachment.path); !os.IsNotExist(err) {
+		t.Errorf("expected spooled attachment to be removed, got %v", err)
+	}
+}
+
+func TestParseSinglePart(t *testing.T) {
+	message, err := Parse(strings.NewReader("Subject: Latin\r\nContent-Type: text/plain; charset=iso-8859-1\r\n\r\ncaf\xe9"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if message.TextBody != "café" || len(message.Attachments) != 0 {
+		t.Errorf("unexpected message: %q with %d attachments", message.TextBody, len(message.Attachments))
+	}
+}
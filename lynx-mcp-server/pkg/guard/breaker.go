@@ -0,0 +1,153 @@
+package guard
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+)
+
+// States of the circuit breaker, the values of the lynx_breaker_state gauge
+const (
+	BREAKER_CLOSED = iota
+	BREAKER_HALF_OPEN
+	BREAKER_OPEN
+)
+
+// Outcomes of a Lynx request for the circuit breaker
+const (
+	OUTCOME_SUCCESS = iota
+	OUTCOME_FAILURE
+	// OUTCOME_UNKNOWN is a request its caller gave up on, it tells nothing about Lynx
+	OUTCOME_UNKNOWN
+)
+
+var stateNames = map[int]string{
+	BREAKER_CLOSED:    "closed",
+	BREAKER_HALF_OPEN: "half-open",
+	BREAKER_OPEN:      "open",
+}
+
+// breaker opens once threshold requests in a row failed and rejects requests for cooldown. A single probe request
+// then tells whether Lynx recovered, closing the breaker, or not, opening it again. A zero threshold disables it.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(limit config.LimitConfig) *breaker {
+	metrics.LynxBreakerState.Set(BREAKER_CLOSED)
+	return &breaker{
+		threshold: limit.BreakerThreshold,
+		cooldown:  limit.BreakerCooldown,
+		now:       time.Now,
+	}
+}
+
+// check rejects a request the breaker won't let through, without letting it probe
+func (b *breaker) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BREAKER_OPEN && b.now().Sub(b.openedAt) < b.cooldown || b.state == BREAKER_HALF_OPEN && b.probing {
+		return b.reject()
+	}
+	return nil
+}
+
+// allow lets a request through, probe when it tells whether Lynx recovered
+func (b *breaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BREAKER_OPEN:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false, b.reject()
+		}
+		b.setState(BREAKER_HALF_OPEN)
+		fallthrough
+	case BREAKER_HALF_OPEN:
+		if b.probing {
+			return false, b.reject()
+		}
+		b.probing = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// record counts the outcome of a request let through
+func (b *breaker) record(probe bool, outcome int) {
+	if b.threshold == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	switch outcome {
+	case OUTCOME_SUCCESS:
+		b.failures = 0
+		if b.state != BREAKER_CLOSED {
+			b.setState(BREAKER_CLOSED)
+			slog.Info("Lynx recovered, circuit breaker closed")
+		}
+	case OUTCOME_FAILURE:
+		b.failures++
+		if probe && b.state == BREAKER_HALF_OPEN || b.state == BREAKER_CLOSED && b.failures >= b.threshold {
+			b.openedAt = b.now()
+			b.setState(BREAKER_OPEN)
+			slog.Warn("Lynx failing, circuit breaker opened", "failures", b.failures, "cooldown", b.cooldown)
+		}
+	}
+}
+
+// reject fails a request fast, it's called with mu held
+func (b *breaker) reject() error {
+	metrics.LynxBreakerRejections.Inc()
+	if b.state == BREAKER_HALF_OPEN {
+		return fmt.Errorf("%w: %d requests in a row failed, checking whether it recovered", ErrUnavailable, b.failures)
+	}
+	retryIn := b.cooldown - b.now().Sub(b.openedAt)
+	return fmt.Errorf("%w: %d requests in a row failed, retry in %s", ErrUnavailable, b.failures, retryIn.Round(time.Second))
+}
+
+// setState changes the state, it's called with mu held
+func (b *breaker) setState(state int) {
+	b.state = state
+	metrics.LynxBreakerState.Set(float64(state))
+}
+
+func (b *breaker) stateName() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return stateNames[b.state]
+}
+
+// outcome tells whether a request shows Lynx failing: no response, a 5xx or throttling
+func outcome(ctx context.Context, resp *http.Response, err error) int {
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return OUTCOME_UNKNOWN
+	case err != nil, resp.StatusCode >= http.StatusInternalServerError, resp.StatusCode == http.StatusTooManyRequests:
+		return OUTCOME_FAILURE
+	}
+	return OUTCOME_SUCCESS
+}
@@ -0,0 +1,101 @@
+// Package guard protects Lynx from the traffic of the server: requests queue within a concurrency and rate limit,
+// each caller in turn, and fail fast while a circuit breaker is open after repeated failures
+package guard
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+// ErrUnavailable is returned for the requests the open circuit breaker rejects
+var ErrUnavailable = errors.New("Lynx unavailable")
+
+// Guard stands in front of all Lynx traffic
+type Guard struct {
+	limiter *limiter
+	breaker *breaker
+	caller  func(context.Context) string
+}
+
+// NewGuard creates a guard enforcing limit, caller names who a request is made for so that callers take turns
+func NewGuard(limit config.LimitConfig, caller func(context.Context) string) *Guard {
+	return &Guard{
+		limiter: newLimiter(limit),
+		breaker: newBreaker(limit),
+		caller:  caller,
+	}
+}
+
+// Transport returns a round tripper sending the requests through next once the guard admitted them. A request
+// counts against the concurrency limit until its response body is closed.
+func (g *Guard) Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{guard: g, next: next}
+}
+
+// State names the state of the circuit breaker: closed, half-open or open
+func (g *Guard) State() string {
+	return g.breaker.stateName()
+}
+
+type transport struct {
+	guard *Guard
+	next  http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	// Requests the breaker would reject don't wait for their turn first
+	if err := t.guard.breaker.check(); err != nil {
+		closeBody(req)
+		return nil, err
+	}
+
+	release, err := t.guard.limiter.acquire(ctx, t.guard.caller(ctx))
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+
+	probe, err := t.guard.breaker.allow()
+	if err != nil {
+		release()
+		closeBody(req)
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	t.guard.breaker.record(probe, outcome(ctx, resp, err))
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// closeBody closes the body of a request that won't be sent, as RoundTrip must
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// releasingBody gives the slot of its request back once closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,113 @@
+package guard
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+func TestLimiterTakesTurns(t *testing.T) {
+	l := newLimiter(config.LimitConfig{MaxConcurrent: 1})
+
+	// Hold the only slot while requests queue
+	release, err := l.acquire(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var admitted []string
+	var wg sync.WaitGroup
+	for _, request := range []struct{ caller, name string }{{"agent", "a1"}, {"agent", "a2"}, {"agent", "a3"}, {"staff", "s1"}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.acquire(context.Background(), request.caller)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			admitted = append(admitted, request.name)
+			mu.Unlock()
+			release()
+		}()
+		waitQueued(t, l, request.caller)
+	}
+
+	release()
+	wg.Wait()
+
+	want := []string{"a1", "s1", "a2", "a3"}
+	if len(admitted) != len(want) {
+		t.Fatalf("admitted %v, want %v", admitted, want)
+	}
+	for i := range want {
+		if admitted[i] != want[i] {
+			t.Fatalf("admitted %v, want %v", admitted, want)
+		}
+	}
+}
+
+// waitQueued waits for the request just started by caller to queue
+func waitQueued(t *testing.T, l *limiter, caller string) {
+	t.Helper()
+	l.mu.Lock()
+	queued := len(l.queues[caller])
+	l.mu.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		n := len(l.queues[caller])
+		l.mu.Unlock()
+		if n > queued {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("request of %q never queued", caller)
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(config.LimitConfig{BreakerThreshold: 2, BreakerCooldown: time.Minute})
+	b.now = func() time.Time { return now }
+
+	steps := []struct {
+		name      string
+		outcome   int // Recorded for the request let through, when there is one
+		advance   time.Duration
+		wantErr   bool
+		wantState int
+	}{
+		{name: "first failure", outcome: OUTCOME_FAILURE, wantState: BREAKER_CLOSED},
+		{name: "canceled request", outcome: OUTCOME_UNKNOWN, wantState: BREAKER_CLOSED},
+		{name: "threshold reached", outcome: OUTCOME_FAILURE, wantState: BREAKER_OPEN},
+		{name: "open rejects", advance: 30 * time.Second, wantErr: true, wantState: BREAKER_OPEN},
+		{name: "failed probe opens again", advance: 31 * time.Second, outcome: OUTCOME_FAILURE, wantState: BREAKER_OPEN},
+		{name: "open again rejects", advance: 59 * time.Second, wantErr: true, wantState: BREAKER_OPEN},
+		{name: "successful probe closes", advance: time.Second, outcome: OUTCOME_SUCCESS, wantState: BREAKER_CLOSED},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		probe, err := b.allow()
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: allow() error = %v, want error %v", step.name, err, step.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("%s: allow() error = %v, want ErrUnavailable", step.name, err)
+		}
+		if err == nil {
+			b.record(probe, step.outcome)
+		}
+		if b.state != step.wantState {
+			t.Fatalf("%s: state = %s, want %s", step.name, stateNames[b.state], stateNames[step.wantState])
+		}
+	}
+}
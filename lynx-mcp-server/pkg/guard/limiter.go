@@ -0,0 +1,151 @@
+package guard
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+)
+
+// limiter admits requests up to maxConcurrent at once, spaced by interval. Callers with waiting requests take
+// turns, an agent firing dozens of searches doesn't hold up the others.
+type limiter struct {
+	maxConcurrent int
+	interval      time.Duration
+
+	mu        sync.Mutex
+	active    int
+	nextStart time.Time
+	queues    map[string][]*waiter
+	// Callers with waiting requests, the first one is served next
+	turns []string
+	timer *time.Timer
+}
+
+// waiter is a request waiting for its turn, ready is closed once admitted
+type waiter struct {
+	ready chan struct{}
+}
+
+func newLimiter(limit config.LimitConfig) *limiter {
+	l := &limiter{
+		maxConcurrent: limit.MaxConcurrent,
+		queues:        make(map[string][]*waiter),
+	}
+	if limit.RequestsPerSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / limit.RequestsPerSecond)
+	}
+	return l
+}
+
+// acquire waits for the turn of a request made for caller, the returned function releases its slot
+func (l *limiter) acquire(ctx context.Context, caller string) (func(), error) {
+	w := &waiter{ready: make(chan struct{})}
+	start := time.Now()
+
+	l.mu.Lock()
+	if len(l.queues[caller]) == 0 {
+		l.turns = append(l.turns, caller)
+	}
+	l.queues[caller] = append(l.queues[caller], w)
+	metrics.LynxQueued.Inc()
+	l.dispatch()
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		l.mu.Lock()
+		removed := l.remove(caller, w)
+		l.mu.Unlock()
+		// Admitted meanwhile, the slot goes to the next request
+		if !removed {
+			l.release()
+		}
+		return nil, ctx.Err()
+	}
+
+	metrics.LynxQueueWait.Observe(time.Since(start).Seconds())
+	var once sync.Once
+	return func() { once.Do(l.release) }, nil
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	metrics.LynxActive.Dec()
+	l.dispatch()
+}
+
+// dispatch admits the waiting requests the limits allow, one per caller in turn. It's called with mu held.
+func (l *limiter) dispatch() {
+	for len(l.turns) > 0 {
+		if l.maxConcurrent > 0 && l.active >= l.maxConcurrent {
+			return
+		}
+
+		now := time.Now()
+		if l.interval > 0 && now.Before(l.nextStart) {
+			if l.timer == nil {
+				l.timer = time.AfterFunc(l.nextStart.Sub(now), l.wake)
+			}
+			return
+		}
+
+		caller := l.turns[0]
+		l.turns = l.turns[1:]
+		queue := l.queues[caller]
+		if len(queue) > 1 {
+			l.queues[caller] = queue[1:]
+			l.turns = append(l.turns, caller)
+		} else {
+			delete(l.queues, caller)
+		}
+
+		l.active++
+		if l.interval > 0 {
+			l.nextStart = now.Add(l.interval)
+		}
+		metrics.LynxQueued.Dec()
+		metrics.LynxActive.Inc()
+		close(queue[0].ready)
+	}
+}
+
+// wake admits the requests held back by the rate limit once the next one may start
+func (l *limiter) wake() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.timer = nil
+	l.dispatch()
+}
+
+// remove drops a request that stopped waiting, false when it was admitted already. It's called with mu held.
+func (l *limiter) remove(caller string, w *waiter) bool {
+	queue := l.queues[caller]
+	for i, queued := range queue {
+		if queued != w {
+			continue
+		}
+
+		metrics.LynxQueued.Dec()
+		if len(queue) > 1 {
+			l.queues[caller] = append(queue[:i:i], queue[i+1:]...)
+			return true
+		}
+		delete(l.queues, caller)
+		for j, turn := range l.turns {
+			if turn == caller {
+				l.turns = append(l.turns[:j:j], l.turns[j+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -1,11 +1,26 @@
 package gwt
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 )
 
+// ErrException is wrapped by the errors of "//EX" responses, Lynx rejected the call
+var ErrException = errors.New("GWT error")
+
+// RPCMethod returns the method called by a GWT-RPC request body, empty when the body isn't one.
+// The body starts with the version, the flags and the string table size, then the module base URL, the policy
+// strong name, the service interface and the method.
+func RPCMethod(body string) string {
+	fields := strings.SplitN(body, "|", 8)
+	if len(fields) < 8 {
+		return ""
+	}
+	return fields[6]
+}
+
 // parseGWTArray parses a GWT array format and returns the elements
 func parseGWTArray(arrayStr string) ([]interface{}, error) {
 	// Remove outer brackets
@@ -120,6 +135,13 @@ func parseGWTElement(element string) (interface{}, error) {
 	return element, nil
 }
 
+// EscapeGWTString escapes a value for a GWT-RPC request payload, where | separates fields
+func EscapeGWTString(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "|", "\\!")
+	return strings.ReplaceAll(s, "\x00", "\\0")
+}
+
 // unescapeGWTString removes surrounding double quotes and converts unicode escape sequences
 func unescapeGWTString(s string) string {
 	// Remove surrounding double quotes if present
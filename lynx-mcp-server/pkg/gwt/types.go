@@ -1,9 +1,36 @@
 package gwt
 
+import "slices"
+
 const (
 	CONTENT_TYPE = "text/x-gwt-rpc; charset=utf-8"
+
+	// MODULE_PATH is the module base of the Lynx client, serialization policies are served from it
+	MODULE_PATH = "/lynx/lynx/"
+	// POLICY_SUFFIX ends the name of a serialization policy file, after its strong name
+	POLICY_SUFFIX = ".gwt.rpc"
 )
 
+// Policy is the serialization policy a request body names, Lynx rejects calls made with a policy it no longer serves
+type Policy struct {
+	Service    string `json:"service"`
+	StrongName string `json:"strongName"`
+}
+
+// Policies lists the serialization policies of the request bodies built by this package
+var Policies = []Policy{
+	{Service: "com.lynxtraveltech.common.gui.client.rpc.SecurityService", StrongName: "4775EB021C85EC0B04470837F40FC64A"},
+	{Service: "com.lynxtraveltech.client.client.rpc.FileService", StrongName: "63A734E3E71C14883B20AFEC1238F6A7"},
+}
+
+// WriteMethods lists the RPC methods changing Lynx, repeating one whose response was lost could apply it twice
+var WriteMethods = []string{"saveFileDocumentsDetails"}
+
+// IsWrite reports whether an RPC method changes Lynx
+func IsWrite(method string) bool {
+	return slices.Contains(WriteMethods, method)
+}
+
 const (
 	GWT_TYPE_ARRAY               = "java.util.ArrayList"
 	GWT_TYPE_BIGDECIMAL          = "java.math.BigDecimal"
@@ -0,0 +1,193 @@
+// Package health checks whether the server can serve requests: its configuration, signing in to Lynx and the
+// GWT serialization policies its requests name
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+)
+
+const (
+	CHECK_CONFIG   = "config"
+	CHECK_LOGIN    = "login"
+	CHECK_POLICIES = "policies"
+
+	STATUS_OK      = "ok"
+	STATUS_FAILED  = "failed"
+	STATUS_SKIPPED = "skipped"
+
+	// CACHE_TTL is how long a readiness report is reused, probes every few seconds mustn't each reach Lynx
+	CACHE_TTL = 10 * time.Second
+	// CHECK_TIMEOUT bounds each check
+	CHECK_TIMEOUT = 10 * time.Second
+)
+
+// Check is the outcome of one readiness check
+type Check struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"durationMs"`
+}
+
+// Report is the outcome of the readiness checks, ready when none failed
+type Report struct {
+	Ready     bool      `json:"ready"`
+	CheckedAt time.Time `json:"checkedAt"`
+	Checks    []Check   `json:"checks"`
+}
+
+// PolicyCheck tells whether Lynx still serves a serialization policy
+type PolicyCheck struct {
+	gwt.Policy
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// LynxReport describes the state of the connection to Lynx
+type LynxReport struct {
+	RemoteHost        string                `json:"remoteHost"`
+	Sessions          []utils.SessionStatus `json:"sessions"`
+	Upstream          metrics.UpstreamStats `json:"upstream"`
+	Policies          []PolicyCheck         `json:"policies"`
+	PoliciesCheckedAt time.Time             `json:"policiesCheckedAt"`
+}
+
+// Checker runs the readiness checks, at most once per CACHE_TTL
+type Checker struct {
+	cfg    config.Config
+	client *http.Client
+
+	mu       sync.Mutex
+	report   Report
+	policies []PolicyCheck
+}
+
+// NewChecker creates the checker of the server configuration
+func NewChecker(cfg config.Config) *Checker {
+	return &Checker{
+		cfg:    cfg,
+		client: utils.NewHTTPClient(),
+	}
+}
+
+// Ready returns the readiness report, running the checks when the last report is older than CACHE_TTL.
+// Concurrent callers wait for the same run.
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.report.CheckedAt) < CACHE_TTL {
+		return c.report
+	}
+
+	report := Report{Ready: true, CheckedAt: time.Now().UTC()}
+	run := func(name string, check func(ctx context.Context) (string, error)) {
+		ctx, cancel := context.WithTimeout(ctx, CHECK_TIMEOUT)
+		defer cancel()
+
+		start := time.Now()
+		status, err := check(ctx)
+		result := Check{Name: name, Status: status, DurationMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			result.Status = STATUS_FAILED
+			result.Error = err.Error()
+			report.Ready = false
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	run(CHECK_CONFIG, c.checkConfig)
+	run(CHECK_LOGIN, c.checkLogin)
+	run(CHECK_POLICIES, c.checkPolicies)
+
+	c.report = report
+	return report
+}
+
+// Lynx reports the sessions, the recent Lynx requests and the policy check of the last readiness report
+func (c *Checker) Lynx(ctx context.Context) LynxReport {
+	report := c.Ready(ctx)
+
+	c.mu.Lock()
+	policies := append([]PolicyCheck(nil), c.policies...)
+	c.mu.Unlock()
+
+	return LynxReport{
+		RemoteHost:        c.cfg.Lynx.RemoteHost,
+		Sessions:          utils.Sessions(),
+		Upstream:          metrics.Upstream.Snapshot(time.Now()),
+		Policies:          policies,
+		PoliciesCheckedAt: report.CheckedAt,
+	}
+}
+
+// checkConfig validates the configuration again, the files it names may have changed since the start
+func (c *Checker) checkConfig(ctx context.Context) (string, error) {
+	if err := c.cfg.Validate(); err != nil {
+		return "", err
+	}
+	return STATUS_OK, nil
+}
+
+// checkLogin gets a session of the service account, from the session cache when it is still valid
+func (c *Checker) checkLogin(ctx context.Context) (string, error) {
+	if c.cfg.Lynx.Username == "" {
+		// Every caller runs as their own account
+		return STATUS_SKIPPED, nil
+	}
+	if _, _, err := utils.GetOrCreateSession(ctx, c.cfg.Lynx); err != nil {
+		return "", err
+	}
+	return STATUS_OK, nil
+}
+
+// checkPolicies fetches the serialization policy files, Lynx stops serving them when it is redeployed with other
+// strong names and then rejects our requests
+func (c *Checker) checkPolicies(ctx context.Context) (string, error) {
+	policies := make([]PolicyCheck, len(gwt.Policies))
+	var failed error
+	for i, policy := range gwt.Policies {
+		policies[i] = PolicyCheck{Policy: policy, Status: STATUS_OK}
+		if err := c.fetchPolicy(ctx, policy); err != nil {
+			policies[i].Status = STATUS_FAILED
+			policies[i].Error = err.Error()
+			failed = fmt.Errorf("%s: %w", policy.Service, err)
+		}
+	}
+	c.policies = policies
+
+	if failed != nil {
+		return "", failed
+	}
+	return STATUS_OK, nil
+}
+
+func (c *Checker) fetchPolicy(ctx context.Context, policy gwt.Policy) error {
+	url := fmt.Sprintf("https://%s%s%s%s", c.cfg.Lynx.RemoteHost, gwt.MODULE_PATH, policy.StrongName, gwt.POLICY_SUFFIX)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create policy request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch policy %s: %w", policy.StrongName, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("policy %s answered %s", policy.StrongName, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+)
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name        string
+		bearerToken string
+		missing     string // Strong name Lynx no longer serves
+		wantReady   bool
+		wantFailed  string
+	}{
+		{name: "ready", bearerToken: "secret", wantReady: true},
+		{name: "invalid config", wantFailed: CHECK_CONFIG},
+		{name: "policy gone", bearerToken: "secret", missing: gwt.Policies[1].StrongName, wantFailed: CHECK_POLICIES},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lynx := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasPrefix(r.URL.Path, gwt.MODULE_PATH) || (tt.missing != "" && strings.Contains(r.URL.Path, tt.missing)) {
+					http.NotFound(w, r)
+				}
+			}))
+			defer lynx.Close()
+
+			cfg := config.Default()
+			cfg.Server.BearerToken = tt.bearerToken
+			cfg.Lynx.RemoteHost = strings.TrimPrefix(lynx.URL, "https://")
+			cfg.Lynx.CompanyCode = "ACME"
+			cfg.Lynx.RequireAccount = true
+			cfg.Lynx.Accounts = []config.LynxAccountConfig{{Identity: "jdoe", Username: "jdoe", Password: "secret"}}
+			checker := NewChecker(cfg)
+			checker.client = lynx.Client()
+
+			report := checker.Ready(context.Background())
+			if report.Ready != tt.wantReady {
+				t.Errorf("Ready = %v, want %v: %+v", report.Ready, tt.wantReady, report.Checks)
+			}
+			for _, check := range report.Checks {
+				if check.Name == CHECK_LOGIN && check.Status != STATUS_SKIPPED {
+					t.Errorf("login check = %s, want skipped without a service account", check.Status)
+				}
+				if (check.Status == STATUS_FAILED) != (check.Name == tt.wantFailed) {
+					t.Errorf("%s check = %s %s", check.Name, check.Status, check.Error)
+				}
+			}
+
+			for _, policy := range checker.Lynx(context.Background()).Policies {
+				if (policy.Status == STATUS_FAILED) != (policy.StrongName == tt.missing) {
+					t.Errorf("policy %s = %s", policy.Service, policy.Status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+// Package idempotency replays the result of a write call to the calls repeating its idempotency key, so workflows
+// retried after a timeout don't create the same attachment or document twice in Lynx
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// CLEANUP_INTERVAL is how often expired records are removed
+const CLEANUP_INTERVAL = time.Minute
+
+var (
+	ErrKeyReused = errors.New("idempotency key already used with a different payload")
+)
+
+// recordsBucket holds the records by key in the store file
+var recordsBucket = []byte("records")
+
+// Record is the result of the first successful call made with a key, or of its queued call once applied. Fingerprint
+// identifies its payload.
+type Record struct {
+	Fingerprint string          `json:"fingerprint"`
+	Result      json.RawMessage `json:"result"`
+	StoredAt    time.Time       `json:"storedAt"`
+	ExpiresAt   time.Time       `json:"expiresAt"`
+}
+
+// Store keeps the records of keys in memory for their TTL, and in a bbolt file across restarts when configured.
+// A key is held by one call at a time, the calls repeating it wait for its result.
+type Store struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.Mutex
+	records  map[string]Record
+	inFlight map[string]*call
+	db       *bolt.DB
+}
+
+// call is a call holding a key, done is closed once it finished
+type call struct {
+	fingerprint string
+	done        chan struct{}
+}
+
+// NewStore creates the store of the configuration, the unexpired records of its file are loaded
+func NewStore(idempotencyConfig config.IdempotencyConfig) (*Store, error) {
+	s := &Store{
+		ttl:      idempotencyConfig.TTL,
+		now:      time.Now,
+		records:  make(map[string]Record),
+		inFlight: make(map[string]*call),
+	}
+	if idempotencyConfig.File == "" {
+		return s, nil
+	}
+
+	db, err := bolt.Open(idempotencyConfig.File, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open idempotency file: %w", err)
+	}
+	now := s.now()
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(recordsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var record Record
+			if err := json.Unmarshal(v, &record); err == nil && now.Before(record.ExpiresAt) {
+				s.records[string(k)] = record
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load idempotency file: %w", err)
+	}
+	s.db = db
+	return s, nil
+}
+
+// Begin claims key for a call with the given payload fingerprint. It returns the record of the earlier call made with
+// key, waiting for it while it runs, or nil once the caller holds key and must Finish it. A key used with another
+// payload fails with ErrKeyReused.
+func (s *Store) Begin(ctx context.Context, key string, fingerprint string) (*Record, error) {
+	for {
+		s.mu.Lock()
+		if record, ok := s.records[key]; ok && s.now().Before(record.ExpiresAt) {
+			s.mu.Unlock()
+			if record.Fingerprint != fingerprint {
+				return nil, ErrKeyReused
+			}
+			return &record, nil
+		}
+
+		running, ok := s.inFlight[key]
+		if !ok {
+			s.inFlight[key] = &call{fingerprint: fingerprint, done: make(chan struct{})}
+			s.mu.Unlock()
+			return nil, nil
+		}
+		s.mu.Unlock()
+
+		if running.fingerprint != fingerprint {
+			return nil, ErrKeyReused
+		}
+		// The earlier call may fail, the key is then claimed again
+		select {
+		case <-running.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Finish releases key, the result is replayed to the calls repeating it unless nil: failed calls can be retried
+// with the same key. A result stored meanwhile by Replace is kept.
+func (s *Store) Finish(key string, fingerprint string, result json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if running, ok := s.inFlight[key]; ok {
+		delete(s.inFlight, key)
+		close(running.done)
+	}
+
+	if result == nil {
+		return
+	}
+	// The queued call of key may have been applied before its queued result is stored
+	if record, ok := s.records[key]; ok && s.now().Before(record.ExpiresAt) {
+		return
+	}
+	s.put(key, fingerprint, result)
+}
+
+// Replace stores the final result of a key once its queued call was applied, instead of the queued result
+func (s *Store) Replace(key string, fingerprint string, result json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(key, fingerprint, result)
+}
+
+// Forget removes the record of key, e.g. once its queued call was canceled, the key can then be used again
+func (s *Store) Forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	if s.db == nil {
+		return
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).Delete([]byte(key))
+	})
+	if err != nil {
+		slog.Warn("Failed to write idempotency file", "error", err)
+	}
+}
+
+// StartCleanup removes the expired records every CLEANUP_INTERVAL until ctx is done
+func (s *Store) StartCleanup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(CLEANUP_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.removeExpired()
+			}
+		}
+	}()
+}
+
+// Close closes the store file
+func (s *Store) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// put records the result of key, the caller holds the lock
+func (s *Store) put(key string, fingerprint string, result json.RawMessage) {
+	now := s.now()
+	record := Record{
+		Fingerprint: fingerprint,
+		Result:      result,
+		StoredAt:    now,
+		ExpiresAt:   now.Add(s.ttl),
+	}
+	s.records[key] = record
+
+	if s.db == nil {
+		return
+	}
+	if err := s.store(key, record); err != nil {
+		slog.Warn("Failed to write idempotency file", "error", err)
+	}
+}
+
+func (s *Store) store(key string, record Record) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(recordsBucket).Put([]byte(key), value)
+	})
+}
+
+func (s *Store) removeExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var expired []string
+	for key, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			expired = append(expired, key)
+			delete(s.records, key)
+		}
+	}
+
+	if s.db == nil || len(expired) == 0 {
+		return
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(recordsBucket)
+		for _, key := range expired {
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Failed to remove expired records from idempotency file", "error", err)
+	}
+}
+
+// Claim is a key held by a call with the fingerprint of its payload, a call queued in the outbox keeps it to Replace
+// the queued result with its final one
+type Claim struct {
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type contextKey string
+
+const claimContextKey = contextKey("claim")
+
+// WithClaim makes the key held by the call of ctx known to the steps it runs
+func WithClaim(ctx context.Context, claim Claim) context.Context {
+	return context.WithValue(ctx, claimContextKey, claim)
+}
+
+// ClaimFromContext returns the key set by WithClaim
+func ClaimFromContext(ctx context.Context) (Claim, bool) {
+	claim, ok := ctx.Value(claimContextKey).(Claim)
+	return claim, ok
+}
+
+// AccountKey scopes an idempotency key to the Lynx account the call runs as, consultants don't replay each other's
+// results
+func AccountKey(ctx context.Context, lynxConfig config.LynxServerConfig, key string) string {
+	username := lynxConfig.Username
+	if credentials, ok := utils.CredentialsFromContext(ctx); ok {
+		username = credentials.Username
+	}
+
+	sum := sha256.Sum256([]byte(username + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Fingerprint identifies the payload of a call from its parts
+func Fingerprint(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
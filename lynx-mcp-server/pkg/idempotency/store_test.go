@@ -0,0 +1,93 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+func TestStore(t *testing.T) {
+	s, err := NewStore(config.IdempotencyConfig{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	if record, err := s.Begin(ctx, "upload", "a"); record != nil || err != nil {
+		t.Fatalf("Begin() = %v, %v, want the key claimed", record, err)
+	}
+	// A retry sent while the first call runs waits for its result
+	replayed := make(chan *Record)
+	go func() {
+		record, _ := s.Begin(ctx, "upload", "a")
+		replayed <- record
+	}()
+	s.Finish("upload", "a", json.RawMessage(`{"attachmentUrl":"/a.pdf"}`))
+	if record := <-replayed; record == nil || string(record.Result) != `{"attachmentUrl":"/a.pdf"}` {
+		t.Errorf("Begin() while running = %v, want the result of the first call", record)
+	}
+
+	// A failed call leaves the key free
+	s.Begin(ctx, "save", "a")
+	s.Finish("save", "a", nil)
+
+	tests := []struct {
+		name        string
+		key         string
+		fingerprint string
+		at          time.Time
+		wantRecord  bool
+		wantErr     error
+	}{
+		{name: "repeated", key: "upload", fingerprint: "a", at: now, wantRecord: true},
+		{name: "other payload", key: "upload", fingerprint: "b", at: now, wantErr: ErrKeyReused},
+		{name: "after failure", key: "save", fingerprint: "a", at: now},
+		{name: "expired", key: "upload", fingerprint: "b", at: now.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.now = func() time.Time { return tt.at }
+			record, err := s.Begin(ctx, tt.key, tt.fingerprint)
+			if (record != nil) != tt.wantRecord || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Begin(%q, %q) = %v, %v, want a record %v and error %v", tt.key, tt.fingerprint, record, err, tt.wantRecord, tt.wantErr)
+			}
+		})
+	}
+
+	// The key of a running call can't be reused with another payload either
+	if _, err := s.Begin(ctx, "save", "b"); !errors.Is(err, ErrKeyReused) {
+		t.Errorf("Begin() of a running key with another payload = %v, want %v", err, ErrKeyReused)
+	}
+}
+
+func TestStoreFile(t *testing.T) {
+	idempotencyConfig := config.IdempotencyConfig{TTL: time.Hour, File: filepath.Join(t.TempDir(), "idempotency.db")}
+	ctx := context.Background()
+
+	s, err := NewStore(idempotencyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Begin(ctx, "upload", "a")
+	s.Finish("upload", "a", json.RawMessage(`{}`))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewStore(idempotencyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if record, err := reopened.Begin(ctx, "upload", "a"); record == nil || err != nil {
+		t.Errorf("Begin() after reopening = %v, %v, want the stored record", record, err)
+	}
+}
@@ -0,0 +1,79 @@
+// Package logging sets up structured logging: level, format, redaction of sensitive values, request and trace IDs
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TRACE_ID_KEY = "trace_id"
+
+// Setup makes the redacting handler the default of slog and of the log package, writing to w
+func Setup(loggingConfig config.LoggingConfig, w io.Writer) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(loggingConfig.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	var next slog.Handler
+	if loggingConfig.Format == config.LOG_FORMAT_JSON {
+		next = slog.NewJSONHandler(w, options)
+	} else {
+		next = slog.NewTextHandler(w, options)
+	}
+
+	logger := slog.New(NewHandler(next, NewPolicy(loggingConfig.Redact)))
+	slog.SetDefault(logger)
+	return logger
+}
+
+// Handler redacts every record and adds the request and trace IDs of the context
+type Handler struct {
+	next   slog.Handler
+	policy *Policy
+}
+
+// NewHandler wraps a handler with a redaction policy
+func NewHandler(next slog.Handler, policy *Policy) *Handler {
+	return &Handler{next: next, policy: policy}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.policy.String(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.policy.Attr(attr))
+		return true
+	})
+
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		redacted.AddAttrs(slog.String(REQUEST_ID_KEY, requestID))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		redacted.AddAttrs(slog.String(TRACE_ID_KEY, spanContext.TraceID().String()))
+	}
+
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = h.policy.Attr(attr)
+	}
+	return &Handler{next: h.next.WithAttrs(redacted), policy: h.policy}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), policy: h.policy}
+}
@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+func TestHandlerRedacts(t *testing.T) {
+	tests := []struct {
+		name     string
+		redact   []string
+		message  string
+		args     []any
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "session cookie in message",
+			redact:   config.RedactCategories,
+			message:  "Using JSESSIONID=ABC123DEF456",
+			contains: []string{"JSESSIONID=" + REDACTED},
+			excludes: []string{"ABC123DEF456"},
+		},
+		{
+			name:     "bearer token",
+			redact:   config.RedactCategories,
+			message:  "Lynx request",
+			args:     []any{"header", "Bearer eyJhbGciOiJIUzI1NiJ9.payload.signature"},
+			contains: []string{"Bearer " + REDACTED},
+			excludes: []string{"eyJhbGciOiJIUzI1NiJ9"},
+		},
+		{
+			name:     "password by key",
+			redact:   config.RedactCategories,
+			message:  "Signed in",
+			args:     []any{"password", "hunter2"},
+			excludes: []string{"hunter2"},
+		},
+		{
+			name:     "email and phone in tool arguments",
+			redact:   config.RedactCategories,
+			message:  "beforeCallTool",
+			args:     []any{"arguments", map[string]any{"note": "Call jane.doe@example.com on +61 412 345 678 or (02) 9876 5432"}},
+			contains: []string{"[EMAIL]", "[PHONE]"},
+			excludes: []string{"jane.doe@example.com", "412 345 678", "9876 5432"},
+		},
+		{
+			name:     "party name and content keep the size",
+			redact:   config.RedactCategories,
+			message:  "beforeCallTool",
+			args:     []any{"arguments", map[string]any{"partyName": "Smith", "fileBinary": "SGVsbG8=", "fileReference": "12345"}},
+			contains: []string{"[REDACTED 8 bytes]", "12345"},
+			excludes: []string{"Smith", "SGVsbG8="},
+		},
+		{
+			name:     "errors",
+			redact:   config.RedactCategories,
+			message:  "Lynx request failed",
+			args:     []any{"error", errors.New("login failed for jane.doe@example.com")},
+			contains: []string{"[EMAIL]"},
+			excludes: []string{"jane.doe@example.com"},
+		},
+		{
+			name:     "nothing redacted",
+			redact:   nil,
+			message:  "Using JSESSIONID=ABC123DEF456",
+			args:     []any{"partyName", "Smith"},
+			contains: []string{"ABC123DEF456", "Smith"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), NewPolicy(tt.redact)))
+
+			logger.Info(tt.message, tt.args...)
+
+			output := buf.String()
+			for _, want := range tt.contains {
+				if !strings.Contains(output, want) {
+					t.Errorf("output %s doesn't contain %q", output, want)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(output, unwanted) {
+					t.Errorf("output %s contains %q", output, unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestPhonePatternKeepsIdentifiers(t *testing.T) {
+	policy := NewPolicy(config.RedactCategories)
+
+	for _, value := range []string{"2024-03-15", "File 123456 transaction 7890123", "voucher 20240315"} {
+		if redacted := policy.String(value); redacted != value {
+			t.Errorf("String(%q) = %q, want it unchanged", value, redacted)
+		}
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "client ID is kept", header: "abc-123", keep: true},
+		{name: "missing ID is generated", header: ""},
+		{name: "forged ID is replaced", header: "abc\nlevel=ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), NewPolicy(nil)))
+
+			var seen string
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = RequestIDFromContext(r.Context())
+				logger.InfoContext(r.Context(), "handled")
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(REQUEST_ID_HEADER, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if seen == "" || rec.Header().Get(REQUEST_ID_HEADER) != seen {
+				t.Fatalf("request ID %q, response header %q", seen, rec.Header().Get(REQUEST_ID_HEADER))
+			}
+			if tt.keep != (seen == tt.header) {
+				t.Errorf("request ID = %q, header %q", seen, tt.header)
+			}
+
+			var record map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("failed to decode log record: %v", err)
+			}
+			if record[REQUEST_ID_KEY] != seen {
+				t.Errorf("logged %s = %v, want %q", REQUEST_ID_KEY, record[REQUEST_ID_KEY], seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+const REDACTED = "[REDACTED]"
+
+var (
+	cookiePattern = regexp.MustCompile(`(?i)(JSESSIONID[=:]\s*)[^;,\s"]+`)
+	bearerPattern = regexp.MustCompile(`(?i)(Bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// International numbers, or local ones starting with 0 such as (02) 9876 5432 or 0412 345 678. Dates, file
+	// identifiers and vouchers don't start with + or 0.
+	phonePattern = regexp.MustCompile(`\+\d[\d\s().-]{6,}\d|\(?\b0\d{1,4}\)?[\s.-]?\d{3,4}[\s.-]?\d{3,4}\b`)
+)
+
+// Keys are compared lowercased, a key matches when it contains one of the fragments. Content keys must match exactly,
+// contentType or bodySize aren't content.
+var (
+	cookieKeys   = []string{"cookie", "jsessionid"}
+	passwordKeys = []string{"password", "secret", "authorization", "access_token", "refresh_token", "code_verifier"}
+	nameKeys     = []string{"partyname", "clientname", "travellername", "passengername", "firstname", "lastname", "surname"}
+	contentKeys  = []string{"content", "html", "binary", "filebinary", "eml", "body", "document"}
+)
+
+// Policy masks the categories of sensitive values selected in the configuration
+type Policy struct {
+	categories []string
+}
+
+// NewPolicy returns the redaction policy of the given categories
+func NewPolicy(categories []string) *Policy {
+	return &Policy{categories: categories}
+}
+
+func (p *Policy) has(category string) bool {
+	return slices.Contains(p.categories, category)
+}
+
+// String masks the sensitive values found in free text
+func (p *Policy) String(value string) string {
+	if p.has(config.REDACT_COOKIES) {
+		value = cookiePattern.ReplaceAllString(value, "${1}"+REDACTED)
+	}
+	if p.has(config.REDACT_PASSWORDS) {
+		value = bearerPattern.ReplaceAllString(value, "${1}"+REDACTED)
+	}
+	if p.has(config.REDACT_EMAILS) {
+		value = emailPattern.ReplaceAllString(value, "[EMAIL]")
+	}
+	if p.has(config.REDACT_PHONES) {
+		value = phonePattern.ReplaceAllString(value, "[PHONE]")
+	}
+	return value
+}
+
+// Attr masks an attribute by its key, then the sensitive values found in its value
+func (p *Policy) Attr(attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() == slog.KindGroup {
+		group := attr.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redacted[i] = p.Attr(member)
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(redacted...)}
+	}
+
+	if masked, ok := p.key(attr.Key, attr.Value.Any()); ok {
+		return slog.Any(attr.Key, masked)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, p.String(attr.Value.String()))
+	case slog.KindAny:
+		return slog.Any(attr.Key, p.value(attr.Value.Any()))
+	}
+	return attr
+}
+
+// key masks the whole value when the key names a sensitive category
+func (p *Policy) key(key string, value any) (any, bool) {
+	key = strings.ToLower(key)
+	matches := func(fragments []string) bool {
+		return slices.ContainsFunc(fragments, func(fragment string) bool { return strings.Contains(key, fragment) })
+	}
+
+	switch {
+	case p.has(config.REDACT_COOKIES) && matches(cookieKeys),
+		p.has(config.REDACT_PASSWORDS) && matches(passwordKeys),
+		p.has(config.REDACT_NAMES) && matches(nameKeys):
+		return REDACTED, true
+	case p.has(config.REDACT_CONTENT) && slices.Contains(contentKeys, key):
+		// The size is kept, it helps diagnosing uploads
+		if text, ok := value.(string); ok {
+			return fmt.Sprintf("[REDACTED %d bytes]", len(text)), true
+		}
+		return REDACTED, true
+	}
+	return nil, false
+}
+
+// value masks nested arguments, such as the arguments of a tool call
+func (p *Policy) value(value any) any {
+	switch typed := value.(type) {
+	case string:
+		return p.String(typed)
+	case error:
+		return p.String(typed.Error())
+	case map[string]any:
+		redacted := make(map[string]any, len(typed))
+		for key, member := range typed {
+			if masked, ok := p.key(key, member); ok {
+				redacted[key] = masked
+			} else {
+				redacted[key] = p.value(member)
+			}
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(typed))
+		for i, member := range typed {
+			redacted[i] = p.value(member)
+		}
+		return redacted
+	}
+	return value
+}
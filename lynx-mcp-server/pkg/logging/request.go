@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-ID"
+	REQUEST_ID_KEY    = "request_id"
+)
+
+// requestIDPattern accepts the IDs of proxies and clients, anything else is replaced so logs can't be forged
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type contextKey string
+
+const requestIDContextKey = contextKey("requestID")
+
+// WithRequestID stores the correlation ID logged with every record of ctx
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// RequestIDFromContext returns the correlation ID of ctx, empty when there is none
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// NewRequestID returns a random correlation ID
+func NewRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Middleware gives each HTTP request a correlation ID, the one of the X-Request-ID header when valid. The ID is sent
+// back in the response and carried by the context through MCP hooks and tools down to Lynx requests.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(REQUEST_ID_HEADER)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = NewRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, requestID)
+
+		ctx := WithRequestID(r.Context(), requestID)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		level := slog.LevelDebug
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "HTTP request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// statusRecorder keeps the response status, it still flushes for the SSE stream
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,66 @@
+package lynx
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+)
+
+// Accounts maps authenticated callers to their own Lynx account, so Lynx history names the consultant rather than
+// the service account
+type Accounts struct {
+	accounts       map[string]utils.Credentials
+	requireAccount bool
+}
+
+// NewAccounts indexes the consultant accounts by caller identity
+func NewAccounts(lynxConfig config.LynxServerConfig) *Accounts {
+	accounts := &Accounts{
+		accounts:       make(map[string]utils.Credentials, len(lynxConfig.Accounts)),
+		requireAccount: lynxConfig.RequireAccount && len(lynxConfig.Accounts) > 0,
+	}
+
+	for _, account := range lynxConfig.Accounts {
+		accounts.accounts[account.Identity] = utils.Credentials{
+			Username: account.Username,
+			Password: account.Password,
+		}
+	}
+
+	return accounts
+}
+
+// Credentials returns the Lynx account of a caller, false when they use the service account. Callers without an
+// account fail when one is required.
+func (a *Accounts) Credentials(identity string) (utils.Credentials, bool, error) {
+	credentials, ok := a.accounts[identity]
+	if !ok && a.requireAccount {
+		return utils.Credentials{}, false, fmt.Errorf("no Lynx account is configured for %s", identity)
+	}
+	return credentials, ok, nil
+}
+
+// Middleware makes the Lynx requests of the caller run as their own account, it must run behind the token middleware.
+// Callers without an account use the service account, or are rejected when an account is required.
+func (a *Accounts) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		identity := auth.IdentityFromContext(req.Context())
+
+		credentials, ok, err := a.Credentials(identity)
+		if err != nil {
+			slog.WarnContext(req.Context(), "Forbidden, no Lynx account", "method", req.Method, "path", req.URL.Path, "identity", identity)
+			http.Error(w, "No Lynx account is configured for "+identity, http.StatusForbidden)
+			return
+		}
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(utils.WithCredentials(req.Context(), credentials)))
+	})
+}
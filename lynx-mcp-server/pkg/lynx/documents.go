@@ -0,0 +1,197 @@
+package lynx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+)
+
+// SaveTransactionDocument saves a document against a transaction of a file
+func SaveTransactionDocument(ctx context.Context, lynxConfig config.LynxServerConfig, session *utils.SessionContext, args *gwt.TransactionDocumentSaveDetailsArgs) error {
+	client := utils.NewHTTPClient()
+
+	args.RemoteHost = lynxConfig.RemoteHost
+	args.Content = Attribute(ctx, args.Content)
+	body := gwt.BuildTransactionDocumentSaveGWTBody(args)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_SERVICE_URL), strings.NewReader(body))
+
+	if err != nil {
+		return fmt.Errorf("failed to create transaction document save details request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
+	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+
+	// A lost response is only retried once the document is known to be missing
+	verify, err := saveCheck(ctx, lynxConfig, session, args.FileIdentifier, args.TransactionIdentifier, gwt.FileDocument{DocumentName: args.Name, DocumentType: args.Type, AttachmentUrl: args.AttachmentURL})
+	if err != nil {
+		return fmt.Errorf("failed to list transaction documents before saving: %w", err)
+	}
+	bodyStr, err := utils.RetryWriteRequest(ctx, client, req, &lynxConfig.Retry, verify)
+	Written(args.FileIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to execute transaction document save details request after retries: %w", err)
+	}
+	if bodyStr == "" {
+		// The save landed though its response was lost
+		return nil
+	}
+
+	// Parse the GWT response body
+	_, err = utils.ParseResponse(ctx, body, bodyStr, parseDocumentSave)
+	if err != nil {
+		return fmt.Errorf("failed to parse transaction document save details response: %w", err)
+	}
+
+	return nil
+}
+
+// SaveFileDocument saves a document at file level
+func SaveFileDocument(ctx context.Context, lynxConfig config.LynxServerConfig, session *utils.SessionContext, args *gwt.FileDocumentSaveDetailsArgs) error {
+	client := utils.NewHTTPClient()
+
+	args.RemoteHost = lynxConfig.RemoteHost
+	args.Content = Attribute(ctx, args.Content)
+	body := gwt.BuildFileDocumentSaveGWTBody(args)
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_SERVICE_URL), strings.NewReader(body))
+
+	if err != nil {
+		return fmt.Errorf("failed to create file document save details request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
+	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+
+	// A lost response is only retried once the document is known to be missing
+	verify, err := saveCheck(ctx, lynxConfig, session, args.FileIdentifier, "", gwt.FileDocument{DocumentName: args.Name, DocumentType: args.Type, AttachmentUrl: args.AttachmentURL})
+	if err != nil {
+		return fmt.Errorf("failed to list file documents before saving: %w", err)
+	}
+	bodyStr, err := utils.RetryWriteRequest(ctx, client, req, &lynxConfig.Retry, verify)
+	Written(args.FileIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to execute file document save details request after retries: %w", err)
+	}
+	if bodyStr == "" {
+		// The save landed though its response was lost
+		return nil
+	}
+
+	// Parse the GWT response body
+	_, err = utils.ParseResponse(ctx, body, bodyStr, parseDocumentSave)
+	if err != nil {
+		return fmt.Errorf("failed to parse file document save details response: %w", err)
+	}
+
+	return nil
+}
+
+// ListDocuments lists the documents of a transaction of a file, or of the file itself when transactionIdentifier is
+// empty
+func ListDocuments(ctx context.Context, lynxConfig config.LynxServerConfig, session *utils.SessionContext, fileIdentifier string, transactionIdentifier string) (*gwt.FileDocumentsResponseArray, error) {
+	client := utils.NewHTTPClient()
+
+	body := gwt.BuildFileDocumentsByFileReferenceGWTBody(&gwt.BuildFileDocumentsByFileReferenceArgs{
+		RemoteHost:     lynxConfig.RemoteHost,
+		FileIdentifier: fileIdentifier,
+	})
+	if transactionIdentifier != "" {
+		body = gwt.BuildFileDocumentsByTransactionReferenceGWTBody(&gwt.FileDocumentsByTransactionReferenceArgs{
+			RemoteHost:            lynxConfig.RemoteHost,
+			FileIdentifier:        fileIdentifier,
+			TransactionIdentifier: transactionIdentifier,
+		})
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_SERVICE_URL), strings.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file documents request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
+	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+
+	// Use retry utility with exponential backoff
+	resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, &lynxConfig.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute file documents request after retries: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Parse the GWT response body
+	documents, err := utils.ParseResponse(ctx, body, bodyStr, gwt.ParseFileDocumentsListResponseBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file documents response: %w", err)
+	}
+
+	return documents, nil
+}
+
+// saveCheck tells whether a save of document whose response was lost landed: Lynx then lists a new document with its
+// name, type and attachment URL. Documents are listed once a save failed, and before saving a document without
+// attachment so that an earlier one with the same name and type isn't taken for the save. Content isn't compared,
+// Lynx may return it reformatted and it ends with the attribution of the caller.
+func saveCheck(ctx context.Context, lynxConfig config.LynxServerConfig, session *utils.SessionContext, fileIdentifier string, transactionIdentifier string, document gwt.FileDocument) (utils.Verify, error) {
+	// Attachment URLs are new for each upload, no earlier document has them
+	var earlier map[string]bool
+	if document.AttachmentUrl == "" {
+		documents, err := ListDocuments(ctx, lynxConfig, session, fileIdentifier, transactionIdentifier)
+		if err != nil {
+			return nil, err
+		}
+		earlier = matchingDocuments(documents.Results, document)
+	}
+
+	return func(ctx context.Context) (bool, error) {
+		documents, err := ListDocuments(ctx, lynxConfig, session, fileIdentifier, transactionIdentifier)
+		if err != nil {
+			return false, err
+		}
+		return savedDocument(documents.Results, document, earlier), nil
+	}, nil
+}
+
+// matchingDocuments returns the identifiers of the listed documents with the name, type and attachment URL of document
+func matchingDocuments(listed []gwt.FileDocument, document gwt.FileDocument) map[string]bool {
+	matching := make(map[string]bool)
+	for _, candidate := range listed {
+		if candidate.DocumentName == document.DocumentName && candidate.DocumentType == document.DocumentType && candidate.AttachmentUrl == document.AttachmentUrl {
+			matching[candidate.DocumentIdentifier] = true
+		}
+	}
+	return matching
+}
+
+// savedDocument reports whether a document matching document is listed that wasn't listed before the save
+func savedDocument(listed []gwt.FileDocument, document gwt.FileDocument, earlier map[string]bool) bool {
+	for identifier := range matchingDocuments(listed, document) {
+		if !earlier[identifier] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDocumentSave adapts ParseDocumentSaveResponseBody to utils.ParseResponse, the response holds no value
+func parseDocumentSave(responseBody string) (struct{}, error) {
+	return struct{}{}, gwt.ParseDocumentSaveResponseBody(responseBody)
+}
+
+// Attribute records who filed a document in its content, Lynx documents have no author field.
+// The HTML comment isn't shown in Lynx but comes back with the document content.
+func Attribute(ctx context.Context, content string) string {
+	identity := auth.IdentityFromContext(ctx)
+	if identity == "" {
+		return content
+	}
+
+	// "--" would end the comment early
+	identity = strings.ReplaceAll(identity, "--", "-")
+	return content + fmt.Sprintf("<!-- %s%s -->", ATTRIBUTION_PREFIX, identity)
+}
@@ -0,0 +1,39 @@
+package lynx
+
+import (
+	"testing"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+)
+
+func TestSavedDocument(t *testing.T) {
+	note := gwt.FileDocument{DocumentName: "Supplier confirmation", DocumentType: "EMAIL"}
+	voucher := gwt.FileDocument{DocumentName: "voucher.pdf", DocumentType: "SUPP", AttachmentUrl: "/documents/file/f1/d2.pdf"}
+
+	// A note with the same name and type was filed before the save
+	before := []gwt.FileDocument{
+		{DocumentIdentifier: "1", DocumentName: "Supplier confirmation", DocumentType: "EMAIL", Content: "Confirmed for 4 Oct"},
+	}
+	earlier := matchingDocuments(before, note)
+
+	tests := []struct {
+		name     string
+		listed   []gwt.FileDocument
+		document gwt.FileDocument
+		earlier  map[string]bool
+		expected bool
+	}{
+		{"duplicate note not saved", before, note, earlier, false},
+		{"duplicate note saved", append(before, gwt.FileDocument{DocumentIdentifier: "2", DocumentName: "Supplier confirmation", DocumentType: "EMAIL"}), note, earlier, true},
+		{"other type", []gwt.FileDocument{{DocumentIdentifier: "2", DocumentName: "Supplier confirmation", DocumentType: "SUPP"}}, note, earlier, false},
+		{"attachment saved", []gwt.FileDocument{{DocumentIdentifier: "3", DocumentName: "voucher.pdf", DocumentType: "SUPP", AttachmentUrl: "/documents/file/f1/d2.pdf"}}, voucher, nil, true},
+		{"attachment not saved", before, voucher, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if saved := savedDocument(tt.listed, tt.document, tt.earlier); saved != tt.expected {
+				t.Errorf("savedDocument() = %v, want %v", saved, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package lynx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+)
+
+// ErrFileNotFound is returned when no Lynx file has the file reference
+var ErrFileNotFound = errors.New("no file found")
+
+// FindFileByReference returns the file with exactly this file reference, the search also returns files whose
+// reference only starts with it
+func FindFileByReference(ctx context.Context, lynxConfig config.LynxServerConfig, session *utils.SessionContext, fileReference string) (*gwt.FileSearchResult, error) {
+	files, err := SearchFilesByFileReference(ctx, lynxConfig, session, fileReference)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files.Results {
+		if strings.EqualFold(file.FileReference, fileReference) {
+			return &file, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w for file reference %s", ErrFileNotFound, fileReference)
+}
+
+// SearchFilesByFileReference runs the Lynx file search for a single file reference
+func SearchFilesByFileReference(ctx context.Context, lynxConfig config.LynxServerConfig, session *utils.SessionContext, fileReference string) (*gwt.FileSearchResponseArray, error) {
+	client := utils.NewHTTPClient()
+
+	body := gwt.BuildFileSearchByFileReferenceGWTBody(&gwt.FileSearchByFileReferenceArgs{
+		RemoteHost:    lynxConfig.RemoteHost,
+		FileReference: fileReference,
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_SERVICE_URL), strings.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file search request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
+	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+
+	// Use retry utility with exponential backoff
+	resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, &lynxConfig.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute file search request after retries: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Parse the GWT response body
+	fileSearchResponseBody, err := utils.ParseResponse(ctx, body, bodyStr, gwt.ParseFileSearchResponseBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file search response: %w", err)
+	}
+
+	return fileSearchResponseBody, nil
+}
+
+// RetrieveItinerary fetches the itinerary and its transactions for a file
+func RetrieveItinerary(ctx context.Context, lynxConfig config.LynxServerConfig, session *utils.SessionContext, fileIdentifier string) (*gwt.RetrieveItineraryResponseArray, error) {
+	client := utils.NewHTTPClient()
+
+	body := gwt.BuildRetrieveItineraryGWTBody(&gwt.RetrieveItineraryArgs{
+		RemoteHost:     lynxConfig.RemoteHost,
+		FileIdentifier: fileIdentifier,
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_SERVICE_URL), strings.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retrieve itinerary request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
+	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+
+	// Use retry utility with exponential backoff
+	resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, &lynxConfig.Retry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute retrieve itinerary request after retries: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Parse the GWT response body
+	retrieveItineraryResponseBody, err := utils.ParseResponse(ctx, body, bodyStr, gwt.ParseRetrieveItineraryResponseBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse retrieve itinerary response: %w", err)
+	}
+
+	return retrieveItineraryResponseBody, nil
+}
@@ -0,0 +1,33 @@
+// Package lynx wraps the Lynx GWT-RPC calls shared by tools and REST endpoints
+package lynx
+
+import "sync"
+
+const (
+	LYNX_FILE_SERVICE_URL string = "/lynx/service/file.rpc"
+
+	// ATTRIBUTION_PREFIX starts the comment naming who filed a document
+	ATTRIBUTION_PREFIX = "lynx-mcp-server filed by "
+)
+
+var (
+	writeHooksMu sync.RWMutex
+	writeHooks   []func(fileIdentifier string)
+)
+
+// OnWrite registers a function called with the file identifier of every document or attachment saved to Lynx, such
+// as dropping what a cache holds about the file
+func OnWrite(hook func(fileIdentifier string)) {
+	writeHooksMu.Lock()
+	defer writeHooksMu.Unlock()
+	writeHooks = append(writeHooks, hook)
+}
+
+// Written calls the OnWrite functions once a save to a file was attempted, a failed save may still have landed
+func Written(fileIdentifier string) {
+	writeHooksMu.RLock()
+	defer writeHooksMu.RUnlock()
+	for _, hook := range writeHooks {
+		hook(fileIdentifier)
+	}
+}
@@ -0,0 +1,69 @@
+package match
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dateLayouts are the accepted date hint layouts, day first as used by Lynx
+var dateLayouts = []string{
+	"2006-01-02",
+	"02 Jan 2006",
+	"2 Jan 2006",
+	"02 January 2006",
+	"2 January 2006",
+	"02/01/2006",
+	"2/1/2006",
+	"02.01.2006",
+}
+
+// ParseDate parses a date hint in one of the accepted layouts
+func ParseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected a date like 2025-10-04 or 04 Oct 2025", value)
+}
+
+// ParseDateRange parses Lynx itinerary dates: "02 Nov 2025", "04/05 Oct 2025" or "30 Sep/02 Oct 2025"
+func ParseDateRange(value string) (time.Time, time.Time, bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	year := fields[len(fields)-1]
+	from, to, isRange := strings.Cut(strings.Join(fields[:len(fields)-1], " "), "/")
+
+	if !isRange {
+		end, err := time.Parse("02 Jan 2006", from+" "+year)
+		return end, end, err == nil
+	}
+
+	// "04/05 Oct 2025" shares the month, "30 Sep/02 Oct 2025" has its own
+	end, err := time.Parse("02 Jan 2006", to+" "+year)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	startValue := from + " " + end.Format("Jan")
+	if strings.Contains(from, " ") {
+		startValue = from
+	}
+
+	start, err := time.Parse("02 Jan 2006", startValue+" "+year)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	// Stays over new year, e.g. "30 Dec/02 Jan 2026"
+	if start.After(end) {
+		start = start.AddDate(-1, 0, 0)
+	}
+
+	return start, end, true
+}
@@ -0,0 +1,224 @@
+// Package match resolves itinerary transactions from loose hints such as supplier names, vouchers and dates
+package match
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+)
+
+const (
+	// SUPPLIER_MIN_SCORE is the lowest supplier similarity still considered a match
+	SUPPLIER_MIN_SCORE = 0.6
+	// WORD_MIN_SIMILARITY is the lowest similarity for two words to be considered the same, absorbing typos
+	WORD_MIN_SIMILARITY = 0.8
+	// UNAMBIGUOUS_MARGIN is how far the best candidate must score above the next one to be picked
+	UNAMBIGUOUS_MARGIN = 0.2
+
+	DATE_MATCH_SCORE          = 1.0
+	DATE_ADJACENT_MATCH_SCORE = 0.5
+	VOUCHER_EXACT_SCORE       = 1.0
+	VOUCHER_PARTIAL_SCORE     = 0.7
+)
+
+// Hints describe the transaction being looked for, empty hints are ignored
+type Hints struct {
+	Supplier string
+	Voucher  string
+	Date     string
+}
+
+// Candidate is a transaction matching every given hint
+type Candidate struct {
+	gwt.ItineraryTransactionSummary
+	Score     float64  `json:"score"`
+	MatchedBy []string `json:"matchedBy"`
+}
+
+// Transactions scores the transactions against the hints and returns the ones matching all of them,
+// best first. Without hints every transaction is a candidate.
+func Transactions(transactions []gwt.ItineraryTransactionSummary, hints Hints) ([]Candidate, error) {
+	var hintDate time.Time
+	if hints.Date != "" {
+		parsed, err := ParseDate(hints.Date)
+		if err != nil {
+			return nil, err
+		}
+		hintDate = parsed
+	}
+
+	candidates := []Candidate{}
+	for _, transaction := range transactions {
+		candidate := Candidate{ItineraryTransactionSummary: transaction, MatchedBy: []string{}}
+		total, count := 0.0, 0
+
+		if hints.Voucher != "" {
+			score := voucherScore(hints.Voucher, transaction)
+			if score == 0 {
+				continue
+			}
+			total, count = total+score, count+1
+			candidate.MatchedBy = append(candidate.MatchedBy, "voucher")
+		}
+
+		if hints.Supplier != "" {
+			score := supplierScore(hints.Supplier, transaction.Supplier)
+			if score < SUPPLIER_MIN_SCORE {
+				continue
+			}
+			total, count = total+score, count+1
+			candidate.MatchedBy = append(candidate.MatchedBy, "supplier")
+		}
+
+		if !hintDate.IsZero() {
+			score := dateScore(hintDate, transaction.Date)
+			if score == 0 {
+				continue
+			}
+			total, count = total+score, count+1
+			candidate.MatchedBy = append(candidate.MatchedBy, "date")
+		}
+
+		if count > 0 {
+			candidate.Score = total / float64(count)
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	return candidates, nil
+}
+
+// Best returns the single candidate the hints point to, or false when the choice is ambiguous
+func Best(candidates []Candidate) (Candidate, bool) {
+	if len(candidates) == 0 {
+		return Candidate{}, false
+	}
+	if len(candidates) == 1 || candidates[0].Score-candidates[1].Score >= UNAMBIGUOUS_MARGIN {
+		return candidates[0], true
+	}
+	return Candidate{}, false
+}
+
+// voucherScore compares a voucher hint to the voucher and confirmation numbers of a transaction
+func voucherScore(hint string, transaction gwt.ItineraryTransactionSummary) float64 {
+	hint = normalizeReference(hint)
+	if hint == "" {
+		return 0
+	}
+
+	references := []string{transaction.VoucherIdentifier}
+	references = append(references, strings.FieldsFunc(transaction.ConfirmationNumber, func(r rune) bool {
+		return r == '/' || r == ',' || unicode.IsSpace(r)
+	})...)
+
+	best := 0.0
+	for _, reference := range references {
+		reference = normalizeReference(reference)
+		switch {
+		case reference == "":
+		case reference == hint:
+			return VOUCHER_EXACT_SCORE
+		case len(hint) >= 4 && strings.HasSuffix(reference, hint):
+			// Agents often quote only the end of a voucher, e.g. 569-4 for 16454569-4
+			best = VOUCHER_PARTIAL_SCORE
+		}
+	}
+	return best
+}
+
+// normalizeReference drops separators and case, so 16454569-4 and 16454569 4 compare equal
+func normalizeReference(reference string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToUpper(reference) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// supplierScore is the share of hint words found among the supplier words, tolerating typos
+func supplierScore(hint string, supplier string) float64 {
+	hintWords := words(hint)
+	supplierWords := words(supplier)
+	if len(hintWords) == 0 || len(supplierWords) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, hintWord := range hintWords {
+		best := 0.0
+		for _, supplierWord := range supplierWords {
+			if similarity := wordSimilarity(hintWord, supplierWord); similarity > best {
+				best = similarity
+			}
+		}
+		if best >= WORD_MIN_SIMILARITY {
+			total += best
+		}
+	}
+
+	return total / float64(len(hintWords))
+}
+
+func words(value string) []string {
+	return strings.FieldsFunc(strings.ToUpper(value), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// wordSimilarity is 1 minus the Levenshtein distance relative to the longest word
+func wordSimilarity(a string, b string) float64 {
+	ar, br := []rune(a), []rune(b)
+	longest := max(len(ar), len(br))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(ar, br))/float64(longest)
+}
+
+func levenshtein(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+// dateScore checks a date against a transaction date or date range, a day either side still scores
+func dateScore(hint time.Time, transactionDate string) float64 {
+	start, end, ok := ParseDateRange(transactionDate)
+	if !ok {
+		return 0
+	}
+
+	switch {
+	case !hint.Before(start) && !hint.After(end):
+		return DATE_MATCH_SCORE
+	case !hint.Before(start.AddDate(0, 0, -1)) && !hint.After(end.AddDate(0, 0, 1)):
+		return DATE_ADJACENT_MATCH_SCORE
+	default:
+		return 0
+	}
+}
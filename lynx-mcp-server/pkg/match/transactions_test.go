@@ -0,0 +1,91 @@
+package match
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+)
+
+var testTransactions = []gwt.ItineraryTransactionSummary{
+	{TransactionIdentifier: "BgBFw", Supplier: "CROWNE PLAZA ALICE SPRINGS LASSETERS", Date: "04/05 Oct 2025", VoucherIdentifier: "16454569-4", ConfirmationNumber: "PPA3CC057402/XX124156"},
+	{TransactionIdentifier: "BgBFx", Supplier: "ADVENTURE TOURS AUSTRALIA", Date: "05/09 Oct 2025", VoucherIdentifier: "16454569-3", ConfirmationNumber: "223447575"},
+	{TransactionIdentifier: "BgExO", Supplier: "ADVENTURE TOURS AUSTRALIA", Date: "25/31 Oct 2025", VoucherIdentifier: "16454569-2", ConfirmationNumber: "PPA3XXX503-778"},
+	{TransactionIdentifier: "BgFGt", Supplier: "IBIS PERTH", Date: "10/13 Oct 2025", VoucherIdentifier: "16454569-12", ConfirmationNumber: "RUE234MPTA"},
+}
+
+func TestTransactions(t *testing.T) {
+	tests := []struct {
+		name               string
+		hints              Hints
+		expectedCandidates []string
+		expectedBest       string
+	}{
+		{"exact voucher", Hints{Voucher: "16454569-4"}, []string{"BgBFw"}, "BgBFw"},
+		{"voucher end", Hints{Voucher: "569-12"}, []string{"BgFGt"}, "BgFGt"},
+		{"confirmation number part", Hints{Voucher: "xx124156"}, []string{"BgBFw"}, "BgBFw"},
+		{"supplier with typo", Hints{Supplier: "Crown Plaza Alice Springs"}, []string{"BgBFw"}, "BgBFw"},
+		{"ambiguous supplier", Hints{Supplier: "Adventure Tours"}, []string{"BgBFx", "BgExO"}, ""},
+		{"supplier and date", Hints{Supplier: "Adventure Tours", Date: "2025-10-27"}, []string{"BgExO"}, "BgExO"},
+		{"date inside range beats adjacent date", Hints{Date: "06 Oct 2025"}, []string{"BgBFx", "BgBFw"}, "BgBFx"},
+		{"day first date", Hints{Date: "13/10/2025"}, []string{"BgFGt"}, "BgFGt"},
+		{"no match", Hints{Supplier: "Sealink"}, []string{}, ""},
+		{"no hints", Hints{}, []string{"BgBFw", "BgBFx", "BgExO", "BgFGt"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates, err := Transactions(testTransactions, tt.hints)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			identifiers := []string{}
+			for _, candidate := range candidates {
+				identifiers = append(identifiers, candidate.TransactionIdentifier)
+			}
+			if !reflect.DeepEqual(identifiers, tt.expectedCandidates) {
+				t.Errorf("expected candidates %v, got %v", tt.expectedCandidates, identifiers)
+			}
+
+			best, ok := Best(candidates)
+			if ok != (tt.expectedBest != "") || best.TransactionIdentifier != tt.expectedBest {
+				t.Errorf("expected best %q, got %q (%v)", tt.expectedBest, best.TransactionIdentifier, ok)
+			}
+		})
+	}
+
+	if _, err := Transactions(testTransactions, Hints{Date: "next tuesday"}); err == nil {
+		t.Error("expected an error for an invalid date hint")
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	date := func(value string) time.Time {
+		parsed, _ := time.Parse("2006-01-02", value)
+		return parsed
+	}
+
+	tests := []struct {
+		value         string
+		expectedStart time.Time
+		expectedEnd   time.Time
+		expectedOk    bool
+	}{
+		{"02 Nov 2025", date("2025-11-02"), date("2025-11-02"), true},
+		{"04/05 Oct 2025", date("2025-10-04"), date("2025-10-05"), true},
+		{"30 Sep/02 Oct 2025", date("2025-09-30"), date("2025-10-02"), true},
+		{"30 Dec/02 Jan 2026", date("2025-12-30"), date("2026-01-02"), true},
+		{"-", time.Time{}, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			start, end, ok := ParseDateRange(tt.value)
+			if ok != tt.expectedOk || !start.Equal(tt.expectedStart) || !end.Equal(tt.expectedEnd) {
+				t.Errorf("expected %v %v %v, got %v %v %v", tt.expectedStart, tt.expectedEnd, tt.expectedOk, start, end, ok)
+			}
+		})
+	}
+}
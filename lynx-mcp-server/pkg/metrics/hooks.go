@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// calls holds the start time of the tool calls in flight, by session and request ID
+var calls sync.Map
+
+// AddHooks collects the tool call and SSE session metrics through the MCP server hooks
+func AddHooks(hooks *server.Hooks) {
+	hooks.AddBeforeCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest) {
+		calls.Store(callKey(ctx, id), time.Now())
+	})
+	hooks.AddAfterCallTool(func(ctx context.Context, id any, message *mcp.CallToolRequest, result *mcp.CallToolResult) {
+		observeCall(ctx, id, message.Params.Name)
+		if result != nil && result.IsError {
+			ToolErrors.WithLabelValues(message.Params.Name, ERROR_RESULT).Inc()
+		}
+	})
+	hooks.AddOnError(func(ctx context.Context, id any, method mcp.MCPMethod, message any, err error) {
+		if method != mcp.MethodToolsCall {
+			return
+		}
+		// Requests that couldn't be decoded never reached BeforeCallTool
+		callToolRequest, ok := message.(*mcp.CallToolRequest)
+		if !ok || callToolRequest.Params.Name == "" {
+			return
+		}
+
+		tool := callToolRequest.Params.Name
+		errorType := ErrorType(err)
+		// Clients choose the names of unknown tools, they would make a label value each
+		if errorType == ERROR_NOT_FOUND {
+			tool = UNKNOWN_TOOL
+		}
+		observeCall(ctx, id, tool)
+		ToolErrors.WithLabelValues(tool, errorType).Inc()
+	})
+
+	hooks.AddOnRegisterSession(func(ctx context.Context, session server.ClientSession) {
+		SSESessions.Inc()
+	})
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		SSESessions.Dec()
+	})
+}
+
+// observeCall counts a tool call and records its latency since BeforeCallTool
+func observeCall(ctx context.Context, id any, tool string) {
+	ToolCalls.WithLabelValues(tool).Inc()
+
+	start, ok := calls.LoadAndDelete(callKey(ctx, id))
+	if !ok {
+		return
+	}
+	ToolDuration.WithLabelValues(tool).Observe(time.Since(start.(time.Time)).Seconds())
+}
+
+// callKey identifies a request, request IDs are only unique within a session
+func callKey(ctx context.Context, id any) string {
+	sessionID := ""
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		sessionID = session.SessionID()
+	}
+	return fmt.Sprintf("%s/%v", sessionID, id)
+}
@@ -0,0 +1,220 @@
+// Package metrics exposes Prometheus metrics of tool calls, Lynx requests, sessions and uploads
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	NAMESPACE = "lynxmcp"
+
+	// Error types of failed tool calls
+	ERROR_RESULT    = "result"
+	ERROR_LYNX      = "lynx_exception"
+	ERROR_TIMEOUT   = "timeout"
+	ERROR_CANCELED  = "canceled"
+	ERROR_NOT_FOUND = "not_found"
+	ERROR_INTERNAL  = "internal"
+
+	RESULT_SUCCESS = "success"
+	RESULT_FAILURE = "failure"
+
+	CACHE_HIT  = "hit"
+	CACHE_MISS = "miss"
+	// CACHE_BYPASS is a call asking for a fresh result
+	CACHE_BYPASS = "bypass"
+
+	// Outcomes of calls made with an idempotency key
+	IDEMPOTENCY_NEW      = "new"
+	IDEMPOTENCY_REPLAYED = "replayed"
+	IDEMPOTENCY_REJECTED = "rejected"
+
+	// Outcomes of the attempts to apply a queued write
+	OUTBOX_APPLIED  = "applied"
+	OUTBOX_REQUEUED = "requeued"
+	OUTBOX_FAILED   = "failed"
+
+	// UNKNOWN_TOOL labels the calls of tools that don't exist
+	UNKNOWN_TOOL = "unknown"
+)
+
+// Registry holds the metrics of the server, with the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	ToolCalls = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "tool_calls_total",
+		Help:      "Tool calls by tool.",
+	}, []string{"tool"})
+
+	ToolErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "tool_errors_total",
+		Help:      "Failed tool calls by tool and error type.",
+	}, []string{"tool", "type"})
+
+	ToolDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "tool_duration_seconds",
+		Help:      "Tool call latency by tool.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"tool"})
+
+	ToolCache = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "tool_cache_total",
+		Help:      "Calls of cached tools by tool and result: hit, miss or bypass.",
+	}, []string{"tool", "result"})
+
+	IdempotentCalls = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "idempotent_calls_total",
+		Help:      "Write calls made with an idempotency key by tool or endpoint and outcome: new, replayed or rejected.",
+	}, []string{"tool", "result"})
+
+	OutboxEntries = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "outbox_entries",
+		Help:      "Writes queued in the outbox by status: pending or failed.",
+	}, []string{"status"})
+
+	OutboxAttempts = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "outbox_attempts_total",
+		Help:      "Attempts to apply queued writes by tool and result: applied, requeued or failed.",
+	}, []string{"tool", "result"})
+
+	LynxRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_requests_total",
+		Help:      "HTTP requests sent to Lynx by RPC method and status, every attempt counts.",
+	}, []string{"method", "status"})
+
+	LynxRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_request_duration_seconds",
+		Help:      "Lynx HTTP request latency by RPC method.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"method"})
+
+	LynxRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_retries_total",
+		Help:      "Lynx requests retried by RetryHTTPRequest, by RPC method and class of the failure retried.",
+	}, []string{"method", "failure"})
+
+	LynxExceptions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_exceptions_total",
+		Help:      "//EX responses of Lynx by RPC method.",
+	}, []string{"method"})
+
+	LynxParseFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_parse_failures_total",
+		Help:      "Lynx responses that couldn't be parsed, by RPC method.",
+	}, []string{"method"})
+
+	LynxQueued = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_queued_requests",
+		Help:      "Lynx requests waiting for the concurrency or rate limit.",
+	})
+
+	LynxActive = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_active_requests",
+		Help:      "Lynx requests in flight.",
+	})
+
+	LynxQueueWait = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_queue_wait_seconds",
+		Help:      "Time Lynx requests waited for the concurrency or rate limit.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	})
+
+	LynxBreakerState = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_breaker_state",
+		Help:      "State of the Lynx circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+
+	LynxBreakerRejections = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_breaker_rejections_total",
+		Help:      "Lynx requests failed fast by the open circuit breaker.",
+	})
+
+	LynxLogins = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_logins_total",
+		Help:      "Sign ins to Lynx by result.",
+	}, []string{"result"})
+
+	SessionCache = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "lynx_session_cache_total",
+		Help:      "Lookups of the Lynx session cache by result, hit or miss.",
+	}, []string{"result"})
+
+	UploadBytes = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "upload_bytes_total",
+		Help:      "Attachment bytes uploaded to Lynx.",
+	})
+
+	SSESessions = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "sse_sessions",
+		Help:      "Active SSE sessions.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ErrorType classifies the error of a failed tool call
+func ErrorType(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ERROR_TIMEOUT
+	case errors.Is(err, context.Canceled):
+		return ERROR_CANCELED
+	case errors.Is(err, server.ErrToolNotFound):
+		return ERROR_NOT_FOUND
+	case errors.Is(err, gwt.ErrException):
+		return ERROR_LYNX
+	}
+	return ERROR_INTERNAL
+}
+
+// Result labels the outcome of an operation
+func Result(err error) string {
+	if err != nil {
+		return RESULT_FAILURE
+	}
+	return RESULT_SUCCESS
+}
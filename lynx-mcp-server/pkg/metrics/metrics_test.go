@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRPCMethod(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		path string
+		want string
+	}{
+		{
+			name: "GWT-RPC body",
+			body: "7|0|8|https://lynx.example.com/lynx/lynx/|63A734E3E71C14883B20AFEC1238F6A7|com.lynxtraveltech.client.client.rpc.FileService|getFileDocumentsAsList|J|java.lang.Long/4227064769|",
+			path: "/lynx/service/file.rpc",
+			want: "getFileDocumentsAsList",
+		},
+		{
+			name: "other body",
+			body: "fileId=1061848",
+			path: "/lynx/fileDocumentUpload",
+			want: "fileDocumentUpload",
+		},
+		{
+			name: "no body",
+			path: "/lynx/fileDocumentUpload",
+			want: "fileDocumentUpload",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req, err := http.NewRequest(http.MethodPost, "https://lynx.example.com"+tt.path, body)
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+
+			if got := RPCMethod(req); got != tt.want {
+				t.Errorf("RPCMethod() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransportCountsRequests(t *testing.T) {
+	lynx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer lynx.Close()
+
+	client := &http.Client{Transport: Transport}
+	body := "7|0|9|https://lynx.example.com/lynx/lynx/|63A734E3E71C14883B20AFEC1238F6A7|com.lynxtraveltech.client.client.rpc.FileService|search|"
+	before := testutil.ToFloat64(LynxRequests.WithLabelValues("search", "503"))
+
+	resp, err := client.Post(lynx.URL+"/lynx/service/file.rpc", "text/x-gwt-rpc", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := testutil.ToFloat64(LynxRequests.WithLabelValues("search", "503")) - before; got != 1 {
+		t.Errorf("lynx_requests_total{method=search,status=503} increased by %v, want 1", got)
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "deadline", err: fmt.Errorf("search: %w", context.DeadlineExceeded), want: ERROR_TIMEOUT},
+		{name: "canceled", err: context.Canceled, want: ERROR_CANCELED},
+		{name: "unknown tool", err: fmt.Errorf("tool 'x' not found: %w", server.ErrToolNotFound), want: ERROR_NOT_FOUND},
+		{name: "Lynx exception", err: fmt.Errorf("RetryHTTPRequest attempt 1: %w: File is locked", gwt.ErrException), want: ERROR_LYNX},
+		{name: "other", err: errors.New("invalid partyName argument"), want: ERROR_INTERNAL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorType(tt.err); got != tt.want {
+				t.Errorf("ErrorType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamWindow(t *testing.T) {
+	u := &upstream{}
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	u.Record(start, "")
+	u.Record(start, "retrieveItinerary: 502 Bad Gateway")
+	u.Record(start.Add(5*time.Minute), "")
+	u.Record(start.Add(5*time.Minute), "")
+
+	tests := []struct {
+		name         string
+		at           time.Time
+		wantRequests int
+		wantErrors   int
+	}{
+		{name: "whole window", at: start.Add(5 * time.Minute), wantRequests: 4, wantErrors: 1},
+		{name: "first minute expired", at: start.Add(UPSTREAM_WINDOW_MINUTES * time.Minute), wantRequests: 2, wantErrors: 0},
+		{name: "all expired", at: start.Add(time.Hour), wantRequests: 0, wantErrors: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := u.Snapshot(tt.at)
+			if stats.Requests != tt.wantRequests || stats.Errors != tt.wantErrors {
+				t.Errorf("Snapshot() = %d requests %d errors, want %d and %d", stats.Requests, stats.Errors, tt.wantRequests, tt.wantErrors)
+			}
+			if stats.LastError != "retrieveItinerary: 502 Bad Gateway" {
+				t.Errorf("LastError = %q", stats.LastError)
+			}
+		})
+	}
+}
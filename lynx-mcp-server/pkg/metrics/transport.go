@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+)
+
+// Transport is the round tripper shared by the Lynx HTTP clients, it counts and times every request
+var Transport http.RoundTripper = &transport{next: http.DefaultTransport}
+
+type transport struct {
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := RPCMethod(req)
+	start := time.Now()
+
+	resp, err := t.next.RoundTrip(req)
+
+	LynxRequestDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	status, failure := "error", ""
+	if err != nil {
+		failure = method + ": " + err.Error()
+	} else {
+		status = strconv.Itoa(resp.StatusCode)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			failure = method + ": " + resp.Status
+		}
+	}
+	LynxRequests.WithLabelValues(method, status).Inc()
+	Upstream.Record(time.Now(), failure)
+
+	return resp, err
+}
+
+// RPCMethod names the Lynx call of a request: the GWT-RPC method when the body can be replayed, the last element
+// of the path otherwise, such as fileDocumentUpload for streamed uploads
+func RPCMethod(req *http.Request) string {
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			defer body.Close()
+			// The method is within the first few hundred bytes, before any argument
+			head, _ := io.ReadAll(io.LimitReader(body, 512))
+			if method := gwt.RPCMethod(string(head)); method != "" {
+				return method
+			}
+		}
+	}
+	return path.Base(req.URL.Path)
+}
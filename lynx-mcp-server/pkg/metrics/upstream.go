@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// UPSTREAM_WINDOW_MINUTES is how far back Upstream reports the outcome of Lynx requests
+const UPSTREAM_WINDOW_MINUTES = 15
+
+// Upstream keeps the outcome of recent Lynx requests, Prometheus counters only tell rates to whoever scrapes them
+var Upstream = &upstream{}
+
+// UpstreamStats summarizes the Lynx requests of the last UPSTREAM_WINDOW_MINUTES
+type UpstreamStats struct {
+	WindowMinutes int        `json:"windowMinutes"`
+	Requests      int        `json:"requests"`
+	Errors        int        `json:"errors"`
+	ErrorRate     float64    `json:"errorRate"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorAt   *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+type upstream struct {
+	mu          sync.Mutex
+	buckets     [UPSTREAM_WINDOW_MINUTES]upstreamBucket
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// upstreamBucket counts the requests of one minute
+type upstreamBucket struct {
+	minute   int64
+	requests int
+	errors   int
+}
+
+// Record counts a request, failure describes why it failed and is empty for a success
+func (u *upstream) Record(now time.Time, failure string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	minute := now.Unix() / 60
+	bucket := &u.buckets[minute%UPSTREAM_WINDOW_MINUTES]
+	if bucket.minute != minute {
+		*bucket = upstreamBucket{minute: minute}
+	}
+
+	bucket.requests++
+	if failure != "" {
+		bucket.errors++
+		u.lastError = failure
+		u.lastErrorAt = now
+	}
+}
+
+// Snapshot sums the requests of the window ending at now
+func (u *upstream) Snapshot(now time.Time) UpstreamStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	stats := UpstreamStats{WindowMinutes: UPSTREAM_WINDOW_MINUTES}
+	minute := now.Unix() / 60
+	for _, bucket := range u.buckets {
+		if bucket.minute > minute-UPSTREAM_WINDOW_MINUTES && bucket.minute <= minute {
+			stats.Requests += bucket.requests
+			stats.Errors += bucket.errors
+		}
+	}
+	if stats.Requests > 0 {
+		stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+	}
+	if !u.lastErrorAt.IsZero() {
+		lastErrorAt := u.lastErrorAt.UTC()
+		stats.LastError = u.lastError
+		stats.LastErrorAt = &lastErrorAt
+	}
+	return stats
+}
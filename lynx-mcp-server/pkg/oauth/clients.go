@@ -0,0 +1,260 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	CLIENT_ID_PREFIX = "mcp_"
+
+	// MAX_CLIENTS bounds the open dynamic registration endpoint
+	MAX_CLIENTS = 1000
+
+	// UNUSED_CLIENT_TTL expires clients nobody signed in with, registering is the first step of a sign in
+	UNUSED_CLIENT_TTL = time.Hour
+	// CLIENT_IDLE_TTL expires clients nobody signed in with or refreshed a token of for that long
+	CLIENT_IDLE_TTL = 30 * 24 * time.Hour
+	// CLIENT_USE_RESOLUTION is how stale the saved last use of a client may be, refreshes don't write the file each time
+	CLIENT_USE_RESOLUTION = time.Hour
+)
+
+var ErrTooManyClients = errors.New("too many registered clients")
+
+// Client is a public client registered dynamically (RFC 7591), it authenticates with PKCE instead of a secret
+type Client struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"client_name,omitempty"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	IssuedAt     time.Time `json:"issued_at"`
+	LastUsedAt   time.Time `json:"last_used_at,omitempty"`
+}
+
+// expired reports whether the client went unused for too long
+func (c Client) expired(now time.Time, idleTTL time.Duration) bool {
+	if c.LastUsedAt.IsZero() {
+		return now.Sub(c.IssuedAt) > UNUSED_CLIENT_TTL
+	}
+	return now.Sub(c.LastUsedAt) > idleTTL
+}
+
+// Clients keeps the registered clients, in a file when one is configured. Clients nobody signed in with expire
+// after UNUSED_CLIENT_TTL, the others once idle for idleTTL.
+type Clients struct {
+	mu      sync.Mutex
+	path    string
+	idleTTL time.Duration
+	clients map[string]Client
+}
+
+// NewClients loads the clients registered before a restart, path may be empty to keep them in memory
+func NewClients(path string, idleTTL time.Duration) (*Clients, error) {
+	clients := &Clients{
+		path:    path,
+		idleTTL: idleTTL,
+		clients: make(map[string]Client),
+	}
+
+	if path == "" {
+		return clients, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return clients, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OAuth clients file: %w", err)
+	}
+
+	var list []Client
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse OAuth clients file %s: %w", path, err)
+	}
+	for _, client := range list {
+		clients.clients[client.ID] = client
+	}
+
+	return clients, nil
+}
+
+// Register validates the redirect URIs and adds a client. Expired clients are dropped first, then the oldest one
+// nobody signed in with when there are still too many.
+func (c *Clients) Register(name string, redirectURIs []string, now time.Time) (Client, error) {
+	if len(redirectURIs) == 0 {
+		return Client{}, fmt.Errorf("redirect_uris is required")
+	}
+	for _, redirectURI := range redirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return Client{}, err
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Client{}, fmt.Errorf("failed to generate client id: %w", err)
+	}
+
+	client := Client{
+		ID:           CLIENT_ID_PREFIX + hex.EncodeToString(id),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		IssuedAt:     now,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := maps.Clone(c.clients)
+
+	for id, registered := range c.clients {
+		if registered.expired(now, c.idleTTL) {
+			delete(c.clients, id)
+		}
+	}
+
+	if len(c.clients) >= MAX_CLIENTS {
+		var oldest *Client
+		for _, registered := range c.clients {
+			if registered.LastUsedAt.IsZero() && (oldest == nil || registered.IssuedAt.Before(oldest.IssuedAt)) {
+				oldest = &registered
+			}
+		}
+		if oldest == nil {
+			return Client{}, ErrTooManyClients
+		}
+		delete(c.clients, oldest.ID)
+	}
+
+	c.clients[client.ID] = client
+	if err := c.save(); err != nil {
+		c.clients = previous
+		return Client{}, err
+	}
+
+	return client, nil
+}
+
+// Get returns a registered client, expired ones are unknown
+func (c *Clients) Get(id string, now time.Time) (Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client, ok := c.clients[id]
+	if !ok || client.expired(now, c.idleTTL) {
+		return Client{}, false
+	}
+	return client, true
+}
+
+// Use records that someone signed in with the client or refreshed one of its tokens, keeping it from expiring
+func (c *Clients) Use(id string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client, ok := c.clients[id]
+	if !ok || now.Sub(client.LastUsedAt) < CLIENT_USE_RESOLUTION {
+		return nil
+	}
+
+	client.LastUsedAt = now
+	c.clients[id] = client
+	return c.save()
+}
+
+// AllowsRedirect reports whether the redirect URI was registered. Loopback redirects may use any port,
+// desktop clients listen on a free port picked at each sign in (RFC 8252).
+func (c Client) AllowsRedirect(redirectURI string) bool {
+	if slices.Contains(c.RedirectURIs, redirectURI) {
+		return true
+	}
+
+	requested, err := url.Parse(redirectURI)
+	if err != nil || !isLoopback(requested) {
+		return false
+	}
+
+	for _, registered := range c.RedirectURIs {
+		allowed, err := url.Parse(registered)
+		if err == nil && isLoopback(allowed) && allowed.Scheme == requested.Scheme &&
+			allowed.Hostname() == requested.Hostname() && allowed.Path == requested.Path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// save writes the clients file, the caller holds the lock
+func (c *Clients) save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	list := make([]Client, 0, len(c.clients))
+	for _, client := range c.clients {
+		list = append(list, client)
+	}
+	slices.SortFunc(list, func(a, b Client) int { return a.IssuedAt.Compare(b.IssuedAt) })
+
+	content, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode OAuth clients: %w", err)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(c.path), ".clients-*")
+	if err != nil {
+		return fmt.Errorf("failed to write OAuth clients file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write OAuth clients file: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write OAuth clients file: %w", err)
+	}
+
+	return os.Rename(temp.Name(), c.path)
+}
+
+// validateRedirectURI accepts https redirects and http ones to the loopback interface only
+func validateRedirectURI(redirectURI string) error {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+		return fmt.Errorf("invalid redirect_uri %q", redirectURI)
+	}
+
+	switch {
+	case parsed.Scheme == "https" && parsed.Host != "":
+		return nil
+	case parsed.Scheme == "http" && isLoopback(parsed):
+		return nil
+	}
+
+	return fmt.Errorf("redirect_uri %q must use https, or http on localhost", redirectURI)
+}
+
+func isLoopback(parsed *url.URL) bool {
+	if parsed.Scheme != "http" {
+		return false
+	}
+	host := parsed.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
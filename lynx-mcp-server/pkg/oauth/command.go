@@ -0,0 +1,41 @@
+package oauth
+
+import (
+	"flag"
+	"fmt"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/credentials"
+)
+
+// Command runs the users subcommand, printing the password hashes to put in the users file
+func Command(name string, args []string) error {
+	flags := flag.NewFlagSet(name+" users", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `Usage: %s users COMMAND
+
+Commands:
+  hash-password  Print the bcrypt hash of a password read from the terminal or stdin
+`, name)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch flags.Arg(0) {
+	case "hash-password":
+		password, err := credentials.ReadSecret("Password")
+		if err != nil {
+			return err
+		}
+		hash, err := HashPassword(password)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hash)
+		return nil
+	}
+
+	flags.Usage()
+	return flag.ErrHelp
+}
@@ -0,0 +1,79 @@
+package oauth
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in - Lynx MCP Server</title>
+<style>
+body { font-family: sans-serif; max-width: 24em; margin: 4em auto; padding: 0 1em; }
+label, input, button { display: block; width: 100%; box-sizing: border-box; }
+input { margin: 0.25em 0 1em; padding: 0.5em; }
+button { padding: 0.5em; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Lynx MCP Server</h1>
+<p><strong>{{if .ClientName}}{{.ClientName}}{{else}}{{.ClientID}}{{end}}</strong> wants to access Lynx on your behalf.</p>
+{{if .Scope}}<p>Requested scopes: {{.Scope}}</p>{{end}}
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post">
+<input type="hidden" name="response_type" value="{{.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="resource" value="{{.Resource}}">
+<label for="username">Username</label>
+<input id="username" name="username" autocomplete="username" required autofocus>
+<label for="password">Password</label>
+<input id="password" name="password" type="password" autocomplete="current-password" required>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Sign in failed - Lynx MCP Server</title>
+</head>
+<body>
+<h1>Lynx MCP Server</h1>
+<p>{{.}}</p>
+</body>
+</html>
+`))
+
+// renderLogin shows the sign in form, it can't be framed by another site
+func renderLogin(w http.ResponseWriter, status int, request authorizeRequest) {
+	render(w, status, loginPage, request)
+}
+
+// renderError shows an error to the user, used when the client can't be trusted with a redirect
+func renderError(w http.ResponseWriter, status int, message string) {
+	render(w, status, errorPage, message)
+}
+
+func render(w http.ResponseWriter, status int, page *template.Template, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := page.Execute(w, data); err != nil {
+		slog.Error("Failed to render page", "page", page.Name(), "error", err)
+	}
+}
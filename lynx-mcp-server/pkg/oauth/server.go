@@ -0,0 +1,639 @@
+// Package oauth is a minimal OAuth 2.1 authorization server for MCP clients, staff sign in with a local users file
+// and get short-lived access tokens the bearer token middleware accepts
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+const (
+	WELL_KNOWN_PROTECTED_RESOURCE   = "/.well-known/oauth-protected-resource"
+	WELL_KNOWN_AUTHORIZATION_SERVER = "/.well-known/oauth-authorization-server"
+	AUTHORIZE_PATH                  = "/authorize"
+	TOKEN_PATH                      = "/token"
+	REGISTER_PATH                   = "/register"
+
+	AUTHORIZATION_CODE_TTL = time.Minute
+	CODE_CHALLENGE_METHOD  = "S256"
+
+	GRANT_AUTHORIZATION_CODE = "authorization_code"
+	GRANT_REFRESH_TOKEN      = "refresh_token"
+
+	// MAX_REGISTRATION_SIZE bounds the body of dynamic client registrations
+	MAX_REGISTRATION_SIZE = 64 * 1024
+)
+
+// authorization is an issued authorization code waiting to be exchanged
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	username      string
+	scopes        []string
+	expiresAt     time.Time
+}
+
+// refreshGrant is an issued refresh token, it is rotated at each use
+type refreshGrant struct {
+	clientID  string
+	username  string
+	scopes    []string
+	expiresAt time.Time
+}
+
+// Server issues access tokens signed with a key generated at startup, a restart signs everyone out
+type Server struct {
+	publicURL       string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+
+	users    *Users
+	clients  *Clients
+	secret   []byte
+	verifier *auth.JWTVerifier
+
+	mu            sync.Mutex
+	codes         map[string]authorization
+	refreshTokens map[string]refreshGrant
+
+	// Wrong passwords are limited per username and per client IP, registrations per client IP
+	failedSignIns     *throttle
+	failedSignInsByIP *throttle
+	registrationsByIP *throttle
+
+	now func() time.Time
+}
+
+// NewServer loads the users and the registered clients
+func NewServer(oauthConfig config.OAuthConfig) (*Server, error) {
+	users, err := LoadUsers(oauthConfig.UsersFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clients stay while their refresh tokens may still be used
+	clients, err := NewClients(oauthConfig.ClientsFile, max(CLIENT_IDLE_TTL, oauthConfig.RefreshTokenTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate OAuth signing key: %w", err)
+	}
+
+	publicURL := strings.TrimSuffix(oauthConfig.PublicURL, "/")
+
+	verifier, err := auth.NewJWTVerifier(config.JWTConfig{
+		Secret:        string(secret),
+		Issuer:        publicURL,
+		Audience:      publicURL,
+		ScopesClaim:   "scope",
+		ToolsClaim:    "tools",
+		IdentityClaim: "sub",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		publicURL:         publicURL,
+		accessTokenTTL:    oauthConfig.AccessTokenTTL,
+		refreshTokenTTL:   oauthConfig.RefreshTokenTTL,
+		users:             users,
+		clients:           clients,
+		secret:            secret,
+		verifier:          verifier,
+		codes:             make(map[string]authorization),
+		refreshTokens:     make(map[string]refreshGrant),
+		failedSignIns:     newThrottle(MAX_FAILED_SIGN_INS_PER_USER, SIGN_IN_PERIOD),
+		failedSignInsByIP: newThrottle(MAX_FAILED_SIGN_INS_PER_IP, SIGN_IN_PERIOD),
+		registrationsByIP: newThrottle(MAX_REGISTRATIONS_PER_IP, REGISTRATION_PERIOD),
+		now:               time.Now,
+	}, nil
+}
+
+// Verify accepts the access tokens issued by this server
+func (s *Server) Verify(token string) (*auth.Token, error) {
+	return s.verifier.Verify(token)
+}
+
+// ResourceMetadataURL is advertised in the WWW-Authenticate header of 401 responses
+func (s *Server) ResourceMetadataURL() string {
+	return s.publicURL + WELL_KNOWN_PROTECTED_RESOURCE
+}
+
+// Router registers routes, such as http.ServeMux
+type Router interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// Register adds the metadata and authorization server routes, they must not sit behind the bearer token middleware
+func (s *Server) Register(router Router) {
+	router.Handle(WELL_KNOWN_PROTECTED_RESOURCE, cors(http.HandlerFunc(s.handleProtectedResource)))
+	router.Handle(WELL_KNOWN_PROTECTED_RESOURCE+"/", cors(http.HandlerFunc(s.handleProtectedResource)))
+	router.Handle(WELL_KNOWN_AUTHORIZATION_SERVER, cors(http.HandlerFunc(s.handleAuthorizationServer)))
+	router.Handle(AUTHORIZE_PATH, http.HandlerFunc(s.handleAuthorize))
+	router.Handle(TOKEN_PATH, cors(http.HandlerFunc(s.handleToken)))
+	router.Handle(REGISTER_PATH, cors(http.HandlerFunc(s.handleRegister)))
+}
+
+// handleProtectedResource serves the protected resource metadata (RFC 9728)
+func (s *Server) handleProtectedResource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"resource":                 s.publicURL,
+		"authorization_servers":    []string{s.publicURL},
+		"scopes_supported":         supportedScopes(),
+		"bearer_methods_supported": []string{"header"},
+		"resource_name":            "Lynx MCP Server",
+	})
+}
+
+// handleAuthorizationServer serves the authorization server metadata (RFC 8414)
+func (s *Server) handleAuthorizationServer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	grantTypes := []string{GRANT_AUTHORIZATION_CODE}
+	if s.refreshTokenTTL > 0 {
+		grantTypes = append(grantTypes, GRANT_REFRESH_TOKEN)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.publicURL,
+		"authorization_endpoint":                s.publicURL + AUTHORIZE_PATH,
+		"token_endpoint":                        s.publicURL + TOKEN_PATH,
+		"registration_endpoint":                 s.publicURL + REGISTER_PATH,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 grantTypes,
+		"code_challenge_methods_supported":      []string{CODE_CHALLENGE_METHOD},
+		"token_endpoint_auth_methods_supported": []string{"none"},
+		"scopes_supported":                      supportedScopes(),
+	})
+}
+
+// authorizeRequest holds the parameters of an authorization request, carried through the sign in form
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	ClientName          string
+	RedirectURI         string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	State               string
+	Scope               string
+	Resource            string
+	Error               string
+}
+
+// handleAuthorize shows the sign in form and issues an authorization code once the user signed in
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	request := authorizeRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		State:               r.Form.Get("state"),
+		Scope:               r.Form.Get("scope"),
+		Resource:            r.Form.Get("resource"),
+	}
+
+	// Errors are only sent back to the client once its redirect URI is trusted
+	client, ok := s.clients.Get(request.ClientID, s.now())
+	if !ok {
+		renderError(w, http.StatusBadRequest, "Unknown client, register it again from your MCP client")
+		return
+	}
+	if !client.AllowsRedirect(request.RedirectURI) {
+		renderError(w, http.StatusBadRequest, "The redirect URI isn't registered for this client")
+		return
+	}
+	request.ClientName = client.Name
+
+	switch {
+	case request.ResponseType != "code":
+		s.redirectError(w, r, request, "unsupported_response_type", "response_type must be code")
+		return
+	case request.CodeChallenge == "" || request.CodeChallengeMethod != CODE_CHALLENGE_METHOD:
+		s.redirectError(w, r, request, "invalid_request", "PKCE with code_challenge_method S256 is required")
+		return
+	// The only resource is the server itself, its URL with or without the trailing slash of the root path
+	case request.Resource != "" && request.Resource != s.publicURL && request.Resource != s.publicURL+"/":
+		s.redirectError(w, r, request, "invalid_target", "unknown resource")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		renderLogin(w, http.StatusOK, request)
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	userKey := strings.ToLower(username)
+	ip := clientIP(r)
+	now := s.now()
+
+	// Passwords aren't checked at all once too many were wrong, until attempts are available again
+	if wait := max(s.failedSignIns.wait(userKey, now), s.failedSignInsByIP.wait(ip, now)); wait > 0 {
+		slog.WarnContext(r.Context(), "OAuth sign in throttled", "user", username, "ip", ip)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		request.Error = "Too many failed sign ins, try again in " + wait.Round(time.Second).String()
+		renderLogin(w, http.StatusTooManyRequests, request)
+		return
+	}
+
+	user, ok := s.users.Authenticate(username, r.PostForm.Get("password"))
+	if !ok {
+		s.failedSignIns.allow(userKey, now)
+		s.failedSignInsByIP.allow(ip, now)
+		slog.WarnContext(r.Context(), "OAuth sign in failed", "user", username, "ip", ip)
+		request.Error = "Invalid username or password"
+		renderLogin(w, http.StatusUnauthorized, request)
+		return
+	}
+
+	scopes := grantedScopes(user.Scopes, strings.Fields(request.Scope))
+	if len(scopes) == 0 {
+		s.redirectError(w, r, request, "invalid_scope", "none of the requested scopes is granted to "+user.Username)
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		http.Error(w, "Failed to issue authorization code", http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	s.sweep()
+	s.codes[hashToken(code)] = authorization{
+		clientID:      client.ID,
+		redirectURI:   request.RedirectURI,
+		codeChallenge: request.CodeChallenge,
+		username:      user.Username,
+		scopes:        scopes,
+		expiresAt:     s.now().Add(AUTHORIZATION_CODE_TTL),
+	}
+	s.mu.Unlock()
+
+	if err := s.clients.Use(client.ID, now); err != nil {
+		slog.WarnContext(r.Context(), "Failed to record OAuth client use", "client", client.ID, "error", err)
+	}
+
+	slog.InfoContext(r.Context(), "OAuth sign in", "user", user.Username, "client", client.ID, "client_title", client.Name)
+
+	s.redirect(w, r, request, url.Values{"code": {code}})
+}
+
+// handleToken exchanges authorization codes and refresh tokens for access tokens
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if _, ok := s.clients.Get(clientID, s.now()); !ok {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "unknown client")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case GRANT_AUTHORIZATION_CODE:
+		s.mu.Lock()
+		key := hashToken(r.PostForm.Get("code"))
+		issued, ok := s.codes[key]
+		// Codes are single use, even when the exchange fails
+		delete(s.codes, key)
+		s.mu.Unlock()
+
+		switch {
+		case !ok || s.now().After(issued.expiresAt):
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired authorization code")
+		case issued.clientID != clientID || issued.redirectURI != r.PostForm.Get("redirect_uri"):
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "authorization code was issued to another client or redirect URI")
+		case !verifyCodeChallenge(r.PostForm.Get("code_verifier"), issued.codeChallenge):
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier doesn't match the code_challenge")
+		default:
+			s.issueTokens(w, clientID, issued.username, issued.scopes)
+		}
+
+	case GRANT_REFRESH_TOKEN:
+		if s.refreshTokenTTL <= 0 {
+			tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "refresh tokens are disabled")
+			return
+		}
+
+		s.mu.Lock()
+		key := hashToken(r.PostForm.Get("refresh_token"))
+		grant, ok := s.refreshTokens[key]
+		delete(s.refreshTokens, key)
+		s.mu.Unlock()
+
+		if !ok || s.now().After(grant.expiresAt) || grant.clientID != clientID {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired refresh token")
+			return
+		}
+
+		// The users file may have changed since the sign in, the user can't gain scopes by refreshing
+		user, ok := s.users.Get(grant.username)
+		if !ok {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "user no longer exists")
+			return
+		}
+		scopes := grantedScopes(user.Scopes, grant.scopes)
+		if len(scopes) == 0 {
+			tokenError(w, http.StatusBadRequest, "invalid_grant", "user no longer has the granted scopes")
+			return
+		}
+
+		if err := s.clients.Use(clientID, s.now()); err != nil {
+			slog.WarnContext(r.Context(), "Failed to record OAuth client use", "client", clientID, "error", err)
+		}
+
+		s.issueTokens(w, clientID, grant.username, scopes)
+
+	default:
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+// issueTokens answers with a signed access token, and a refresh token when enabled
+func (s *Server) issueTokens(w http.ResponseWriter, clientID string, username string, scopes []string) {
+	user, _ := s.users.Get(username)
+	now := s.now()
+
+	jti, err := randomToken()
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+
+	claims := map[string]any{
+		"iss":       s.publicURL,
+		"aud":       s.publicURL,
+		"sub":       username,
+		"client_id": clientID,
+		"scope":     strings.Join(scopes, " "),
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+		"exp":       now.Add(s.accessTokenTTL).Unix(),
+		"jti":       jti,
+	}
+	if len(user.Tools) > 0 {
+		claims["tools"] = user.Tools
+	}
+
+	accessToken, err := auth.SignHS256(claims, s.secret)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+
+	response := map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(s.accessTokenTTL.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	}
+
+	if s.refreshTokenTTL > 0 {
+		refreshToken, err := randomToken()
+		if err != nil {
+			tokenError(w, http.StatusInternalServerError, "server_error", "failed to issue token")
+			return
+		}
+
+		s.mu.Lock()
+		s.sweep()
+		s.refreshTokens[hashToken(refreshToken)] = refreshGrant{
+			clientID:  clientID,
+			username:  username,
+			scopes:    scopes,
+			expiresAt: now.Add(s.refreshTokenTTL),
+		}
+		s.mu.Unlock()
+
+		response["refresh_token"] = refreshToken
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleRegister registers public clients dynamically (RFC 7591)
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if ok, wait := s.registrationsByIP.allow(clientIP(r), s.now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		registrationError(w, http.StatusTooManyRequests, "temporarily_unavailable", "too many client registrations, try again later")
+		return
+	}
+
+	var metadata struct {
+		ClientName              string   `json:"client_name"`
+		RedirectURIs            []string `json:"redirect_uris"`
+		TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_REGISTRATION_SIZE)).Decode(&metadata); err != nil {
+		registrationError(w, http.StatusBadRequest, "invalid_client_metadata", "invalid JSON body")
+		return
+	}
+
+	if metadata.TokenEndpointAuthMethod != "" && metadata.TokenEndpointAuthMethod != "none" {
+		registrationError(w, http.StatusBadRequest, "invalid_client_metadata", "only public clients are supported, token_endpoint_auth_method must be none")
+		return
+	}
+
+	client, err := s.clients.Register(metadata.ClientName, metadata.RedirectURIs, s.now())
+	if errors.Is(err, ErrTooManyClients) {
+		registrationError(w, http.StatusServiceUnavailable, "temporarily_unavailable", err.Error())
+		return
+	}
+	if err != nil {
+		registrationError(w, http.StatusBadRequest, "invalid_redirect_uri", err.Error())
+		return
+	}
+
+	slog.InfoContext(r.Context(), "OAuth client registered", "client", client.ID, "client_title", client.Name)
+
+	grantTypes := []string{GRANT_AUTHORIZATION_CODE}
+	if s.refreshTokenTTL > 0 {
+		grantTypes = append(grantTypes, GRANT_REFRESH_TOKEN)
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"client_id":                  client.ID,
+		"client_id_issued_at":        client.IssuedAt.Unix(),
+		"client_name":                client.Name,
+		"redirect_uris":              client.RedirectURIs,
+		"token_endpoint_auth_method": "none",
+		"grant_types":                grantTypes,
+		"response_types":             []string{"code"},
+	})
+}
+
+// sweep drops expired codes and refresh tokens and the throttled keys that recovered, the caller holds the lock
+func (s *Server) sweep() {
+	now := s.now()
+	s.failedSignIns.sweep(now)
+	s.failedSignInsByIP.sweep(now)
+	s.registrationsByIP.sweep(now)
+	for key, code := range s.codes {
+		if now.After(code.expiresAt) {
+			delete(s.codes, key)
+		}
+	}
+	for key, grant := range s.refreshTokens {
+		if now.After(grant.expiresAt) {
+			delete(s.refreshTokens, key)
+		}
+	}
+}
+
+// grantedScopes keeps the requested scopes the user has, all of them when none was requested
+func grantedScopes(userScopes []string, requested []string) []string {
+	if len(requested) == 0 {
+		return userScopes
+	}
+
+	admin := slices.Contains(userScopes, string(auth.SCOPE_ADMIN))
+
+	var granted []string
+	for _, scope := range requested {
+		if !slices.Contains(supportedScopes(), scope) || slices.Contains(granted, scope) {
+			continue
+		}
+		if admin || slices.Contains(userScopes, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted
+}
+
+func supportedScopes() []string {
+	return []string{string(auth.SCOPE_READ), string(auth.SCOPE_WRITE), string(auth.SCOPE_UPLOAD), string(auth.SCOPE_APPROVE), string(auth.SCOPE_ADMIN)}
+}
+
+// verifyCodeChallenge checks the PKCE verifier against the S256 challenge
+func verifyCodeChallenge(verifier string, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func randomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// hashToken keys codes and refresh tokens, a memory dump doesn't reveal usable values
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// redirect sends the user back to the client with the parameters, the state and the issuer (RFC 9207)
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, request authorizeRequest, parameters url.Values) {
+	target, err := url.Parse(request.RedirectURI)
+	if err != nil {
+		renderError(w, http.StatusBadRequest, "Invalid redirect URI")
+		return
+	}
+
+	query := target.Query()
+	for key, values := range parameters {
+		query[key] = values
+	}
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	query.Set("iss", s.publicURL)
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (s *Server) redirectError(w http.ResponseWriter, r *http.Request, request authorizeRequest, code string, description string) {
+	s.redirect(w, r, request, url.Values{"error": {code}, "error_description": {description}})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func registrationError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// cors lets browser based MCP clients reach the metadata, token and registration endpoints
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, MCP-Protocol-Version")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,359 @@
+package oauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testPublicURL   = "https://mcp.example.com"
+	testRedirectURI = "http://127.0.0.1:8765/callback"
+	testVerifier    = "dBjftJeZ4CVP-mJ92K9qgRkoQW1zZzBdz8lTmr6tmQ8abc"
+)
+
+func newTestServer(t *testing.T) (*Server, *http.ServeMux) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usersFile := filepath.Join(t.TempDir(), "users.yaml")
+	users := "users:\n" +
+		"  - username: alice\n    passwordHash: " + string(hash) + "\n    scopes: [read, write]\n" +
+		"  - username: bob\n    passwordHash: " + string(hash) + "\n    scopes: [read]\n    tools: [file_search_by_party_name]\n"
+	if err := os.WriteFile(usersFile, []byte(users), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewServer(config.OAuthConfig{
+		PublicURL:       testPublicURL + "/",
+		UsersFile:       usersFile,
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	server.Register(mux)
+	return server, mux
+}
+
+func serve(mux *http.ServeMux, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func registerClient(t *testing.T, mux *http.ServeMux) string {
+	body, _ := json.Marshal(map[string]any{
+		"client_name":   "Desktop",
+		"redirect_uris": []string{"http://127.0.0.1/callback"},
+	})
+	response := serve(mux, httptest.NewRequest(http.MethodPost, REGISTER_PATH, bytes.NewReader(body)))
+	if response.Code != http.StatusCreated {
+		t.Fatalf("register: status %d: %s", response.Code, response.Body)
+	}
+
+	var client struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&client); err != nil {
+		t.Fatal(err)
+	}
+	return client.ClientID
+}
+
+// signIn posts the sign in form and returns the redirect
+func signIn(t *testing.T, mux *http.ServeMux, clientID string, username string, password string, scope string) *url.URL {
+	response := postSignIn(mux, clientID, username, password, scope, "")
+	if response.Code != http.StatusFound {
+		return nil
+	}
+	location, err := url.Parse(response.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+// postSignIn posts the sign in form for a resource
+func postSignIn(mux *http.ServeMux, clientID string, username string, password string, scope string, resource string) *httptest.ResponseRecorder {
+	sum := sha256.Sum256([]byte(testVerifier))
+	form := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {testRedirectURI},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {CODE_CHALLENGE_METHOD},
+		"state":                 {"xyz"},
+		"scope":                 {scope},
+		"username":              {username},
+		"password":              {password},
+	}
+	if resource != "" {
+		form.Set("resource", resource)
+	}
+	request := httptest.NewRequest(http.MethodPost, AUTHORIZE_PATH, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return serve(mux, request)
+}
+
+func exchange(mux *http.ServeMux, form url.Values) (int, map[string]any) {
+	request := httptest.NewRequest(http.MethodPost, TOKEN_PATH, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := serve(mux, request)
+
+	var body map[string]any
+	json.NewDecoder(response.Body).Decode(&body)
+	return response.Code, body
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	server, mux := newTestServer(t)
+	clientID := registerClient(t, mux)
+
+	registry, err := auth.NewRegistry(config.MCPServerConfig{}, map[string][]auth.Scope{
+		"file_search_by_party_name": {auth.SCOPE_READ},
+		"file_document_save":        {auth.SCOPE_WRITE},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.AddVerifier(server, 0)
+
+	if location := signIn(t, mux, clientID, "alice", "wrong", ""); location != nil {
+		t.Fatalf("wrong password redirected to %s", location)
+	}
+
+	location := signIn(t, mux, clientID, "alice", "secret", "read upload")
+	if location == nil || location.Query().Get("state") != "xyz" || location.Query().Get("code") == "" {
+		t.Fatalf("unexpected redirect %v", location)
+	}
+	code := location.Query().Get("code")
+
+	tokenRequest := url.Values{
+		"grant_type":    {GRANT_AUTHORIZATION_CODE},
+		"client_id":     {clientID},
+		"redirect_uri":  {testRedirectURI},
+		"code":          {code},
+		"code_verifier": {testVerifier},
+	}
+	status, body := exchange(mux, tokenRequest)
+	if status != http.StatusOK {
+		t.Fatalf("token: status %d: %v", status, body)
+	}
+
+	// upload isn't granted to alice, only read remains
+	if body["scope"] != "read" {
+		t.Errorf("expected scope read, got %v", body["scope"])
+	}
+
+	token, err := registry.Authenticate(body["access_token"].(string))
+	if err != nil {
+		t.Fatalf("registry rejected the access token: %v", err)
+	}
+	if token.Identity != "alice" || !token.HasScope(auth.SCOPE_READ) || token.HasScope(auth.SCOPE_WRITE) {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	// Codes are single use
+	if status, _ := exchange(mux, tokenRequest); status != http.StatusBadRequest {
+		t.Errorf("reused code: expected status 400, got %d", status)
+	}
+
+	// Refresh tokens are rotated
+	refreshRequest := url.Values{
+		"grant_type":    {GRANT_REFRESH_TOKEN},
+		"client_id":     {clientID},
+		"refresh_token": {body["refresh_token"].(string)},
+	}
+	if status, refreshed := exchange(mux, refreshRequest); status != http.StatusOK || refreshed["access_token"] == "" {
+		t.Errorf("refresh: status %d: %v", status, refreshed)
+	}
+	if status, _ := exchange(mux, refreshRequest); status != http.StatusBadRequest {
+		t.Errorf("reused refresh token: expected status 400, got %d", status)
+	}
+}
+
+func TestTokenRejected(t *testing.T) {
+	_, mux := newTestServer(t)
+	clientID := registerClient(t, mux)
+	otherClientID := registerClient(t, mux)
+
+	tests := []struct {
+		name   string
+		modify func(url.Values)
+	}{
+		{"wrong verifier", func(form url.Values) { form.Set("code_verifier", strings.Repeat("x", 43)) }},
+		{"other redirect", func(form url.Values) { form.Set("redirect_uri", "http://127.0.0.1:9999/callback") }},
+		{"other client", func(form url.Values) { form.Set("client_id", otherClientID) }},
+		{"unknown code", func(form url.Values) { form.Set("code", "unknown") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location := signIn(t, mux, clientID, "bob", "secret", "")
+			if location == nil {
+				t.Fatal("sign in failed")
+			}
+
+			form := url.Values{
+				"grant_type":    {GRANT_AUTHORIZATION_CODE},
+				"client_id":     {clientID},
+				"redirect_uri":  {testRedirectURI},
+				"code":          {location.Query().Get("code")},
+				"code_verifier": {testVerifier},
+			}
+			tt.modify(form)
+
+			status, body := exchange(mux, form)
+			if status == http.StatusOK {
+				t.Fatalf("expected the exchange to fail, got %v", body)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRejectsUnregisteredRedirect(t *testing.T) {
+	_, mux := newTestServer(t)
+	clientID := registerClient(t, mux)
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {"https://attacker.example.com/callback"},
+		"code_challenge":        {"challenge"},
+		"code_challenge_method": {CODE_CHALLENGE_METHOD},
+	}
+	response := serve(mux, httptest.NewRequest(http.MethodGet, AUTHORIZE_PATH+"?"+query.Encode(), nil))
+	if response.Code != http.StatusBadRequest || response.Header().Get("Location") != "" {
+		t.Errorf("expected an error page, got status %d to %q", response.Code, response.Header().Get("Location"))
+	}
+}
+
+func TestAuthorizeResource(t *testing.T) {
+	_, mux := newTestServer(t)
+	clientID := registerClient(t, mux)
+
+	tests := []struct {
+		resource string
+		accepted bool
+	}{
+		{testPublicURL, true},
+		{testPublicURL + "/", true},
+		{testPublicURL + ".attacker.example.com", false},
+		{testPublicURL + "/other", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resource, func(t *testing.T) {
+			response := postSignIn(mux, clientID, "bob", "secret", "", tt.resource)
+			location, _ := url.Parse(response.Header().Get("Location"))
+			if accepted := location.Query().Get("code") != ""; accepted != tt.accepted {
+				t.Errorf("expected accepted %v, got status %d to %q", tt.accepted, response.Code, location)
+			}
+		})
+	}
+}
+
+func TestSignInThrottled(t *testing.T) {
+	server, mux := newTestServer(t)
+	clientID := registerClient(t, mux)
+
+	now := time.Now()
+	server.now = func() time.Time { return now }
+
+	for range MAX_FAILED_SIGN_INS_PER_USER {
+		if response := postSignIn(mux, clientID, "alice", "wrong", "", ""); response.Code != http.StatusUnauthorized {
+			t.Fatalf("expected a failed sign in, got status %d", response.Code)
+		}
+	}
+
+	// The right password isn't even checked until attempts are available again, other users aren't affected
+	if response := postSignIn(mux, clientID, "ALICE", "secret", "", ""); response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Errorf("expected the sign in to be throttled, got status %d", response.Code)
+	}
+	if location := signIn(t, mux, clientID, "bob", "secret", ""); location == nil {
+		t.Error("expected another user to sign in")
+	}
+
+	now = now.Add(SIGN_IN_PERIOD)
+	if location := signIn(t, mux, clientID, "alice", "secret", ""); location == nil {
+		t.Error("expected the sign in to succeed once the period passed")
+	}
+}
+
+func TestRegisterThrottled(t *testing.T) {
+	_, mux := newTestServer(t)
+
+	for range MAX_REGISTRATIONS_PER_IP {
+		registerClient(t, mux)
+	}
+
+	body := `{"redirect_uris": ["http://127.0.0.1/callback"]}`
+	response := serve(mux, httptest.NewRequest(http.MethodPost, REGISTER_PATH, strings.NewReader(body)))
+	if response.Code != http.StatusTooManyRequests {
+		t.Errorf("expected registrations to be throttled, got status %d", response.Code)
+	}
+}
+
+func TestClientsExpire(t *testing.T) {
+	clients, err := NewClients("", CLIENT_IDLE_TTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	var registered []Client
+	for range MAX_CLIENTS {
+		client, err := clients.Register("Desktop", []string{testRedirectURI}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registered = append(registered, client)
+		now = now.Add(time.Millisecond)
+	}
+	if err := clients.Use(registered[0].ID, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest client nobody signed in with makes room for a new one
+	if _, err := clients.Register("Desktop", []string{testRedirectURI}, now); err != nil {
+		t.Fatalf("expected an unused client to be evicted, got %v", err)
+	}
+	if _, ok := clients.Get(registered[0].ID, now); !ok {
+		t.Error("expected the used client to be kept")
+	}
+	if _, ok := clients.Get(registered[1].ID, now); ok {
+		t.Error("expected the oldest unused client to be evicted")
+	}
+
+	// Unused clients expire quickly, used ones once idle for long
+	if _, ok := clients.Get(registered[2].ID, now.Add(UNUSED_CLIENT_TTL+time.Second)); ok {
+		t.Error("expected the unused client to expire")
+	}
+	if _, ok := clients.Get(registered[0].ID, now.Add(UNUSED_CLIENT_TTL+time.Second)); !ok {
+		t.Error("expected the used client to be kept")
+	}
+	if _, ok := clients.Get(registered[0].ID, now.Add(CLIENT_IDLE_TTL+time.Second)); ok {
+		t.Error("expected the idle client to expire")
+	}
+}
@@ -0,0 +1,81 @@
+package oauth
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+)
+
+const (
+	// MAX_FAILED_SIGN_INS_PER_USER wrong passwords are accepted per username every SIGN_IN_PERIOD
+	MAX_FAILED_SIGN_INS_PER_USER = 5
+	// MAX_FAILED_SIGN_INS_PER_IP wrong passwords are accepted per client IP every SIGN_IN_PERIOD, across usernames
+	MAX_FAILED_SIGN_INS_PER_IP = 20
+	SIGN_IN_PERIOD             = 15 * time.Minute
+
+	// MAX_REGISTRATIONS_PER_IP clients can be registered per client IP every REGISTRATION_PERIOD
+	MAX_REGISTRATIONS_PER_IP = 10
+	REGISTRATION_PERIOD      = time.Hour
+)
+
+// throttle keeps a rate limit per key, such as a username or a client IP
+type throttle struct {
+	mu       sync.Mutex
+	limit    int
+	period   time.Duration
+	limiters map[string]*auth.Limiter
+}
+
+func newThrottle(limit int, period time.Duration) *throttle {
+	return &throttle{
+		limit:    limit,
+		period:   period,
+		limiters: make(map[string]*auth.Limiter),
+	}
+}
+
+// allow takes one attempt of the key, or returns how long until one is available
+func (t *throttle) allow(key string, now time.Time) (bool, time.Duration) {
+	return t.limiter(key).Allow(now)
+}
+
+// wait returns how long until the key has an attempt left, without taking it
+func (t *throttle) wait(key string, now time.Time) time.Duration {
+	return t.limiter(key).Wait(now)
+}
+
+func (t *throttle) limiter(key string) *auth.Limiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limiter, ok := t.limiters[key]
+	if !ok {
+		limiter = auth.NewLimiter(t.limit, t.period)
+		t.limiters[key] = limiter
+	}
+	return limiter
+}
+
+// sweep drops the keys whose limit refilled, they behave like new ones
+func (t *throttle) sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, limiter := range t.limiters {
+		if limiter.Full(now) {
+			delete(t.limiters, key)
+		}
+	}
+}
+
+// clientIP returns the address of the peer, forwarded headers are ignored as anyone can set them
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,107 @@
+package oauth
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// User is a staff member allowed to sign in, the scopes and tools bound the tokens issued to them
+type User struct {
+	Username     string   `yaml:"username"`
+	PasswordHash string   `yaml:"passwordHash"`
+	Scopes       []string `yaml:"scopes"`
+	Tools        []string `yaml:"tools,omitempty"`
+}
+
+// Users are the staff members of the users file, by username
+type Users struct {
+	users map[string]User
+
+	// dummyHash keeps the response time of unknown usernames close to wrong passwords
+	dummyHash []byte
+}
+
+// LoadUsers reads the users file
+func LoadUsers(path string) (*Users, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	var file struct {
+		Users []User `yaml:"users"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse users file %s: %w", path, err)
+	}
+
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("lynx-mcp-server"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	users := &Users{
+		users:     make(map[string]User),
+		dummyHash: dummyHash,
+	}
+
+	for i, user := range file.Users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("users file %s: user %d has no username", path, i)
+		}
+		if _, ok := users.users[user.Username]; ok {
+			return nil, fmt.Errorf("users file %s: user %s is listed twice", path, user.Username)
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("users file %s: user %s: passwordHash must be a bcrypt hash", path, user.Username)
+		}
+		for _, scope := range user.Scopes {
+			switch auth.Scope(scope) {
+			case auth.SCOPE_READ, auth.SCOPE_WRITE, auth.SCOPE_UPLOAD, auth.SCOPE_ADMIN, auth.SCOPE_METRICS, auth.SCOPE_APPROVE:
+			default:
+				return nil, fmt.Errorf("users file %s: user %s: unknown scope %q", path, user.Username, scope)
+			}
+		}
+		users.users[user.Username] = user
+	}
+
+	return users, nil
+}
+
+// Authenticate checks the password of a user
+func (u *Users) Authenticate(username string, password string) (User, bool) {
+	user, ok := u.users[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(u.dummyHash, []byte(password))
+		return User{}, false
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return User{}, false
+	}
+
+	return user, true
+}
+
+// Get returns a user, to refresh tokens with the current scopes of the user
+func (u *Users) Get(username string) (User, bool) {
+	user, ok := u.users[username]
+	return user, ok
+}
+
+// HashPassword returns the bcrypt hash to put in the users file
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,718 @@
+// Package outbox queues the write tool calls that failed because Lynx was unreachable and applies them once it is
+// back, so no document or attachment is lost while Lynx is down
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/idempotency"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/staging"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// STATUS_PENDING is an entry waiting for its next attempt
+	STATUS_PENDING = "pending"
+	// STATUS_RUNNING is the entry being applied
+	STATUS_RUNNING = "running"
+	// STATUS_FAILED is an entry Lynx refused or that ran out of attempts, it waits for outbox_retry or outbox_cancel
+	STATUS_FAILED = "failed"
+	// STATUS_QUEUED is returned to the calls whose write was queued
+	STATUS_QUEUED = "queued"
+
+	// IDLE_INTERVAL is how often the worker looks for due entries when nothing was queued or retried
+	IDLE_INTERVAL = time.Minute
+
+	// ATTACHMENT_ARGUMENT is the staged attachment of a call, the outbox keeps a copy since staged attachments expire
+	// and don't survive restarts
+	ATTACHMENT_ARGUMENT = "attachmentHandle"
+)
+
+var (
+	ErrNotFound = errors.New("unknown outbox entry")
+	ErrRunning  = errors.New("outbox entry is being applied")
+)
+
+var (
+	// entriesBucket holds the entries by ID, in the order they were queued
+	entriesBucket = []byte("entries")
+	// attachmentsBucket holds the content of the attachments of the entries by entry ID
+	attachmentsBucket = []byte("attachments")
+)
+
+// Entry is a queued write tool call, it runs as the caller who made it
+type Entry struct {
+	ID            string             `json:"id"`
+	Tool          string             `json:"tool"`
+	Arguments     map[string]any     `json:"arguments"`
+	Identity      string             `json:"identity,omitempty"`
+	TokenName     string             `json:"tokenName,omitempty"`
+	Attachment    *Attachment        `json:"attachment,omitempty"`
+	Idempotency   *idempotency.Claim `json:"idempotency,omitempty"`
+	Status        string             `json:"status"`
+	Attempts      int                `json:"attempts"`
+	LastError     string             `json:"lastError,omitempty"`
+	CreatedAt     time.Time          `json:"createdAt"`
+	NextAttemptAt time.Time          `json:"nextAttemptAt"`
+}
+
+// Attachment describes the copy of the staged attachment of an entry, it's staged again for each attempt
+type Attachment struct {
+	FileName    string `json:"fileName"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// Enqueue queues a call of one tool, for the REST endpoints doing what the tool does
+type Enqueue func(ctx context.Context, arguments map[string]any) (*Entry, error)
+
+// progress holds the arguments replaced by the steps of a call that changed Lynx, so a queued call doesn't make them
+// again
+type progress struct {
+	mu        sync.Mutex
+	arguments map[string]any
+}
+
+type contextKey string
+
+const progressContextKey = contextKey("progress")
+
+// Outbox keeps the queued calls in a bbolt file and applies them one at a time, with a backoff between attempts
+type Outbox struct {
+	outboxConfig config.OutboxConfig
+	serverConfig config.MCPServerConfig
+	lynxConfig   config.LynxServerConfig
+	accounts     *lynx.Accounts
+	attachments  *staging.Store
+	keys         *idempotency.Store
+	db           *bolt.DB
+
+	mu       sync.Mutex
+	handlers map[string]server.ToolHandlerFunc
+	running  string // ID of the entry being applied
+	wake     chan struct{}
+	stop     context.CancelFunc
+	done     chan struct{}
+}
+
+// New opens the outbox file of the configuration, queued calls run as the Lynx account of their caller within the
+// timeout and the retry policy of their tool. Their idempotency keys get their result from keys once they are applied.
+func New(outboxConfig config.OutboxConfig, serverConfig config.MCPServerConfig, lynxConfig config.LynxServerConfig, accounts *lynx.Accounts, attachments *staging.Store, keys *idempotency.Store) (*Outbox, error) {
+	db, err := bolt.Open(outboxConfig.File, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox file: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{entriesBucket, attachmentsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize outbox file: %w", err)
+	}
+
+	o := &Outbox{
+		outboxConfig: outboxConfig,
+		serverConfig: serverConfig,
+		lynxConfig:   lynxConfig,
+		accounts:     accounts,
+		attachments:  attachments,
+		keys:         keys,
+		db:           db,
+		handlers:     make(map[string]server.ToolHandlerFunc),
+		wake:         make(chan struct{}, 1),
+	}
+	o.report()
+	return o, nil
+}
+
+// Handle registers the handler applying the queued calls of a tool, and wraps it so calls failing because Lynx is
+// unreachable are queued instead
+func (o *Outbox) Handle(tool string, next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	o.mu.Lock()
+	o.handlers[tool] = next
+	o.mu.Unlock()
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		done := &progress{}
+		result, err := next(context.WithValue(ctx, progressContextKey, done), request)
+		if !Queueable(err) {
+			return result, err
+		}
+
+		entry, queueErr := o.Add(ctx, tool, done.apply(request.GetArguments()))
+		if queueErr != nil {
+			slog.ErrorContext(ctx, "Failed to queue write", "tool", tool, "error", queueErr)
+			return result, err
+		}
+		return utils.NewToolResultJSON(Queued(entry, err)), nil
+	}
+}
+
+// Enqueuer returns the function queueing calls of tool
+func (o *Outbox) Enqueuer(tool string) Enqueue {
+	return func(ctx context.Context, arguments map[string]any) (*Entry, error) {
+		return o.Add(ctx, tool, arguments)
+	}
+}
+
+// Done records that a step of a queued tool changed Lynx, the call is queued or attempted again with the arguments
+// replaced so the step isn't made twice, e.g. the attachmentHandle of an uploaded attachment by its attachmentUrl.
+// Nil values remove the argument. It does nothing outside of the outbox.
+func Done(ctx context.Context, arguments map[string]any) {
+	done, ok := ctx.Value(progressContextKey).(*progress)
+	if !ok {
+		return
+	}
+
+	done.mu.Lock()
+	defer done.mu.Unlock()
+	if done.arguments == nil {
+		done.arguments = make(map[string]any)
+	}
+	for name, value := range arguments {
+		done.arguments[name] = value
+	}
+}
+
+// Queues reports whether the call of ctx is queued when it fails with a Queueable error, it then resumes from the
+// steps recorded with Done. Tools reporting a partial failure as their result return the error instead.
+func Queues(ctx context.Context) bool {
+	_, ok := ctx.Value(progressContextKey).(*progress)
+	return ok
+}
+
+// apply returns a copy of arguments with the replacements of the finished steps
+func (p *progress) apply(arguments map[string]any) map[string]any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	replaced := make(map[string]any, len(arguments))
+	for name, value := range arguments {
+		replaced[name] = value
+	}
+	for name, value := range p.arguments {
+		if value == nil {
+			delete(replaced, name)
+		} else {
+			replaced[name] = value
+		}
+	}
+	return replaced
+}
+
+// Add queues a call of tool made by the caller of ctx, its staged attachment is copied into the outbox
+func (o *Outbox) Add(ctx context.Context, tool string, arguments map[string]any) (*Entry, error) {
+	now := time.Now()
+	entry := &Entry{
+		Tool:          tool,
+		Arguments:     arguments,
+		Identity:      auth.IdentityFromContext(ctx),
+		Status:        STATUS_PENDING,
+		CreatedAt:     now,
+		NextAttemptAt: now.Add(o.outboxConfig.InitialDelay),
+	}
+	if token, ok := auth.TokenFromContext(ctx); ok {
+		entry.TokenName = token.Name
+	}
+	if claim, ok := idempotency.ClaimFromContext(ctx); ok {
+		entry.Idempotency = &claim
+	}
+
+	var content []byte
+	if handle, _ := arguments[ATTACHMENT_ARGUMENT].(string); handle != "" {
+		staged, reader, err := o.attachments.Open(ctx, handle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open staged attachment: %w", err)
+		}
+		content, err = io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy staged attachment: %w", err)
+		}
+		entry.Attachment = &Attachment{
+			FileName:    staged.FileName,
+			ContentType: staged.ContentType,
+			Size:        staged.Size,
+			SHA256:      staged.SHA256,
+		}
+	}
+
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+		sequence, err := entries.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = strconv.FormatUint(sequence, 10)
+
+		if content != nil {
+			if err := tx.Bucket(attachmentsBucket).Put(entryKey(sequence), content); err != nil {
+				return err
+			}
+		}
+		return putEntry(tx, entry)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write outbox file: %w", err)
+	}
+
+	slog.WarnContext(ctx, "Queued write while Lynx is unreachable", "outboxId", entry.ID, "tool", tool)
+	o.report()
+	o.signal()
+	return entry, nil
+}
+
+// List returns the entries queued by identity, every entry when empty, in the order they were queued
+func (o *Outbox) List(identity string) ([]Entry, error) {
+	o.mu.Lock()
+	running := o.running
+	o.mu.Unlock()
+
+	entries := []Entry{}
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if identity != "" && entry.Identity != identity {
+				return nil
+			}
+			if entry.ID == running {
+				entry.Status = STATUS_RUNNING
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox file: %w", err)
+	}
+	return entries, nil
+}
+
+// Retry makes an entry queued by identity, any entry when empty, due now with a fresh set of attempts
+func (o *Outbox) Retry(id string, identity string) (*Entry, error) {
+	entry, err := o.update(id, identity, func(tx *bolt.Tx, entry *Entry) error {
+		entry.Status = STATUS_PENDING
+		entry.Attempts = 0
+		entry.NextAttemptAt = time.Now()
+		return putEntry(tx, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Outbox entry retried", "outboxId", id, "tool", entry.Tool)
+	o.report()
+	o.signal()
+	return entry, nil
+}
+
+// Cancel removes an entry queued by identity, any entry when empty, without applying it
+func (o *Outbox) Cancel(id string, identity string) (*Entry, error) {
+	entry, err := o.update(id, identity, func(tx *bolt.Tx, entry *Entry) error {
+		return deleteEntry(tx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The idempotency key of the call can be used again
+	if entry.Idempotency != nil {
+		o.keys.Forget(entry.Idempotency.Key)
+	}
+
+	slog.Info("Outbox entry canceled", "outboxId", id, "tool", entry.Tool)
+	o.report()
+	return entry, nil
+}
+
+// Start applies the due entries in the background until Close
+func (o *Outbox) Start() {
+	ctx, stop := context.WithCancel(context.Background())
+	o.stop = stop
+	o.done = make(chan struct{})
+
+	go func() {
+		defer close(o.done)
+
+		for {
+			next := o.applyDue(ctx)
+
+			wait := IDLE_INTERVAL
+			if !next.IsZero() {
+				wait = min(max(time.Until(next), 0), IDLE_INTERVAL)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-o.wake:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Close stops the worker, an entry being applied stays queued, and closes the outbox file
+func (o *Outbox) Close() error {
+	if o.stop != nil {
+		o.stop()
+		<-o.done
+	}
+	return o.db.Close()
+}
+
+// Queueable reports whether a write failed because Lynx didn't receive its request. Only the tools making a single
+// change are queued, or whose earlier changes are recorded with Done: the request that failed changed nothing.
+func Queueable(err error) bool {
+	return errors.Is(err, utils.ErrUnreachable)
+}
+
+// Queued describes a queued write to its caller
+func Queued(entry *Entry, err error) map[string]any {
+	return map[string]any{
+		"status":        STATUS_QUEUED,
+		"outboxId":      entry.ID,
+		"nextAttemptAt": entry.NextAttemptAt,
+		"error":         err.Error(),
+		"message":       "Lynx is unreachable, the change was queued and will be applied once Lynx is back. outbox_list shows whether it is still waiting.",
+	}
+}
+
+// applyDue applies the pending entries whose attempt is due, and returns when the next one is due
+func (o *Outbox) applyDue(ctx context.Context) time.Time {
+	for ctx.Err() == nil {
+		entry, next, err := o.nextDue(time.Now())
+		if err != nil {
+			slog.Error("Failed to read outbox file", "error", err)
+			return time.Time{}
+		}
+		if entry == nil {
+			return next
+		}
+		o.apply(ctx, entry)
+	}
+	return time.Time{}
+}
+
+// nextDue returns the first pending entry due at now, or when the next one is due
+func (o *Outbox) nextDue(now time.Time) (*Entry, time.Time, error) {
+	var due *Entry
+	var next time.Time
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil || entry.Status != STATUS_PENDING || due != nil {
+				return err
+			}
+			if !entry.NextAttemptAt.After(now) {
+				due = &entry
+			} else if next.IsZero() || entry.NextAttemptAt.Before(next) {
+				next = entry.NextAttemptAt
+			}
+			return nil
+		})
+	})
+	return due, next, err
+}
+
+// apply runs the call of an entry, it's removed once applied and otherwise waits for its next attempt, unless Lynx
+// refused it or it ran out of attempts
+func (o *Outbox) apply(ctx context.Context, entry *Entry) {
+	o.mu.Lock()
+	o.running = entry.ID
+	handler, ok := o.handlers[entry.Tool]
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		o.running = ""
+		o.mu.Unlock()
+	}()
+
+	// Canceled or retried since it was found due
+	entry, err := o.load(entry.ID)
+	if err != nil || entry.Status != STATUS_PENDING {
+		return
+	}
+
+	var applied *mcp.CallToolResult
+	err = fmt.Errorf("tool %s is not available", entry.Tool)
+	if ok {
+		applied, err = o.call(ctx, entry, handler)
+	}
+	// Shutting down, the entry is applied again after restart
+	if ctx.Err() != nil {
+		return
+	}
+
+	result := metrics.OUTBOX_APPLIED
+	update := func(tx *bolt.Tx) error { return deleteEntry(tx, entry.ID) }
+	if err != nil {
+		entry.Attempts++
+		entry.LastError = err.Error()
+		result = metrics.OUTBOX_REQUEUED
+		entry.NextAttemptAt = time.Now().Add(o.delay(entry.Attempts))
+		if !Queueable(err) || entry.Attempts >= o.outboxConfig.MaxAttempts {
+			result = metrics.OUTBOX_FAILED
+			entry.Status = STATUS_FAILED
+		}
+		update = func(tx *bolt.Tx) error { return putEntry(tx, entry) }
+	}
+	metrics.OutboxAttempts.WithLabelValues(entry.Tool, result).Inc()
+
+	if err := o.db.Update(update); err != nil {
+		slog.Error("Failed to write outbox file", "outboxId", entry.ID, "error", err)
+	}
+	o.report()
+
+	// Calls repeating the idempotency key get the result of the applied call instead of the queued one
+	if err == nil && applied != nil && entry.Idempotency != nil {
+		if stored, marshalErr := json.Marshal(applied); marshalErr == nil {
+			o.keys.Replace(entry.Idempotency.Key, entry.Idempotency.Fingerprint, stored)
+		}
+	}
+
+	switch result {
+	case metrics.OUTBOX_APPLIED:
+		slog.Info("Applied queued write", "outboxId", entry.ID, "tool", entry.Tool, "identity", entry.Identity)
+	case metrics.OUTBOX_REQUEUED:
+		slog.Warn("Queued write failed, retrying later", "outboxId", entry.ID, "tool", entry.Tool, "attempts", entry.Attempts, "nextAttemptAt", entry.NextAttemptAt, "error", err)
+	default:
+		slog.Error("Queued write failed, waiting for outbox_retry or outbox_cancel", "outboxId", entry.ID, "tool", entry.Tool, "attempts", entry.Attempts, "error", err)
+	}
+}
+
+// call runs the handler of an entry as its caller and under the retry policy of its tool, its attachment staged again
+func (o *Outbox) call(ctx context.Context, entry *Entry, handler server.ToolHandlerFunc) (*mcp.CallToolResult, error) {
+	ctx = auth.WithToken(ctx, &auth.Token{Name: entry.TokenName, Identity: entry.Identity})
+	credentials, ok, err := o.accounts.Credentials(entry.Identity)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		ctx = utils.WithCredentials(ctx, credentials)
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.serverConfig.Timeout(entry.Tool))
+	defer cancel()
+	if _, ok := o.lynxConfig.ToolRetry[entry.Tool]; ok {
+		ctx = utils.WithRetryConfig(ctx, o.lynxConfig.RetryFor(entry.Tool))
+	}
+
+	arguments := make(map[string]any, len(entry.Arguments))
+	for name, value := range entry.Arguments {
+		arguments[name] = value
+	}
+	if entry.Attachment != nil {
+		handle, err := o.stage(ctx, entry)
+		if err != nil {
+			return nil, err
+		}
+		defer o.attachments.Delete(ctx, handle)
+		arguments[ATTACHMENT_ARGUMENT] = handle
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = entry.Tool
+	request.Params.Arguments = arguments
+
+	done := &progress{}
+	result, err := handler(context.WithValue(ctx, progressContextKey, done), request)
+	if err == nil && result != nil && result.IsError {
+		err = errors.New(resultText(result))
+	}
+
+	// The next attempt doesn't make the steps of this one again, nor needs the attachment once it was uploaded
+	if err != nil {
+		entry.Arguments = done.apply(entry.Arguments)
+		if _, ok := entry.Arguments[ATTACHMENT_ARGUMENT]; !ok {
+			entry.Attachment = nil
+		}
+	}
+	return result, err
+}
+
+// stage stages the attachment of an entry from its copy and returns its handle
+func (o *Outbox) stage(ctx context.Context, entry *Entry) (string, error) {
+	id, err := strconv.ParseUint(entry.ID, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid outbox entry ID: %w", err)
+	}
+
+	var content []byte
+	err = o.db.View(func(tx *bolt.Tx) error {
+		content = bytes.Clone(tx.Bucket(attachmentsBucket).Get(entryKey(id)))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read outbox file: %w", err)
+	}
+
+	staged, err := o.attachments.Put(ctx, entry.Attachment.FileName, entry.Attachment.ContentType, bytes.NewReader(content), entry.Attachment.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage queued attachment: %w", err)
+	}
+	return staged.Handle, nil
+}
+
+func (o *Outbox) load(id string) (*Entry, error) {
+	sequence, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var entry Entry
+	err = o.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(entriesBucket).Get(entryKey(sequence))
+		if value == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(value, &entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// update changes the entry queued by identity, any entry when empty, unless it's being applied
+func (o *Outbox) update(id string, identity string, change func(tx *bolt.Tx, entry *Entry) error) (*Entry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if id == o.running {
+		return nil, ErrRunning
+	}
+
+	sequence, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	var entry Entry
+	err = o.db.Update(func(tx *bolt.Tx) error {
+		value := tx.Bucket(entriesBucket).Get(entryKey(sequence))
+		if value == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return err
+		}
+		if identity != "" && entry.Identity != identity {
+			return ErrNotFound
+		}
+		return change(tx, &entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// delay is how long an entry waits after its failed attempts, doubling up to the maximum delay
+func (o *Outbox) delay(attempts int) time.Duration {
+	delay := o.outboxConfig.InitialDelay
+	for i := 1; i < attempts && delay < o.outboxConfig.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, o.outboxConfig.MaxDelay)
+}
+
+// signal wakes the worker up, an entry may be due
+func (o *Outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// report counts the entries by status in the metrics
+func (o *Outbox) report() {
+	counts := map[string]int{STATUS_PENDING: 0, STATUS_FAILED: 0}
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err == nil {
+				counts[entry.Status]++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return
+	}
+	for status, count := range counts {
+		metrics.OutboxEntries.WithLabelValues(status).Set(float64(count))
+	}
+}
+
+func putEntry(tx *bolt.Tx, entry *Entry) error {
+	sequence, err := strconv.ParseUint(entry.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if entry.Attachment == nil {
+		if err := tx.Bucket(attachmentsBucket).Delete(entryKey(sequence)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(entriesBucket).Put(entryKey(sequence), value)
+}
+
+func deleteEntry(tx *bolt.Tx, id string) error {
+	sequence, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(attachmentsBucket).Delete(entryKey(sequence)); err != nil {
+		return err
+	}
+	return tx.Bucket(entriesBucket).Delete(entryKey(sequence))
+}
+
+// entryKey keeps the entries in the order they were queued
+func entryKey(sequence uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, sequence)
+}
+
+// resultText joins the text content of an error result
+func resultText(result *mcp.CallToolResult) string {
+	var text string
+	for _, content := range result.Content {
+		if textContent, ok := content.(mcp.TextContent); ok {
+			text += textContent.Text
+		}
+	}
+	return text
+}
@@ -0,0 +1,260 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/idempotency"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/staging"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func newOutbox(t *testing.T) (*Outbox, *staging.Store) {
+	stagingConfig := config.DefaultStagingConfig()
+	stagingConfig.Directory = t.TempDir()
+	attachments, err := staging.NewStore(stagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := idempotency.NewStore(config.IdempotencyConfig{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outboxConfig := config.OutboxConfig{
+		File:         filepath.Join(t.TempDir(), "outbox.db"),
+		MaxAttempts:  3,
+		InitialDelay: time.Nanosecond,
+		MaxDelay:     time.Nanosecond,
+	}
+	o, err := New(outboxConfig, config.DefaultMCPServerConfig(), config.LynxServerConfig{}, lynx.NewAccounts(config.LynxServerConfig{}), attachments, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Close() })
+	return o, attachments
+}
+
+func TestOutbox(t *testing.T) {
+	o, attachments := newOutbox(t)
+	ctx := context.Background()
+
+	// Lynx is down for the call and the first attempt, the staged attachment is gone by then
+	failures := []error{
+		fmt.Errorf("%w: dial tcp: connection refused", utils.ErrUnreachable),
+		fmt.Errorf("%w: 503 Service Unavailable", utils.ErrUnreachable),
+	}
+	var applied []string
+	handler := o.Handle("file_document_save", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if len(failures) > 0 {
+			err := failures[0]
+			failures = failures[1:]
+			return nil, err
+		}
+		_, content, err := attachments.Open(ctx, request.GetArguments()[ATTACHMENT_ARGUMENT].(string))
+		if err != nil {
+			return nil, err
+		}
+		defer content.Close()
+		data, _ := io.ReadAll(content)
+		applied = append(applied, string(data))
+		return mcp.NewToolResultText("saved"), nil
+	})
+
+	staged, err := attachments.Put(ctx, "voucher.pdf", "application/pdf", strings.NewReader("voucher"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "file_document_save"
+	request.Params.Arguments = map[string]any{"fileIdentifier": "1", ATTACHMENT_ARGUMENT: staged.Handle}
+
+	result, err := handler(ctx, request)
+	if err != nil || result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, STATUS_QUEUED) {
+		t.Fatalf("handler() = %v, %v, want the write queued", result, err)
+	}
+	attachments.Delete(ctx, staged.Handle)
+
+	// Retried at once after the failed attempt, the delays are a nanosecond
+	o.applyDue(ctx)
+	entries, _ := o.List("")
+	if len(entries) != 0 || len(failures) != 0 || len(applied) != 1 || applied[0] != "voucher" {
+		t.Errorf("List() once applied = %+v with %v applied, want no entry and the attachment applied", entries, applied)
+	}
+}
+
+func TestOutboxFailed(t *testing.T) {
+	o, _ := newOutbox(t)
+	ctx := context.Background()
+
+	refused := true
+	o.Handle("transaction_document_save", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if refused {
+			return nil, errors.New("Lynx exception: transaction locked")
+		}
+		return mcp.NewToolResultText("saved"), nil
+	})
+
+	entry, err := o.Add(ctx, "transaction_document_save", map[string]any{"transactionIdentifier": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Refused by Lynx, it waits for a person
+	o.applyDue(ctx)
+	if entries, _ := o.List(""); len(entries) != 1 || entries[0].Status != STATUS_FAILED {
+		t.Fatalf("List() after a refusal = %+v, want 1 failed entry", entries)
+	}
+
+	if _, err := o.Retry(entry.ID, "someone else"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Retry() of another caller's entry = %v, want %v", err, ErrNotFound)
+	}
+	refused = false
+	if _, err := o.Retry(entry.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	o.applyDue(ctx)
+	if entries, _ := o.List(""); len(entries) != 0 {
+		t.Errorf("List() after retry = %+v, want no entry", entries)
+	}
+
+	entry, _ = o.Add(ctx, "transaction_document_save", map[string]any{"transactionIdentifier": "8"})
+	if _, err := o.Cancel(entry.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Cancel(entry.ID, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel() twice = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestOutboxDone(t *testing.T) {
+	o, attachments := newOutbox(t)
+	ctx := context.Background()
+
+	// The attachment is uploaded before Lynx goes down, the save is applied with its URL
+	uploads := 0
+	var saved []string
+	handler := o.Handle("file_document_save", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		arguments := request.GetArguments()
+		if _, ok := arguments[ATTACHMENT_ARGUMENT]; ok {
+			uploads++
+			Done(ctx, map[string]any{ATTACHMENT_ARGUMENT: nil, "attachmentUrl": "/documents/file/f1/d1.pdf"})
+			return nil, fmt.Errorf("%w: dial tcp: connection refused", utils.ErrUnreachable)
+		}
+		saved = append(saved, arguments["attachmentUrl"].(string))
+		return mcp.NewToolResultText("saved"), nil
+	})
+
+	staged, err := attachments.Put(ctx, "voucher.pdf", "application/pdf", strings.NewReader("voucher"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "file_document_save"
+	request.Params.Arguments = map[string]any{"fileIdentifier": "1", ATTACHMENT_ARGUMENT: staged.Handle}
+
+	if _, err := handler(ctx, request); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := o.List("")
+	if len(entries) != 1 || entries[0].Attachment != nil || entries[0].Arguments["attachmentUrl"] != "/documents/file/f1/d1.pdf" {
+		t.Fatalf("List() = %+v, want the save queued with the uploaded attachmentUrl", entries)
+	}
+
+	o.applyDue(ctx)
+	if uploads != 1 || len(saved) != 1 {
+		t.Errorf("applied with %d uploads and saves %v, want 1 upload and 1 save", uploads, saved)
+	}
+}
+
+func TestOutboxIdempotencyKey(t *testing.T) {
+	o, _ := newOutbox(t)
+	claim := idempotency.Claim{Key: "save", Fingerprint: "a"}
+	ctx := idempotency.WithClaim(context.Background(), claim)
+
+	down := true
+	handler := o.Handle("transaction_document_save", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if down {
+			return nil, fmt.Errorf("%w: dial tcp: connection refused", utils.ErrUnreachable)
+		}
+		return mcp.NewToolResultText("saved"), nil
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "transaction_document_save"
+	request.Params.Arguments = map[string]any{"transactionIdentifier": "7"}
+
+	// The key holds the queued result until the call is applied
+	o.keys.Begin(ctx, claim.Key, claim.Fingerprint)
+	queued, err := handler(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(queued)
+	o.keys.Finish(claim.Key, claim.Fingerprint, encoded)
+
+	down = false
+	o.applyDue(ctx)
+	record, err := o.keys.Begin(ctx, claim.Key, claim.Fingerprint)
+	if err != nil || record == nil || !strings.Contains(string(record.Result), "saved") {
+		t.Fatalf("Begin() once applied = %v, %v, want the result of the applied call", record, err)
+	}
+
+	// Canceling a queued call frees its key
+	down = true
+	entry, err := o.Add(idempotency.WithClaim(context.Background(), idempotency.Claim{Key: "note", Fingerprint: "a"}), "transaction_document_save", request.GetArguments())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.keys.Finish("note", "a", encoded)
+	if _, err := o.Cancel(entry.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := o.keys.Begin(ctx, "note", "b"); record != nil || err != nil {
+		t.Errorf("Begin() after cancel = %v, %v, want the key free", record, err)
+	}
+}
+
+func TestOutboxToolRetry(t *testing.T) {
+	o, _ := newOutbox(t)
+	o.lynxConfig.Retry = config.DefaultRetryConfig()
+	o.lynxConfig.ToolRetry = map[string]config.RetryConfig{"file_document_save": {MaxAttempts: 1}}
+	o.outboxConfig.MaxAttempts = 1
+	ctx := context.Background()
+
+	var requests atomic.Int32
+	lynx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer lynx.Close()
+
+	// The queued call follows lynx.toolRetry, not the policy its handler gives
+	o.Handle("file_document_save", func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, lynx.URL+"/lynx/service/file.rpc", nil)
+		_, _, err := utils.RetryHTTPRequest(ctx, utils.NewHTTPClient(), req, utils.DefaultRetryConfig())
+		return nil, err
+	})
+	if _, err := o.Add(ctx, "file_document_save", map[string]any{"fileIdentifier": "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	o.applyDue(ctx)
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Lynx got %d requests, want 1", got)
+	}
+}
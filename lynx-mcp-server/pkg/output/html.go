@@ -0,0 +1,98 @@
+package output
+
+import (
+	"html"
+	"strings"
+)
+
+// blockTags are HTML elements rendered on their own line once converted to text
+var blockTags = map[string]bool{
+	"br": true, "p": true, "div": true, "li": true, "tr": true, "table": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "hr": true,
+}
+
+// HTMLToText converts document HTML content into readable plain text
+func HTMLToText(content string) string {
+	var text strings.Builder
+	skipping := ""
+
+	for i := 0; i < len(content); {
+		if content[i] != '<' {
+			end := strings.IndexByte(content[i:], '<')
+			if end < 0 {
+				end = len(content) - i
+			}
+			if skipping == "" {
+				text.WriteString(content[i : i+end])
+			}
+			i += end
+			continue
+		}
+
+		end := strings.IndexByte(content[i:], '>')
+		if end < 0 {
+			// Unterminated tag, keep the remainder as text
+			if skipping == "" {
+				text.WriteString(content[i:])
+			}
+			break
+		}
+
+		tagName, closing := parseTagName(content[i+1 : i+end])
+		i += end + 1
+
+		if skipping != "" {
+			if closing && tagName == skipping {
+				skipping = ""
+			}
+			continue
+		}
+
+		switch {
+		case !closing && (tagName == "script" || tagName == "style"):
+			skipping = tagName
+		case tagName == "td" || tagName == "th":
+			if closing {
+				text.WriteString(" ")
+			}
+		case blockTags[tagName]:
+			if !closing && tagName == "li" {
+				text.WriteString("\n- ")
+			} else {
+				text.WriteString("\n")
+			}
+		}
+	}
+
+	return normalizeWhitespace(html.UnescapeString(text.String()))
+}
+
+// parseTagName returns the lower case name of a tag and whether it is a closing tag
+func parseTagName(tag string) (string, bool) {
+	tag = strings.TrimSpace(tag)
+	closing := strings.HasPrefix(tag, "/")
+	tag = strings.TrimPrefix(tag, "/")
+
+	end := strings.IndexAny(tag, " \t\n\r/")
+	if end >= 0 {
+		tag = tag[:end]
+	}
+
+	return strings.ToLower(tag), closing
+}
+
+// normalizeWhitespace collapses spaces within lines and drops empty lines
+func normalizeWhitespace(text string) string {
+	text = strings.ReplaceAll(text, "\u00a0", " ")
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
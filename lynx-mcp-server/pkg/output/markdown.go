@@ -0,0 +1,119 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+)
+
+// writeMarkdown renders objects as bold key/value lines and arrays of objects as tables
+func writeMarkdown(builder *strings.Builder, value interface{}, depth int) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case *orderedMap:
+		for _, key := range v.keys {
+			child := v.values[key]
+			switch childValue := child.(type) {
+			case *orderedMap:
+				writeHeading(builder, key, depth)
+				writeMarkdown(builder, childValue, depth+1)
+			case []interface{}:
+				if isObjectArray(childValue) {
+					writeHeading(builder, key, depth)
+					writeMarkdown(builder, childValue, depth+1)
+				} else {
+					fmt.Fprintf(builder, "**%s:** %s\n", key, joinScalars(childValue))
+				}
+			default:
+				fmt.Fprintf(builder, "**%s:** %s\n", key, markdownScalar(child))
+			}
+		}
+	case []interface{}:
+		if isObjectArray(v) {
+			writeTable(builder, v)
+		} else {
+			for _, item := range v {
+				fmt.Fprintf(builder, "- %s\n", markdownScalar(item))
+			}
+		}
+	default:
+		fmt.Fprintf(builder, "%s\n", markdownScalar(v))
+	}
+}
+
+func writeHeading(builder *strings.Builder, title string, depth int) {
+	level := depth + 2
+	if level > 6 {
+		level = 6
+	}
+	fmt.Fprintf(builder, "\n%s %s\n\n", strings.Repeat("#", level), title)
+}
+
+// writeTable renders an array of objects, columns are the union of keys in order of appearance
+func writeTable(builder *strings.Builder, rows []interface{}) {
+	var columns []string
+	seen := make(map[string]bool)
+
+	for _, row := range rows {
+		for _, key := range row.(*orderedMap).keys {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+
+	builder.WriteString("| " + strings.Join(columns, " | ") + " |\n")
+	builder.WriteString("|" + strings.Repeat(" --- |", len(columns)) + "\n")
+
+	for _, row := range rows {
+		values := row.(*orderedMap).values
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			cells[i] = tableCell(values[column])
+		}
+		builder.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+}
+
+func isObjectArray(values []interface{}) bool {
+	if len(values) == 0 {
+		return false
+	}
+	for _, value := range values {
+		if _, ok := value.(*orderedMap); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func joinScalars(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = markdownScalar(value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func markdownScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case *orderedMap, []interface{}:
+		jsonBytes, err := toJSON(v, false)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return jsonBytes
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func tableCell(value interface{}) string {
+	cell := markdownScalar(value)
+	cell = strings.ReplaceAll(cell, "|", "\\|")
+	cell = strings.ReplaceAll(cell, "\n", "<br>")
+	return cell
+}
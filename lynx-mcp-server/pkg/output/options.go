@@ -0,0 +1,252 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	FORMAT_JSON     = "json"
+	FORMAT_COMPACT  = "compact"
+	FORMAT_MARKDOWN = "markdown"
+
+	CONTENT_FORMAT_HTML = "html"
+	CONTENT_FORMAT_TEXT = "text"
+
+	CURSOR_PREFIX = "v2"
+	// CURSOR_ARGUMENT is left out of the arguments a cursor is bound to, with the arguments that don't change the
+	// result
+	CURSOR_ARGUMENT = "cursor"
+
+	OUTPUT_PROPERTIES_SCHEMA = `{
+		"format": {
+			"type": "string",
+			"enum": ["json", "compact", "markdown"],
+			"description": "Output format: full json (default), compact json without empty fields, or markdown"
+		},
+		"fields": {
+			"type": "array",
+			"items": {
+				"type": "string"
+			},
+			"description": "Only return these fields, nested fields use dots (e.g. itineraries.supplier)"
+		},
+		"contentFormat": {
+			"type": "string",
+			"enum": ["html", "text"],
+			"description": "Document content as raw html or converted to plain text (default: html for json, text otherwise)"
+		},
+		"maxContentLength": {
+			"type": "integer",
+			"description": "Maximum number of characters of document content returned, longer contents come with a continuation cursor"
+		},
+		"cursor": {
+			"type": "string",
+			"description": "Continuation cursor returned by a previous truncated call with the same arguments"
+		}
+	}`
+)
+
+// ErrCursorMismatch is returned for a cursor used with other arguments than the call that returned it
+var ErrCursorMismatch = errors.New("cursor was returned for other arguments, call again with the same arguments or without cursor")
+
+// cursorIgnoredArguments don't change the result of a call, a cursor stays valid when they change
+var cursorIgnoredArguments = []string{CURSOR_ARGUMENT, "fresh", "idempotencyKey"}
+
+// Options controls how a tool result is rendered for the model.
+// Offset is the number of content characters returned by the previous pages, Key identifies the arguments of the
+// call and ContentHash the contents the cursor was returned for.
+type Options struct {
+	Format           string
+	Fields           []string
+	ContentFormat    string
+	MaxContentLength int
+	Offset           int
+	Key              string
+	ContentHash      string
+}
+
+// ParseOptions reads the common output arguments of a tool call
+func ParseOptions(arguments map[string]interface{}) (Options, error) {
+	options := Options{
+		Format: FORMAT_JSON,
+	}
+
+	if format, ok := arguments["format"].(string); ok && format != "" {
+		switch format {
+		case FORMAT_JSON, FORMAT_COMPACT, FORMAT_MARKDOWN:
+			options.Format = format
+		default:
+			return options, fmt.Errorf("invalid format argument: %v", format)
+		}
+	}
+
+	options.ContentFormat = CONTENT_FORMAT_TEXT
+	if options.Format == FORMAT_JSON {
+		options.ContentFormat = CONTENT_FORMAT_HTML
+	}
+
+	if contentFormat, ok := arguments["contentFormat"].(string); ok && contentFormat != "" {
+		switch contentFormat {
+		case CONTENT_FORMAT_HTML, CONTENT_FORMAT_TEXT:
+			options.ContentFormat = contentFormat
+		default:
+			return options, fmt.Errorf("invalid contentFormat argument: %v", contentFormat)
+		}
+	}
+
+	switch fields := arguments["fields"].(type) {
+	case nil:
+	case []interface{}:
+		for _, field := range fields {
+			fieldName, ok := field.(string)
+			if !ok {
+				return options, fmt.Errorf("invalid fields argument: %v", arguments["fields"])
+			}
+			options.Fields = append(options.Fields, strings.TrimSpace(fieldName))
+		}
+	case string:
+		// Comma separated list, as sent by the command line client
+		for _, fieldName := range strings.Split(fields, ",") {
+			if fieldName = strings.TrimSpace(fieldName); fieldName != "" {
+				options.Fields = append(options.Fields, fieldName)
+			}
+		}
+	default:
+		return options, fmt.Errorf("invalid fields argument: %v", arguments["fields"])
+	}
+
+	maxContentLength, err := parseInt(arguments["maxContentLength"])
+	if err != nil || maxContentLength < 0 {
+		return options, fmt.Errorf("invalid maxContentLength argument: %v", arguments["maxContentLength"])
+	}
+	options.MaxContentLength = maxContentLength
+
+	options.Key, err = argumentsKey(arguments)
+	if err != nil {
+		return options, err
+	}
+
+	if cursor, ok := arguments[CURSOR_ARGUMENT].(string); ok && cursor != "" {
+		position, err := decodeCursor(cursor)
+		if err != nil {
+			return options, fmt.Errorf("invalid cursor argument: %w", err)
+		}
+		if position.key != options.Key {
+			return options, fmt.Errorf("invalid cursor argument: %w", ErrCursorMismatch)
+		}
+		options.Offset = position.offset
+		options.ContentHash = position.contentHash
+	}
+
+	return options, nil
+}
+
+// WithOutputProperties adds the common output arguments to a tool input schema
+func WithOutputProperties(schema json.RawMessage) json.RawMessage {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(schema, &decoded); err != nil {
+		return schema
+	}
+
+	var outputProperties map[string]interface{}
+	if err := json.Unmarshal([]byte(OUTPUT_PROPERTIES_SCHEMA), &outputProperties); err != nil {
+		return schema
+	}
+
+	properties, ok := decoded["properties"].(map[string]interface{})
+	if !ok {
+		properties = make(map[string]interface{})
+		decoded["properties"] = properties
+	}
+
+	for name, property := range outputProperties {
+		if _, exists := properties[name]; !exists {
+			properties[name] = property
+		}
+	}
+
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		return schema
+	}
+
+	return json.RawMessage(encoded)
+}
+
+func parseInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return int(v), nil
+	case int:
+		return v, nil
+	case string:
+		if v == "" {
+			return 0, nil
+		}
+		return strconv.Atoi(v)
+	default:
+		return 0, fmt.Errorf("unexpected type %T", value)
+	}
+}
+
+// argumentsKey identifies the arguments of a call but the ones which don't change its result
+func argumentsKey(arguments map[string]interface{}) (string, error) {
+	keyed := make(map[string]interface{}, len(arguments))
+	for name, value := range arguments {
+		if !slices.Contains(cursorIgnoredArguments, name) {
+			keyed[name] = value
+		}
+	}
+	// Map keys are marshalled sorted, the same arguments give the same key
+	encoded, err := json.Marshal(keyed)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode arguments: %w", err)
+	}
+	return shortHash(encoded), nil
+}
+
+// cursor is the position of the next page of a call
+type cursor struct {
+	key         string
+	contentHash string
+	offset      int
+}
+
+func encodeCursor(position cursor) string {
+	encoded := strings.Join([]string{CURSOR_PREFIX, position.key, position.contentHash, strconv.Itoa(position.offset)}, ":")
+	return base64.RawURLEncoding.EncodeToString([]byte(encoded))
+}
+
+func decodeCursor(encoded string) (cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor{}, err
+	}
+
+	parts := strings.Split(string(decoded), ":")
+	if len(parts) != 4 || parts[0] != CURSOR_PREFIX {
+		return cursor{}, fmt.Errorf("unexpected cursor: %s", encoded)
+	}
+
+	offset, err := strconv.Atoi(parts[3])
+	if err != nil || offset < 0 {
+		return cursor{}, fmt.Errorf("unexpected cursor: %s", encoded)
+	}
+
+	return cursor{key: parts[1], contentHash: parts[2], offset: offset}, nil
+}
+
+func shortHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
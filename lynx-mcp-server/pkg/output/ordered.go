@@ -0,0 +1,142 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// orderedMap is a decoded JSON object that keeps the field order of the original struct
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{
+		values: make(map[string]interface{}),
+	}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, exists := m.values[key]; !exists {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := encodeJSON(&buffer, m, true); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// encodeJSON writes an ordered value as compact JSON, escaping HTML characters only when asked to
+func encodeJSON(buffer *bytes.Buffer, value interface{}, escapeHTML bool) error {
+	switch v := value.(type) {
+	case *orderedMap:
+		buffer.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := encodeJSON(buffer, key, escapeHTML); err != nil {
+				return err
+			}
+			buffer.WriteByte(':')
+			if err := encodeJSON(buffer, v.values[key], escapeHTML); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte('}')
+	case []interface{}:
+		buffer.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buffer.WriteByte(',')
+			}
+			if err := encodeJSON(buffer, item, escapeHTML); err != nil {
+				return err
+			}
+		}
+		buffer.WriteByte(']')
+	default:
+		var scalar bytes.Buffer
+		encoder := json.NewEncoder(&scalar)
+		encoder.SetEscapeHTML(escapeHTML)
+		if err := encoder.Encode(v); err != nil {
+			return err
+		}
+		// Encode terminates each value with a newline
+		buffer.Write(bytes.TrimSuffix(scalar.Bytes(), []byte("\n")))
+	}
+	return nil
+}
+
+// toOrdered converts any JSON serialisable value into orderedMap, []interface{} and scalar values
+func toOrdered(data interface{}) (interface{}, error) {
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+
+	return decodeOrderedValue(decoder)
+}
+
+func decodeOrderedValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch delim := token.(type) {
+	case json.Delim:
+		switch delim {
+		case '{':
+			object := newOrderedMap()
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, ok := keyToken.(string)
+				if !ok {
+					return nil, fmt.Errorf("unexpected object key %v", keyToken)
+				}
+				value, err := decodeOrderedValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				object.set(key, value)
+			}
+			// consume closing brace
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return object, nil
+		case '[':
+			array := make([]interface{}, 0)
+			for decoder.More() {
+				value, err := decodeOrderedValue(decoder)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			}
+			// consume closing bracket
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+			return array, nil
+		default:
+			return nil, fmt.Errorf("unexpected delimiter %v", delim)
+		}
+	default:
+		return token, nil
+	}
+}
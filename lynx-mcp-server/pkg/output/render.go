@@ -0,0 +1,286 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	CONTENT_FIELD = "content"
+)
+
+// NewToolResult renders a tool result according to the requested output options. maxContentLength bounds the
+// characters of the document contents, the rest of the result is always whole so that it stays valid.
+func NewToolResult(data interface{}, options Options) *mcp.CallToolResult {
+	value, err := prepare(data, options)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to render response: %v", err))
+	}
+
+	var continuation []byte
+	if options.MaxContentLength > 0 {
+		var contents []string
+		collectContent(value, &contents)
+		total := 0
+		for _, content := range contents {
+			total += len([]rune(content))
+		}
+		contentHash := shortHash([]byte(strings.Join(contents, "\x00")))
+
+		// Pages are cut from the contents of each call, they must not have changed since the previous page
+		if options.ContentHash != "" && options.ContentHash != contentHash {
+			return mcp.NewToolResultError("the result changed since the previous page, call again without cursor")
+		}
+		if options.Offset > total {
+			return mcp.NewToolResultError(fmt.Sprintf("cursor is past the end of the content (%d characters)", total))
+		}
+
+		end := min(options.Offset+options.MaxContentLength, total)
+		position := 0
+		value = cutContent(value, options.Offset, end, &position)
+
+		// Truncated content, the continuation is fetched by calling the tool again with the cursor
+		if end < total {
+			continuation, _ = json.Marshal(map[string]interface{}{
+				"truncated":       true,
+				"returnedLength":  end - options.Offset,
+				"remainingLength": total - end,
+				"nextCursor":      encodeCursor(cursor{key: options.Key, contentHash: contentHash, offset: end}),
+			})
+		}
+	}
+
+	text, err := format(value, options)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to render response: %v", err))
+	}
+
+	result := &mcp.CallToolResult{}
+	result.Content = append(result.Content, mcp.TextContent{
+		Type: "text",
+		Text: text,
+	})
+	if continuation != nil {
+		result.Content = append(result.Content, mcp.TextContent{
+			Type: "text",
+			Text: string(continuation),
+		})
+	}
+
+	return result
+}
+
+// Render converts the data into text according to the format, projection and content options
+func Render(data interface{}, options Options) (string, error) {
+	value, err := prepare(data, options)
+	if err != nil {
+		return "", err
+	}
+	return format(value, options)
+}
+
+// prepare applies the content and projection options to the data
+func prepare(data interface{}, options Options) (interface{}, error) {
+	value, err := toOrdered(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.ContentFormat == CONTENT_FORMAT_TEXT {
+		value = convertContent(value)
+	}
+
+	if len(options.Fields) > 0 {
+		value = project(value, splitFields(options.Fields))
+	}
+
+	return value, nil
+}
+
+// format renders the prepared data in the requested format
+func format(value interface{}, options Options) (string, error) {
+	switch options.Format {
+	case FORMAT_JSON:
+		return toJSON(value, true)
+	case FORMAT_COMPACT:
+		value = prune(value)
+		if value == nil {
+			return "{}", nil
+		}
+		return toJSON(value, false)
+	case FORMAT_MARKDOWN:
+		var builder strings.Builder
+		writeMarkdown(&builder, prune(value), 0)
+		return strings.TrimSpace(builder.String()), nil
+	default:
+		return "", fmt.Errorf("unsupported format: %s", options.Format)
+	}
+}
+
+// collectContent lists the document contents in the order they are rendered
+func collectContent(value interface{}, contents *[]string) {
+	switch v := value.(type) {
+	case *orderedMap:
+		for _, key := range v.keys {
+			if content, ok := v.values[key].(string); ok && key == CONTENT_FIELD {
+				*contents = append(*contents, content)
+			} else {
+				collectContent(v.values[key], contents)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectContent(item, contents)
+		}
+	}
+}
+
+// cutContent keeps the characters from start to end of the document contents taken one after the other, position
+// counts the characters of the contents already walked
+func cutContent(value interface{}, start int, end int, position *int) interface{} {
+	switch v := value.(type) {
+	case *orderedMap:
+		for _, key := range v.keys {
+			if content, ok := v.values[key].(string); ok && key == CONTENT_FIELD {
+				runes := []rune(content)
+				from := min(max(start-*position, 0), len(runes))
+				to := min(max(end-*position, from), len(runes))
+				*position += len(runes)
+				v.values[key] = string(runes[from:to])
+			} else {
+				v.values[key] = cutContent(v.values[key], start, end, position)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = cutContent(v[i], start, end, position)
+		}
+	}
+	return value
+}
+
+// convertContent replaces HTML document content with plain text
+func convertContent(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *orderedMap:
+		for _, key := range v.keys {
+			if content, ok := v.values[key].(string); ok && key == CONTENT_FIELD {
+				v.values[key] = HTMLToText(content)
+			} else {
+				v.values[key] = convertContent(v.values[key])
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = convertContent(v[i])
+		}
+	}
+	return value
+}
+
+// splitFields turns dotted field paths into a tree of wanted fields
+func splitFields(fields []string) map[string]interface{} {
+	tree := make(map[string]interface{})
+
+	for _, field := range fields {
+		node := tree
+		parts := strings.Split(field, ".")
+		for i, part := range parts {
+			child, exists := node[part]
+			if i == len(parts)-1 {
+				// Whole subtree requested
+				node[part] = nil
+				break
+			}
+			childNode, ok := child.(map[string]interface{})
+			if !ok {
+				if exists && child == nil {
+					// Already requested as a whole
+					break
+				}
+				childNode = make(map[string]interface{})
+				node[part] = childNode
+			}
+			node = childNode
+		}
+	}
+
+	return tree
+}
+
+// project keeps only the requested fields, arrays are projected element by element
+func project(value interface{}, tree map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case *orderedMap:
+		projected := newOrderedMap()
+		for _, key := range v.keys {
+			subtree, wanted := tree[key]
+			if !wanted {
+				continue
+			}
+			if subtreeMap, ok := subtree.(map[string]interface{}); ok {
+				projected.set(key, project(v.values[key], subtreeMap))
+			} else {
+				projected.set(key, v.values[key])
+			}
+		}
+		return projected
+	case []interface{}:
+		projected := make([]interface{}, len(v))
+		for i := range v {
+			projected[i] = project(v[i], tree)
+		}
+		return projected
+	default:
+		return value
+	}
+}
+
+// prune removes empty strings, nulls, empty arrays and empty objects
+func prune(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		if v == "" {
+			return nil
+		}
+		return v
+	case *orderedMap:
+		pruned := newOrderedMap()
+		for _, key := range v.keys {
+			if child := prune(v.values[key]); child != nil {
+				pruned.set(key, child)
+			}
+		}
+		if len(pruned.keys) == 0 {
+			return nil
+		}
+		return pruned
+	case []interface{}:
+		pruned := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if child := prune(item); child != nil {
+				pruned = append(pruned, child)
+			}
+		}
+		if len(pruned) == 0 {
+			return nil
+		}
+		return pruned
+	default:
+		return value
+	}
+}
+
+func toJSON(value interface{}, escapeHTML bool) (string, error) {
+	var buffer bytes.Buffer
+	if err := encodeJSON(&buffer, value, escapeHTML); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
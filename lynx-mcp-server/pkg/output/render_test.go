@@ -0,0 +1,137 @@
+package output
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+type testDocument struct {
+	DocumentName string `json:"documentName"`
+	DocumentType string `json:"documentType"`
+	Content      string `json:"content"`
+}
+
+type testDocuments struct {
+	Count   int            `json:"count"`
+	Results []testDocument `json:"results"`
+}
+
+var documents = testDocuments{
+	Count: 2,
+	Results: []testDocument{
+		{DocumentName: "Voucher", DocumentType: "SUPP", Content: "<p>Pick-up at <b>08:30</b></p><p>Pier&nbsp;3 &amp; jetty</p>"},
+		{DocumentName: "Notes", DocumentType: "", Content: ""},
+	},
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name           string
+		arguments      map[string]interface{}
+		expectedResult string
+	}{
+		{
+			name:           "Default json keeps field order and html content",
+			arguments:      map[string]interface{}{},
+			expectedResult: `{"count":2,"results":[{"documentName":"Voucher","documentType":"SUPP","content":"\u003cp\u003ePick-up at \u003cb\u003e08:30\u003c/b\u003e\u003c/p\u003e\u003cp\u003ePier\u0026nbsp;3 \u0026amp; jetty\u003c/p\u003e"},{"documentName":"Notes","documentType":"","content":""}]}`,
+		},
+		{
+			name:           "Compact drops empty fields and converts content",
+			arguments:      map[string]interface{}{"format": "compact"},
+			expectedResult: `{"count":2,"results":[{"documentName":"Voucher","documentType":"SUPP","content":"Pick-up at 08:30\nPier 3 & jetty"},{"documentName":"Notes"}]}`,
+		},
+		{
+			name:           "Fields projection on nested arrays",
+			arguments:      map[string]interface{}{"fields": []interface{}{"count", "results.documentName"}},
+			expectedResult: `{"count":2,"results":[{"documentName":"Voucher"},{"documentName":"Notes"}]}`,
+		},
+		{
+			name:      "Markdown renders arrays of objects as tables",
+			arguments: map[string]interface{}{"format": "markdown"},
+			expectedResult: "**count:** 2\n\n## results\n\n" +
+				"| documentName | documentType | content |\n" +
+				"| --- | --- | --- |\n" +
+				"| Voucher | SUPP | Pick-up at 08:30<br>Pier 3 & jetty |\n" +
+				"| Notes |  |  |",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := ParseOptions(tt.arguments)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			result, err := Render(documents, options)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if result != tt.expectedResult {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.expectedResult, result)
+			}
+		})
+	}
+}
+
+func TestNewToolResultContinuation(t *testing.T) {
+	contents := make([]string, len(documents.Results))
+	arguments := map[string]interface{}{"fileIdentifier": "1061848", "maxContentLength": float64(25)}
+
+	pages := 0
+	for ; pages < 100; pages++ {
+		options, err := ParseOptions(arguments)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		result := NewToolResult(documents, options)
+		if result.IsError {
+			t.Fatalf("unexpected error result: %v", result.Content)
+		}
+
+		// Every page is valid JSON, only the contents are cut
+		var page testDocuments
+		if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &page); err != nil {
+			t.Fatalf("page %d isn't valid JSON: %v", pages, err)
+		}
+		for i, document := range page.Results {
+			contents[i] += document.Content
+		}
+
+		if len(result.Content) == 1 {
+			break
+		}
+
+		continuation := result.Content[1].(mcp.TextContent).Text
+		idx := strings.Index(continuation, `"nextCursor":"`)
+		cursor := continuation[idx+len(`"nextCursor":"`):]
+		arguments["cursor"] = cursor[:strings.Index(cursor, `"`)]
+	}
+
+	if pages != 2 {
+		t.Errorf("expected 3 pages, got %d", pages+1)
+	}
+	for i, document := range documents.Results {
+		if contents[i] != document.Content {
+			t.Errorf("expected pages to add up to the content %q, got %q", document.Content, contents[i])
+		}
+	}
+
+	// The cursor is bound to the arguments of the call that returned it
+	arguments["fileIdentifier"] = "1061849"
+	if _, err := ParseOptions(arguments); !errors.Is(err, ErrCursorMismatch) {
+		t.Errorf("ParseOptions() with other arguments = %v, want %v", err, ErrCursorMismatch)
+	}
+}
+
+func TestParseOptionsInvalidFormat(t *testing.T) {
+	if _, err := ParseOptions(map[string]interface{}{"format": "xml"}); err == nil {
+		t.Errorf("expected error for unsupported format")
+	}
+}
@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/confirm"
+)
+
+// PendingActions serves the REST endpoints people approve or deny pending write actions with, outside the MCP
+// session of the agent that requested them
+type PendingActions struct {
+	store *confirm.Store
+}
+
+// NewPendingActions creates the pending action endpoints of the store
+func NewPendingActions(store *confirm.Store) *PendingActions {
+	return &PendingActions{store: store}
+}
+
+// HandleList handles GET /actions, listing the pending actions with their previews
+func (p *PendingActions) HandleList(w http.ResponseWriter, r *http.Request) {
+	actions := p.store.List()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"actions": actions,
+		"count":   len(actions),
+	})
+}
+
+// HandleApprove handles POST /actions/{actionId}/approve, the action runs once the agent calls confirm_action
+func (p *PendingActions) HandleApprove(w http.ResponseWriter, r *http.Request) {
+	action, err := p.store.Approve(r.Context(), r.PathValue("actionId"))
+	if err != nil {
+		http.Error(w, "Failed to approve action: "+err.Error(), pendingActionErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(action)
+}
+
+// HandleDeny handles POST /actions/{actionId}/deny, the action is discarded
+func (p *PendingActions) HandleDeny(w http.ResponseWriter, r *http.Request) {
+	action, err := p.store.Deny(r.Context(), r.PathValue("actionId"))
+	if err != nil {
+		http.Error(w, "Failed to deny action: "+err.Error(), pendingActionErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "denied",
+		"actionId": action.ID,
+		"toolName": action.ToolName,
+	})
+}
+
+// pendingActionErrorStatus maps pending action errors to HTTP status codes
+func pendingActionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, confirm.ErrUnknownAction):
+		return http.StatusNotFound
+	case errors.Is(err, confirm.ErrSelfApproval):
+		return http.StatusForbidden
+	case errors.Is(err, confirm.ErrAlreadyApproved):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
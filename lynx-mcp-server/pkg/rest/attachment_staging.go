@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/staging"
+)
+
+const (
+	CONTENT_SHA256_HEADER = "X-Content-SHA256"
+)
+
+// NewAttachmentStagingHandler handles the REST endpoint staging attachment bytes once,
+// tools then refer to them by the returned attachment handle
+func NewAttachmentStagingHandler(store *staging.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Stream the multipart body instead of buffering the whole form
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Failed to read multipart form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				http.Error(w, "file is required", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to read multipart form: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if part.FormName() != "file" {
+				part.Close()
+				continue
+			}
+
+			attachment, err := store.Put(r.Context(), part.FileName(), part.Header.Get("Content-Type"), part, r.Header.Get(CONTENT_SHA256_HEADER))
+			part.Close()
+
+			if err != nil {
+				http.Error(w, "Failed to stage attachment: "+err.Error(), stagingErrorStatus(err))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(attachment)
+			return
+		}
+	}
+}
+
+// stagingErrorStatus maps staging errors to HTTP status codes
+func stagingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, staging.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, staging.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, staging.ErrChecksumMismatch):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
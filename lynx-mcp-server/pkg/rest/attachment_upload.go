@@ -1,169 +1,267 @@
 package rest
 
 import (
-	"bytes"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
-	"strings"
+	"os"
 
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
-	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/tools"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/idempotency"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
 )
 
 const (
-	MAX_UPLOAD_SIZE = 32 << 20 // 32MB
+	// MULTIPART_OVERHEAD is the room left for form fields and boundaries on top of the file size limit
+	MULTIPART_OVERHEAD = 1 << 20 // 1MB
+
+	// IDEMPOTENCY_KEY_HEADER makes an upload return the result of the earlier upload with the same key
+	IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+	// IDEMPOTENT_REPLAYED_HEADER is set on the responses replaying an earlier upload
+	IDEMPOTENT_REPLAYED_HEADER = "Idempotent-Replayed"
+	// ATTACHMENT_UPLOAD_ENDPOINT names the endpoint in metrics and idempotency fingerprints
+	ATTACHMENT_UPLOAD_ENDPOINT = "/attachmentUpload"
 )
 
-// HandleAttachmentUpload handles the REST endpoint for attachment upload
-func HandleAttachmentUpload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// NewAttachmentUploadHandler handles the REST endpoint for attachment upload.
+// The file part is streamed straight to Lynx, it is only spooled to disk when fileId comes after it or when an
+// Idempotency-Key header asks for its checksum before uploading.
+func NewAttachmentUploadHandler(lynxConfig config.LynxServerConfig, uploads *upload.Service, keys *idempotency.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	// Parse multipart form
-	err := r.ParseMultipartForm(MAX_UPLOAD_SIZE)
-	if err != nil {
-		http.Error(w, "Failed to parse multipart form: "+err.Error(), http.StatusBadRequest)
-		return
-	}
+		r.Body = http.MaxBytesReader(w, r.Body, uploads.MaxSize()+MULTIPART_OVERHEAD)
 
-	// Get file from form
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "Failed to get file from form: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-	defer file.Close()
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Failed to parse multipart form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	// Get fileId from form
-	fileId := r.FormValue("fileId")
-	if fileId == "" {
-		http.Error(w, "fileId is required", http.StatusBadRequest)
-		return
+		fileId := r.URL.Query().Get("fileId")
+		idempotencyKey := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+		var spooled *os.File
+		var spooledName, checksum string
+
+		defer func() {
+			if spooled != nil {
+				spooled.Close()
+				os.Remove(spooled.Name())
+			}
+		}()
+
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, "Failed to parse multipart form: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			switch part.FormName() {
+			case "fileId":
+				value, err := io.ReadAll(io.LimitReader(part, MULTIPART_OVERHEAD))
+				part.Close()
+				if err != nil {
+					http.Error(w, "Failed to read fileId: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				fileId = string(value)
+			case "file":
+				if spooled != nil {
+					part.Close()
+					http.Error(w, "Failed to get file from form: only one file can be uploaded", http.StatusBadRequest)
+					return
+				}
+				if fileId != "" && idempotencyKey == "" {
+					// fileId already known, stream the part straight to Lynx
+					uploadFile(w, r, lynxConfig, uploads, fileId, part.FileName(), part)
+					part.Close()
+					return
+				}
+
+				// fileId comes later in the form or the checksum is needed first, keep the file on disk meanwhile
+				spooled, checksum, err = spoolPart(part, uploads.MaxSize())
+				spooledName = part.FileName()
+				part.Close()
+				if err != nil {
+					http.Error(w, "Failed to get file from form: "+err.Error(), uploadErrorStatus(err))
+					return
+				}
+			default:
+				part.Close()
+			}
+		}
+
+		if spooled == nil {
+			http.Error(w, "Failed to get file from form: file is required", http.StatusBadRequest)
+			return
+		}
+
+		if fileId == "" {
+			http.Error(w, "fileId is required", http.StatusBadRequest)
+			return
+		}
+
+		if idempotencyKey != "" {
+			uploadFileOnce(w, r, lynxConfig, uploads, keys, idempotencyKey, fileId, spooledName, checksum, spooled)
+			return
+		}
+		uploadFile(w, r, lynxConfig, uploads, fileId, spooledName, spooled)
 	}
+}
 
-	// Read file data
-	fileData, err := io.ReadAll(file)
+// uploadFile streams the content to Lynx and writes the JSON response
+func uploadFile(
+	w http.ResponseWriter,
+	r *http.Request,
+	lynxConfig config.LynxServerConfig,
+	uploads *upload.Service,
+	fileId string,
+	fileName string,
+	content io.Reader,
+) {
+	result, status, err := sendFile(r, lynxConfig, uploads, fileId, fileName, content)
 	if err != nil {
-		http.Error(w, "Failed to read file data: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	// Encode file data to base64
-	binaryAsBase64 := base64.StdEncoding.EncodeToString(fileData)
+	// Return the result as JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
 
-	// Create a buffer to write multipart data
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
+// uploadFileOnce uploads the content once per idempotency key of the Lynx account, the requests repeating the key
+// for the same file and content get the result of the first successful upload
+func uploadFileOnce(
+	w http.ResponseWriter,
+	r *http.Request,
+	lynxConfig config.LynxServerConfig,
+	uploads *upload.Service,
+	keys *idempotency.Store,
+	idempotencyKey string,
+	fileId string,
+	fileName string,
+	checksum string,
+	content io.Reader,
+) {
+	key := idempotency.AccountKey(r.Context(), lynxConfig, idempotencyKey)
+	fingerprint := idempotency.Fingerprint(ATTACHMENT_UPLOAD_ENDPOINT, fileId, fileName, checksum)
 
-	// Add fileId part
-	fileIdField, err := writer.CreateFormField("fileId")
+	record, err := keys.Begin(r.Context(), key, fingerprint)
+	if errors.Is(err, idempotency.ErrKeyReused) {
+		metrics.IdempotentCalls.WithLabelValues(ATTACHMENT_UPLOAD_ENDPOINT, metrics.IDEMPOTENCY_REJECTED).Inc()
+		http.Error(w, "Invalid "+IDEMPOTENCY_KEY_HEADER+" header: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to create fileId field: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to claim "+IDEMPOTENCY_KEY_HEADER+": "+err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	fileIdField.Write([]byte(fileId))
 
-	// Decode base64 data
-	decodedFileData, err := base64.StdEncoding.DecodeString(binaryAsBase64)
-	if err != nil {
-		http.Error(w, "Failed to decode base64 data: "+err.Error(), http.StatusInternalServerError)
+	if record != nil {
+		metrics.IdempotentCalls.WithLabelValues(ATTACHMENT_UPLOAD_ENDPOINT, metrics.IDEMPOTENCY_REPLAYED).Inc()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(IDEMPOTENT_REPLAYED_HEADER, "true")
+		w.WriteHeader(http.StatusOK)
+		w.Write(record.Result)
 		return
 	}
+	metrics.IdempotentCalls.WithLabelValues(ATTACHMENT_UPLOAD_ENDPOINT, metrics.IDEMPOTENCY_NEW).Inc()
+
+	var stored json.RawMessage
+	defer func() { keys.Finish(key, fingerprint, stored) }()
 
-	// Add file part
-	fileField, err := writer.CreateFormFile("file", header.Filename)
+	result, status, err := sendFile(r, lynxConfig, uploads, fileId, fileName, content)
 	if err != nil {
-		http.Error(w, "Failed to create file field: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), status)
 		return
 	}
-	fileField.Write(decodedFileData)
 
-	// Close the multipart writer
-	writer.Close()
+	stored, _ = json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(stored)
+}
 
+// sendFile streams the content to Lynx, failures come with their HTTP status code
+func sendFile(
+	r *http.Request,
+	lynxConfig config.LynxServerConfig,
+	uploads *upload.Service,
+	fileId string,
+	fileName string,
+	content io.Reader,
+) (*upload.Result, int, error) {
 	// Get session
-	lynxConfig := config.NewLynxServerConfig()
 	session, _, err := utils.GetOrCreateSession(r.Context(), lynxConfig)
 	if err != nil {
-		http.Error(w, "Failed to get session: "+err.Error(), http.StatusInternalServerError)
-		return
+		return nil, http.StatusInternalServerError, fmt.Errorf("Failed to get session: %w", err)
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, tools.LYNX_ATTACHMENT_UPLOAD_URL), &requestBody)
+	result, err := uploads.Upload(r.Context(), session, upload.Request{
+		FileIdentifier: fileId,
+		FileName:       fileName,
+		Content:        content,
+		Progress: func(sent int64) {
+			slog.DebugContext(r.Context(), "Attachment upload progress", "file_name", fileName, "sent", sent)
+		},
+	})
 	if err != nil {
-		http.Error(w, "Failed to create attachment upload request: "+err.Error(), http.StatusInternalServerError)
-		return
+		return nil, uploadErrorStatus(err), err
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+	return result, http.StatusOK, nil
+}
 
-	// Execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// spoolPart copies a multipart file to a temporary file, enforcing the size limit, and returns its SHA-256 checksum
+func spoolPart(part *multipart.Part, maxSize int64) (*os.File, string, error) {
+	spooled, err := os.CreateTemp("", "lynx-upload-*")
 	if err != nil {
-		http.Error(w, "Failed to execute attachment upload request: "+err.Error(), http.StatusInternalServerError)
-		return
+		return nil, "", err
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "Failed to read response body: "+err.Error(), http.StatusInternalServerError)
-		return
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(spooled, hasher), io.LimitReader(part, maxSize+1))
+	if err == nil && written > maxSize {
+		err = upload.ErrTooLarge
 	}
-	bodyStr := string(bodyBytes)
-
-	// Check if request was successful
-	if resp.StatusCode != http.StatusOK {
-		http.Error(w, fmt.Sprintf("Attachment upload failed with status %d: %s", resp.StatusCode, bodyStr), http.StatusInternalServerError)
-		return
+	if err == nil {
+		_, err = spooled.Seek(0, io.SeekStart)
 	}
 
-	// Parse response to extract attachment URL
-	attachmentUrl, err := parseResponseBody(bodyStr)
 	if err != nil {
-		http.Error(w, "Invalid attachment upload response: "+err.Error(), http.StatusInternalServerError)
-		return
+		spooled.Close()
+		os.Remove(spooled.Name())
+		return nil, "", err
 	}
 
-	// Return the result as JSON
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf(`{"attachmentUrl": "%s"}`, attachmentUrl)))
+	return spooled, hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
-// parseResponseBody parses the response body to extract the attachment URL
-// Expected format: "SUCCESS:/documents/file/f16476987/d20250708231038.pdf:\n"
-func parseResponseBody(responseBody string) (string, error) {
-	if !strings.HasPrefix(responseBody, "SUCCESS:") {
-		return "", fmt.Errorf("unexpected response format: %s", responseBody)
-	}
+// uploadErrorStatus maps upload errors to HTTP status codes
+func uploadErrorStatus(err error) int {
+	var maxBytesError *http.MaxBytesError
 
-	// Remove "SUCCESS:" prefix
-	urlPart := strings.TrimPrefix(responseBody, "SUCCESS:")
-
-	// Trim whitespace and line breaks
-	urlPart = strings.TrimSpace(urlPart)
-
-	// Ensure response ends with ": " and strip it out
-	if !strings.HasSuffix(urlPart, ":") {
-		return "", fmt.Errorf("response does not end with ':': %s", responseBody)
+	switch {
+	case errors.Is(err, upload.ErrTooLarge), errors.As(err, &maxBytesError):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
 	}
-	urlPart = strings.TrimSuffix(urlPart, ":")
-
-	// Validate that we have a URL path
-	if urlPart == "" || !strings.HasPrefix(urlPart, "/") {
-		return "", fmt.Errorf("invalid attachment URL in response: %s", responseBody)
-	}
-
-	return urlPart, nil
 }
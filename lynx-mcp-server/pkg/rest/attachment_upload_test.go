@@ -0,0 +1,41 @@
+package rest
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
+)
+
+func TestAttachmentUploadRepeatedFile(t *testing.T) {
+	spoolDirectory := t.TempDir()
+	t.Setenv("TMPDIR", spoolDirectory)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, name := range []string{"a.pdf", "b.pdf"} {
+		part, _ := form.CreateFormFile("file", name)
+		part.Write([]byte("voucher"))
+	}
+	form.Close()
+
+	uploadConfig := config.UploadConfig{MaxSize: 1 << 10}
+	handler := NewAttachmentUploadHandler(config.LynxServerConfig{}, upload.NewService(config.LynxServerConfig{}, uploadConfig), nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/attachmentUpload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a repeated file part, got %d: %s", http.StatusBadRequest, recorder.Code, recorder.Body.String())
+	}
+	if entries, _ := os.ReadDir(spoolDirectory); len(entries) != 0 {
+		t.Errorf("expected the spooled file to be removed, found %d files", len(entries))
+	}
+}
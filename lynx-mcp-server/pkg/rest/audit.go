@@ -0,0 +1,37 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/audit"
+)
+
+// NewAuditQueryHandler handles the REST endpoint searching the audit log with the file, user, from, to and limit
+// query parameters
+func NewAuditQueryHandler(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		filter, err := audit.ParseFilter(r.URL.Query().Get)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries, err := auditLog.Query(filter)
+		if err != nil {
+			http.Error(w, "Failed to query audit log: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"entries": entries,
+			"count":   len(entries),
+		})
+	}
+}
@@ -0,0 +1,532 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+)
+
+const (
+	UPLOAD_OFFSET_HEADER = "Upload-Offset"
+	CONTENT_RANGE_HEADER = "Content-Range"
+	UPLOAD_ID_PREFIX     = "upl_"
+	UPLOAD_PATH_VALUE    = "uploadId"
+)
+
+// uploadSession describes a resumable upload, it's saved next to the part file its bytes are appended to.
+// Owner is the identity of the caller who created it, only they can resume it.
+type uploadSession struct {
+	ID        string    `json:"uploadId"`
+	Owner     string    `json:"owner,omitempty"`
+	FileID    string    `json:"fileId"`
+	FileName  string    `json:"fileName"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// chunkedUpload is an open resumable upload, its requests run one at a time
+type chunkedUpload struct {
+	mu sync.Mutex
+	uploadSession
+}
+
+// ChunkedUploads implements the resumable upload API: create a session, PUT byte ranges,
+// query the offset and finalize to forward the assembled file to Lynx
+type ChunkedUploads struct {
+	mu               sync.Mutex
+	directory        string
+	ttl              time.Duration
+	maxSize          int64
+	maxSessions      int
+	maxDirectorySize int64
+	reservedSize     int64
+	lynxConfig       config.LynxServerConfig
+	uploads          *upload.Service
+	sessions         map[string]*chunkedUpload
+}
+
+// NewChunkedUploads creates the chunk directory and reloads sessions left by a previous run
+func NewChunkedUploads(lynxConfig config.LynxServerConfig, uploadConfig config.UploadConfig, uploads *upload.Service) (*ChunkedUploads, error) {
+	if err := os.MkdirAll(uploadConfig.ChunkDirectory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+
+	c := &ChunkedUploads{
+		directory:        uploadConfig.ChunkDirectory,
+		ttl:              uploadConfig.SessionTTL,
+		maxSize:          uploadConfig.MaxSize,
+		maxSessions:      uploadConfig.MaxSessions,
+		maxDirectorySize: uploadConfig.MaxChunkDirectorySize,
+		lynxConfig:       lynxConfig,
+		uploads:          uploads,
+		sessions:         make(map[string]*chunkedUpload),
+	}
+
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// HandleCreate handles POST /uploads, the JSON body gives fileId, fileName, size and an optional sha256
+func (c *ChunkedUploads) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	var createRequest struct {
+		FileID   string `json:"fileId"`
+		FileName string `json:"fileName"`
+		Size     int64  `json:"size"`
+		SHA256   string `json:"sha256"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(r.Body, MULTIPART_OVERHEAD)).Decode(&createRequest); err != nil {
+		http.Error(w, "Invalid upload request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if createRequest.FileID == "" || createRequest.FileName == "" {
+		http.Error(w, "fileId and fileName are required", http.StatusBadRequest)
+		return
+	}
+
+	if createRequest.Size <= 0 {
+		http.Error(w, "size must be positive", http.StatusBadRequest)
+		return
+	}
+
+	if createRequest.Size > c.maxSize {
+		http.Error(w, upload.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	session := &chunkedUpload{uploadSession: uploadSession{
+		ID:        id,
+		Owner:     auth.IdentityFromContext(r.Context()),
+		FileID:    createRequest.FileID,
+		FileName:  filepath.Base(createRequest.FileName),
+		Size:      createRequest.Size,
+		SHA256:    strings.ToLower(createRequest.SHA256),
+		CreatedAt: now,
+		ExpiresAt: now.Add(c.ttl),
+	}}
+
+	// The declared size is reserved up front, chunks can't grow an upload past it
+	if err := c.reserve(session); err != nil {
+		http.Error(w, "Failed to create upload: "+err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
+	if err := os.WriteFile(c.partPath(id), nil, 0o600); err != nil {
+		c.remove(session)
+		http.Error(w, "Failed to create upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := c.save(session); err != nil {
+		c.remove(session)
+		http.Error(w, "Failed to create upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Created chunked upload", "upload", id, "file_name", session.FileName, "size", session.Size)
+
+	w.Header().Set("Location", r.URL.Path+"/"+id)
+	writeSession(w, http.StatusCreated, &session.uploadSession)
+}
+
+// HandleChunk handles PUT /uploads/{uploadId}, the body is appended at the offset given
+// by the Content-Range or Upload-Offset header, which must match the current offset
+func (c *ChunkedUploads) HandleChunk(w http.ResponseWriter, r *http.Request) {
+	session, ok := c.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	start, end, err := parseChunkRange(r, session.Size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !c.lockActive(w, session) {
+		return
+	}
+	defer session.mu.Unlock()
+
+	// Out of sync clients get the current offset back and resume from there
+	if start != session.Offset {
+		writeSession(w, http.StatusConflict, &session.uploadSession)
+		return
+	}
+
+	file, err := os.OpenFile(c.partPath(session.ID), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		http.Error(w, "Failed to open upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	remaining := session.Size - session.Offset
+	if end >= 0 {
+		remaining = end - start + 1
+	}
+
+	// Keep whatever arrived before a dropped connection, the client resumes from the new offset
+	written, copyErr := io.Copy(file, io.LimitReader(r.Body, remaining))
+	closeErr := file.Close()
+
+	session.Offset += written
+	session.ExpiresAt = time.Now().Add(c.ttl)
+
+	if err := c.save(session); err != nil {
+		http.Error(w, "Failed to save upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if copyErr != nil || closeErr != nil {
+		slog.WarnContext(r.Context(), "Chunked upload interrupted", "upload", session.ID, "offset", session.Offset, "error", errors.Join(copyErr, closeErr))
+		http.Error(w, fmt.Sprintf("Upload interrupted at offset %d", session.Offset), http.StatusBadRequest)
+		return
+	}
+
+	writeSession(w, http.StatusOK, &session.uploadSession)
+}
+
+// HandleStatus handles GET and HEAD /uploads/{uploadId}, returning the current offset
+func (c *ChunkedUploads) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	session, ok := c.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if !c.lockActive(w, session) {
+		return
+	}
+	defer session.mu.Unlock()
+
+	writeSession(w, http.StatusOK, &session.uploadSession)
+}
+
+// HandleFinalize handles POST /uploads/{uploadId}/finalize, forwarding the assembled file to Lynx
+func (c *ChunkedUploads) HandleFinalize(w http.ResponseWriter, r *http.Request) {
+	session, ok := c.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if !c.lockActive(w, session) {
+		return
+	}
+	defer session.mu.Unlock()
+
+	if session.Offset != session.Size {
+		writeSession(w, http.StatusConflict, &session.uploadSession)
+		return
+	}
+
+	file, err := os.Open(c.partPath(session.ID))
+	if err != nil {
+		http.Error(w, "Failed to open upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	if session.SHA256 != "" {
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, file); err != nil {
+			http.Error(w, "Failed to read upload: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if checksum := hex.EncodeToString(hasher.Sum(nil)); checksum != session.SHA256 {
+			http.Error(w, fmt.Sprintf("SHA-256 mismatch: expected %s, got %s", session.SHA256, checksum), http.StatusUnprocessableEntity)
+			return
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "Failed to read upload: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Get session
+	lynxSession, _, err := utils.GetOrCreateSession(r.Context(), c.lynxConfig)
+	if err != nil {
+		http.Error(w, "Failed to get session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := c.uploads.Upload(r.Context(), lynxSession, upload.Request{
+		FileIdentifier: session.FileID,
+		FileName:       session.FileName,
+		Content:        file,
+	})
+	if err != nil {
+		// The assembled file is kept so that finalize can be retried
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+
+	c.remove(session)
+
+	// Return the result as JSON
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// HandleCancel handles DELETE /uploads/{uploadId}
+func (c *ChunkedUploads) HandleCancel(w http.ResponseWriter, r *http.Request) {
+	session, ok := c.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if !c.lockActive(w, session) {
+		return
+	}
+	defer session.mu.Unlock()
+
+	c.remove(session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartCleanup removes expired sessions periodically until the context is done
+func (c *ChunkedUploads) StartCleanup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				c.mu.Lock()
+				var expired []*chunkedUpload
+				for _, session := range c.sessions {
+					if now.After(session.ExpiresAt) {
+						expired = append(expired, session)
+					}
+				}
+				c.mu.Unlock()
+
+				for _, session := range expired {
+					slog.Info("Chunked upload expired", "upload", session.ID)
+					session.mu.Lock()
+					c.remove(session)
+					session.mu.Unlock()
+				}
+			}
+		}
+	}()
+}
+
+func (c *ChunkedUploads) lookup(w http.ResponseWriter, r *http.Request) (*chunkedUpload, bool) {
+	id := r.PathValue(UPLOAD_PATH_VALUE)
+
+	c.mu.Lock()
+	session, ok := c.sessions[id]
+	c.mu.Unlock()
+
+	// Uploads of other callers are unknown, like their staged attachments
+	if !ok || time.Now().After(session.ExpiresAt) || session.Owner != auth.IdentityFromContext(r.Context()) {
+		http.Error(w, "Unknown or expired upload", http.StatusNotFound)
+		return nil, false
+	}
+
+	return session, true
+}
+
+// lockActive locks the session, returning false when it was finalized or cancelled meanwhile
+func (c *ChunkedUploads) lockActive(w http.ResponseWriter, session *chunkedUpload) bool {
+	session.mu.Lock()
+
+	c.mu.Lock()
+	_, ok := c.sessions[session.ID]
+	c.mu.Unlock()
+
+	if !ok {
+		session.mu.Unlock()
+		http.Error(w, "Unknown or expired upload", http.StatusNotFound)
+		return false
+	}
+
+	return true
+}
+
+// reserve opens the upload when there is room for one more of its size
+func (c *ChunkedUploads) reserve(session *chunkedUpload) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.sessions) >= c.maxSessions {
+		return fmt.Errorf("too many open uploads, %d at most", c.maxSessions)
+	}
+	if c.reservedSize+session.Size > c.maxDirectorySize {
+		return fmt.Errorf("open uploads would exceed %d bytes", c.maxDirectorySize)
+	}
+
+	c.reservedSize += session.Size
+	c.sessions[session.ID] = session
+	return nil
+}
+
+func (c *ChunkedUploads) remove(session *chunkedUpload) {
+	c.mu.Lock()
+	if _, ok := c.sessions[session.ID]; ok {
+		c.reservedSize -= session.Size
+		delete(c.sessions, session.ID)
+	}
+	c.mu.Unlock()
+
+	os.Remove(c.partPath(session.ID))
+	os.Remove(c.metadataPath(session.ID))
+}
+
+// save writes the session metadata next to its part file so uploads survive a restart
+func (c *ChunkedUploads) save(session *chunkedUpload) error {
+	metadata, err := json.Marshal(&session.uploadSession)
+	if err != nil {
+		return err
+	}
+
+	temporaryPath := c.metadataPath(session.ID) + ".tmp"
+	if err := os.WriteFile(temporaryPath, metadata, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryPath, c.metadataPath(session.ID))
+}
+
+// reload restores sessions from disk, the offset is taken from the part file size. The most recently used sessions
+// are kept within the limits of open uploads and chunk directory size, the others are removed.
+func (c *ChunkedUploads) reload() error {
+	metadataPaths, err := filepath.Glob(filepath.Join(c.directory, UPLOAD_ID_PREFIX+"*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list chunk directory: %w", err)
+	}
+
+	now := time.Now()
+	var sessions []*chunkedUpload
+	for _, metadataPath := range metadataPaths {
+		session := &chunkedUpload{}
+
+		metadata, err := os.ReadFile(metadataPath)
+		if err == nil {
+			err = json.Unmarshal(metadata, &session.uploadSession)
+		}
+
+		var info os.FileInfo
+		if err == nil {
+			info, err = os.Stat(c.partPath(session.ID))
+		}
+
+		if err != nil || now.After(session.ExpiresAt) || info.Size() > session.Size {
+			os.Remove(metadataPath)
+			os.Remove(strings.TrimSuffix(metadataPath, ".json") + ".part")
+			continue
+		}
+
+		session.Offset = info.Size()
+		sessions = append(sessions, session)
+	}
+
+	// Sessions expire TTL after their last chunk, the latest expiry was used last
+	slices.SortFunc(sessions, func(a, b *chunkedUpload) int { return b.ExpiresAt.Compare(a.ExpiresAt) })
+	for _, session := range sessions {
+		if len(c.sessions) >= c.maxSessions || c.reservedSize+session.Size > c.maxDirectorySize {
+			slog.Warn("Dropped chunked upload over the limits", "upload", session.ID)
+			os.Remove(c.partPath(session.ID))
+			os.Remove(c.metadataPath(session.ID))
+			continue
+		}
+
+		c.reservedSize += session.Size
+		c.sessions[session.ID] = session
+	}
+
+	if len(c.sessions) > 0 {
+		slog.Info("Resumed chunked uploads", "count", len(c.sessions))
+	}
+
+	return nil
+}
+
+func (c *ChunkedUploads) partPath(id string) string {
+	return filepath.Join(c.directory, id+".part")
+}
+
+func (c *ChunkedUploads) metadataPath(id string) string {
+	return filepath.Join(c.directory, id+".json")
+}
+
+// parseChunkRange reads the chunk start from "Content-Range: bytes start-end/size" or "Upload-Offset: start",
+// end is -1 when the chunk length isn't given
+func parseChunkRange(r *http.Request, size int64) (int64, int64, error) {
+	if contentRange := r.Header.Get(CONTENT_RANGE_HEADER); contentRange != "" {
+		var start, end, total int64
+		if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+			return 0, 0, fmt.Errorf("invalid %s header: %s", CONTENT_RANGE_HEADER, contentRange)
+		}
+
+		if total != size || start < 0 || end < start || end >= size {
+			return 0, 0, fmt.Errorf("invalid %s header for a %d bytes upload: %s", CONTENT_RANGE_HEADER, size, contentRange)
+		}
+
+		return start, end, nil
+	}
+
+	if uploadOffset := r.Header.Get(UPLOAD_OFFSET_HEADER); uploadOffset != "" {
+		start, err := strconv.ParseInt(uploadOffset, 10, 64)
+		if err != nil || start < 0 || start > size {
+			return 0, 0, fmt.Errorf("invalid %s header: %s", UPLOAD_OFFSET_HEADER, uploadOffset)
+		}
+
+		return start, -1, nil
+	}
+
+	return 0, 0, fmt.Errorf("%s or %s header is required", CONTENT_RANGE_HEADER, UPLOAD_OFFSET_HEADER)
+}
+
+func writeSession(w http.ResponseWriter, status int, session *uploadSession) {
+	w.Header().Set(UPLOAD_OFFSET_HEADER, strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(session)
+}
+
+func newUploadID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	return UPLOAD_ID_PREFIX + hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,234 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
+)
+
+func newTestChunkedUploads(t *testing.T, directory string) (*ChunkedUploads, *http.ServeMux) {
+	t.Helper()
+
+	uploadConfig := config.UploadConfig{
+		MaxSize:               1 << 10,
+		ChunkDirectory:        directory,
+		SessionTTL:            time.Hour,
+		MaxSessions:           2,
+		MaxChunkDirectorySize: 3 << 9,
+	}
+
+	chunkedUploads, err := NewChunkedUploads(config.LynxServerConfig{}, uploadConfig, upload.NewService(config.LynxServerConfig{}, uploadConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /uploads", chunkedUploads.HandleCreate)
+	mux.HandleFunc("GET /uploads/{uploadId}", chunkedUploads.HandleStatus)
+	mux.HandleFunc("PUT /uploads/{uploadId}", chunkedUploads.HandleChunk)
+	mux.HandleFunc("DELETE /uploads/{uploadId}", chunkedUploads.HandleCancel)
+
+	return chunkedUploads, mux
+}
+
+func serve(mux *http.ServeMux, method string, target string, body string, headers map[string]string) (*httptest.ResponseRecorder, *uploadSession) {
+	return serveAs(mux, nil, method, target, body, headers)
+}
+
+// serveAs serves a request authenticated with token, unauthenticated when nil
+func serveAs(mux *http.ServeMux, token *auth.Token, method string, target string, body string, headers map[string]string) (*httptest.ResponseRecorder, *uploadSession) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != nil {
+		req = req.WithContext(auth.WithToken(req.Context(), token))
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, req)
+
+	session := &uploadSession{}
+	json.Unmarshal(recorder.Body.Bytes(), session)
+
+	return recorder, session
+}
+
+func TestChunkedUpload(t *testing.T) {
+	directory := t.TempDir()
+	_, mux := newTestChunkedUploads(t, directory)
+
+	recorder, session := serve(mux, "POST", "/uploads", `{"fileId":"$xOpT","fileName":"voucher.pdf","size":10}`, nil)
+	if recorder.Code != http.StatusCreated || session.ID == "" {
+		t.Fatalf("expected upload to be created, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	target := "/uploads/" + session.ID
+
+	tests := []struct {
+		name           string
+		body           string
+		headers        map[string]string
+		expectedStatus int
+		expectedOffset int64
+	}{
+		{
+			name:           "First chunk with Content-Range",
+			body:           "01234",
+			headers:        map[string]string{CONTENT_RANGE_HEADER: "bytes 0-4/10"},
+			expectedStatus: http.StatusOK,
+			expectedOffset: 5,
+		},
+		{
+			name:           "Replayed chunk is refused with the current offset",
+			body:           "01234",
+			headers:        map[string]string{CONTENT_RANGE_HEADER: "bytes 0-4/10"},
+			expectedStatus: http.StatusConflict,
+			expectedOffset: 5,
+		},
+		{
+			name:           "Chunk without range header is refused",
+			body:           "56789",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Remaining bytes with Upload-Offset",
+			body:           "56789",
+			headers:        map[string]string{UPLOAD_OFFSET_HEADER: "5"},
+			expectedStatus: http.StatusOK,
+			expectedOffset: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, session := serve(mux, "PUT", target, tt.body, tt.headers)
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+			if tt.expectedStatus != http.StatusBadRequest && session.Offset != tt.expectedOffset {
+				t.Errorf("expected offset %d, got %d", tt.expectedOffset, session.Offset)
+			}
+		})
+	}
+
+	// A restarted server picks the upload up where it was
+	_, restartedMux := newTestChunkedUploads(t, directory)
+	recorder, session = serve(restartedMux, "GET", target, "", nil)
+	if recorder.Code != http.StatusOK || session.Offset != 10 || recorder.Header().Get(UPLOAD_OFFSET_HEADER) != "10" {
+		t.Errorf("expected resumed upload at offset 10, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder, _ = serve(restartedMux, "DELETE", target, "", nil)
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("expected upload to be cancelled, got %d", recorder.Code)
+	}
+
+	recorder, _ = serve(restartedMux, "GET", target, "", nil)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected cancelled upload to be gone, got %d", recorder.Code)
+	}
+}
+
+func TestChunkedUploadLimits(t *testing.T) {
+	_, mux := newTestChunkedUploads(t, t.TempDir())
+
+	tests := []struct {
+		name     string
+		size     int
+		expected int
+	}{
+		{"first upload", 1024, http.StatusCreated},
+		{"over the chunk directory size", 1024, http.StatusInsufficientStorage},
+		{"second upload", 512, http.StatusCreated},
+		{"over the open uploads", 1, http.StatusInsufficientStorage},
+	}
+
+	var first string
+	for _, test := range tests {
+		recorder, session := serve(mux, "POST", "/uploads", fmt.Sprintf(`{"fileId":"$xOpT","fileName":"voucher.pdf","size":%d}`, test.size), nil)
+		if recorder.Code != test.expected {
+			t.Fatalf("%s: expected status %d, got %d: %s", test.name, test.expected, recorder.Code, recorder.Body.String())
+		}
+		if first == "" {
+			first = session.ID
+		}
+	}
+
+	// Canceling an upload frees its place and its size
+	if recorder, _ := serve(mux, "DELETE", "/uploads/"+first, "", nil); recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected upload to be canceled, got %d", recorder.Code)
+	}
+	if recorder, _ := serve(mux, "POST", "/uploads", `{"fileId":"$xOpT","fileName":"voucher.pdf","size":1024}`, nil); recorder.Code != http.StatusCreated {
+		t.Errorf("expected upload to be created once another was canceled, got %d", recorder.Code)
+	}
+}
+
+func TestChunkedUploadOwner(t *testing.T) {
+	_, mux := newTestChunkedUploads(t, t.TempDir())
+	owner := &auth.Token{Name: "n8n", Identity: "n8n"}
+	other := &auth.Token{Name: "jdoe", Identity: "jdoe@example.com"}
+
+	recorder, session := serveAs(mux, owner, "POST", "/uploads", `{"fileId":"$xOpT","fileName":"voucher.pdf","size":10}`, nil)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("expected upload to be created, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	target := "/uploads/" + session.ID
+
+	// Another caller knowing the upload ID can't append to it nor cancel it
+	if recorder, _ := serveAs(mux, other, "PUT", target, "01234", map[string]string{UPLOAD_OFFSET_HEADER: "0"}); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected chunk of another caller to be refused, got %d", recorder.Code)
+	}
+	if recorder, _ := serveAs(mux, other, "DELETE", target, "", nil); recorder.Code != http.StatusNotFound {
+		t.Errorf("expected cancel of another caller to be refused, got %d", recorder.Code)
+	}
+	if recorder, session := serveAs(mux, owner, "PUT", target, "01234", map[string]string{UPLOAD_OFFSET_HEADER: "0"}); recorder.Code != http.StatusOK || session.Offset != 5 {
+		t.Errorf("expected chunk of the owner at offset 5, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestChunkedUploadReloadLimits(t *testing.T) {
+	directory := t.TempDir()
+	_, mux := newTestChunkedUploads(t, directory)
+	for range 2 {
+		if recorder, _ := serve(mux, "POST", "/uploads", `{"fileId":"$xOpT","fileName":"voucher.pdf","size":10}`, nil); recorder.Code != http.StatusCreated {
+			t.Fatalf("expected upload to be created, got %d", recorder.Code)
+		}
+	}
+
+	// A restart with fewer open uploads allowed keeps only as many
+	uploadConfig := config.UploadConfig{
+		MaxSize:               1 << 10,
+		ChunkDirectory:        directory,
+		SessionTTL:            time.Hour,
+		MaxSessions:           1,
+		MaxChunkDirectorySize: 3 << 9,
+	}
+	restarted, err := NewChunkedUploads(config.LynxServerConfig{}, uploadConfig, upload.NewService(config.LynxServerConfig{}, uploadConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.sessions) != 1 {
+		t.Errorf("expected 1 resumed upload, got %d", len(restarted.sessions))
+	}
+	if metadata, _ := filepath.Glob(filepath.Join(directory, "*.json")); len(metadata) != 1 {
+		t.Errorf("expected the dropped upload to be removed, %d left", len(metadata))
+	}
+}
+
+func TestChunkedUploadTooLarge(t *testing.T) {
+	_, mux := newTestChunkedUploads(t, t.TempDir())
+
+	recorder, _ := serve(mux, "POST", "/uploads", `{"fileId":"$xOpT","fileName":"voucher.pdf","size":2048}`, nil)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d, got %d", http.StatusRequestEntityTooLarge, recorder.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/email"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/outbox"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+)
+
+const (
+	// EMAIL_SIZE_FACTOR leaves room for base64 encoded attachments, several per email, on top of the upload limit
+	EMAIL_SIZE_FACTOR = 2
+)
+
+// NewEmailIngestHandler handles the REST endpoint filing a raw RFC 822 email against its booking.
+// fileReference, transactionIdentifier and documentType query parameters override what is found in the email.
+// When enqueue is set, emails that can't be filed while Lynx is unreachable are queued and answered 202 Accepted,
+// unless a document was filed already.
+func NewEmailIngestHandler(lynxConfig config.LynxServerConfig, uploads *upload.Service, enqueue outbox.Enqueue) http.HandlerFunc {
+	ingester := email.NewIngester(lynxConfig, uploads)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, uploads.MaxSize()*EMAIL_SIZE_FACTOR)
+
+		// The raw email is kept to be queued
+		var raw []byte
+		body := io.Reader(r.Body)
+		if enqueue != nil {
+			var err error
+			raw, err = io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Failed to parse email: "+err.Error(), emailReadErrorStatus(err))
+				return
+			}
+			body = bytes.NewReader(raw)
+		}
+
+		// Attachments are spooled to disk while the email is parsed
+		message, err := email.Parse(body)
+		if err != nil {
+			http.Error(w, "Failed to parse email: "+err.Error(), emailReadErrorStatus(err))
+			return
+		}
+		defer message.Close()
+
+		query := r.URL.Query()
+		ingestion := email.Ingestion{
+			FileReference:         query.Get("fileReference"),
+			TransactionIdentifier: query.Get("transactionIdentifier"),
+			DocumentType:          query.Get("documentType"),
+		}
+
+		// Get session
+		session, _, err := utils.GetOrCreateSession(r.Context(), lynxConfig)
+		if err != nil {
+			if enqueue != nil && outbox.Queueable(err) {
+				queueEmail(w, r, enqueue, raw, ingestion, err)
+				return
+			}
+			http.Error(w, "Failed to get session: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result, err := ingester.Ingest(r.Context(), session, message, ingestion)
+		if err != nil && result != nil {
+			// Filing stopped part way, the documents already filed are listed next to the error
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(emailIngestErrorStatus(err))
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+		if err != nil {
+			if enqueue != nil && outbox.Queueable(err) {
+				queueEmail(w, r, enqueue, raw, ingestion, err)
+				return
+			}
+			http.Error(w, "Failed to ingest email: "+err.Error(), emailIngestErrorStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// queueEmail queues the email as an email_ingest call to be filed once Lynx is back
+func queueEmail(w http.ResponseWriter, r *http.Request, enqueue outbox.Enqueue, raw []byte, ingestion email.Ingestion, failed error) {
+	arguments := map[string]any{"eml": string(raw)}
+	if ingestion.FileReference != "" {
+		arguments["fileReference"] = ingestion.FileReference
+	}
+	if ingestion.TransactionIdentifier != "" {
+		arguments["transactionIdentifier"] = ingestion.TransactionIdentifier
+	}
+	if ingestion.DocumentType != "" {
+		arguments["documentType"] = ingestion.DocumentType
+	}
+
+	entry, err := enqueue(r.Context(), arguments)
+	if err != nil {
+		http.Error(w, "Failed to ingest email: "+failed.Error(), emailIngestErrorStatus(failed))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(outbox.Queued(entry, failed))
+}
+
+// emailReadErrorStatus maps errors reading the email to HTTP status codes
+func emailReadErrorStatus(err error) int {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// emailIngestErrorStatus maps ingestion errors to HTTP status codes
+func emailIngestErrorStatus(err error) int {
+	if errors.Is(err, email.ErrFileNotFound) {
+		return http.StatusUnprocessableEntity
+	}
+	return uploadErrorStatus(err)
+}
@@ -0,0 +1,35 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/health"
+)
+
+// HandleHealthz handles the liveness probe, answering as long as the process serves requests
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": health.STATUS_OK})
+}
+
+// NewReadyzHandler handles the readiness probe, answering 503 Service Unavailable while a check fails
+func NewReadyzHandler(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Ready(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// NewDebugLynxHandler handles the diagnostics endpoint of the connection to Lynx
+func NewDebugLynxHandler(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(checker.Lynx(r.Context()))
+	}
+}
@@ -0,0 +1,301 @@
+package staging
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+const (
+	HANDLE_PREFIX    = "att_"
+	CLEANUP_INTERVAL = time.Minute
+)
+
+var (
+	ErrFileTooLarge     = errors.New("attachment exceeds maximum file size")
+	ErrQuotaExceeded    = errors.New("attachment staging quota exceeded")
+	ErrChecksumMismatch = errors.New("attachment SHA-256 checksum mismatch")
+	ErrNotFound         = errors.New("unknown or expired attachment handle")
+)
+
+// Attachment describes bytes staged on disk until a tool forwards them to Lynx
+type Attachment struct {
+	Handle      string    `json:"attachmentHandle"`
+	FileName    string    `json:"fileName"`
+	ContentType string    `json:"contentType,omitempty"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+
+	owner string
+	path  string
+}
+
+// Store keeps staged attachments in a directory with a TTL and size quotas
+type Store struct {
+	mu           sync.Mutex
+	directory    string
+	ttl          time.Duration
+	maxFileSize  int64
+	maxTotalSize int64
+	totalSize    int64
+	attachments  map[string]*Attachment
+}
+
+// NewStore creates the staging directory, attachments left over from a previous run are removed
+func NewStore(stagingConfig config.StagingConfig) (*Store, error) {
+	if err := os.MkdirAll(stagingConfig.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	leftovers, err := filepath.Glob(filepath.Join(stagingConfig.Directory, HANDLE_PREFIX+"*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list staging directory: %w", err)
+	}
+	for _, leftover := range leftovers {
+		os.Remove(leftover)
+	}
+
+	return &Store{
+		directory:    stagingConfig.Directory,
+		ttl:          stagingConfig.TTL,
+		maxFileSize:  stagingConfig.MaxFileSize,
+		maxTotalSize: stagingConfig.MaxTotalSize,
+		attachments:  make(map[string]*Attachment),
+	}, nil
+}
+
+// Put streams the reader to disk and returns the staged attachment, its handle only works for the caller of ctx.
+// When expectedSHA256 is set the content must match it. The quota is taken as the content is written, uploads
+// running at once can't exceed it together.
+func (s *Store) Put(ctx context.Context, fileName string, contentType string, reader io.Reader, expectedSHA256 string) (*Attachment, error) {
+	handle, err := newHandle()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(s.directory, handle)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staged file: %w", err)
+	}
+
+	hasher := sha256.New()
+	quota := &quotaWriter{store: s, file: file}
+	// Read one byte past the limit to detect oversized attachments
+	size, err := io.Copy(io.MultiWriter(quota, hasher), io.LimitReader(reader, s.maxFileSize+1))
+	closeErr := file.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && size > s.maxFileSize {
+		err = ErrFileTooLarge
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if err == nil && expectedSHA256 != "" && !strings.EqualFold(checksum, expectedSHA256) {
+		err = ErrChecksumMismatch
+	}
+
+	if err != nil {
+		os.Remove(path)
+		s.release(quota.reserved)
+		return nil, err
+	}
+
+	now := time.Now()
+	attachment := &Attachment{
+		Handle:      handle,
+		FileName:    filepath.Base(fileName),
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      checksum,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+		owner:       auth.IdentityFromContext(ctx),
+		path:        path,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attachments[handle] = attachment
+
+	slog.Info("Staged attachment", "handle", handle, "file_name", attachment.FileName, "size", size)
+
+	return attachment, nil
+}
+
+// Get returns the metadata of an attachment staged by the caller of ctx
+func (s *Store) Get(ctx context.Context, handle string) (*Attachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpiredLocked(time.Now())
+
+	return s.ownedLocked(ctx, handle)
+}
+
+// Open returns a reader over the staged attachment, the SHA-256 recorded at staging time
+// is verified again once the reader reaches EOF
+func (s *Store) Open(ctx context.Context, handle string) (*Attachment, io.ReadCloser, error) {
+	attachment, err := s.Get(ctx, handle)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(attachment.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open staged attachment: %w", err)
+	}
+
+	return attachment, &verifyingReader{
+		file:     file,
+		hasher:   sha256.New(),
+		expected: attachment.SHA256,
+	}, nil
+}
+
+// Delete removes an attachment staged by the caller of ctx before it expires
+func (s *Store) Delete(ctx context.Context, handle string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attachment, err := s.ownedLocked(ctx, handle)
+	if err != nil {
+		return err
+	}
+
+	s.removeLocked(attachment)
+	return nil
+}
+
+// StartCleanup removes expired attachments periodically until the context is done
+func (s *Store) StartCleanup(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(CLEANUP_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.mu.Lock()
+				s.removeExpiredLocked(now)
+				s.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// ownedLocked returns the attachment if the caller of ctx staged it, handles of other callers are unknown to it
+func (s *Store) ownedLocked(ctx context.Context, handle string) (*Attachment, error) {
+	attachment, ok := s.attachments[handle]
+	if !ok || attachment.owner != auth.IdentityFromContext(ctx) {
+		return nil, ErrNotFound
+	}
+	return attachment, nil
+}
+
+// reserve takes size bytes of the quota, expired attachments are removed first when it's exceeded
+func (s *Store) reserve(size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.totalSize+size > s.maxTotalSize {
+		s.removeExpiredLocked(time.Now())
+		if s.totalSize+size > s.maxTotalSize {
+			return ErrQuotaExceeded
+		}
+	}
+	s.totalSize += size
+	return nil
+}
+
+// release gives back bytes of the quota taken for an attachment that wasn't staged
+func (s *Store) release(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.totalSize -= size
+}
+
+func (s *Store) removeExpiredLocked(now time.Time) {
+	for _, attachment := range s.attachments {
+		if now.After(attachment.ExpiresAt) {
+			slog.Info("Staged attachment expired", "handle", attachment.Handle)
+			s.removeLocked(attachment)
+		}
+	}
+}
+
+func (s *Store) removeLocked(attachment *Attachment) {
+	if err := os.Remove(attachment.path); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove staged attachment", "handle", attachment.Handle, "error", err)
+	}
+	s.totalSize -= attachment.Size
+	delete(s.attachments, attachment.Handle)
+}
+
+// quotaWriter takes the quota of the bytes before writing them to the staged file
+type quotaWriter struct {
+	store    *Store
+	file     *os.File
+	reserved int64
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if err := q.store.reserve(int64(len(p))); err != nil {
+		return 0, err
+	}
+	q.reserved += int64(len(p))
+
+	return q.file.Write(p)
+}
+
+// verifyingReader fails the final read when the content no longer matches its checksum
+type verifyingReader struct {
+	file     *os.File
+	hasher   hash.Hash
+	expected string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.file.Read(p)
+	v.hasher.Write(p[:n])
+
+	if err == io.EOF && hex.EncodeToString(v.hasher.Sum(nil)) != v.expected {
+		return n, ErrChecksumMismatch
+	}
+
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.file.Close()
+}
+
+func newHandle() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate attachment handle: %w", err)
+	}
+	return HANDLE_PREFIX + hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,209 @@
+package staging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+)
+
+var ctx = auth.WithToken(context.Background(), &auth.Token{Name: "n8n", Identity: "n8n"})
+
+func newTestStore(t *testing.T, ttl time.Duration) *Store {
+	t.Helper()
+	store, err := NewStore(config.StagingConfig{
+		Directory:    t.TempDir(),
+		TTL:          ttl,
+		MaxFileSize:  16,
+		MaxTotalSize: 24,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return store
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPut(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		expectedSHA256 string
+		expectedError  error
+	}{
+		{
+			name:    "Stages content and computes its checksum",
+			content: "voucher",
+		},
+		{
+			name:           "Accepts a matching checksum",
+			content:        "voucher",
+			expectedSHA256: strings.ToUpper(checksum("voucher")),
+		},
+		{
+			name:           "Rejects a mismatching checksum",
+			content:        "voucher",
+			expectedSHA256: checksum("invoice"),
+			expectedError:  ErrChecksumMismatch,
+		},
+		{
+			name:          "Rejects files over the size limit",
+			content:       strings.Repeat("x", 17),
+			expectedError: ErrFileTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, time.Minute)
+
+			attachment, err := store.Put(ctx, "../voucher.pdf", "application/pdf", strings.NewReader(tt.content), tt.expectedSHA256)
+			if tt.expectedError != nil {
+				if !errors.Is(err, tt.expectedError) {
+					t.Fatalf("expected error %v, got %v", tt.expectedError, err)
+				}
+				if entries, _ := os.ReadDir(store.directory); len(entries) != 0 {
+					t.Errorf("expected rejected attachment to be removed from disk")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if attachment.FileName != "voucher.pdf" || attachment.SHA256 != checksum(tt.content) {
+				t.Errorf("unexpected attachment: %+v", attachment)
+			}
+
+			_, reader, err := store.Open(ctx, attachment.Handle)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer reader.Close()
+
+			content, err := io.ReadAll(reader)
+			if err != nil || string(content) != tt.content {
+				t.Errorf("expected content %q, got %q (%v)", tt.content, content, err)
+			}
+		})
+	}
+}
+
+func TestPutQuota(t *testing.T) {
+	store := newTestStore(t, time.Minute)
+
+	first, err := store.Put(ctx, "a.pdf", "", strings.NewReader(strings.Repeat("a", 16)), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := store.Put(ctx, "b.pdf", "", strings.NewReader(strings.Repeat("b", 16)), ""); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+
+	if err := store.Delete(ctx, first.Handle); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := store.Put(ctx, "b.pdf", "", strings.NewReader(strings.Repeat("b", 16)), ""); err != nil {
+		t.Errorf("expected quota to be released, got %v", err)
+	}
+}
+
+// concurrentReader stages another attachment once part of its own content was written
+type concurrentReader struct {
+	store *Store
+	parts []string
+	err   error
+}
+
+func (c *concurrentReader) Read(p []byte) (int, error) {
+	if len(c.parts) == 0 {
+		return 0, io.EOF
+	}
+	if len(c.parts) == 1 {
+		_, c.err = c.store.Put(ctx, "b.pdf", "", strings.NewReader(strings.Repeat("b", 16)), "")
+	}
+	n := copy(p, c.parts[0])
+	c.parts = c.parts[1:]
+	return n, nil
+}
+
+func TestPutQuotaWhileCopying(t *testing.T) {
+	store := newTestStore(t, time.Minute)
+
+	reader := &concurrentReader{store: store, parts: []string{strings.Repeat("a", 12), strings.Repeat("a", 4)}}
+	if _, err := store.Put(ctx, "a.pdf", "", reader, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !errors.Is(reader.err, ErrQuotaExceeded) {
+		t.Errorf("expected the bytes being written to count towards the quota, got %v", reader.err)
+	}
+}
+
+func TestOpenOtherCaller(t *testing.T) {
+	store := newTestStore(t, time.Minute)
+
+	attachment, err := store.Put(ctx, "a.pdf", "", strings.NewReader("a"), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	otherCtx := auth.WithToken(context.Background(), &auth.Token{Name: "jdoe", Identity: "jdoe@example.com"})
+	if _, _, err := store.Open(otherCtx, attachment.Handle); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the handle of another caller to be unknown, got %v", err)
+	}
+	if err := store.Delete(otherCtx, attachment.Handle); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the handle of another caller not to be deleted, got %v", err)
+	}
+}
+
+func TestOpenExpired(t *testing.T) {
+	store := newTestStore(t, time.Millisecond)
+
+	attachment, err := store.Put(ctx, "a.pdf", "", strings.NewReader("a"), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, _, err := store.Open(ctx, attachment.Handle); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected expired attachment to be gone, got %v", err)
+	}
+}
+
+func TestOpenDetectsTampering(t *testing.T) {
+	store := newTestStore(t, time.Minute)
+
+	attachment, err := store.Put(ctx, "a.pdf", "", strings.NewReader("original"), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(attachment.path, []byte("tampered"), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, reader, err := store.Open(ctx, attachment.Handle)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer reader.Close()
+
+	if _, err := io.ReadAll(reader); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/match"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/outbox"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/output"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	TOOL_ATTACH_DOCUMENT             string = "attach_document_to_booking"
+	TOOL_ATTACH_DOCUMENT_DESCRIPTION string = "Attach a staged document to a booking transaction in one call: finds the file, picks the transaction from supplier, voucher or date hints, uploads the attachment and saves the document. Returns a disambiguation list instead when several transactions match"
+	TOOL_ATTACH_DOCUMENT_SCHEMA      string = `{
+		"type": "object",
+		"description": "Attach a staged document to a booking transaction",
+		"properties": {
+			"fileReference": {
+				"type": "string",
+				"description": "File reference, e.g. FTSWA230184"
+			},
+			"supplier": {
+				"type": "string",
+				"description": "Supplier name hint, matched fuzzily"
+			},
+			"voucher": {
+				"type": "string",
+				"description": "Voucher or confirmation number hint, the end of the number is enough"
+			},
+			"date": {
+				"type": "string",
+				"description": "Service date hint, e.g. 2025-10-04 or 04 Oct 2025"
+			},
+			"transactionIdentifier": {
+				"type": "string",
+				"description": "Transaction identifier picked from a previous disambiguation list, hints are ignored when given"
+			},
+			"attachmentHandle": {
+				"type": "string",
+				"description": "Handle of the staged attachment"
+			},
+			"attachmentUrl": {
+				"type": "string",
+				"description": "Attachment URL an earlier attempt uploaded, instead of attachmentHandle. Set by the outbox"
+			},
+			"name": {
+				"type": "string",
+				"description": "Document name, defaults to the attachment file name"
+			},
+			"content": {
+				"type": "string",
+				"description": "Document content (as plain text or HTML)"
+			},
+			"type": {
+				"type": "string",
+				"description": "Document type"
+			}
+		},
+		"required": ["fileReference", "type"],
+		"outputSchema": {
+			"type": "object",
+			"properties": {
+				"status": {
+					"type": "string",
+					"description": "attached, ambiguous, no_match, or uploaded when the attachment was uploaded but saving the document failed"
+				},
+				"fileReference": {
+					"type": "string",
+					"description": "File reference"
+				},
+				"fileIdentifier": {
+					"type": "string",
+					"description": "File identifier"
+				},
+				"transaction": {
+					"type": "object",
+					"description": "Transaction the document was attached to"
+				},
+				"document": {
+					"type": "object",
+					"properties": {
+						"name": {
+							"type": "string",
+							"description": "Document name"
+						},
+						"type": {
+							"type": "string",
+							"description": "Document type"
+						},
+						"attachmentUrl": {
+							"type": "string",
+							"description": "Attachment URL"
+						},
+						"size": {
+							"type": "integer",
+							"description": "Attachment size in bytes"
+						},
+						"sha256": {
+							"type": "string",
+							"description": "Attachment SHA-256"
+						}
+					}
+				},
+				"candidates": {
+					"type": "array",
+					"description": "Matching transactions, best first, when the hints don't point to a single one",
+					"items": {
+						"type": "object"
+					}
+				},
+				"message": {
+					"type": "string",
+					"description": "What was done, or what to do next"
+				}
+			},
+			"required": ["status", "fileReference", "fileIdentifier", "message"]
+		}
+	}`
+
+	ATTACH_STATUS_ATTACHED  = "attached"
+	ATTACH_STATUS_AMBIGUOUS = "ambiguous"
+	ATTACH_STATUS_NO_MATCH  = "no_match"
+	ATTACH_STATUS_UPLOADED  = "uploaded"
+)
+
+// AttachDocumentResult reports what attach_document_to_booking did
+type AttachDocumentResult struct {
+	Status         string            `json:"status"`
+	FileReference  string            `json:"fileReference"`
+	FileIdentifier string            `json:"fileIdentifier"`
+	Transaction    *match.Candidate  `json:"transaction,omitempty"`
+	Document       *AttachedDocument `json:"document,omitempty"`
+	Candidates     []match.Candidate `json:"candidates,omitempty"`
+	Message        string            `json:"message"`
+}
+
+// AttachedDocument is the document saved by attach_document_to_booking
+type AttachedDocument struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	AttachmentURL string `json:"attachmentUrl"`
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256"`
+}
+
+// NewAttachDocumentHandler returns the attach_document_to_booking handler, nothing is uploaded
+// unless the target transaction is resolved
+func NewAttachDocumentHandler(lynxConfig config.LynxServerConfig, attachments Attachments) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
+
+		if err != nil {
+			return nil, err
+		}
+
+		arguments := request.GetArguments()
+
+		options, err := output.ParseOptions(arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		fileReference, ok := arguments["fileReference"].(string)
+		if !ok || fileReference == "" {
+			return nil, fmt.Errorf("invalid file reference argument: %v", arguments["fileReference"])
+		}
+
+		attachmentHandle, _ := arguments["attachmentHandle"].(string)
+		attachmentUrl, _ := arguments["attachmentUrl"].(string)
+		if attachmentHandle != "" && attachmentUrl != "" {
+			return nil, fmt.Errorf("attachmentUrl and attachmentHandle are mutually exclusive")
+		}
+
+		// The attachment uploaded by an earlier attempt of a queued call is saved as is
+		var fileName string
+		if attachmentUrl == "" {
+			staged, err := attachments.Store.Get(ctx, attachmentHandle)
+			if err != nil {
+				return nil, fmt.Errorf("invalid attachmentHandle argument: %w", err)
+			}
+			fileName = staged.FileName
+		}
+
+		documentType, ok := arguments["type"].(string)
+		if !ok || documentType == "" {
+			return nil, fmt.Errorf("invalid type argument: %v", arguments["type"])
+		}
+
+		name, _ := arguments["name"].(string)
+		if name == "" {
+			name = fileName
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid name argument: %v", arguments["name"])
+		}
+
+		attach := attachDocumentArgs{
+			FileReference: fileReference,
+			AttachmentURL: attachmentUrl,
+			Name:          name,
+			Type:          documentType,
+		}
+		attach.Content, _ = arguments["content"].(string)
+		attach.TransactionIdentifier, _ = arguments["transactionIdentifier"].(string)
+		attach.Hints.Supplier, _ = arguments["supplier"].(string)
+		attach.Hints.Voucher, _ = arguments["voucher"].(string)
+		attach.Hints.Date, _ = arguments["date"].(string)
+
+		result, err := attachDocument(ctx, bookingLynx{
+			findFile: func(ctx context.Context, fileReference string) (*gwt.FileSearchResult, error) {
+				return lynx.FindFileByReference(ctx, lynxConfig, session, fileReference)
+			},
+			retrieveItinerary: func(ctx context.Context, fileIdentifier string) (*gwt.RetrieveItineraryResponseArray, error) {
+				return lynx.RetrieveItinerary(ctx, lynxConfig, session, fileIdentifier)
+			},
+			upload: func(ctx context.Context, fileIdentifier string) (*upload.Result, error) {
+				return attachments.upload(ctx, request, session, fileIdentifier)
+			},
+			saveDocument: func(ctx context.Context, args *gwt.TransactionDocumentSaveDetailsArgs) error {
+				return lynx.SaveTransactionDocument(ctx, lynxConfig, session, args)
+			},
+		}, attach)
+		if err != nil {
+			return nil, err
+		}
+
+		// The attachment is in Lynx but not linked, calling again would upload it once more
+		rendered := output.NewToolResult(result, options)
+		if result.Status == ATTACH_STATUS_UPLOADED {
+			rendered.IsError = true
+		}
+		return rendered, nil
+	}
+}
+
+// bookingLynx holds the Lynx calls of attach_document_to_booking
+type bookingLynx struct {
+	findFile          func(ctx context.Context, fileReference string) (*gwt.FileSearchResult, error)
+	retrieveItinerary func(ctx context.Context, fileIdentifier string) (*gwt.RetrieveItineraryResponseArray, error)
+	upload            func(ctx context.Context, fileIdentifier string) (*upload.Result, error)
+	saveDocument      func(ctx context.Context, args *gwt.TransactionDocumentSaveDetailsArgs) error
+}
+
+// attachDocumentArgs are the arguments of attach_document_to_booking past the attachment handle, AttachmentURL is the
+// attachment an earlier attempt uploaded
+type attachDocumentArgs struct {
+	FileReference         string
+	TransactionIdentifier string
+	AttachmentURL         string
+	Hints                 match.Hints
+	Name                  string
+	Content               string
+	Type                  string
+}
+
+// attachDocument resolves the transaction then uploads the attachment and saves it as a document of the transaction
+func attachDocument(ctx context.Context, booking bookingLynx, args attachDocumentArgs) (*AttachDocumentResult, error) {
+	file, err := booking.findFile(ctx, args.FileReference)
+	if err != nil {
+		return nil, err
+	}
+
+	itinerary, err := booking.retrieveItinerary(ctx, file.FileIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AttachDocumentResult{
+		FileReference:  file.FileReference,
+		FileIdentifier: file.FileIdentifier,
+	}
+
+	var candidates []match.Candidate
+	if args.TransactionIdentifier != "" {
+		for _, transaction := range itinerary.Itineraries {
+			if transaction.TransactionIdentifier == args.TransactionIdentifier {
+				candidates = append(candidates, match.Candidate{ItineraryTransactionSummary: transaction, Score: 1, MatchedBy: []string{"transactionIdentifier"}})
+			}
+		}
+	} else {
+		candidates, err = match.Transactions(itinerary.Itineraries, args.Hints)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	transaction, ok := match.Best(candidates)
+	if !ok {
+		if len(candidates) == 0 {
+			result.Status = ATTACH_STATUS_NO_MATCH
+			result.Message = fmt.Sprintf("No transaction of %s matches the hints, nothing was uploaded. Check the hints or pass a transactionIdentifier from retrieve_itinerary", file.FileReference)
+		} else {
+			result.Status = ATTACH_STATUS_AMBIGUOUS
+			result.Candidates = candidates
+			result.Message = fmt.Sprintf("%d transactions match, nothing was uploaded. Call again with the transactionIdentifier of the right candidate", len(candidates))
+		}
+		return result, nil
+	}
+
+	uploaded := &upload.Result{AttachmentURL: args.AttachmentURL}
+	if uploaded.AttachmentURL == "" {
+		uploaded, err = booking.upload(ctx, file.FileIdentifier)
+		if err != nil {
+			return nil, err
+		}
+
+		// A queued call saves the uploaded attachment to the same transaction instead of uploading it again
+		outbox.Done(ctx, map[string]any{
+			outbox.ATTACHMENT_ARGUMENT: nil,
+			"attachmentUrl":            uploaded.AttachmentURL,
+			"name":                     args.Name,
+			"transactionIdentifier":    transaction.TransactionIdentifier,
+		})
+	}
+
+	result.Transaction = &transaction
+	result.Document = &AttachedDocument{
+		Name:          args.Name,
+		Type:          args.Type,
+		AttachmentURL: uploaded.AttachmentURL,
+		Size:          uploaded.Size,
+		SHA256:        uploaded.SHA256,
+	}
+
+	err = booking.saveDocument(ctx, &gwt.TransactionDocumentSaveDetailsArgs{
+		FileIdentifier:        file.FileIdentifier,
+		TransactionIdentifier: transaction.TransactionIdentifier,
+
+		Name:          args.Name,
+		Content:       args.Content,
+		Type:          args.Type,
+		AttachmentURL: uploaded.AttachmentURL,
+	})
+	if err != nil {
+		// Queued, the save is applied with the uploaded attachment once Lynx is back
+		if outbox.Queues(ctx) && outbox.Queueable(err) {
+			return nil, err
+		}
+
+		// Lynx has no way to remove the uploaded attachment, the failure is reported rather than returned so that
+		// a retry doesn't upload it again
+		result.Status = ATTACH_STATUS_UPLOADED
+		result.Message = fmt.Sprintf("Attachment uploaded to %s but saving the document failed: %v. Save it with transaction_document_save and this attachmentUrl instead of calling again", uploaded.AttachmentURL, err)
+		return result, nil
+	}
+
+	result.Status = ATTACH_STATUS_ATTACHED
+	result.Message = fmt.Sprintf("Attached %s to transaction %s (%s, %s) of %s", args.Name, transaction.TransactionIdentifier, transaction.Supplier, transaction.Date, file.FileReference)
+
+	return result, nil
+}
+
+// GetAttachDocumentSchema returns the complete JSON schema for the attach document to booking tool
+func GetAttachDocumentSchema() json.RawMessage {
+	return WithIdempotencyKeyProperty(output.WithOutputProperties(json.RawMessage(TOOL_ATTACH_DOCUMENT_SCHEMA)))
+}
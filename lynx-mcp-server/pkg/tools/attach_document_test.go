@@ -0,0 +1,170 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/idempotency"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/match"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/outbox"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/staging"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// testBooking answers the Lynx calls of attach_document_to_booking for FTSWA230184 and counts uploads and saves
+func testBooking(uploads *int, saved *[]*gwt.TransactionDocumentSaveDetailsArgs, saveErr error) bookingLynx {
+	return bookingLynx{
+		findFile: func(ctx context.Context, fileReference string) (*gwt.FileSearchResult, error) {
+			return &gwt.FileSearchResult{FileReference: "FTSWA230184", FileIdentifier: "$xOpT"}, nil
+		},
+		retrieveItinerary: func(ctx context.Context, fileIdentifier string) (*gwt.RetrieveItineraryResponseArray, error) {
+			return &gwt.RetrieveItineraryResponseArray{Itineraries: []gwt.ItineraryTransactionSummary{
+				{TransactionIdentifier: "BgBFw", Supplier: "CROWNE PLAZA ALICE SPRINGS LASSETERS", Date: "04/05 Oct 2025", VoucherIdentifier: "16454569-4"},
+				{TransactionIdentifier: "BgBFx", Supplier: "ADVENTURE TOURS AUSTRALIA", Date: "05/09 Oct 2025", VoucherIdentifier: "16454569-3"},
+				{TransactionIdentifier: "BgExO", Supplier: "ADVENTURE TOURS AUSTRALIA", Date: "25/31 Oct 2025", VoucherIdentifier: "16454569-2"},
+			}}, nil
+		},
+		upload: func(ctx context.Context, fileIdentifier string) (*upload.Result, error) {
+			*uploads++
+			return &upload.Result{AttachmentURL: fmt.Sprintf("/documents/file/f16476987/d%d.pdf", *uploads), Size: 9}, nil
+		},
+		saveDocument: func(ctx context.Context, args *gwt.TransactionDocumentSaveDetailsArgs) error {
+			if saveErr != nil {
+				return saveErr
+			}
+			*saved = append(*saved, args)
+			return nil
+		},
+	}
+}
+
+func TestAttachDocument(t *testing.T) {
+	tests := []struct {
+		name                string
+		args                attachDocumentArgs
+		saveErr             error
+		expectedStatus      string
+		expectedTransaction string
+		expectedCandidates  int
+		expectedUploads     int
+		expectedSaves       int
+	}{
+		{"attached by voucher", attachDocumentArgs{Hints: match.Hints{Voucher: "569-4"}}, nil, ATTACH_STATUS_ATTACHED, "BgBFw", 0, 1, 1},
+		{"attached by transaction identifier", attachDocumentArgs{TransactionIdentifier: "BgExO"}, nil, ATTACH_STATUS_ATTACHED, "BgExO", 0, 1, 1},
+		{"ambiguous supplier", attachDocumentArgs{Hints: match.Hints{Supplier: "Adventure Tours"}}, nil, ATTACH_STATUS_AMBIGUOUS, "", 2, 0, 0},
+		{"no match", attachDocumentArgs{Hints: match.Hints{Supplier: "Sealink"}}, nil, ATTACH_STATUS_NO_MATCH, "", 0, 0, 0},
+		{"unknown transaction identifier", attachDocumentArgs{TransactionIdentifier: "BgZZZ"}, nil, ATTACH_STATUS_NO_MATCH, "", 0, 0, 0},
+		{"save failing after the upload", attachDocumentArgs{Hints: match.Hints{Voucher: "569-4"}}, utils.ErrUnreachable, ATTACH_STATUS_UPLOADED, "BgBFw", 0, 1, 0},
+		{"uploaded by an earlier attempt", attachDocumentArgs{TransactionIdentifier: "BgBFw", AttachmentURL: "/documents/file/f16476987/d1.pdf"}, nil, ATTACH_STATUS_ATTACHED, "BgBFw", 0, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uploads := 0
+			var saved []*gwt.TransactionDocumentSaveDetailsArgs
+
+			args := tt.args
+			args.FileReference = "FTSWA230184"
+			args.Name = "voucher.pdf"
+			args.Type = "SUPP"
+
+			result, err := attachDocument(context.Background(), testBooking(&uploads, &saved, tt.saveErr), args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if result.Status != tt.expectedStatus || len(result.Candidates) != tt.expectedCandidates {
+				t.Errorf("expected %s with %d candidates, got %s with %d: %s", tt.expectedStatus, tt.expectedCandidates, result.Status, len(result.Candidates), result.Message)
+			}
+			if uploads != tt.expectedUploads || len(saved) != tt.expectedSaves {
+				t.Errorf("expected %d uploads and %d saves, got %d and %d", tt.expectedUploads, tt.expectedSaves, uploads, len(saved))
+			}
+
+			transaction := ""
+			if result.Transaction != nil {
+				transaction = result.Transaction.TransactionIdentifier
+			}
+			if transaction != tt.expectedTransaction {
+				t.Errorf("expected transaction %q, got %q", tt.expectedTransaction, transaction)
+			}
+
+			// The uploaded attachment is reported whether or not the document was saved
+			if tt.expectedUploads > 0 && (result.Document == nil || result.Document.AttachmentURL != "/documents/file/f16476987/d1.pdf") {
+				t.Errorf("expected the uploaded attachment in the result, got %+v", result.Document)
+			}
+			if len(saved) > 0 && (saved[0].TransactionIdentifier != tt.expectedTransaction || saved[0].AttachmentURL != "/documents/file/f16476987/d1.pdf") {
+				t.Errorf("unexpected save: %+v", saved[0])
+			}
+		})
+	}
+}
+
+func TestAttachDocumentFileNotFound(t *testing.T) {
+	uploads := 0
+	booking := testBooking(&uploads, nil, nil)
+	booking.findFile = func(ctx context.Context, fileReference string) (*gwt.FileSearchResult, error) {
+		return nil, fmt.Errorf("%w for file reference %s", lynx.ErrFileNotFound, fileReference)
+	}
+
+	_, err := attachDocument(context.Background(), booking, attachDocumentArgs{FileReference: "FTSWA999999"})
+	if !errors.Is(err, lynx.ErrFileNotFound) || uploads != 0 {
+		t.Errorf("expected ErrFileNotFound without upload, got %v after %d uploads", err, uploads)
+	}
+}
+
+func TestAttachDocumentQueued(t *testing.T) {
+	stagingConfig := config.DefaultStagingConfig()
+	stagingConfig.Directory = t.TempDir()
+	attachments, err := staging.NewStore(stagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := idempotency.NewStore(config.IdempotencyConfig{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outboxConfig := config.OutboxConfig{File: filepath.Join(t.TempDir(), "outbox.db"), MaxAttempts: 3, InitialDelay: time.Hour, MaxDelay: time.Hour}
+	queue, err := outbox.New(outboxConfig, config.DefaultMCPServerConfig(), config.LynxServerConfig{}, lynx.NewAccounts(config.LynxServerConfig{}), attachments, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+
+	// Lynx goes down between the upload and the save, the queued call saves the uploaded attachment
+	uploads := 0
+	handler := queue.Handle(TOOL_ATTACH_DOCUMENT, func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, err := attachDocument(ctx, testBooking(&uploads, nil, fmt.Errorf("%w: dial tcp: connection refused", utils.ErrUnreachable)), attachDocumentArgs{
+			FileReference: "FTSWA230184",
+			Hints:         match.Hints{Voucher: "569-4"},
+			Name:          "voucher.pdf",
+			Type:          "SUPP",
+		})
+		return nil, err
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = TOOL_ATTACH_DOCUMENT
+	request.Params.Arguments = map[string]any{"fileReference": "FTSWA230184", "voucher": "569-4", "attachmentHandle": "stg_1", "type": "SUPP"}
+	if _, err := handler(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := queue.List("")
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 queued call, got %d", len(entries))
+	}
+	arguments := entries[0].Arguments
+	if _, ok := arguments["attachmentHandle"]; ok || arguments["attachmentUrl"] != "/documents/file/f16476987/d1.pdf" || arguments["transactionIdentifier"] != "BgBFw" || arguments["name"] != "voucher.pdf" {
+		t.Errorf("expected the call queued with the uploaded attachment, got %v", arguments)
+	}
+}
@@ -7,12 +7,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime/multipart"
-	"net/http"
-	"strings"
 
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/outbox"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/output"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/staging"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/upload"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 const (
@@ -20,8 +24,12 @@ const (
 	ATTACHMENT_UPLOAD_DESCRIPTION string = "Upload attachment for using with file document"
 	ATTACHMENT_UPLOAD_SCHEMA      string = `{
 		"type": "object",
-		"description": "Upload an attachment for using with file document",
+		"description": "Upload an attachment for using with file document, either from a staged attachment handle or a base64 binary",
 		"properties": {
+			"attachmentHandle": {
+				"type": "string",
+				"description": "Handle of an attachment staged through the /attachments REST endpoint (preferred over binary)"
+			},
 			"binary": {
 				"type": "string",
 				"description": "Base64-encoded binary"
@@ -32,10 +40,10 @@ const (
 			},
 			"fileName": {
 				"type": "string",
-				"description": "File name"
+				"description": "File name (defaults to the staged file name when using attachmentHandle)"
 			}
 		},
-		"required": ["binary", "identifer", "fileName"],
+		"required": ["identifer"],
 		"outputSchema": {
 			"type": "object",
 			"properties": {
@@ -47,137 +55,143 @@ const (
 			"required": ["attachmentUrl"]
 		}
 	}`
-
-	LYNX_ATTACHMENT_UPLOAD_URL string = "/lynx/fileDocumentUpload"
 )
 
-func HandleAttachmentUpload(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
+// Attachments gives tools access to staged attachments and to the upload service
+type Attachments struct {
+	Store   *staging.Store
+	Uploads *upload.Service
+}
 
-	if err != nil {
-		return nil, err
-	}
+// NewAttachmentUploadHandler returns the attachment_upload handler
+func NewAttachmentUploadHandler(lynxConfig config.LynxServerConfig, attachments Attachments) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
 
-	client := &http.Client{}
+		if err != nil {
+			return nil, err
+		}
 
-	arguments := request.GetArguments()
+		arguments := request.GetArguments()
 
-	binaryAsBase64, ok := arguments["binary"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid binary argument: %v", arguments["binary"])
-	}
+		options, err := output.ParseOptions(arguments)
+		if err != nil {
+			return nil, err
+		}
 
-	identifer, ok := arguments["identifer"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid identifier argument: %v", arguments["identifer"])
-	}
+		identifer, ok := arguments["identifer"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid identifier argument: %v", arguments["identifer"])
+		}
 
-	fileName, ok := arguments["fileName"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid file name argument: %v", arguments["fileName"])
+		result, err := attachments.upload(ctx, request, session, identifer)
+		if err != nil {
+			return nil, err
+		}
+
+		return output.NewToolResult(map[string]interface{}{
+			"attachmentUrl": result.AttachmentURL,
+		}, options), nil
 	}
+}
 
-	// Create a buffer to write multipart data
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
+// resolveAttachmentUrl returns the attachmentUrl argument, or uploads the staged attachmentHandle
+// to the given file and returns its URL
+func (a Attachments) resolveAttachmentUrl(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+	session *utils.SessionContext,
+	fileIdentifier string,
+) (string, error) {
+	arguments := request.GetArguments()
 
-	// Add fileId part
-	fileIdField, err := writer.CreateFormField("fileId")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create fileId field: %w", err)
-	}
-	fileIdField.Write([]byte(identifer))
+	attachmentHandle, _ := arguments["attachmentHandle"].(string)
+	attachmentUrl, _ := arguments["attachmentUrl"].(string)
 
-	// Decode base64 data
-	fileData, err := base64.StdEncoding.DecodeString(binaryAsBase64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64 data: %w", err)
+	if attachmentHandle == "" {
+		return attachmentUrl, nil
 	}
 
-	// Add file part
-	fileField, err := writer.CreateFormFile("file", fileName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create file field: %w", err)
+	if attachmentUrl != "" {
+		return "", fmt.Errorf("attachmentUrl and attachmentHandle are mutually exclusive")
 	}
-	fileField.Write(fileData)
 
-	// Close the multipart writer
-	writer.Close()
-
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_ATTACHMENT_UPLOAD_URL), &requestBody)
+	result, err := a.upload(ctx, request, session, fileIdentifier)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create attachment upload request: %w", err)
+		return "", err
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+	// A save queued after the upload uses its URL instead of uploading the attachment again
+	outbox.Done(ctx, map[string]any{outbox.ATTACHMENT_ARGUMENT: nil, "attachmentUrl": result.AttachmentURL})
 
-	// Execute the request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute attachment upload request: %w", err)
-	}
-	defer resp.Body.Close()
+	return result.AttachmentURL, nil
+}
 
-	// Read response body
-	bodyBytes, err := io.ReadAll(resp.Body)
+// upload streams the attachment given in the tool arguments to Lynx, reporting progress to the client
+func (a Attachments) upload(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+	session *utils.SessionContext,
+	fileIdentifier string,
+) (*upload.Result, error) {
+	fileName, size, content, err := a.open(ctx, request.GetArguments())
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
-	bodyStr := string(bodyBytes)
+	defer content.Close()
+
+	return a.Uploads.Upload(ctx, session, upload.Request{
+		FileIdentifier: fileIdentifier,
+		FileName:       fileName,
+		Content:        content,
+		Progress:       utils.NewProgressNotifier(ctx, request, size),
+	})
+}
 
-	// Check if request was successful
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Attachment upload failed with status %d: %s", resp.StatusCode, bodyStr)
-	}
+// open returns the file name, size and content of the attachment given either
+// as attachmentHandle or as base64 binary
+func (a Attachments) open(ctx context.Context, arguments map[string]interface{}) (string, int64, io.ReadCloser, error) {
+	fileName, _ := arguments["fileName"].(string)
 
-	attachmentUrl, err := parseResponseBody(bodyStr)
+	if attachmentHandle, ok := arguments["attachmentHandle"].(string); ok && attachmentHandle != "" {
+		if _, hasBinary := arguments["binary"]; hasBinary {
+			return "", 0, nil, fmt.Errorf("binary and attachmentHandle are mutually exclusive")
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("Invalid attachment upload response: %w", err)
-	}
+		attachment, content, err := a.Store.Open(ctx, attachmentHandle)
+		if err != nil {
+			return "", 0, nil, fmt.Errorf("invalid attachmentHandle argument: %w", err)
+		}
 
-	return &mcp.CallToolResult{
-		Content: []mcp.Content{
-			&mcp.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf(`{"attachmentUrl": "%s"}`, attachmentUrl),
-			},
-		},
-	}, nil
-}
+		if fileName == "" {
+			fileName = attachment.FileName
+		}
 
-// Parse response body to extract the attachment URL
-// Expected format: "SUCCESS:/documents/file/f16476987/d20250708231038.pdf:\n"
-func parseResponseBody(responseBody string) (string, error) {
-	if !strings.HasPrefix(responseBody, "SUCCESS:") {
-		return "", fmt.Errorf("unexpected response format: %s", responseBody)
+		return fileName, attachment.Size, content, nil
 	}
 
-	// Remove "SUCCESS:" prefix
-	urlPart := strings.TrimPrefix(responseBody, "SUCCESS:")
-
-	// Trim whitespace and line breaks
-	urlPart = strings.TrimSpace(urlPart)
+	binaryAsBase64, ok := arguments["binary"].(string)
+	if !ok {
+		return "", 0, nil, fmt.Errorf("invalid binary argument: %v", arguments["binary"])
+	}
 
-	// Ensure response ends with ": " and strip it out
-	if !strings.HasSuffix(urlPart, ":") {
-		return "", fmt.Errorf("response does not end with ':': %s", responseBody)
+	if fileName == "" {
+		return "", 0, nil, fmt.Errorf("invalid file name argument: %v", arguments["fileName"])
 	}
-	urlPart = strings.TrimSuffix(urlPart, ":")
 
-	// Validate that we have a URL path
-	if urlPart == "" || !strings.HasPrefix(urlPart, "/") {
-		return "", fmt.Errorf("invalid attachment URL in response: %s", responseBody)
+	// Decode base64 data
+	fileData, err := base64.StdEncoding.DecodeString(binaryAsBase64)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("failed to decode base64 data: %w", err)
 	}
 
-	return urlPart, nil
+	return fileName, int64(len(fileData)), io.NopCloser(bytes.NewReader(fileData)), nil
 }
 
 // GetAttachmentUploadSchema returns the complete JSON schema for the file search tool
 func GetAttachmentUploadSchema() json.RawMessage {
-	return json.RawMessage(ATTACHMENT_UPLOAD_SCHEMA)
+	return WithIdempotencyKeyProperty(output.WithOutputProperties(json.RawMessage(ATTACHMENT_UPLOAD_SCHEMA)))
 }
@@ -0,0 +1,88 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/audit"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	TOOL_AUDIT_QUERY             string = "audit_query"
+	TOOL_AUDIT_QUERY_DESCRIPTION string = "Search the audit log of changes made to Lynx through this server, newest first"
+	TOOL_AUDIT_QUERY_SCHEMA      string = `{
+		"type": "object",
+		"description": "Search the audit log of changes made to Lynx through this server, newest first",
+		"properties": {
+			"file": {
+				"type": "string",
+				"description": "File reference or file identifier the changes were made to"
+			},
+			"user": {
+				"type": "string",
+				"description": "Identity of the caller, a token name or the user of a JWT or OAuth token"
+			},
+			"from": {
+				"type": "string",
+				"format": "date-time",
+				"description": "Earliest time of the changes, RFC 3339"
+			},
+			"to": {
+				"type": "string",
+				"format": "date-time",
+				"description": "Latest time of the changes, RFC 3339"
+			},
+			"limit": {
+				"type": "integer",
+				"minimum": 1,
+				"maximum": 1000,
+				"description": "Maximum number of entries, 100 by default"
+			}
+		}
+	}`
+)
+
+// NewAuditQueryHandler returns the audit_query handler reading the given audit log
+func NewAuditQueryHandler(auditLog *audit.Log) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		arguments := request.GetArguments()
+
+		filter, err := audit.ParseFilter(func(name string) string {
+			switch value := arguments[name].(type) {
+			case nil:
+				return ""
+			case float64:
+				return strconv.FormatFloat(value, 'f', -1, 64)
+			default:
+				return fmt.Sprint(value)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := auditLog.Query(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		return utils.NewToolResultJSON(map[string]interface{}{
+			"entries": entries,
+			"count":   len(entries),
+		}), nil
+	}
+}
+
+// GetAuditQuerySchema returns the complete JSON schema for the audit query tool
+func GetAuditQuerySchema() json.RawMessage {
+	return json.RawMessage(TOOL_AUDIT_QUERY_SCHEMA)
+}
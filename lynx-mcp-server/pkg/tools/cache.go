@@ -0,0 +1,132 @@
+package tools
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/cache"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// FRESH_ARGUMENT makes a cached tool fetch its result from Lynx, the cache is refreshed with it
+	FRESH_ARGUMENT = "fresh"
+	// FRESH_PROPERTY_SCHEMA describes FRESH_ARGUMENT in the schemas of the cached tools
+	FRESH_PROPERTY_SCHEMA = `{
+		"type": "boolean",
+		"description": "Fetch from Lynx instead of returning a recently cached result, e.g. after a change made directly in Lynx"
+	}`
+	// CACHE_META_KEY holds in the result metadata whether the result came from the cache
+	CACHE_META_KEY = "cache"
+)
+
+// NewCacheMiddleware answers the calls of the tools with a TTL from the cache, by Lynx account and arguments. The
+// result metadata tells whether the result came from the cache and how old it is. It only wraps read tools, a
+// write answered from the cache would never reach Lynx.
+func NewCacheMiddleware(results *cache.Cache, cacheConfig config.CacheConfig, lynxConfig config.LynxServerConfig) server.ToolHandlerMiddleware {
+	return func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			tool := request.Params.Name
+			ttl := cacheConfig.TTLs[tool]
+			if ttl <= 0 {
+				return next(ctx, request)
+			}
+
+			arguments := request.GetArguments()
+			key, err := cacheKey(ctx, lynxConfig, tool, arguments)
+			if err != nil {
+				return next(ctx, request)
+			}
+
+			now := time.Now()
+			status := metrics.CACHE_BYPASS
+			if fresh, _ := arguments[FRESH_ARGUMENT].(bool); !fresh {
+				status = metrics.CACHE_MISS
+				if entry, ok := results.Get(key, now); ok {
+					result, err := mcp.ParseCallToolResult(&entry.Result)
+					if err == nil {
+						metrics.ToolCache.WithLabelValues(tool, metrics.CACHE_HIT).Inc()
+						return withCacheMeta(result, true, entry.StoredAt, now), nil
+					}
+				}
+			}
+			metrics.ToolCache.WithLabelValues(tool, status).Inc()
+
+			// A save landing while the result is fetched invalidates the file before the result could be stored
+			generation := results.Generation()
+			result, err := next(ctx, request)
+			if err != nil || result == nil || result.IsError {
+				return result, err
+			}
+
+			encoded, err := json.Marshal(result)
+			if err == nil {
+				results.PutSince(key, cache.Entry{
+					Result:    encoded,
+					Files:     cachedFiles(arguments),
+					StoredAt:  now,
+					ExpiresAt: now.Add(ttl),
+				}, generation)
+			}
+			return withCacheMeta(result, false, now, now), nil
+		}
+	}
+}
+
+// WithFreshProperty adds FRESH_ARGUMENT to the schema of a cached tool
+func WithFreshProperty(schema json.RawMessage) json.RawMessage {
+	return withProperty(schema, FRESH_ARGUMENT, FRESH_PROPERTY_SCHEMA)
+}
+
+// cacheKey identifies a call by the Lynx account it runs as, its tool and its arguments but FRESH_ARGUMENT:
+// consultants don't get results fetched with the rights of another account
+func cacheKey(ctx context.Context, lynxConfig config.LynxServerConfig, tool string, arguments map[string]any) (string, error) {
+	username := lynxConfig.Username
+	if credentials, ok := utils.CredentialsFromContext(ctx); ok {
+		username = credentials.Username
+	}
+
+	keyed := make(map[string]any, len(arguments))
+	for name, value := range arguments {
+		if name != FRESH_ARGUMENT {
+			keyed[name] = value
+		}
+	}
+	// Map keys are marshalled sorted, the same arguments give the same key
+	encoded, err := json.Marshal(keyed)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(username + "\x00" + tool + "\x00" + string(encoded)))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cachedFiles lists the files a result describes, it's dropped once they change
+func cachedFiles(arguments map[string]any) []string {
+	if fileIdentifier, ok := arguments["fileIdentifier"].(string); ok && fileIdentifier != "" {
+		return []string{fileIdentifier}
+	}
+	return nil
+}
+
+func withCacheMeta(result *mcp.CallToolResult, hit bool, storedAt time.Time, now time.Time) *mcp.CallToolResult {
+	if result.Meta == nil {
+		result.Meta = make(map[string]any)
+	}
+	meta := map[string]any{"hit": hit}
+	if hit {
+		meta["storedAt"] = storedAt.UTC().Format(time.RFC3339)
+		meta["ageSeconds"] = int(now.Sub(storedAt).Seconds())
+	}
+	result.Meta[CACHE_META_KEY] = meta
+	return result
+}
@@ -1,7 +1,50 @@
 package tools
 
 import (
-	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"encoding/json"
+
+	"github.com/mark3labs/mcp-go/mcp"
 )
 
-var lynxConfig = config.NewLynxServerConfig()
+// ReadOnlyToolAnnotation describes a tool that only reads from Lynx
+func ReadOnlyToolAnnotation(title string) mcp.ToolAnnotation {
+	return mcp.ToolAnnotation{
+		Title:           title,
+		ReadOnlyHint:    mcp.ToBoolPtr(true),
+		DestructiveHint: mcp.ToBoolPtr(false),
+		IdempotentHint:  mcp.ToBoolPtr(true),
+		OpenWorldHint:   mcp.ToBoolPtr(true),
+	}
+}
+
+// WriteToolAnnotation describes a tool that modifies a live booking in Lynx
+func WriteToolAnnotation(title string, destructive bool, idempotent bool) mcp.ToolAnnotation {
+	return mcp.ToolAnnotation{
+		Title:           title,
+		ReadOnlyHint:    mcp.ToBoolPtr(false),
+		DestructiveHint: mcp.ToBoolPtr(destructive),
+		IdempotentHint:  mcp.ToBoolPtr(idempotent),
+		OpenWorldHint:   mcp.ToBoolPtr(true),
+	}
+}
+
+// withProperty adds a property shared by several tools to the schema of a tool
+func withProperty(schema json.RawMessage, name string, propertySchema string) json.RawMessage {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(schema, &decoded); err != nil {
+		return schema
+	}
+
+	properties, ok := decoded["properties"].(map[string]interface{})
+	if !ok {
+		properties = make(map[string]interface{})
+		decoded["properties"] = properties
+	}
+	properties[name] = json.RawMessage(propertySchema)
+
+	encoded, err := json.Marshal(decoded)
+	if err != nil {
+		return schema
+	}
+	return json.RawMessage(encoded)
+}
@@ -0,0 +1,70 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/confirm"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	TOOL_CONFIRM_ACTION             string = "confirm_action"
+	TOOL_CONFIRM_ACTION_DESCRIPTION string = "Run a pending write action once a person has approved its preview outside this conversation, or reject it"
+	TOOL_CONFIRM_ACTION_SCHEMA      string = `{
+		"type": "object",
+		"description": "Run a pending write action once a person has approved it through /actions, or reject it",
+		"properties": {
+			"actionId": {
+				"type": "string",
+				"description": "Pending action identifier returned by the write tool"
+			},
+			"reject": {
+				"type": "boolean",
+				"description": "Discard the pending action instead of running it"
+			}
+		},
+		"required": ["actionId"]
+	}`
+)
+
+// NewConfirmActionHandler returns the confirm_action handler settling actions held in the given store
+func NewConfirmActionHandler(store *confirm.Store) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		arguments := request.GetArguments()
+
+		actionID, ok := arguments["actionId"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid actionId argument: %v", arguments["actionId"])
+		}
+
+		reject, _ := arguments["reject"].(bool)
+
+		if reject {
+			action, err := store.Reject(ctx, actionID)
+			if err != nil {
+				return nil, err
+			}
+
+			return utils.NewToolResultJSON(map[string]interface{}{
+				"status":   "rejected",
+				"actionId": action.ID,
+				"toolName": action.ToolName,
+			}), nil
+		}
+
+		return store.Confirm(ctx, actionID)
+	}
+}
+
+// GetConfirmActionSchema returns the complete JSON schema for the confirm action tool
+func GetConfirmActionSchema() json.RawMessage {
+	return json.RawMessage(TOOL_CONFIRM_ACTION_SCHEMA)
+}
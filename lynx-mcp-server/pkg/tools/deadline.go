@@ -0,0 +1,42 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// NewDeadlineMiddleware bounds each tool call by the timeout of its tool. A call failing once its deadline passed
+// or its context was canceled reports it, rather than the Lynx request it interrupted.
+// confirm_action is left to the deadline of the action it confirms, applied by the pending action store.
+func NewDeadlineMiddleware(serverConfig config.MCPServerConfig) server.ToolHandlerMiddleware {
+	return func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if request.Params.Name == TOOL_CONFIRM_ACTION {
+				return next(ctx, request)
+			}
+
+			timeout := serverConfig.Timeout(request.Params.Name)
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			result, err := next(ctx, request)
+			if err == nil || ctx.Err() == nil {
+				return result, err
+			}
+
+			if !errors.Is(err, ctx.Err()) {
+				err = fmt.Errorf("%w: %w", ctx.Err(), err)
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%s timed out after %s: %w", request.Params.Name, timeout, err)
+			}
+			return nil, fmt.Errorf("%s canceled: %w", request.Params.Name, err)
+		}
+	}
+}
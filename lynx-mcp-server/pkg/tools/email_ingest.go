@@ -0,0 +1,213 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/email"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/outbox"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/output"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	TOOL_EMAIL_INGEST             string = "email_ingest"
+	TOOL_EMAIL_INGEST_DESCRIPTION string = "File a supplier email against its booking: finds the file and transaction referenced in the email, uploads its attachments and saves the email text as a document"
+	TOOL_EMAIL_INGEST_SCHEMA      string = `{
+		"type": "object",
+		"description": "File a supplier email against its booking",
+		"properties": {
+			"eml": {
+				"type": "string",
+				"description": "Raw RFC 822 message (.eml content)"
+			},
+			"attachmentHandle": {
+				"type": "string",
+				"description": "Handle of a staged .eml file to use instead of eml"
+			},
+			"fileReference": {
+				"type": "string",
+				"description": "File reference to use instead of the one found in the email"
+			},
+			"transactionIdentifier": {
+				"type": "string",
+				"description": "Transaction identifier to use instead of matching vouchers and confirmation numbers"
+			},
+			"documentType": {
+				"type": "string",
+				"description": "Document type of the saved documents, defaults to EMAIL"
+			},
+			"filedDocuments": {
+				"type": "integer",
+				"description": "Number of documents an earlier attempt filed, the note and then the attachments in order, they are not filed again. Set by the outbox"
+			},
+			"uploadedAttachmentUrl": {
+				"type": "string",
+				"description": "Attachment URL an earlier attempt uploaded for the next document, it is not uploaded again. Set by the outbox"
+			}
+		},
+		"outputSchema": {
+			"type": "object",
+			"properties": {
+				"subject": {
+					"type": "string",
+					"description": "Email subject"
+				},
+				"from": {
+					"type": "string",
+					"description": "Email sender"
+				},
+				"fileReference": {
+					"type": "string",
+					"description": "File reference the email was filed against"
+				},
+				"fileIdentifier": {
+					"type": "string",
+					"description": "File identifier the email was filed against"
+				},
+				"transactionIdentifier": {
+					"type": "string",
+					"description": "Transaction identifier, empty when the email was filed at file level"
+				},
+				"matchedBy": {
+					"type": "string",
+					"description": "How the transaction was found: voucher, confirmationNumber, argument, ambiguous when several transactions match, or none"
+				},
+				"candidates": {
+					"type": "array",
+					"items": {
+						"type": "string"
+					},
+					"description": "Transactions matching the email when it could not be filed against a single one"
+				},
+				"warning": {
+					"type": "string",
+					"description": "Set when several transactions match and the email was filed at file level instead"
+				},
+				"documents": {
+					"type": "array",
+					"items": {
+						"type": "object",
+						"properties": {
+							"name": {
+								"type": "string",
+								"description": "Document name"
+							},
+							"type": {
+								"type": "string",
+								"description": "Document type"
+							},
+							"attachmentUrl": {
+								"type": "string",
+								"description": "Attachment URL of uploaded attachments"
+							},
+							"size": {
+								"type": "integer",
+								"description": "Attachment size in bytes"
+							}
+						},
+						"required": ["name", "type"]
+					}
+				},
+				"error": {
+					"type": "string",
+					"description": "Set when filing stopped part way, documents then lists what was filed before the failure"
+				}
+			},
+			"required": ["subject", "from", "fileReference", "fileIdentifier", "matchedBy", "documents"]
+		}
+	}`
+)
+
+// NewEmailIngestHandler returns the email_ingest handler
+func NewEmailIngestHandler(lynxConfig config.LynxServerConfig, attachments Attachments) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
+
+		if err != nil {
+			return nil, err
+		}
+
+		arguments := request.GetArguments()
+
+		options, err := output.ParseOptions(arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := attachments.openEmail(ctx, arguments)
+		if err != nil {
+			return nil, err
+		}
+		defer raw.Close()
+
+		message, err := email.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		defer message.Close()
+
+		fileReference, _ := arguments["fileReference"].(string)
+		transactionIdentifier, _ := arguments["transactionIdentifier"].(string)
+		documentType, _ := arguments["documentType"].(string)
+		filed, _ := arguments[email.FILED_DOCUMENTS_ARGUMENT].(float64)
+		uploadedAttachmentURL, _ := arguments[email.UPLOADED_ATTACHMENT_URL_ARGUMENT].(string)
+
+		result, err := email.NewIngester(lynxConfig, attachments.Uploads).Ingest(ctx, session, message, email.Ingestion{
+			FileReference:         fileReference,
+			TransactionIdentifier: transactionIdentifier,
+			DocumentType:          documentType,
+			Filed:                 int(filed),
+			UploadedAttachmentURL: uploadedAttachmentURL,
+		})
+		// A queued email files the documents left once Lynx is back
+		if err != nil && (result == nil || outbox.Queues(ctx) && outbox.Queueable(err)) {
+			return nil, err
+		}
+
+		// Documents filed before a failure are reported, the call is still an error
+		rendered := output.NewToolResult(result, options)
+		if err != nil {
+			rendered.IsError = true
+		}
+		return rendered, nil
+	}
+}
+
+func (a Attachments) openEmail(ctx context.Context, arguments map[string]interface{}) (io.ReadCloser, error) {
+	eml, _ := arguments["eml"].(string)
+	attachmentHandle, _ := arguments["attachmentHandle"].(string)
+
+	if eml != "" && attachmentHandle != "" {
+		return nil, fmt.Errorf("eml and attachmentHandle are mutually exclusive")
+	}
+
+	if attachmentHandle != "" {
+		_, content, err := a.Store.Open(ctx, attachmentHandle)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attachmentHandle argument: %w", err)
+		}
+		return content, nil
+	}
+
+	if eml == "" {
+		return nil, fmt.Errorf("either eml or attachmentHandle is required")
+	}
+
+	return io.NopCloser(strings.NewReader(eml)), nil
+}
+
+// GetEmailIngestSchema returns the complete JSON schema for the email ingest tool
+func GetEmailIngestSchema() json.RawMessage {
+	return WithIdempotencyKeyProperty(output.WithOutputProperties(json.RawMessage(TOOL_EMAIL_INGEST_SCHEMA)))
+}
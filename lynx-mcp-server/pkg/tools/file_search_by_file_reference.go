@@ -4,13 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"strings"
 
-	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/output"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
 
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 const (
@@ -82,59 +83,42 @@ const (
 			"required": ["count", "results"]
 		}
 	}`
-
-	LYNX_FILE_SEARCH_BY_FILE_REFERENCE_URL string = "/lynx/service/file.rpc"
 )
 
-func HandleFileSearchByFileReference(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
-
-	if err != nil {
-		return nil, err
-	}
-
-	client := &http.Client{}
+// NewFileSearchByFileReferenceHandler returns the file_search_by_file_reference handler
+func NewFileSearchByFileReferenceHandler(lynxConfig config.LynxServerConfig) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
 
-	arguments := request.GetArguments()
-
-	fileReference, ok := arguments["fileReference"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid file reference argument: %v", arguments["fileReference"])
-	}
+		if err != nil {
+			return nil, err
+		}
 
-	body := gwt.BuildFileSearchByFileReferenceGWTBody(&gwt.FileSearchByFileReferenceArgs{
-		RemoteHost:    lynxConfig.RemoteHost,
-		FileReference: fileReference,
-	})
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_SEARCH_BY_FILE_REFERENCE_URL), strings.NewReader(body))
+		arguments := request.GetArguments()
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to create file search request: %w", err)
-	}
+		options, err := output.ParseOptions(arguments)
+		if err != nil {
+			return nil, err
+		}
 
-	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
-	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+		fileReference, ok := arguments["fileReference"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid file reference argument: %v", arguments["fileReference"])
+		}
 
-	// Use retry utility with exponential backoff
-	resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute file search request after retries: %w", err)
-	}
-	defer resp.Body.Close()
+		fileSearchResponseBody, err := lynx.SearchFilesByFileReference(ctx, lynxConfig, session, fileReference)
+		if err != nil {
+			return nil, err
+		}
 
-	// Parse the GWT response body
-	fileSearchResponseBody, err := gwt.ParseFileSearchResponseBody(bodyStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse file search response: %w", err)
+		return output.NewToolResult(fileSearchResponseBody, options), nil
 	}
-
-	return utils.NewToolResultJSON(fileSearchResponseBody), nil
 }
 
 // GetFileSearchByFileReferenceSchema returns the complete JSON schema for the file search by file reference tool
 func GetFileSearchByFileReferenceSchema() json.RawMessage {
-	return json.RawMessage(TOOL_FILE_SEARCH_BY_FILE_REFERENCE_SCHEMA)
+	return WithFreshProperty(output.WithOutputProperties(json.RawMessage(TOOL_FILE_SEARCH_BY_FILE_REFERENCE_SCHEMA)))
 }
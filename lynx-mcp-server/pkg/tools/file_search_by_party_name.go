@@ -7,10 +7,13 @@ import (
 	"net/http"
 	"strings"
 
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/output"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
 
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 const (
@@ -86,55 +89,63 @@ const (
 	LYNX_FILE_SEARCH_BY_PARTY_NAME_URL string = "/lynx/service/file.rpc"
 )
 
-func HandleFileSearchByPartyName(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
+// NewFileSearchByPartyNameHandler returns the file_search_by_party_name handler
+func NewFileSearchByPartyNameHandler(lynxConfig config.LynxServerConfig) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
 
-	if err != nil {
-		return nil, err
-	}
+		if err != nil {
+			return nil, err
+		}
 
-	client := &http.Client{}
+		client := utils.NewHTTPClient()
 
-	arguments := request.GetArguments()
+		arguments := request.GetArguments()
 
-	partyName, ok := arguments["partyName"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid partyName argument: %v", arguments["partyName"])
-	}
+		options, err := output.ParseOptions(arguments)
+		if err != nil {
+			return nil, err
+		}
 
-	body := gwt.BuildFileSearchByPartyNameGWTBody(&gwt.FileSearchByPartyNameArgs{
-		RemoteHost: lynxConfig.RemoteHost,
-		PartyName:  partyName,
-	})
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_SEARCH_BY_PARTY_NAME_URL), strings.NewReader(body))
+		partyName, ok := arguments["partyName"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid partyName argument: %v", arguments["partyName"])
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to create file search request: %w", err)
-	}
+		body := gwt.BuildFileSearchByPartyNameGWTBody(&gwt.FileSearchByPartyNameArgs{
+			RemoteHost: lynxConfig.RemoteHost,
+			PartyName:  partyName,
+		})
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_SEARCH_BY_PARTY_NAME_URL), strings.NewReader(body))
 
-	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
-	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file search request: %w", err)
+		}
 
-	// Use retry utility with exponential backoff
-	resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute file search request after retries: %w", err)
-	}
-	defer resp.Body.Close()
+		req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
+		req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
 
-	// Parse the GWT response body
-	fileSearchResponseBody, err := gwt.ParseFileSearchResponseBody(bodyStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse  file search response: %w", err)
-	}
+		// Use retry utility with exponential backoff
+		resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, &lynxConfig.Retry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute file search request after retries: %w", err)
+		}
+		defer resp.Body.Close()
 
-	return utils.NewToolResultJSON(fileSearchResponseBody), nil
+		// Parse the GWT response body
+		fileSearchResponseBody, err := utils.ParseResponse(ctx, body, bodyStr, gwt.ParseFileSearchResponseBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse  file search response: %w", err)
+		}
+
+		return output.NewToolResult(fileSearchResponseBody, options), nil
+	}
 }
 
 // GetFileSearchByPartyNameSchema returns the complete JSON schema for the file search tool
 func GetFileSearchByPartyNameSchema() json.RawMessage {
-	return json.RawMessage(TOOL_FILE_SEARCH_BY_PARTY_NAME_SCHEMA)
+	return WithFreshProperty(output.WithOutputProperties(json.RawMessage(TOOL_FILE_SEARCH_BY_PARTY_NAME_SCHEMA)))
 }
@@ -0,0 +1,128 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/idempotency"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/staging"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// IDEMPOTENCY_KEY_ARGUMENT makes a write tool return the result of its earlier call with the same key
+	IDEMPOTENCY_KEY_ARGUMENT = "idempotencyKey"
+	// IDEMPOTENCY_KEY_PROPERTY_SCHEMA describes IDEMPOTENCY_KEY_ARGUMENT in the schemas of the write tools
+	IDEMPOTENCY_KEY_PROPERTY_SCHEMA = `{
+		"type": "string",
+		"description": "Unique key of this change, e.g. a workflow execution ID. Calls repeating it return the result of the first one instead of changing Lynx again, it can't be reused for another change."
+	}`
+	// IDEMPOTENCY_META_KEY holds in the result metadata whether the result was replayed
+	IDEMPOTENCY_META_KEY = "idempotency"
+)
+
+// idempotencyIgnoredArguments don't change what a call does in Lynx, only how its result is rendered: a retry
+// changing them still repeats the same change
+var idempotencyIgnoredArguments = []string{IDEMPOTENCY_KEY_ARGUMENT, "format", "fields", "contentFormat", "maxContentLength"}
+
+// NewIdempotencyMiddleware runs a write tool once per idempotency key of a Lynx account, calls repeating the key
+// with the same arguments get the result of the first successful one, or of the queued one once it was applied. Calls
+// without a key run as usual. Staged attachments are identified by their content, a retry staging the same file
+// again gets another handle.
+func NewIdempotencyMiddleware(keys *idempotency.Store, attachments *staging.Store, lynxConfig config.LynxServerConfig) server.ToolHandlerMiddleware {
+	return func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			tool := request.Params.Name
+			arguments := request.GetArguments()
+			key, _ := arguments[IDEMPOTENCY_KEY_ARGUMENT].(string)
+			if key == "" {
+				return next(ctx, request)
+			}
+
+			fingerprint, err := argumentsFingerprint(ctx, attachments, tool, arguments)
+			if err != nil {
+				return nil, err
+			}
+
+			key = idempotency.AccountKey(ctx, lynxConfig, key)
+			record, err := keys.Begin(ctx, key, fingerprint)
+			if err != nil {
+				if errors.Is(err, idempotency.ErrKeyReused) {
+					metrics.IdempotentCalls.WithLabelValues(tool, metrics.IDEMPOTENCY_REJECTED).Inc()
+				}
+				return nil, fmt.Errorf("invalid %s argument: %w", IDEMPOTENCY_KEY_ARGUMENT, err)
+			}
+			if record != nil {
+				result, err := mcp.ParseCallToolResult(&record.Result)
+				if err != nil {
+					return nil, fmt.Errorf("failed to replay result: %w", err)
+				}
+				metrics.IdempotentCalls.WithLabelValues(tool, metrics.IDEMPOTENCY_REPLAYED).Inc()
+				return withIdempotencyMeta(result, true, record.StoredAt), nil
+			}
+			metrics.IdempotentCalls.WithLabelValues(tool, metrics.IDEMPOTENCY_NEW).Inc()
+
+			var stored json.RawMessage
+			defer func() { keys.Finish(key, fingerprint, stored) }()
+
+			// A call queued in the outbox keeps the key, its queued result is replaced once it was applied
+			result, err := next(idempotency.WithClaim(ctx, idempotency.Claim{Key: key, Fingerprint: fingerprint}), request)
+			if err != nil || result == nil || result.IsError {
+				return result, err
+			}
+
+			// A result that can't be encoded is not replayed, the key stays free
+			stored, _ = json.Marshal(result)
+			return withIdempotencyMeta(result, false, time.Now()), nil
+		}
+	}
+}
+
+// WithIdempotencyKeyProperty adds IDEMPOTENCY_KEY_ARGUMENT to the schema of a write tool
+func WithIdempotencyKeyProperty(schema json.RawMessage) json.RawMessage {
+	return withProperty(schema, IDEMPOTENCY_KEY_ARGUMENT, IDEMPOTENCY_KEY_PROPERTY_SCHEMA)
+}
+
+// argumentsFingerprint identifies the payload of a call by its tool and its arguments but idempotencyIgnoredArguments,
+// the staged attachment by its SHA-256 instead of its handle
+func argumentsFingerprint(ctx context.Context, attachments *staging.Store, tool string, arguments map[string]any) (string, error) {
+	payload := make(map[string]any, len(arguments))
+	for name, value := range arguments {
+		if !slices.Contains(idempotencyIgnoredArguments, name) {
+			payload[name] = value
+		}
+	}
+	if handle, _ := payload["attachmentHandle"].(string); handle != "" {
+		staged, err := attachments.Get(ctx, handle)
+		if err != nil {
+			return "", fmt.Errorf("invalid attachmentHandle argument: %w", err)
+		}
+		payload["attachmentHandle"] = "sha256:" + staged.SHA256
+	}
+	// Map keys are marshalled sorted, the same arguments give the same fingerprint
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode arguments: %w", err)
+	}
+	return idempotency.Fingerprint(tool, string(encoded)), nil
+}
+
+func withIdempotencyMeta(result *mcp.CallToolResult, replayed bool, storedAt time.Time) *mcp.CallToolResult {
+	if result.Meta == nil {
+		result.Meta = make(map[string]any)
+	}
+	meta := map[string]any{"replayed": replayed}
+	if replayed {
+		meta["storedAt"] = storedAt.UTC().Format(time.RFC3339)
+	}
+	result.Meta[IDEMPOTENCY_META_KEY] = meta
+	return result
+}
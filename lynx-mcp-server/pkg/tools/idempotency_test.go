@@ -0,0 +1,61 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/staging"
+)
+
+func TestArgumentsFingerprint(t *testing.T) {
+	stagingConfig := config.DefaultStagingConfig()
+	stagingConfig.Directory = t.TempDir()
+	attachments, err := staging.NewStore(stagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// The same file staged twice by a retry gets two handles
+	stage := func(content string) string {
+		staged, err := attachments.Put(ctx, "voucher.pdf", "application/pdf", strings.NewReader(content), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return staged.Handle
+	}
+	first, retried, other := stage("voucher"), stage("voucher"), stage("invoice")
+
+	fingerprint := func(arguments map[string]any) string {
+		fingerprint, err := argumentsFingerprint(ctx, attachments, TOOL_FILE_DOCUMENT_SAVE, arguments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fingerprint
+	}
+	original := fingerprint(map[string]any{"fileIdentifier": "1", "attachmentHandle": first, IDEMPOTENCY_KEY_ARGUMENT: "a"})
+
+	tests := []struct {
+		name      string
+		arguments map[string]any
+		wantSame  bool
+	}{
+		{"same file staged again", map[string]any{"fileIdentifier": "1", "attachmentHandle": retried, IDEMPOTENCY_KEY_ARGUMENT: "a"}, true},
+		{"other output arguments", map[string]any{"fileIdentifier": "1", "attachmentHandle": retried, "format": "markdown", "fields": []any{"status"}}, true},
+		{"other file", map[string]any{"fileIdentifier": "1", "attachmentHandle": other}, false},
+		{"other booking file", map[string]any{"fileIdentifier": "2", "attachmentHandle": first}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := fingerprint(tt.arguments) == original; same != tt.wantSame {
+				t.Errorf("same fingerprint = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+
+	if _, err := argumentsFingerprint(ctx, attachments, TOOL_FILE_DOCUMENT_SAVE, map[string]any{"attachmentHandle": "unknown"}); err == nil {
+		t.Errorf("expected an error for an unknown attachmentHandle")
+	}
+}
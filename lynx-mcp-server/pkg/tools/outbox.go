@@ -0,0 +1,194 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/auth"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/outbox"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	TOOL_OUTBOX_LIST             string = "outbox_list"
+	TOOL_OUTBOX_LIST_DESCRIPTION string = "List the changes queued while Lynx was unreachable, waiting to be applied or failed"
+	TOOL_OUTBOX_LIST_SCHEMA      string = `{
+		"type": "object",
+		"description": "List the changes queued while Lynx was unreachable, oldest first",
+		"properties": {
+			"status": {
+				"type": "string",
+				"enum": ["pending", "running", "failed"],
+				"description": "Only list the changes with this status: pending (waiting for the next attempt), running or failed (waiting for outbox_retry or outbox_cancel)"
+			}
+		}
+	}`
+
+	TOOL_OUTBOX_RETRY             string = "outbox_retry"
+	TOOL_OUTBOX_RETRY_DESCRIPTION string = "Apply a queued change again now, e.g. once the reason it failed was fixed in Lynx"
+	TOOL_OUTBOX_RETRY_SCHEMA      string = `{
+		"type": "object",
+		"description": "Apply a queued change again now",
+		"properties": {
+			"outboxId": {
+				"type": "string",
+				"description": "Identifier of the queued change, from outbox_list"
+			}
+		},
+		"required": ["outboxId"]
+	}`
+
+	TOOL_OUTBOX_CANCEL             string = "outbox_cancel"
+	TOOL_OUTBOX_CANCEL_DESCRIPTION string = "Remove a queued change without applying it to Lynx"
+	TOOL_OUTBOX_CANCEL_SCHEMA      string = `{
+		"type": "object",
+		"description": "Remove a queued change without applying it to Lynx",
+		"properties": {
+			"outboxId": {
+				"type": "string",
+				"description": "Identifier of the queued change, from outbox_list"
+			}
+		},
+		"required": ["outboxId"]
+	}`
+
+	// OUTBOX_PREVIEW_MAX_LENGTH is the number of characters of the string arguments listed, emails and contents are
+	// long
+	OUTBOX_PREVIEW_MAX_LENGTH = 200
+)
+
+// NewOutboxListHandler returns the outbox_list handler, callers only see their own changes unless they are admins
+func NewOutboxListHandler(queue *outbox.Outbox) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		status, _ := request.GetArguments()["status"].(string)
+
+		entries, err := queue.List(outboxOwner(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		listed := []map[string]interface{}{}
+		for _, entry := range entries {
+			if status == "" || entry.Status == status {
+				listed = append(listed, outboxPreview(entry))
+			}
+		}
+
+		return utils.NewToolResultJSON(map[string]interface{}{
+			"entries": listed,
+			"count":   len(listed),
+		}), nil
+	}
+}
+
+// NewOutboxRetryHandler returns the outbox_retry handler
+func NewOutboxRetryHandler(queue *outbox.Outbox) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		outboxID, err := outboxIDArgument(request)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := queue.Retry(outboxID, outboxOwner(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to retry %s: %w", outboxID, err)
+		}
+
+		return utils.NewToolResultJSON(outboxPreview(*entry)), nil
+	}
+}
+
+// NewOutboxCancelHandler returns the outbox_cancel handler
+func NewOutboxCancelHandler(queue *outbox.Outbox) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		outboxID, err := outboxIDArgument(request)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := queue.Cancel(outboxID, outboxOwner(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to cancel %s: %w", outboxID, err)
+		}
+
+		return utils.NewToolResultJSON(map[string]interface{}{
+			"status":   "canceled",
+			"outboxId": entry.ID,
+			"tool":     entry.Tool,
+		}), nil
+	}
+}
+
+// outboxOwner returns the identity whose queued changes the caller manages, empty for admins who manage them all
+func outboxOwner(ctx context.Context) string {
+	if token, ok := auth.TokenFromContext(ctx); ok && token.HasScope(auth.SCOPE_ADMIN) {
+		return ""
+	}
+	return auth.IdentityFromContext(ctx)
+}
+
+func outboxIDArgument(request mcp.CallToolRequest) (string, error) {
+	arguments := request.GetArguments()
+	outboxID, ok := arguments["outboxId"].(string)
+	if !ok || outboxID == "" {
+		return "", fmt.Errorf("invalid outboxId argument: %v", arguments["outboxId"])
+	}
+	return outboxID, nil
+}
+
+// outboxPreview describes a queued change without overly long arguments
+func outboxPreview(entry outbox.Entry) map[string]interface{} {
+	arguments := make(map[string]interface{}, len(entry.Arguments))
+	for name, value := range entry.Arguments {
+		if text, ok := value.(string); ok && len([]rune(text)) > OUTBOX_PREVIEW_MAX_LENGTH {
+			value = string([]rune(text)[:OUTBOX_PREVIEW_MAX_LENGTH]) + "..."
+		}
+		arguments[name] = value
+	}
+
+	preview := map[string]interface{}{
+		"outboxId":      entry.ID,
+		"tool":          entry.Tool,
+		"arguments":     arguments,
+		"identity":      entry.Identity,
+		"status":        entry.Status,
+		"attempts":      entry.Attempts,
+		"createdAt":     entry.CreatedAt,
+		"nextAttemptAt": entry.NextAttemptAt,
+	}
+	if entry.LastError != "" {
+		preview["lastError"] = entry.LastError
+	}
+	if entry.Attachment != nil {
+		preview["attachment"] = entry.Attachment
+	}
+	return preview
+}
+
+// GetOutboxListSchema returns the complete JSON schema for the outbox list tool
+func GetOutboxListSchema() json.RawMessage {
+	return json.RawMessage(TOOL_OUTBOX_LIST_SCHEMA)
+}
+
+// GetOutboxRetrySchema returns the complete JSON schema for the outbox retry tool
+func GetOutboxRetrySchema() json.RawMessage {
+	return json.RawMessage(TOOL_OUTBOX_RETRY_SCHEMA)
+}
+
+// GetOutboxCancelSchema returns the complete JSON schema for the outbox cancel tool
+func GetOutboxCancelSchema() json.RawMessage {
+	return json.RawMessage(TOOL_OUTBOX_CANCEL_SCHEMA)
+}
@@ -7,9 +7,12 @@ import (
 	"net/http"
 	"strings"
 
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/output"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 const (
@@ -81,61 +84,69 @@ const (
 	LYNX_FILE_DOCUMENTS_BY_TRANSACTION_REFERENCE_URL string = "/lynx/service/file.rpc"
 )
 
-func HandleRetrieveFileDocuments(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
+// NewRetrieveFileDocumentsHandler returns the retrieve_file_documents handler
+func NewRetrieveFileDocumentsHandler(lynxConfig config.LynxServerConfig) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
 
-	if err != nil {
-		return nil, err
-	}
+		if err != nil {
+			return nil, err
+		}
 
-	client := &http.Client{}
+		client := utils.NewHTTPClient()
 
-	arguments := request.GetArguments()
+		arguments := request.GetArguments()
 
-	fileIdentifier, ok := arguments["fileIdentifier"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid file identifier argument: %v", arguments["fileIdentifier"])
-	}
+		options, err := output.ParseOptions(arguments)
+		if err != nil {
+			return nil, err
+		}
 
-	transactionIdentifier, ok := arguments["transactionIdentifier"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid transaction identifier argument: %v", arguments["transactionIdentifier"])
-	}
+		fileIdentifier, ok := arguments["fileIdentifier"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid file identifier argument: %v", arguments["fileIdentifier"])
+		}
 
-	body := gwt.BuildFileDocumentsByTransactionReferenceGWTBody(&gwt.FileDocumentsByTransactionReferenceArgs{
-		RemoteHost:            lynxConfig.RemoteHost,
-		FileIdentifier:        fileIdentifier,
-		TransactionIdentifier: transactionIdentifier,
-	})
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_DOCUMENTS_BY_TRANSACTION_REFERENCE_URL), strings.NewReader(body))
+		transactionIdentifier, ok := arguments["transactionIdentifier"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid transaction identifier argument: %v", arguments["transactionIdentifier"])
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to create file search request: %w", err)
-	}
+		body := gwt.BuildFileDocumentsByTransactionReferenceGWTBody(&gwt.FileDocumentsByTransactionReferenceArgs{
+			RemoteHost:            lynxConfig.RemoteHost,
+			FileIdentifier:        fileIdentifier,
+			TransactionIdentifier: transactionIdentifier,
+		})
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_DOCUMENTS_BY_TRANSACTION_REFERENCE_URL), strings.NewReader(body))
 
-	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
-	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file search request: %w", err)
+		}
 
-	// Use retry utility with exponential backoff
-	resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute file search request after retries: %w", err)
-	}
-	defer resp.Body.Close()
+		req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
+		req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
 
-	// Parse the GWT response body
-	fielDocumentsListResponseBody, err := gwt.ParseFileDocumentsListResponseBody(bodyStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse file documents response: %w", err)
-	}
+		// Use retry utility with exponential backoff
+		resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, &lynxConfig.Retry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute file search request after retries: %w", err)
+		}
+		defer resp.Body.Close()
 
-	return utils.NewToolResultJSON(fielDocumentsListResponseBody), nil
+		// Parse the GWT response body
+		fielDocumentsListResponseBody, err := utils.ParseResponse(ctx, body, bodyStr, gwt.ParseFileDocumentsListResponseBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse file documents response: %w", err)
+		}
+
+		return output.NewToolResult(fielDocumentsListResponseBody, options), nil
+	}
 }
 
 // GetRetrieveFileDocumentsSchema returns the complete JSON schema for the retrieve file documents tool
 func GetRetrieveFileDocumentsSchema() json.RawMessage {
-	return json.RawMessage(TOOL_RETRIEVE_FILE_DOCUMENTS_SCHEMA)
+	return WithFreshProperty(output.WithOutputProperties(json.RawMessage(TOOL_RETRIEVE_FILE_DOCUMENTS_SCHEMA)))
 }
@@ -4,13 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"strings"
 
-	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/output"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
 
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 const (
@@ -98,59 +99,42 @@ const (
 			"required": ["type", "partyName", "fileReference", "fileIdentifier", "clientIdentifier", "agentReference", "itineraryCount", "itineraries"]
 		}
 	}`
-
-	LYNX_RETRIEVE_ITINERARY_URL string = "/lynx/service/file.rpc"
 )
 
-func HandleRetrieveItinerary(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
-
-	if err != nil {
-		return nil, err
-	}
-
-	client := &http.Client{}
+// NewRetrieveItineraryHandler returns the retrieve_itinerary handler
+func NewRetrieveItineraryHandler(lynxConfig config.LynxServerConfig) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
 
-	arguments := request.GetArguments()
-
-	fileIdentifier, ok := arguments["fileIdentifier"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid file identifier argument: %v", arguments["fileIdentifier"])
-	}
+		if err != nil {
+			return nil, err
+		}
 
-	body := gwt.BuildRetrieveItineraryGWTBody(&gwt.RetrieveItineraryArgs{
-		RemoteHost:     lynxConfig.RemoteHost,
-		FileIdentifier: fileIdentifier,
-	})
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_RETRIEVE_ITINERARY_URL), strings.NewReader(body))
+		arguments := request.GetArguments()
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to create retrieve itinerary request: %w", err)
-	}
+		options, err := output.ParseOptions(arguments)
+		if err != nil {
+			return nil, err
+		}
 
-	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
-	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+		fileIdentifier, ok := arguments["fileIdentifier"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid file identifier argument: %v", arguments["fileIdentifier"])
+		}
 
-	// Use retry utility with exponential backoff
-	resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute retrieve itinerary request after retries: %w", err)
-	}
-	defer resp.Body.Close()
+		retrieveItineraryResponseBody, err := lynx.RetrieveItinerary(ctx, lynxConfig, session, fileIdentifier)
+		if err != nil {
+			return nil, err
+		}
 
-	// Parse the GWT response body
-	retrieveItineraryResponseBody, err := gwt.ParseRetrieveItineraryResponseBody(bodyStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse retrieve itinerary response: %w", err)
+		return output.NewToolResult(retrieveItineraryResponseBody, options), nil
 	}
-
-	return utils.NewToolResultJSON(retrieveItineraryResponseBody), nil
 }
 
 // GetRetrieveItinerarySchema returns the complete JSON schema for the retrieve itinerary tool
 func GetRetrieveItinerarySchema() json.RawMessage {
-	return json.RawMessage(TOOL_RETRIEVE_ITINERARY_SCHEMA)
+	return WithFreshProperty(output.WithOutputProperties(json.RawMessage(TOOL_RETRIEVE_ITINERARY_SCHEMA)))
 }
@@ -0,0 +1,24 @@
+package tools
+
+import (
+	"context"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// NewRetryMiddleware makes the Lynx requests of the tools listed in lynx.toolRetry follow their own retry policy.
+// confirm_action follows the policy of the action it confirms, applied by the pending action store.
+func NewRetryMiddleware(lynxConfig config.LynxServerConfig) server.ToolHandlerMiddleware {
+	return func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if _, ok := lynxConfig.ToolRetry[request.Params.Name]; ok && request.Params.Name != TOOL_CONFIRM_ACTION {
+				ctx = utils.WithRetryConfig(ctx, lynxConfig.RetryFor(request.Params.Name))
+			}
+			return next(ctx, request)
+		}
+	}
+}
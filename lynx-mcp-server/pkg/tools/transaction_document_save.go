@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"strings"
 
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/output"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 const (
@@ -42,6 +45,10 @@ const (
 			"attachmentUrl": {
 				"type": "string",
 				"description": "Attachment URL"
+			},
+			"attachmentHandle": {
+				"type": "string",
+				"description": "Handle of a staged attachment to upload and link instead of attachmentUrl"
 			}
 		},
 		"required": ["fileIdentifier", "transactionIdentifier", "name", "content", "type"],
@@ -50,89 +57,74 @@ const (
 			"properties": {}
 		}
 	}`
-
-	LYNX_TRANSACTION_DOCUMENT_SAVE_DETAILS_URL string = "/lynx/service/file.rpc"
 )
 
-func HandleTransactionDocumentSave(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
-
-	if err != nil {
-		return nil, err
-	}
-
-	client := &http.Client{}
+// NewTransactionDocumentSaveHandler returns the transaction_document_save handler, staged attachments are uploaded on the fly
+func NewTransactionDocumentSaveHandler(lynxConfig config.LynxServerConfig, attachments Attachments) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		session, _, err := utils.GetOrCreateSession(ctx, lynxConfig)
 
-	arguments := request.GetArguments()
-
-	fileIdentifier, ok := arguments["fileIdentifier"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid file identifier argument: %v", arguments["fileIdentifier"])
-	}
-
-	transactionIdentifier, ok := arguments["transactionIdentifier"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid transaction identifier argument: %v", arguments["transactionIdentifier"])
-	}
+		if err != nil {
+			return nil, err
+		}
 
-	name, ok := arguments["name"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid name argument: %v", arguments["name"])
-	}
+		arguments := request.GetArguments()
 
-	content, ok := arguments["content"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid content argument: %v", arguments["content"])
-	}
+		options, err := output.ParseOptions(arguments)
+		if err != nil {
+			return nil, err
+		}
 
-	documentType, ok := arguments["type"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid type argument: %v", arguments["type"])
-	}
+		fileIdentifier, ok := arguments["fileIdentifier"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid file identifier argument: %v", arguments["fileIdentifier"])
+		}
 
-	attachmentUrl, ok := arguments["attachmentUrl"].(string)
-	if !ok {
-		attachmentUrl = ""
-	}
+		transactionIdentifier, ok := arguments["transactionIdentifier"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid transaction identifier argument: %v", arguments["transactionIdentifier"])
+		}
 
-	body := gwt.BuildTransactionDocumentSaveGWTBody(&gwt.TransactionDocumentSaveDetailsArgs{
-		RemoteHost:            lynxConfig.RemoteHost,
-		FileIdentifier:        fileIdentifier,
-		TransactionIdentifier: transactionIdentifier,
+		name, ok := arguments["name"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid name argument: %v", arguments["name"])
+		}
 
-		Name:          name,
-		Content:       content,
-		Type:          documentType,
-		AttachmentURL: attachmentUrl,
-	})
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, LYNX_FILE_DOCUMENT_SAVE_DETAILS_URL), strings.NewReader(body))
+		content, ok := arguments["content"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid content argument: %v", arguments["content"])
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to create transaction document save details request: %w", err)
-	}
+		documentType, ok := arguments["type"].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid type argument: %v", arguments["type"])
+		}
 
-	req.Header.Set("Content-Type", gwt.CONTENT_TYPE)
-	req.AddCookie(utils.CreateAuthCookie(lynxConfig, session))
+		attachmentUrl, err := attachments.resolveAttachmentUrl(ctx, request, session, fileIdentifier)
+		if err != nil {
+			return nil, err
+		}
 
-	// Use retry utility with exponential backoff
-	resp, bodyStr, err := utils.RetryHTTPRequest(ctx, client, req, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute transaction document save details request after retries: %w", err)
-	}
-	defer resp.Body.Close()
+		err = lynx.SaveTransactionDocument(ctx, lynxConfig, session, &gwt.TransactionDocumentSaveDetailsArgs{
+			FileIdentifier:        fileIdentifier,
+			TransactionIdentifier: transactionIdentifier,
+
+			Name:          name,
+			Content:       content,
+			Type:          documentType,
+			AttachmentURL: attachmentUrl,
+		})
+		if err != nil {
+			return nil, err
+		}
 
-	// Parse the GWT response body
-	err = gwt.ParseDocumentSaveResponseBody(bodyStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse transaction document save details response: %w", err)
+		return output.NewToolResult(map[string]interface{}{}, options), nil
 	}
-
-	return utils.NewToolResultJSON(map[string]interface{}{}), nil
 }
 
 func GetTransactionDocumentSaveDetailsSchema() json.RawMessage {
-	return json.RawMessage(TOOL_TRANSACTION_DOCUMENT_SAVE_SCHEMA)
+	return WithIdempotencyKeyProperty(output.WithOutputProperties(json.RawMessage(TOOL_TRANSACTION_DOCUMENT_SAVE_SCHEMA)))
 }
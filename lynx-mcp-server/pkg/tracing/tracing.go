@@ -0,0 +1,81 @@
+// Package tracing exports OpenTelemetry spans covering HTTP requests, tool calls, Lynx sign ins, RPC attempts and
+// response parsing
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// INSTRUMENTATION_NAME names the tracer of the server
+const INSTRUMENTATION_NAME = "dodmcdund.cc/lynx-travel-agent/lynxmcpserver"
+
+// Setup installs the W3C trace context propagator and, when enabled, the exporter of spans.
+// The returned function flushes the spans still buffered, it must be called before exiting.
+func Setup(ctx context.Context, tracingConfig config.TracingConfig, version string) (func(context.Context) error, error) {
+	// traceparent headers are honoured even when nothing is exported, log records still carry the trace ID
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if !tracingConfig.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch tracingConfig.Exporter {
+	case config.TRACING_EXPORTER_OTLP:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(tracingConfig.Endpoint)}
+		if tracingConfig.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	case config.TRACING_EXPORTER_STDOUT:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", tracingConfig.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s span exporter: %w", tracingConfig.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		// Callers such as n8n decide for the traces they started
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(tracingConfig.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName(tracingConfig.ServiceName),
+			semconv.ServiceVersion(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span, a child of the span of ctx when there is one
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(INSTRUMENTATION_NAME).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End ends a span, marking it failed when err isn't nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
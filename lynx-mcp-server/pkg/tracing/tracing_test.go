@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// record makes the spans of the test land in a recorder
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	if _, err := Setup(context.Background(), config.DefaultTracingConfig(), "test"); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	recorder := record(t)
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "tools/call file_search_by_party_name")
+		span.End()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/message?sessionId=1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name() != "POST /message" {
+		t.Errorf("server span name = %q, want POST /message", server.Name())
+	}
+	if got := server.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID = %s, want the one of traceparent", got)
+	}
+	if server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span parent = %s, want the span of traceparent", server.Parent().SpanID())
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("tool span isn't a child of the request span")
+	}
+}
+
+func TestToolMiddlewareStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *mcp.CallToolResult
+		err    error
+		want   codes.Code
+	}{
+		{name: "success", result: mcp.NewToolResultText("ok"), want: codes.Unset},
+		{name: "error result", result: mcp.NewToolResultError("file not found"), want: codes.Error},
+		{name: "error", err: errors.New("failed to login"), want: codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record(t)
+
+			handler := ToolMiddleware(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return tt.result, tt.err
+			})
+			request := mcp.CallToolRequest{}
+			request.Params.Name = "retrieve_itinerary"
+			handler(context.Background(), request)
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if spans[0].Name() != "tools/call retrieve_itinerary" {
+				t.Errorf("span name = %q", spans[0].Name())
+			}
+			if spans[0].Status().Code != tt.want {
+				t.Errorf("span status = %v, want %v", spans[0].Status().Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/lynx"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/tracing"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	LYNX_ATTACHMENT_UPLOAD_URL string = "/lynx/fileDocumentUpload"
+
+	// PROGRESS_INTERVAL is the number of bytes between two progress reports
+	PROGRESS_INTERVAL = 1 << 20 // 1MB
+)
+
+var (
+	ErrTooLarge = errors.New("attachment exceeds maximum upload size")
+)
+
+// ProgressFunc is called while an attachment is streamed to Lynx with the number of bytes sent so far
+type ProgressFunc func(sent int64)
+
+// Request describes an attachment to stream to Lynx
+type Request struct {
+	FileIdentifier string
+	FileName       string
+	Content        io.Reader
+	Progress       ProgressFunc
+}
+
+// Result describes an attachment uploaded to Lynx
+type Result struct {
+	AttachmentURL string `json:"attachmentUrl"`
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256"`
+}
+
+// Service streams attachments to Lynx without holding them in memory
+type Service struct {
+	lynxConfig config.LynxServerConfig
+	maxSize    int64
+	client     *http.Client
+}
+
+// NewService creates the upload service shared by the REST endpoint and the tools
+func NewService(lynxConfig config.LynxServerConfig, uploadConfig config.UploadConfig) *Service {
+	return &Service{
+		lynxConfig: lynxConfig,
+		maxSize:    uploadConfig.MaxSize,
+		client:     utils.NewHTTPClient(),
+	}
+}
+
+// MaxSize returns the maximum attachment size accepted by the service
+func (s *Service) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Upload streams the content to Lynx through a multipart body written on the fly,
+// the size limit is enforced and the SHA-256 computed while streaming
+func (s *Service) Upload(ctx context.Context, session *utils.SessionContext, request Request) (*Result, error) {
+	ctx, span := tracing.Start(ctx, "lynx.upload")
+	result, err := s.upload(ctx, session, request)
+	lynx.Written(request.FileIdentifier)
+	if result != nil {
+		span.SetAttributes(attribute.Int64("upload.size", result.Size))
+	}
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func (s *Service) upload(ctx context.Context, session *utils.SessionContext, request Request) (*Result, error) {
+	pipeReader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+
+	counter := &countingWriter{
+		hasher:   sha256.New(),
+		progress: request.Progress,
+	}
+
+	// The multipart body is produced while the HTTP client consumes it
+	writeErrors := make(chan error, 1)
+	go func() {
+		err := s.writeMultipart(writer, request, counter)
+		pipeWriter.CloseWithError(err)
+		writeErrors <- err
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", s.lynxConfig.RemoteHost, LYNX_ATTACHMENT_UPLOAD_URL), pipeReader)
+	if err != nil {
+		pipeReader.CloseWithError(err)
+		<-writeErrors
+		return nil, fmt.Errorf("failed to create attachment upload request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.AddCookie(utils.CreateAuthCookie(s.lynxConfig, session))
+
+	// Execute the request
+	resp, err := s.client.Do(req)
+
+	// Make sure the writer goroutine is done before looking at its outcome
+	pipeReader.CloseWithError(io.ErrClosedPipe)
+	writeErr := <-writeErrors
+
+	if writeErr != nil && !errors.Is(writeErr, io.ErrClosedPipe) {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, writeErr
+	}
+
+	if err != nil {
+		return nil, utils.MarkUnreachable(nil, err, true, fmt.Errorf("failed to execute attachment upload request: %w", err))
+	}
+	defer resp.Body.Close()
+
+	// Read response body
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	bodyStr := string(bodyBytes)
+
+	// Check if request was successful
+	if resp.StatusCode != http.StatusOK {
+		return nil, utils.MarkUnreachable(resp, nil, true, fmt.Errorf("Attachment upload failed with status %d: %s", resp.StatusCode, bodyStr))
+	}
+
+	attachmentUrl, err := ParseResponseBody(bodyStr)
+	if err != nil {
+		metrics.LynxParseFailures.WithLabelValues(metrics.RPCMethod(req)).Inc()
+		return nil, fmt.Errorf("Invalid attachment upload response: %w", err)
+	}
+
+	result := &Result{
+		AttachmentURL: attachmentUrl,
+		Size:          counter.written,
+		SHA256:        hex.EncodeToString(counter.hasher.Sum(nil)),
+	}
+
+	metrics.UploadBytes.Add(float64(result.Size))
+	slog.InfoContext(ctx, "Uploaded attachment", "file_name", request.FileName, "size", result.Size, "sha256", result.SHA256, "attachment_url", result.AttachmentURL)
+
+	return result, nil
+}
+
+// writeMultipart writes the fileId and file parts, failing once the content exceeds the size limit
+func (s *Service) writeMultipart(writer *multipart.Writer, request Request, counter *countingWriter) error {
+	// Add fileId part
+	if err := writer.WriteField("fileId", request.FileIdentifier); err != nil {
+		return fmt.Errorf("failed to create fileId field: %w", err)
+	}
+
+	// Add file part
+	fileField, err := writer.CreateFormFile("file", request.FileName)
+	if err != nil {
+		return fmt.Errorf("failed to create file field: %w", err)
+	}
+
+	// Read one byte past the limit to detect oversized attachments
+	written, err := io.Copy(io.MultiWriter(fileField, counter), io.LimitReader(request.Content, s.maxSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to stream attachment: %w", err)
+	}
+	if written > s.maxSize {
+		return ErrTooLarge
+	}
+
+	// Close the multipart writer
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close multipart body: %w", err)
+	}
+
+	return nil
+}
+
+// countingWriter tracks the attachment size and checksum, and reports progress
+type countingWriter struct {
+	hasher       hash.Hash
+	written      int64
+	lastReported int64
+	progress     ProgressFunc
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.hasher.Write(p)
+	c.written += int64(len(p))
+
+	if c.progress != nil && c.written-c.lastReported >= PROGRESS_INTERVAL {
+		c.lastReported = c.written
+		c.progress(c.written)
+	}
+
+	return len(p), nil
+}
+
+// ParseResponseBody parses the response body to extract the attachment URL
+// Expected format: "SUCCESS:/documents/file/f16476987/d20250708231038.pdf:\n"
+func ParseResponseBody(responseBody string) (string, error) {
+	if !strings.HasPrefix(responseBody, "SUCCESS:") {
+		return "", fmt.Errorf("unexpected response format: %s", responseBody)
+	}
+
+	// Remove "SUCCESS:" prefix
+	urlPart := strings.TrimPrefix(responseBody, "SUCCESS:")
+
+	// Trim whitespace and line breaks
+	urlPart = strings.TrimSpace(urlPart)
+
+	// Ensure response ends with ": " and strip it out
+	if !strings.HasSuffix(urlPart, ":") {
+		return "", fmt.Errorf("response does not end with ':': %s", responseBody)
+	}
+	urlPart = strings.TrimSuffix(urlPart, ":")
+
+	// Validate that we have a URL path
+	if urlPart == "" || !strings.HasPrefix(urlPart, "/") {
+		return "", fmt.Errorf("invalid attachment URL in response: %s", responseBody)
+	}
+
+	return urlPart, nil
+}
@@ -0,0 +1,138 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/utils"
+)
+
+func newTestService(t *testing.T, maxSize int64, received *string) *Service {
+	t.Helper()
+
+	lynx := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != LYNX_ATTACHMENT_UPLOAD_URL {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.ContentLength != -1 {
+			t.Errorf("expected a streamed body of unknown length, got %d", r.ContentLength)
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		content, _ := io.ReadAll(file)
+		*received = r.FormValue("fileId") + ":" + header.Filename + ":" + string(content)
+
+		io.WriteString(w, "SUCCESS:/documents/file/f16476987/d20250708231038.pdf:\n")
+	}))
+	t.Cleanup(lynx.Close)
+
+	service := NewService(config.LynxServerConfig{
+		RemoteHost: strings.TrimPrefix(lynx.URL, "https://"),
+	}, config.UploadConfig{
+		MaxSize: maxSize,
+	})
+	service.client = lynx.Client()
+
+	return service
+}
+
+func TestUpload(t *testing.T) {
+	var received string
+	service := newTestService(t, 3<<20, &received)
+
+	content := strings.Repeat("x", 2<<20+10)
+	var progress []int64
+
+	result, err := service.Upload(context.Background(), &utils.SessionContext{}, Request{
+		FileIdentifier: "$xOpT",
+		FileName:       "voucher.pdf",
+		Content:        strings.NewReader(content),
+		Progress: func(sent int64) {
+			progress = append(progress, sent)
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	if result.AttachmentURL != "/documents/file/f16476987/d20250708231038.pdf" ||
+		result.Size != int64(len(content)) ||
+		result.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	if received != "$xOpT:voucher.pdf:"+content {
+		t.Errorf("Lynx received unexpected upload (%d bytes)", len(received))
+	}
+
+	if len(progress) != 2 || progress[0] != PROGRESS_INTERVAL || progress[1] != 2*PROGRESS_INTERVAL {
+		t.Errorf("unexpected progress reports: %v", progress)
+	}
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	var received string
+	service := newTestService(t, 8, &received)
+
+	_, err := service.Upload(context.Background(), &utils.SessionContext{}, Request{
+		FileIdentifier: "$xOpT",
+		FileName:       "voucher.pdf",
+		Content:        strings.NewReader("123456789"),
+	})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	if received != "" {
+		t.Errorf("expected Lynx not to accept a truncated upload, got %q", received)
+	}
+}
+
+func TestParseResponseBody(t *testing.T) {
+	tests := []struct {
+		name           string
+		responseBody   string
+		expectedError  bool
+		expectedResult string
+	}{
+		{
+			name:           "Successful upload",
+			responseBody:   "SUCCESS:/documents/file/f16476987/d20250708231038.pdf:\n",
+			expectedResult: "/documents/file/f16476987/d20250708231038.pdf",
+		},
+		{
+			name:          "Failed upload",
+			responseBody:  "ERROR:session expired",
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseResponseBody(tt.responseBody)
+			if tt.expectedError != (err != nil) {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if result != tt.expectedResult {
+				t.Errorf("expected %q, got %q", tt.expectedResult, result)
+			}
+		})
+	}
+}
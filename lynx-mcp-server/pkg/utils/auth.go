@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -23,6 +29,7 @@ const (
 
 type SessionContext struct {
 	jsessionID string
+	username   string
 	expiresAt  time.Time
 }
 
@@ -30,9 +37,103 @@ func (s *SessionContext) JSESSIONID() string {
 	return s.jsessionID
 }
 
+// Username is the Lynx account the session is signed in as
+func (s *SessionContext) Username() string {
+	return s.username
+}
+
+// Credentials are the Lynx account a request runs as, the service account when the context holds none
+type Credentials struct {
+	Username string
+	Password string
+}
+
 type contextKey string
 
-const sessionContextKey = contextKey("session")
+const (
+	sessionContextKey     = contextKey("session")
+	credentialsContextKey = contextKey("credentials")
+)
+
+// sessions caches one Lynx session per account, each account signs in once per AuthCookieDuration
+var sessions = sessionCache{entries: make(map[string]*sessionEntry)}
+
+type sessionCache struct {
+	mu      sync.Mutex
+	entries map[string]*sessionEntry
+}
+
+// sessionEntry serialises the sign in of one account, concurrent requests wait for the same session.
+// The status is guarded by the cache mutex so it can be read while a sign in is in progress.
+type sessionEntry struct {
+	mu      sync.Mutex
+	session *SessionContext
+	status  SessionStatus
+}
+
+// SessionStatus describes the Lynx session of an account, for diagnostics
+type SessionStatus struct {
+	Username    string     `json:"username"`
+	SignedInAt  *time.Time `json:"signedInAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+func (c *sessionCache) entry(key string) *sessionEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &sessionEntry{}
+		c.entries[key] = entry
+	}
+	return entry
+}
+
+// signedIn records the outcome of a sign in in the status of the entry
+func (c *sessionCache) signedIn(entry *sessionEntry, username string, session *SessionContext, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().UTC()
+	entry.status.Username = username
+	if err != nil {
+		entry.status.LastError = err.Error()
+		entry.status.LastErrorAt = &now
+		return
+	}
+	expiresAt := session.expiresAt.UTC()
+	entry.status.SignedInAt = &now
+	entry.status.ExpiresAt = &expiresAt
+}
+
+// Sessions returns the status of the session of every account that signed in or tried to, by username
+func Sessions() []SessionStatus {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+
+	statuses := make([]SessionStatus, 0, len(sessions.entries))
+	for _, entry := range sessions.entries {
+		if entry.status.Username != "" {
+			statuses = append(statuses, entry.status)
+		}
+	}
+	slices.SortFunc(statuses, func(a, b SessionStatus) int { return strings.Compare(a.Username, b.Username) })
+	return statuses
+}
+
+// WithCredentials makes the Lynx requests of ctx run as another account than the service account
+func WithCredentials(ctx context.Context, credentials Credentials) context.Context {
+	return context.WithValue(ctx, credentialsContextKey, credentials)
+}
+
+// CredentialsFromContext returns the Lynx account set by WithCredentials
+func CredentialsFromContext(ctx context.Context) (Credentials, bool) {
+	credentials, ok := ctx.Value(credentialsContextKey).(Credentials)
+	return credentials, ok
+}
 
 // GetSessionFromContext retrieves the session from context
 func GetSessionFromContext(ctx context.Context) (*SessionContext, bool) {
@@ -40,7 +141,8 @@ func GetSessionFromContext(ctx context.Context) (*SessionContext, bool) {
 	return session, ok
 }
 
-// GetOrCreateSession retrieves an existing session from context or creates a new one if needed
+// GetOrCreateSession retrieves an existing session from context or the cache of the account, or signs in.
+// The account is the one of WithCredentials, or the service account of lynxConfig.
 func GetOrCreateSession(ctx context.Context, lynxConfig config.LynxServerConfig) (*SessionContext, context.Context, error) {
 	// Try to get existing session
 	session, ok := GetSessionFromContext(ctx)
@@ -48,19 +150,49 @@ func GetOrCreateSession(ctx context.Context, lynxConfig config.LynxServerConfig)
 		return session, ctx, nil
 	}
 
-	// No valid session found, create new one
-	session, err := makeAuthRequest(lynxConfig)
-	if err != nil {
-		return nil, ctx, fmt.Errorf("failed to login: %w", err)
+	credentials, ok := CredentialsFromContext(ctx)
+	if !ok {
+		credentials = Credentials{Username: lynxConfig.Username, Password: lynxConfig.Password}
 	}
 
+	// The span covers waiting for a concurrent sign in of the same account
+	spanCtx, span := tracing.Start(ctx, "lynx.session")
+
+	entry := sessions.entry(lynxConfig.RemoteHost + "/" + lynxConfig.CompanyCode + "/" + credentials.Username)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	session = entry.session
+	if session == nil || !session.expiresAt.After(time.Now()) {
+		metrics.SessionCache.WithLabelValues(metrics.CACHE_MISS).Inc()
+		span.SetAttributes(attribute.Bool("lynx.session.cached", false))
+
+		// No valid session found, create new one
+		loginCtx, loginSpan := tracing.Start(spanCtx, "lynx.login")
+		var err error
+		session, err = makeAuthRequest(loginCtx, lynxConfig, credentials)
+		tracing.End(loginSpan, err)
+		metrics.LynxLogins.WithLabelValues(metrics.Result(err)).Inc()
+		sessions.signedIn(entry, credentials.Username, session, err)
+		if err != nil {
+			err = fmt.Errorf("failed to login as %s: %w", credentials.Username, err)
+			tracing.End(span, err)
+			return nil, ctx, err
+		}
+		entry.session = session
+	} else {
+		metrics.SessionCache.WithLabelValues(metrics.CACHE_HIT).Inc()
+		span.SetAttributes(attribute.Bool("lynx.session.cached", true))
+	}
+	span.End()
+
 	// Store new session in context
 	ctx = context.WithValue(ctx, sessionContextKey, session)
 	return session, ctx, nil
 }
 
-// makeAuthRequest performs authentication and returns JSESSIONID
-func makeAuthRequest(lynxConfig config.LynxServerConfig) (*SessionContext, error) {
+// makeAuthRequest performs authentication and returns JSESSIONID, the session cookie itself is never logged
+func makeAuthRequest(ctx context.Context, lynxConfig config.LynxServerConfig, credentials Credentials) (*SessionContext, error) {
 	jar, err := cookiejar.New(nil)
 
 	if err != nil {
@@ -68,18 +200,19 @@ func makeAuthRequest(lynxConfig config.LynxServerConfig) (*SessionContext, error
 	}
 
 	client := &http.Client{
-		Jar: jar,
+		Jar:       jar,
+		Transport: transport,
 	}
 
 	args := &gwt.GWTLoginArgs{
 		RemoteHost:  lynxConfig.RemoteHost,
 		CompanyCode: lynxConfig.CompanyCode,
-		Username:    lynxConfig.Username,
-		Password:    lynxConfig.Password,
+		Username:    credentials.Username,
+		Password:    credentials.Password,
 	}
 
 	body := gwt.BuildGWTLoginBody(args)
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, AUTH_URL), strings.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://%s%s", lynxConfig.RemoteHost, AUTH_URL), strings.NewReader(body))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to create auth request: %w", err)
@@ -90,13 +223,16 @@ func makeAuthRequest(lynxConfig config.LynxServerConfig) (*SessionContext, error
 	resp, err := client.Do(req)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to perform auth request: %w", err)
+		if err := contextError(ctx, 1); err != nil {
+			return nil, err
+		}
+		return nil, MarkUnreachable(nil, err, false, fmt.Errorf("failed to perform auth request: %w", err))
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("auth request failed with status: %d", resp.StatusCode)
+		return nil, MarkUnreachable(resp, nil, false, fmt.Errorf("auth request failed with status: %d", resp.StatusCode))
 	}
 
 	bodyBytes, err := io.ReadAll(resp.Body)
@@ -112,9 +248,10 @@ func makeAuthRequest(lynxConfig config.LynxServerConfig) (*SessionContext, error
 	// Extract JSESSIONID from cookies
 	for _, cookie := range resp.Cookies() {
 		if cookie.Name == JSESSIONID {
-			log.Printf("Using JSESSIONID: %s\n", cookie.Value)
+			slog.InfoContext(ctx, "Signed in to Lynx", "user", credentials.Username)
 			return &SessionContext{
 				jsessionID: cookie.Value,
+				username:   credentials.Username,
 				expiresAt:  time.Now().Add(lynxConfig.AuthCookieDuration),
 			}, nil
 		}
@@ -133,30 +270,3 @@ func CreateAuthCookie(lynxConfig config.LynxServerConfig, session *SessionContex
 		HttpOnly: true,
 	}
 }
-
-// BearerAuthMiddleware creates middleware that checks for Authorization header
-func BearerAuthMiddleware(expectedToken string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get(AUTHORIZATION_HEADER)
-			if authHeader == "" {
-				http.Error(w, "Authorization header required", http.StatusUnauthorized)
-				return
-			}
-
-			if !strings.HasPrefix(authHeader, "Bearer ") {
-				http.Error(w, "Invalid authorization format", http.StatusUnauthorized)
-				return
-			}
-
-			token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
-			expectedTokenTrimmed := strings.TrimSpace(expectedToken)
-			if token != expectedTokenTrimmed {
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-}
@@ -1,10 +1,13 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 )
 
 // newToolResultJSON creates a new CallToolResult with JSON content
@@ -25,3 +28,32 @@ func NewToolResultJSON(data interface{}) *mcp.CallToolResult {
 		},
 	}
 }
+
+// NewProgressNotifier returns a function sending MCP progress notifications for the tool call,
+// it does nothing when the client didn't ask for progress
+func NewProgressNotifier(ctx context.Context, request mcp.CallToolRequest, total int64) func(progress int64) {
+	if request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return func(progress int64) {}
+	}
+
+	mcpServer := server.ServerFromContext(ctx)
+	if mcpServer == nil {
+		return func(progress int64) {}
+	}
+
+	progressToken := request.Params.Meta.ProgressToken
+
+	return func(progress int64) {
+		params := map[string]any{
+			"progressToken": progressToken,
+			"progress":      progress,
+		}
+		if total > 0 {
+			params["total"] = total
+		}
+
+		if err := mcpServer.SendNotificationToClient(ctx, "notifications/progress", params); err != nil {
+			slog.WarnContext(ctx, "Failed to send progress notification", "error", err)
+		}
+	}
+}
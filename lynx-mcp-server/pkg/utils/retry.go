@@ -2,40 +2,141 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/config"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/guard"
 	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/gwt"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/logging"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/metrics"
+	"dodmcdund.cc/lynx-travel-agent/lynxmcpserver/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // RetryConfig holds configuration for retry behavior
-type RetryConfig struct {
-	MaxAttempts       int
-	InitialDelay      time.Duration
-	BackoffMultiplier float64
-	MaxDelay          time.Duration
-}
+type RetryConfig = config.RetryConfig
+
+// Classes of failed Lynx attempts, retries are counted by class
+const (
+	// FAILURE_UNAVAILABLE is a request the open circuit breaker rejected, retrying can't help until it closes
+	FAILURE_UNAVAILABLE = "unavailable"
+	// FAILURE_NETWORK is a request without complete response, Lynx may have processed it
+	FAILURE_NETWORK = "network"
+	// FAILURE_UNSENT is a request whose connection failed, Lynx never got it
+	FAILURE_UNSENT = "unsent"
+	// FAILURE_THROTTLED is a 429 Too Many Requests, Lynx rejected the request and may say when to come back
+	FAILURE_THROTTLED = "throttled"
+	// FAILURE_SERVER is a 5xx status, Lynx or a proxy in front of it failed
+	FAILURE_SERVER = "server"
+	// FAILURE_CLIENT is any other status, repeating the request can't help
+	FAILURE_CLIENT = "client"
+	// FAILURE_BODY is a 200 without "//OK" nor "//EX", such as a maintenance page
+	FAILURE_BODY = "body"
+)
+
+const retryConfigContextKey = contextKey("retryConfig")
+
+// Verify tells whether Lynx applied a write whose attempt failed, the write is retried when it didn't
+type Verify func(ctx context.Context) (bool, error)
+
+var (
+	// ErrWriteUnconfirmed is returned for a write that failed once Lynx may have applied it, it isn't retried
+	// unless a check found the change missing
+	ErrWriteUnconfirmed = errors.New("Lynx may have applied the change, it wasn't retried")
+	// ErrCanceled is returned once the caller gave up on a Lynx request, such as a disconnected client
+	ErrCanceled = errors.New("Lynx request canceled")
+	// ErrTimeout is returned once the deadline of a Lynx request, such as the one of its tool call, passed
+	ErrTimeout = errors.New("Lynx request timed out")
+	// ErrUnreachable is returned once Lynx was down rather than refusing a request, a write then wasn't applied
+	ErrUnreachable = errors.New("Lynx unreachable")
+)
 
 // DefaultRetryConfig returns a default retry configuration
 func DefaultRetryConfig() *RetryConfig {
-	return &RetryConfig{
-		MaxAttempts:       5,
-		InitialDelay:      0, // Immediate first retry
-		BackoffMultiplier: 2.0,
-		MaxDelay:          30 * time.Second,
-	}
+	retryConfig := config.DefaultRetryConfig()
+	return &retryConfig
+}
+
+// transport is the round tripper shared by the Lynx HTTP clients
+var transport http.RoundTripper = metrics.Transport
+
+// NewHTTPClient returns a client for Lynx requests, they share the instrumented transport
+func NewHTTPClient() *http.Client {
+	return &http.Client{Transport: transport}
+}
+
+// SetTransport makes the Lynx HTTP clients created from then on share rt, such as a guard in front of
+// metrics.Transport. It's called on startup, before any client is created.
+func SetTransport(rt http.RoundTripper) {
+	transport = rt
 }
 
 // RetryHTTPRequest executes an HTTP request with exponential backoff retry logic
 // Success condition: response status is OK, has body, and body starts with "//OK"
 func RetryHTTPRequest(ctx context.Context, client *http.Client, req *http.Request, config *RetryConfig) (*http.Response, string, error) {
+	return retryRPC(ctx, client, req, config, nil)
+}
+
+// RetryWriteRequest executes a request changing Lynx. An attempt failing after Lynx may have processed it is only
+// retried once verify found the change missing, the returned body is empty when verify found it applied.
+func RetryWriteRequest(ctx context.Context, client *http.Client, req *http.Request, config *RetryConfig, verify Verify) (string, error) {
+	_, bodyStr, err := retryRPC(ctx, client, req, config, verify)
+	return bodyStr, err
+}
+
+// WithRetryConfig makes the Lynx requests of ctx follow another retry policy than the one they are given, such as
+// the one of their tool
+func WithRetryConfig(ctx context.Context, config RetryConfig) context.Context {
+	return context.WithValue(ctx, retryConfigContextKey, config)
+}
+
+// retryRPC runs the attempts of a Lynx RPC in its span
+func retryRPC(ctx context.Context, client *http.Client, req *http.Request, config *RetryConfig, verify Verify) (*http.Response, string, error) {
+	method := metrics.RPCMethod(req)
+
+	ctx, span := tracing.Start(ctx, "lynx.rpc "+method, attribute.String("rpc.method", method))
+	resp, bodyStr, err := retryHTTPRequest(ctx, method, client, req, config, verify)
+	tracing.End(span, err)
+
+	return resp, bodyStr, err
+}
+
+// ParseResponse parses the response of a Lynx RPC in its own span, failures are counted by RPC method
+func ParseResponse[T any](ctx context.Context, requestBody string, responseBody string, parse func(string) (T, error)) (T, error) {
+	method := gwt.RPCMethod(requestBody)
+	_, span := tracing.Start(ctx, "lynx.parse "+method, attribute.String("rpc.method", method))
+
+	result, err := parse(responseBody)
+	if err != nil {
+		metrics.LynxParseFailures.WithLabelValues(method).Inc()
+	}
+	tracing.End(span, err)
+
+	return result, err
+}
+
+// retryHTTPRequest runs the attempts of RetryHTTPRequest, each one in its own span. Writes are retried when the
+// failure shows Lynx didn't process them or verify found them missing.
+func retryHTTPRequest(ctx context.Context, method string, client *http.Client, req *http.Request, config *RetryConfig, verify Verify) (*http.Response, string, error) {
+	if override, ok := ctx.Value(retryConfigContextKey).(RetryConfig); ok {
+		config = &override
+	}
 	if config == nil {
 		config = DefaultRetryConfig()
 	}
+	write := verify != nil || gwt.IsWrite(method)
 
 	// Read the original request body once to preserve it for retries
 	var originalBody []byte
@@ -53,12 +154,17 @@ func RetryHTTPRequest(ctx context.Context, client *http.Client, req *http.Reques
 	var lastBodyStr string
 
 	for attempt := 0; attempt < config.MaxAttempts; attempt++ {
+		// Nobody waits for the outcome anymore
+		if err := contextError(ctx, attempt); err != nil {
+			return lastResp, lastBodyStr, err
+		}
+
 		// Create a new request for each attempt to ensure the body is preserved
 		var retryReq *http.Request
 		if len(originalBody) > 0 {
-			retryReq, err = http.NewRequest(req.Method, req.URL.String(), strings.NewReader(string(originalBody)))
+			retryReq, err = http.NewRequestWithContext(ctx, req.Method, req.URL.String(), strings.NewReader(string(originalBody)))
 		} else {
-			retryReq, err = http.NewRequest(req.Method, req.URL.String(), nil)
+			retryReq, err = http.NewRequestWithContext(ctx, req.Method, req.URL.String(), nil)
 		}
 		if err != nil {
 			return nil, "", fmt.Errorf("failed to create retry request: %w", err)
@@ -71,76 +177,99 @@ func RetryHTTPRequest(ctx context.Context, client *http.Client, req *http.Reques
 			}
 		}
 
-		// Execute the request
-		resp, err := client.Do(retryReq)
-		if err != nil {
-			lastErr = fmt.Errorf("attempt %d: failed to execute request: %w", attempt+1, err)
-
-			if shouldReturn(attempt, config.MaxAttempts) {
-				return nil, "", lastErr
-			}
+		// Lynx doesn't use it, but it ties our logs to the traffic seen by proxies
+		if requestID := logging.RequestIDFromContext(ctx); requestID != "" {
+			retryReq.Header.Set(logging.REQUEST_ID_HEADER, requestID)
+		}
 
-			if err := waitForRetry(ctx, attempt, config); err != nil {
-				return nil, "", err
+		// Execute the request and read its body, the body is always closed
+		start := time.Now()
+		_, attemptSpan := tracing.Start(ctx, "lynx.attempt", attribute.Int("attempt", attempt+1))
+		resp, err := client.Do(retryReq)
+		var bodyStr string
+		var urlErr *url.Error
+		if errors.Is(err, guard.ErrUnavailable) && errors.As(err, &urlErr) {
+			// Failed fast by the circuit breaker, Lynx never got it
+			err = urlErr.Err
+		} else if err != nil {
+			err = fmt.Errorf("attempt %d: failed to execute request: %w", attempt+1, err)
+		} else {
+			bodyBytes, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			attemptSpan.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if readErr != nil {
+				err = fmt.Errorf("attempt %d: failed to read response body: %w", attempt+1, readErr)
 			}
-			continue
+			bodyStr = string(bodyBytes)
 		}
-
-		// Read response body
-		bodyBytes, err := io.ReadAll(resp.Body)
-		resp.Body.Close() // Always close the body
+		tracing.End(attemptSpan, err)
 
 		if err != nil {
-			lastErr = fmt.Errorf("attempt %d: failed to read response body: %w", attempt+1, err)
-			lastResp = resp
-
-			if shouldReturn(attempt, config.MaxAttempts) {
-				return resp, "", lastErr
-			}
-
-			if err := waitForRetry(ctx, attempt, config); err != nil {
+			if err := contextError(ctx, attempt+1); err != nil {
 				return resp, "", err
 			}
-			continue
+			bodyStr = ""
 		}
 
-		bodyStr := string(bodyBytes)
-
 		// Check success conditions: status OK, has body, and body starts with "//OK"
-		if resp.StatusCode == http.StatusOK && len(bodyStr) > 0 && strings.HasPrefix(bodyStr, "//OK") {
+		if err == nil && resp.StatusCode == http.StatusOK && strings.HasPrefix(bodyStr, "//OK") {
 			// Success! Return the response and body
-			log.Printf("RetryHTTPRequest Success! (attempt=%d)\n", attempt)
+			slog.DebugContext(ctx, "Lynx request succeeded", "url", retryReq.URL.Path, "attempt", attempt+1, "duration", time.Since(start))
 			return resp, bodyStr, nil
 		}
 
 		// Check for GWT error responses that start with "//EX"
-		if resp.StatusCode == http.StatusOK && len(bodyStr) > 0 && strings.HasPrefix(bodyStr, "//EX") {
+		if err == nil && resp.StatusCode == http.StatusOK && strings.HasPrefix(bodyStr, "//EX") {
+			metrics.LynxExceptions.WithLabelValues(method).Inc()
+
 			// Parse the GWT error response to extract the error message
 			errorMessage, err := gwt.ParseResponseError(bodyStr)
 			if err != nil {
 				// If we can't parse the error, return the raw body as error
-				lastErr = fmt.Errorf("RetryHTTPRequest attempt %d: GWT error response (unparseable): %s", attempt+1, bodyStr)
+				lastErr = fmt.Errorf("RetryHTTPRequest attempt %d: %w response (unparseable): %s", attempt+1, gwt.ErrException, bodyStr)
 			} else {
 				// Return the parsed error message
-				lastErr = fmt.Errorf("RetryHTTPRequest attempt %d: GWT error: %s", attempt+1, errorMessage)
+				lastErr = fmt.Errorf("RetryHTTPRequest attempt %d: %w: %s", attempt+1, gwt.ErrException, errorMessage)
 			}
-			lastResp = resp
-			lastBodyStr = bodyStr
 
 			// GWT errors are not retryable, so return immediately
 			return resp, "", lastErr
 		}
 
-		// If we reach here, the request was successful but didn't meet our success criteria
-		lastErr = fmt.Errorf("RetryHTTPRequest attempt %d: unexpected response (status: %d, body: %s)", attempt+1, resp.StatusCode, bodyStr)
-		lastResp = resp
-		lastBodyStr = bodyStr
+		if err == nil {
+			err = fmt.Errorf("RetryHTTPRequest attempt %d: unexpected response (status: %d, body: %s)", attempt+1, resp.StatusCode, bodyStr)
+		}
+		lastErr, lastResp, lastBodyStr = err, resp, bodyStr
+		failure := classify(resp, err)
+		slog.WarnContext(ctx, "Lynx request failed", "url", retryReq.URL.Path, "attempt", attempt+1, "duration", time.Since(start), "failure", failure.class, "error", err)
+
+		// A write Lynx may have applied is only repeated once it's known to be missing, Lynx then didn't process it
+		missing := false
+		if write && failure.retryable() && !failure.rejected() && verify != nil {
+			applied, err := verify(ctx)
+			if err != nil {
+				return resp, bodyStr, fmt.Errorf("%w: %w, checking whether Lynx applied it failed: %w", ErrWriteUnconfirmed, lastErr, err)
+			}
+			if applied {
+				slog.InfoContext(ctx, "Lynx applied the write despite the failed response", "url", retryReq.URL.Path, "attempt", attempt+1)
+				return resp, "", nil
+			}
+			missing = true
+		}
 
-		if shouldReturn(attempt, config.MaxAttempts) {
+		if !failure.retryable() || shouldReturn(attempt, config.MaxAttempts) {
+			if failure.unreachable(write && !missing) {
+				lastErr = fmt.Errorf("%w: %w", ErrUnreachable, lastErr)
+			}
 			return resp, bodyStr, lastErr
 		}
 
-		if err := waitForRetry(ctx, attempt, config); err != nil {
+		if write && !failure.rejected() && !missing {
+			return resp, bodyStr, fmt.Errorf("%w: %w", ErrWriteUnconfirmed, lastErr)
+		}
+
+		metrics.LynxRetries.WithLabelValues(method, failure.class).Inc()
+		if err := waitForRetry(ctx, attempt, config, failure.retryAfter); err != nil {
 			return resp, bodyStr, err
 		}
 	}
@@ -149,41 +278,118 @@ func RetryHTTPRequest(ctx context.Context, client *http.Client, req *http.Reques
 	return lastResp, lastBodyStr, lastErr
 }
 
+// failure describes why an attempt failed, it tells whether to retry and when
+type failure struct {
+	class string
+	// Asked by Lynx with Retry-After
+	retryAfter time.Duration
+}
+
+// retryable reports whether another attempt could succeed
+func (f failure) retryable() bool {
+	return f.class != FAILURE_CLIENT && f.class != FAILURE_UNAVAILABLE
+}
+
+// rejected reports whether Lynx certainly didn't process the request, a write can then be repeated
+func (f failure) rejected() bool {
+	return f.class == FAILURE_UNSENT || f.class == FAILURE_THROTTLED || f.class == FAILURE_UNAVAILABLE
+}
+
+// unreachable reports whether Lynx was down rather than refusing the request, a write only once it certainly didn't
+// process it: pass write false for a write a check found missing
+func (f failure) unreachable(write bool) bool {
+	if write {
+		return f.rejected()
+	}
+	return f.class != FAILURE_CLIENT
+}
+
+// MarkUnreachable wraps failed with ErrUnreachable when the request, sent without RetryHTTPRequest, failed with resp
+// and err because Lynx was down
+func MarkUnreachable(resp *http.Response, err error, write bool, failed error) error {
+	if classify(resp, err).unreachable(write) {
+		return fmt.Errorf("%w: %w", ErrUnreachable, failed)
+	}
+	return failed
+}
+
+// classify tells why an attempt failed from its response, nil when there is none, and its error
+func classify(resp *http.Response, err error) failure {
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, guard.ErrUnavailable):
+		return failure{class: FAILURE_UNAVAILABLE}
+	case resp == nil && errors.As(err, &opErr) && opErr.Op == "dial":
+		return failure{class: FAILURE_UNSENT}
+	case resp == nil:
+		return failure{class: FAILURE_NETWORK}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return failure{class: FAILURE_THROTTLED, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	case resp.StatusCode >= 500:
+		return failure{class: FAILURE_SERVER, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	case resp.StatusCode != http.StatusOK:
+		return failure{class: FAILURE_CLIENT}
+	case err != nil:
+		// The response was cut off
+		return failure{class: FAILURE_NETWORK}
+	default:
+		return failure{class: FAILURE_BODY}
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or as a date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
 // shouldReturn checks if this is the last attempt and we should return instead of retry
 func shouldReturn(attempt, maxAttempts int) bool {
 	return attempt == maxAttempts-1
 }
 
-// waitForRetry waits for the calculated delay before the next retry attempt
-func waitForRetry(ctx context.Context, attempt int, config *RetryConfig) error {
-	delay := calculateDelay(attempt, config)
+// waitForRetry waits for the calculated delay before the next retry attempt, at least as long as Lynx asked
+func waitForRetry(ctx context.Context, attempt int, config *RetryConfig, retryAfter time.Duration) error {
+	delay := max(calculateDelay(attempt+1, config), retryAfter)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
-	case <-time.After(delay):
+		return contextError(ctx, attempt+1)
+	case <-timer.C:
 		return nil
 	}
 }
 
-// calculateDelay calculates the delay for the next retry attempt
-func calculateDelay(attempt int, config *RetryConfig) time.Duration {
-	if attempt == 0 {
-		return config.InitialDelay
-	}
-
-	// Calculate exponential backoff: 0s, 5s, 10s, 30s, 30s
-	delays := []time.Duration{
-		0 * time.Second,  // Immediate first retry
-		5 * time.Second,  // 5 seconds
-		10 * time.Second, // 10 seconds
-		30 * time.Second, // 30 seconds
-		30 * time.Second, // Max delay (capped)
+// contextError tells why ctx is done as ErrCanceled or ErrTimeout, the error still matches the one of ctx
+func contextError(ctx context.Context, attempts int) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return fmt.Errorf("%w after %d attempts: %w", ErrTimeout, attempts, ctx.Err())
+	default:
+		return fmt.Errorf("%w after %d attempts: %w", ErrCanceled, attempts, ctx.Err())
 	}
+}
 
-	if attempt < len(delays) {
-		return delays[attempt]
+// calculateDelay draws the delay before a retry, counted from 1, at random up to its exponential backoff. The full
+// jitter spreads the retries of requests that failed together, such as during a Lynx restart.
+func calculateDelay(retry int, config *RetryConfig) time.Duration {
+	backoff := float64(config.InitialDelay) * math.Pow(config.BackoffMultiplier, float64(retry-1))
+	backoff = min(backoff, float64(config.MaxDelay))
+	if backoff <= 0 {
+		return 0
 	}
-
-	return config.MaxDelay
+	return time.Duration(rand.Int64N(int64(backoff) + 1))
 }
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryHTTPRequestStopsWithContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		cancel       bool // Cancel once Lynx got the first request
+		want         error
+		wantContext  error
+		wantRequests int32
+	}{
+		// Lynx asks to come back in 5s, past the deadline
+		{name: "deadline", timeout: 200 * time.Millisecond, want: ErrTimeout, wantContext: context.DeadlineExceeded, wantRequests: 1},
+		{name: "canceled", timeout: time.Minute, cancel: true, want: ErrCanceled, wantContext: context.Canceled, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			var requests atomic.Int32
+			lynx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if tt.cancel {
+					cancel()
+				}
+				w.Header().Set("Retry-After", "5")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer lynx.Close()
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodPost, lynx.URL+"/lynx/service/file.rpc", strings.NewReader("7|0|4|"))
+			start := time.Now()
+			_, _, err := RetryHTTPRequest(ctx, NewHTTPClient(), req, DefaultRetryConfig())
+
+			if !errors.Is(err, tt.want) || !errors.Is(err, tt.wantContext) {
+				t.Errorf("RetryHTTPRequest() error = %v, want %v and %v", err, tt.want, tt.wantContext)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("Lynx got %d requests, want %d", got, tt.wantRequests)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("RetryHTTPRequest() returned after %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestRetryHTTPRequestClassifiesFailures(t *testing.T) {
+	const readBody = "7|0|4|https://lynx/lynx/lynx/|63A734E3E71C14883B20AFEC1238F6A7|com.lynxtraveltech.client.client.rpc.FileService|getFileDocumentsAsList|"
+	const writeBody = "7|0|4|https://lynx/lynx/lynx/|63A734E3E71C14883B20AFEC1238F6A7|com.lynxtraveltech.client.client.rpc.FileService|saveFileDocumentsDetails|"
+
+	tests := []struct {
+		name         string
+		body         string
+		statuses     []int // Of the attempts, 200 answers "//OK" past them
+		maintenance  bool  // 200 answers a maintenance page instead of "//OK" on the first attempt
+		verify       Verify
+		want         error
+		wantRequests int
+	}{
+		{name: "read retries 5xx", body: readBody, statuses: []int{http.StatusBadGateway}, wantRequests: 2},
+		{name: "read retries maintenance page", body: readBody, maintenance: true, wantRequests: 2},
+		{name: "read stops on 4xx", body: readBody, statuses: []int{http.StatusNotFound}, want: errors.New("status: 404"), wantRequests: 1},
+		{name: "read gives up on Lynx down", body: readBody, statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, want: ErrUnreachable, wantRequests: 3},
+		{name: "write retries 429", body: writeBody, statuses: []int{http.StatusTooManyRequests}, wantRequests: 2},
+		{name: "write gives up on Lynx throttling", body: writeBody, statuses: []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}, want: ErrUnreachable, wantRequests: 3},
+		{name: "write without check stops on 5xx", body: writeBody, statuses: []int{http.StatusBadGateway}, want: ErrWriteUnconfirmed, wantRequests: 1},
+		{name: "write retries once found missing", body: writeBody, statuses: []int{http.StatusBadGateway}, verify: func(context.Context) (bool, error) { return false, nil }, wantRequests: 2},
+		{name: "write gives up on Lynx down once found missing", body: writeBody, statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusBadGateway}, verify: func(context.Context) (bool, error) { return false, nil }, want: ErrUnreachable, wantRequests: 3},
+		{name: "write found applied", body: writeBody, statuses: []int{http.StatusBadGateway}, verify: func(context.Context) (bool, error) { return true, nil }, wantRequests: 1},
+		{name: "write check failing", body: writeBody, statuses: []int{http.StatusBadGateway}, verify: func(context.Context) (bool, error) { return false, errors.New("listing failed") }, want: ErrWriteUnconfirmed, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			lynx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempt := int(requests.Add(1)) - 1
+				switch {
+				case attempt < len(tt.statuses):
+					w.WriteHeader(tt.statuses[attempt])
+				case tt.maintenance && attempt == 0:
+					w.Write([]byte("<html>Down for maintenance</html>"))
+				default:
+					w.Write([]byte("//OK[1,[],0,7]"))
+				}
+			}))
+			defer lynx.Close()
+
+			req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, lynx.URL+"/lynx/service/file.rpc", strings.NewReader(tt.body))
+			config := &RetryConfig{MaxAttempts: 3, InitialDelay: time.Millisecond, BackoffMultiplier: 2, MaxDelay: 10 * time.Millisecond}
+			_, _, err := retryRPC(context.Background(), NewHTTPClient(), req, config, tt.verify)
+
+			switch {
+			case tt.want == nil && err != nil:
+				t.Errorf("retryRPC() error = %v", err)
+			case tt.want != nil && err == nil:
+				t.Errorf("retryRPC() error = nil, want %v", tt.want)
+			case tt.want != nil && !errors.Is(err, tt.want) && !strings.Contains(err.Error(), tt.want.Error()):
+				t.Errorf("retryRPC() error = %v, want %v", err, tt.want)
+			}
+			if got := int(requests.Load()); got != tt.wantRequests {
+				t.Errorf("Lynx got %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestCalculateDelay(t *testing.T) {
+	config := &RetryConfig{MaxAttempts: 5, InitialDelay: time.Second, BackoffMultiplier: 2, MaxDelay: 5 * time.Second}
+	tests := []struct {
+		retry int
+		want  time.Duration // Longest delay drawn
+	}{
+		{retry: 1, want: time.Second},
+		{retry: 2, want: 2 * time.Second},
+		{retry: 3, want: 4 * time.Second},
+		{retry: 4, want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		var longest time.Duration
+		for range 1000 {
+			delay := calculateDelay(tt.retry, config)
+			if delay < 0 || delay > tt.want {
+				t.Fatalf("calculateDelay(%d) = %s, want between 0 and %s", tt.retry, delay, tt.want)
+			}
+			longest = max(longest, delay)
+		}
+		// Full jitter draws across the whole backoff
+		if longest < tt.want/2 {
+			t.Errorf("calculateDelay(%d) drew at most %s in 1000 draws, want up to %s", tt.retry, longest, tt.want)
+		}
+	}
+}